@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+var algorithmsCmd = &cobra.Command{
+	Use:   "algorithms",
+	Short: "List the available dithering algorithms",
+	Args:  usageArgs(cobra.NoArgs),
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, name := range dither.Algorithms() {
+			fmt.Fprintln(cmd.OutOrStdout(), name)
+		}
+	},
+}
+
+func completeAlgorithms(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return dither.Algorithms(), cobra.ShellCompDirectiveNoFileComp
+}
+
+// addDiffusionFlags defines the flags tuning the error diffusion.
+func addDiffusionFlags(c *cobra.Command) {
+	c.Flags().Float64("strength", 1, "Fraction of the quantization error diffused, from 0 to 1, lower for a softer dithering")
+	c.Flags().Bool("serpentine", false, "Scan the rows alternately from left to right and from right to left, against the worm artifacts of the error diffusion")
+}
+
+// addScreenFlags defines the flags of the threshold, halftone and blue-noise
+// algorithms.
+func addScreenFlags(c *cobra.Command) {
+	c.Flags().Float64("threshold", 0.5, "Luma, from 0 to 1, from which the threshold algorithm maps the pixels to the lightest color")
+	c.Flags().Float64("dot-size", 6, "Distance in pixels between the dots of the halftone algorithm")
+	c.Flags().Float64("screen-angle", 45, "Angle in degrees of the rows of dots of the halftone algorithm, clockwise")
+	c.Flags().Int64("seed", 0, "Seed of the mask of the blue-noise algorithm, the same seed giving the same result")
+}
+
+// checkScreenFlags fails when a flag of addScreenFlags is set in fs, even to
+// its default, for the algorithm alg that has no use for it.
+func checkScreenFlags(fs *pflag.FlagSet, alg string) error {
+	d, ok := dither.Lookup(alg)
+	if !ok {
+		// Reported by the validation of the options.
+		return nil
+	}
+	if _, screens := d.(dither.ScreenDitherer); screens {
+		return nil
+	}
+	for _, name := range []string{"threshold", "dot-size", "screen-angle", "seed"} {
+		if fs.Changed(name) {
+			return withExitCode(exitUsage, fmt.Errorf("--%s is set but the algorithm %q has no threshold, halftone screen or mask to set", name, alg))
+		}
+	}
+	return nil
+}
+
+func init() {
+	for _, c := range []*cobra.Command{ditherCmd, watchCmd} {
+		c.Flags().StringP("algorithm", "a", "floyd-steinberg", "Dithering algorithm, see the algorithms command")
+		_ = c.RegisterFlagCompletionFunc("algorithm", completeAlgorithms)
+		addDiffusionFlags(c)
+		addScreenFlags(c)
+	}
+	rootCmd.AddCommand(algorithmsCmd)
+}
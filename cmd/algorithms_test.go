@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestScreenFlags checks that the flags of the threshold, halftone and
+// blue-noise algorithms fail with the other algorithms when they are set,
+// even to their defaults.
+func TestScreenFlags(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writeTestPNG(t, in, 8, 4)
+	for i, tt := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"--threshold", "0.4"}, `--threshold is set but the algorithm "floyd-steinberg" has no threshold`},
+		{[]string{"--threshold", "0.5"}, `--threshold is set but the algorithm "floyd-steinberg" has no threshold`},
+		{[]string{"-a", "atkinson", "--dot-size", "6"}, `--dot-size is set but the algorithm "atkinson"`},
+		{[]string{"-a", "bayer-4x4", "--seed", "0"}, `--seed is set but the algorithm "bayer-4x4"`},
+		{[]string{"-a", "threshold", "--threshold", "0.5"}, ""},
+		{[]string{"-a", "threshold", "--threshold", "0.4"}, ""},
+		{[]string{"-a", "halftone", "--dot-size", "6", "--screen-angle", "15"}, ""},
+		{[]string{"-a", "blue-noise", "--seed", "7"}, ""},
+		{[]string{"--strength", "0.5"}, ""},
+	} {
+		args := append([]string{in, "-o", filepath.Join(dir, fmt.Sprintf("out%d.png", i))}, tt.args...)
+		err := runFls(t, args...)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%q: %v", tt.args, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) || exitCode(err) != exitUsage {
+			t.Errorf("%q: error %v of exit code %d, expected %q", tt.args, err, exitCode(err), tt.err)
+		}
+	}
+}
+
+// TestBlueNoiseSeed checks that the blue-noise results of a --seed are the
+// same on each run, and those of other seeds different.
+func TestBlueNoiseSeed(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writeTestPNG(t, in, 64, 32)
+	var results [][]byte
+	for i, seed := range []string{"7", "7", "8"} {
+		out := filepath.Join(dir, fmt.Sprintf("out%d.png", i))
+		if err := runFls(t, in, "-o", out, "-a", "blue-noise", "--seed", seed); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, data)
+	}
+	if !bytes.Equal(results[0], results[1]) {
+		t.Error("different results of the seed 7")
+	}
+	if bytes.Equal(results[0], results[2]) {
+		t.Error("same results of the seeds 7 and 8")
+	}
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// animated reports whether the input file at path is an animated GIF to be
+// processed frame by frame by processAnimation: whether it has several frames
+// and its result is a GIF too.
+func animated(path string, o *options) bool {
+	if o.mode == modeResize || o.Format != "gif" || dither.FormatOf(path) != "gif" {
+		return false
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		// The error is reported when the file is processed.
+		return false
+	}
+	defer file.Close()
+	info, err := dither.Inspect(file)
+	return err == nil && info.Frames > 1
+}
+
+// processAnimation reduces each frame of the animated GIF file at path,
+// writing the animation at output.
+func processAnimation(cmd *cobra.Command, path, output string, o *options) error {
+	if o.stats || o.statsJSON != "" || o.metrics || o.compareAlgorithms || o.compareGIF != "" || o.sidecar {
+		return withExitCode(exitUsage, errors.New("the statistics, metrics, comparisons and sidecars are not available for animated GIFs"))
+	}
+	logger := log.With().Str("file", path).Logger()
+	st := newStages(cmd.Context(), logger, 5)
+	defer st.finish()
+
+	var file *os.File
+	err := st.run("open", func() (err error) {
+		logger.Info().Str("stage", "open").Msgf("opening file %q", path)
+		if file, err = os.Open(path); err != nil {
+			return withExitCode(exitDecode, fmt.Errorf("reading file %q: %w", path, err))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var g *gif.GIF
+	err = st.run("decode", func() (err error) {
+		g, err = dither.DecodeAnimation(bufio.NewReader(file), o.MaxPixels)
+		if de, ok := err.(*dither.DecodeError); ok {
+			de.Path = path
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info().Int("width", g.Config.Width).Int("height", g.Config.Height).Int("frames", len(g.Image)).Msg("decoded")
+
+	logger.Info().Float32("scale", o.Scale).Str("filter", o.Filter).Str("algorithm", o.Algorithm).Msg("processing...")
+	var out *gif.GIF
+	err = st.run(o.mode.stage(), func() (err error) {
+		out, err = dither.ProcessAnimation(st.ctx, g, o.Options)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = st.run("encode", func() error {
+		if err := gif.EncodeAll(&buf, out); err != nil {
+			return &dither.EncodeError{Err: err}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	st.logger = logger.With().Str("output_path", output).Logger()
+	err = st.run("write", func() error {
+		st.logger.Info().Str("stage", "write").Msgf("writing result at path %q", output)
+		return writeOutput(cmd, output, buf.Bytes())
+	})
+	if err != nil {
+		return err
+	}
+	st.finish()
+	st.processed(image.Rect(0, 0, g.Config.Width, g.Config.Height), image.Rect(0, 0, out.Config.Width, out.Config.Height), buf.Len())
+	if o.timings {
+		return printTimings(o.timingsWriter(), st.timings)
+	}
+	return nil
+}
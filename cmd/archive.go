@@ -0,0 +1,428 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// archiveFormat returns the format of the archive at path from its
+// extension, or an empty string if it is not an archive.
+func archiveFormat(path string) string {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	}
+	return ""
+}
+
+// trimArchiveExt removes the archive extension of path.
+func trimArchiveExt(path string) string {
+	lower := strings.ToLower(path)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			return path[:len(path)-len(ext)]
+		}
+	}
+	return path
+}
+
+var errArchiveTooLarge = errors.New("archive exceeds the maximum uncompressed size set by --max-archive-size")
+
+// budget bounds the total number of bytes read from the entries of an
+// archive, guarding against archive bombs.
+type budget struct {
+	remaining int64
+}
+
+func (b *budget) reader(r io.Reader) io.Reader {
+	return readerFunc(func(p []byte) (int, error) {
+		if b.remaining <= 0 {
+			// The content may end exactly at the limit.
+			var probe [1]byte
+			if n, err := r.Read(probe[:]); n == 0 {
+				return 0, err
+			}
+			return 0, errArchiveTooLarge
+		}
+		if int64(len(p)) > b.remaining {
+			p = p[:b.remaining]
+		}
+		n, err := r.Read(p)
+		b.remaining -= int64(n)
+		return n, err
+	})
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+// walkArchive calls fn with the name and content of each regular file of the
+// archive at path, in archive order, reading at most limit bytes of content
+// in total. The tar formats are streamed.
+func walkArchive(path string, limit int64, fn func(name string, r io.Reader) error) error {
+	b := &budget{remaining: limit}
+	if archiveFormat(path) == "zip" {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("opening %q: %w", f.Name, err)
+			}
+			err = fn(f.Name, b.reader(rc))
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var r io.Reader = bufio.NewReader(file)
+	if archiveFormat(path) == "tar.gz" {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err := fn(hdr.Name, b.reader(tr)); err != nil {
+			return err
+		}
+	}
+}
+
+// entryName cleans the name of an archive entry and reports whether it is
+// safe to write under an output directory.
+func entryName(name string) (string, bool) {
+	name = path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return name, false
+	}
+	return name, true
+}
+
+// entryOutput returns the name of the result of o for the archive entry name.
+func entryOutput(name string, o *options) string {
+	return path.Join(path.Dir(name), o.outputName(path.Base(name)))
+}
+
+// entryLocation returns where the named result of the processing of an
+// archive is written given the --output directory or archive.
+func entryLocation(output, name string) string {
+	if archiveFormat(output) != "" {
+		return output + ":" + name
+	}
+	return filepath.Join(output, filepath.FromSlash(name))
+}
+
+// sink receives the results of the processing of an archive. It is closed
+// with complete false when the processing failed or was interrupted.
+type sink interface {
+	put(name string, data []byte) error
+	close(complete bool) error
+}
+
+// dirSink writes the results under a directory.
+type dirSink struct {
+	dir string
+}
+
+func (s dirSink) put(name string, data []byte) error {
+	p := entryLocation(s.dir, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return dither.WriteFile(p, data)
+}
+
+func (s dirSink) close(complete bool) error { return nil }
+
+// archiveSink writes the results into a new zip or tar archive. The archive
+// is removed if it is not complete.
+type archiveSink struct {
+	file   *os.File
+	zw     *zip.Writer
+	tw     *tar.Writer
+	gz     *gzip.Writer
+	failed bool
+}
+
+func newArchiveSink(path string) (*archiveSink, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating output archive %q: %w", path, err)
+	}
+	s := &archiveSink{file: file}
+	switch archiveFormat(path) {
+	case "zip":
+		s.zw = zip.NewWriter(file)
+	case "tar.gz":
+		s.gz = gzip.NewWriter(file)
+		s.tw = tar.NewWriter(s.gz)
+	default:
+		s.tw = tar.NewWriter(file)
+	}
+	return s, nil
+}
+
+func (s *archiveSink) put(name string, data []byte) error {
+	err := s.write(name, data)
+	if err != nil {
+		s.failed = true
+	}
+	return err
+}
+
+func (s *archiveSink) write(name string, data []byte) error {
+	if s.zw != nil {
+		w, err := s.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	err := s.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.tw.Write(data)
+	return err
+}
+
+func (s *archiveSink) close(complete bool) error {
+	var err error
+	if s.zw != nil {
+		err = s.zw.Close()
+	} else {
+		err = s.tw.Close()
+		if s.gz != nil {
+			if gerr := s.gz.Close(); err == nil {
+				err = gerr
+			}
+		}
+	}
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || s.failed || !complete {
+		os.Remove(s.file.Name())
+	}
+	return err
+}
+
+// processArchive runs the pipeline on the images of the archive at input in a
+// batch, writing the results in the directory or the archive given by
+// --output.
+func processArchive(cmd *cobra.Command, input string, o *options) error {
+	if o.statsJSON != "" {
+		return withExitCode(exitUsage, errors.New("--stats-json cannot be used with archive inputs"))
+	}
+	if o.compareGIF != "" {
+		return withExitCode(exitUsage, errors.New("--compare-gif cannot be used with archive inputs"))
+	}
+	if o.decodeWorkers < 1 || o.renderWorkers < 1 {
+		return withExitCode(exitUsage, errors.New("--decode-workers and --render-workers must be at least 1"))
+	}
+	output := o.output
+	if output == "" {
+		output = filepath.Join(o.outDir, filepath.Base(trimArchiveExt(input))+"_fls")
+	}
+	logger := log.With().Str("file", input).Logger()
+
+	toDir := archiveFormat(output) == ""
+
+	var out sink = dirSink{dir: output}
+	if !toDir && !o.dryRun {
+		s, err := newArchiveSink(output)
+		if err != nil {
+			return withExitCode(exitWrite, err)
+		}
+		out = s
+	}
+
+	var plans []plan
+	prog := startProgress(0)
+	defer prog.finish()
+	added, processed := 0, 0
+	// The names of the entries, by the name of their result.
+	written := make(map[string]string)
+	b := newBatch(cmd.Context(), o, false, func(item *batchItem) error {
+		prog.begin(item.name)
+		location := entryLocation(output, item.output)
+		st := item.st
+		st.logger = st.logger.With().Str("output_path", location).Logger()
+		err := st.run("write", func() error {
+			if err := out.put(item.output, item.res.encoded); err != nil {
+				return withExitCode(exitWrite, fmt.Errorf("writing %q: %w", location, err))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		processed++
+		prog.done()
+
+		if o.stats || o.metrics || o.compareAlgorithms {
+			fmt.Fprintf(o.reports(cmd), "%s:\n", item.name)
+		}
+		sidecarTo := ""
+		if toDir {
+			sidecarTo = location
+		}
+		if err := report(cmd, st, item.name, sidecarTo, item.res, o); err != nil {
+			return err
+		}
+		item.res.release()
+		return nil
+	})
+	err := walkArchive(input, o.maxArchiveSize, func(name string, r io.Reader) error {
+		name, ok := entryName(name)
+		if !ok {
+			logger.Warn().Str("entry", name).Msg("skipping archive entry with an unsafe path")
+			return nil
+		}
+		br := bufio.NewReader(r)
+		format := dither.FormatOf(name)
+		if format == "" {
+			head, _ := br.Peek(dither.SniffLen)
+			format = dither.SniffFormat(head)
+		}
+		if format == "" {
+			logger.Debug().Str("entry", name).Msg("skipping archive entry which is not a supported image")
+			return nil
+		}
+		entry := input + ":" + name
+		dest := entryOutput(name, o)
+		if prev, ok := written[dest]; ok && !o.dryRun {
+			return withExitCode(exitUsage, fmt.Errorf("entries %q and %q would both be written to %q, see --output-template", prev, name, entryLocation(output, dest)))
+		}
+		written[dest] = name
+
+		// The entry is read here, in archive order, and decoded by the
+		// workers of the batch.
+		buf := getBuffer()
+		if _, err := buf.ReadFrom(br); err != nil {
+			return err
+		}
+		if o.dryRun {
+			p := plan{Input: entry, Output: entryLocation(output, dest), OutFormat: o.Format}
+			if p.inspect(bytes.NewReader(buf.Bytes()), o) && toDir {
+				p.checkExisting()
+			}
+			putBuffer(buf.Bytes())
+			plans = append(plans, p)
+			return nil
+		}
+		if err := b.add(entry, format, buf.Bytes(), dest, logger.With().Str("entry", name).Logger()); err != nil {
+			return err
+		}
+		added++
+		return nil
+	})
+	if berr := b.wait(); berr != nil {
+		err = berr
+	}
+	// The batch stopped by --strict keeps the images written before.
+	stopped := o.strict && len(b.failed) > 0 && cmd.Context().Err() == nil
+	if stopped && errors.Is(err, context.Canceled) {
+		err = nil
+	}
+	if o.dryRun {
+		if err != nil {
+			return withExitCode(exitDecode, fmt.Errorf("reading archive %q: %w", input, err))
+		}
+		return reportPlans(cmd, plans)
+	}
+	if cerr := out.close(err == nil); err == nil && cerr != nil {
+		err = withExitCode(exitWrite, fmt.Errorf("writing %q: %w", output, cerr))
+	}
+	if errors.Is(err, context.Canceled) {
+		if toDir {
+			logger.Warn().Int("images", processed).Msgf("interrupted after writing %d image(s) in %q", processed, output)
+		} else {
+			logger.Warn().Int("images", processed).Msgf("interrupted after %d image(s), removed the incomplete archive %q", processed, output)
+		}
+		return err
+	}
+	if err != nil {
+		if exitCode(err) == exitFailure {
+			err = withExitCode(exitDecode, fmt.Errorf("reading archive %q: %w", input, err))
+		}
+		return err
+	}
+	logger.Info().Int("images", processed).Str("output_path", output).Msg("archive processed")
+	switch {
+	case len(b.failed) == 0:
+		return nil
+	case stopped:
+		return withExitCode(exitCode(b.failed[0]), fmt.Errorf("stopped by --strict after writing %d image(s) of archive %q, %d failed (%s)",
+			processed, input, len(b.failed), failureSummary(b.failed)))
+	}
+	return withExitCode(exitCode(b.failed[0]), fmt.Errorf("%d of the %d images of archive %q could not be processed (%s)",
+		len(b.failed), added, input, failureSummary(b.failed)))
+}
+
+func init() {
+	for _, c := range []*cobra.Command{ditherCmd, quantizeCmd, resizeCmd, watchCmd} {
+		c.Flags().Int64("max-archive-size", 1<<30, "Maximum total uncompressed size in bytes of the images read from an archive")
+		c.Flags().Int("decode-workers", 2, "Number of images of a batch or an archive decoded at the same time")
+		c.Flags().Int("render-workers", runtime.NumCPU(), "Number of images of a batch or an archive processed and encoded at the same time")
+	}
+}
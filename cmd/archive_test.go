@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeTestZip writes a zip archive of the given entries, in order, to path.
+func writeTestZip(t *testing.T, path string, names []string, data map[string][]byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data[name])
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// zipEntries returns the names of the entries of the zip archive at path, in
+// order.
+func zipEntries(t *testing.T, path string) []string {
+	t.Helper()
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// TestArchiveOrder checks that the results of an archive are written in the
+// order of its entries, although the smaller images of its end are
+// processed first.
+func TestArchiveOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	var want []string
+	for i, size := range []int{400, 300, 200, 100, 50, 20, 10, 5} {
+		name := string(rune('a'+i)) + ".png"
+		data := encodeTestPNG(t, size, size)
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(data)
+		want = append(want, strings.TrimSuffix(name, ".png")+"_fls.png")
+	}
+	tw.Close()
+	in, out := filepath.Join(dir, "in.tar"), filepath.Join(dir, "out.tar")
+	if err := ioutil.WriteFile(in, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runFls(t, in, "-o", out, "--decode-workers", "4", "--render-workers", "4"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []string
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, h.Name)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results %v, expected %v", got, want)
+	}
+}
+
+// TestArchiveFailures checks that the images of an archive failing are
+// skipped, and stop the processing with --strict, the results of the others
+// being written.
+func TestArchiveFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	valid := encodeTestPNG(t, 30, 20)
+	in := filepath.Join(dir, "in.zip")
+	writeTestZip(t, in, []string{"a.png", "b.png", "notes.txt", "c.png", "sub/d.png"}, map[string][]byte{
+		"a.png":     valid,
+		"b.png":     valid[:len(valid)/2],
+		"notes.txt": []byte("not an image"),
+		"c.png":     valid,
+		"sub/d.png": valid,
+	})
+
+	for _, tt := range []struct {
+		args    []string
+		message string
+		want    []string
+	}{
+		{nil, "1 of the 4 images of archive", []string{"a_fls.png", "c_fls.png", "sub/d_fls.png"}},
+		{[]string{"--strict"}, "stopped by --strict after writing 1 image(s)", []string{"a_fls.png"}},
+	} {
+		out := filepath.Join(dir, "out.zip")
+		err := runFls(t, append([]string{in, "-o", out}, tt.args...)...)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%v: error %v, expected %q", tt.args, err, tt.message)
+		}
+		if code := exitCode(err); code != exitDecode {
+			t.Errorf("%v: exit code %d, expected %d", tt.args, code, exitDecode)
+		}
+		if got := zipEntries(t, out); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: results %v, expected %v", tt.args, got, tt.want)
+		}
+		os.Remove(out)
+	}
+}
+
+// TestArchiveSizeLimit checks that the archives whose images add up to
+// exactly --max-archive-size are processed, and those larger refused. The
+// entries are stored uncompressed, their end then reported by a read past
+// their content.
+func TestArchiveSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	a, b := encodeTestPNG(t, 30, 20), encodeTestPNG(t, 20, 10)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range []struct {
+		name string
+		data []byte
+	}{{"a.png", a}, {"b.png", b}} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(e.data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	in := filepath.Join(dir, "in.zip")
+	if err := ioutil.WriteFile(in, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	size := len(a) + len(b)
+	for i, tt := range []struct {
+		limit int
+		fails bool
+	}{
+		{size, false},
+		{size + 1, false},
+		{size - 1, true},
+		{len(b), true},
+	} {
+		out := filepath.Join(dir, fmt.Sprintf("out%d", i))
+		err := runFls(t, in, "-o", out, "--max-archive-size", strconv.Itoa(tt.limit))
+		if fails := errors.Is(err, errArchiveTooLarge); fails != tt.fails || !fails && err != nil {
+			t.Errorf("limit of %d bytes for %d: error %v", tt.limit, size, err)
+		}
+	}
+}
+
+// TestArchiveCollisions checks that the entries of an archive whose results
+// have the same name are refused, rather than one overwriting the other.
+func TestArchiveCollisions(t *testing.T) {
+	dir := t.TempDir()
+	valid := encodeTestPNG(t, 30, 20)
+	for _, tt := range []struct {
+		names []string
+		err   string
+	}{
+		{[]string{"a.png", "a.jpg"}, `entries "a.png" and "a.jpg" would both be written to`},
+		{[]string{"sub/b.png", "other/../sub/b.png"}, `entries "sub/b.png" and "sub/b.png" would both be written to`},
+		{[]string{"a.png", "sub/a.png", "a/sub.png"}, ""},
+	} {
+		in := filepath.Join(dir, "in.zip")
+		data := make(map[string][]byte)
+		for _, name := range tt.names {
+			data[name] = valid
+		}
+		writeTestZip(t, in, tt.names, data)
+		for _, output := range []string{"out", "out.zip"} {
+			out := filepath.Join(dir, output)
+			err := runFls(t, in, "-o", out)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("%q to %s: %v", tt.names, output, err)
+				}
+				os.RemoveAll(out)
+				continue
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) || exitCode(err) != exitUsage {
+				t.Errorf("%q to %s: error %v of exit code %d, expected %q", tt.names, output, err, exitCode(err), tt.err)
+			}
+			if _, err := os.Stat(out); output == "out.zip" && !os.IsNotExist(err) {
+				t.Errorf("%q: incomplete archive kept", tt.names)
+			}
+			os.RemoveAll(out)
+		}
+	}
+}
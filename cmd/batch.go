@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// batchItem is an image of a batch going through the stages of a batch.
+type batchItem struct {
+	name   string // the name of the image in the logs and errors
+	format string
+	data   []byte // the encoded image, until decoded
+	output string // where the result is to be written, for the encoders
+	st     *stages
+
+	img  image.Image
+	md   dither.Metadata
+	res  *rendered
+	err  error
+	done chan struct{} // closed once res or err is set
+}
+
+// batch decodes, processes and encodes the images added to it in concurrent
+// stages, so that the reading and decoding of the next images overlap with
+// the processing of the current ones and the writing of the previous ones.
+// The results are handed to the write function in the order the images were
+// added, and the images failing are logged and recorded in failed. The number
+// of images in flight is bounded: add blocks while all the workers are busy.
+type batch struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	o        *options
+	inflight chan *batchItem // in input order, for the writer
+	decodes  chan *batchItem
+	renders  chan *batchItem
+	workers  sync.WaitGroup
+	written  chan error
+	failed   []error // the errors of the images failing, once written
+}
+
+// newBatch starts the workers of a batch processing images with o, and the
+// goroutine calling write with the result of each image in input order. The
+// first error returned by write cancels the batch, unless perImage makes it
+// the failure of the image only, like the images failing to be processed.
+// The first image failing with --strict cancels the batch.
+func newBatch(ctx context.Context, o *options, perImage bool, write func(item *batchItem) error) *batch {
+	b := &batch{
+		o:        o,
+		inflight: make(chan *batchItem, o.decodeWorkers+o.renderWorkers+1),
+		decodes:  make(chan *batchItem),
+		renders:  make(chan *batchItem),
+		written:  make(chan error, 1),
+	}
+	b.ctx, b.cancel = context.WithCancel(ctx)
+
+	var decoders sync.WaitGroup
+	for i := 0; i < o.decodeWorkers; i++ {
+		decoders.Add(1)
+		go func() {
+			defer decoders.Done()
+			for item := range b.decodes {
+				item.img, item.md, item.err = decodeStage(item.st, item.name, item.format, bytes.NewReader(item.data), o)
+				putBuffer(item.data)
+				item.data = nil
+				if item.err != nil {
+					close(item.done)
+					continue
+				}
+				b.renders <- item
+			}
+		}()
+	}
+	go func() {
+		decoders.Wait()
+		close(b.renders)
+	}()
+	for i := 0; i < o.renderWorkers; i++ {
+		b.workers.Add(1)
+		go func() {
+			defer b.workers.Done()
+			for item := range b.renders {
+				item.res, item.err = renderImage(item.st, item.img, o.withMetadata(item.md), item.output)
+				item.img = nil
+				close(item.done)
+			}
+		}()
+	}
+
+	go func() {
+		var err error
+		// stopped is set by the first image failing with --strict: the
+		// images after it are not written, even those already processed.
+		stopped := false
+		fail := func(item *batchItem, ierr error) {
+			item.st.logger.Error().Msg(ierr.Error())
+			b.failed = append(b.failed, ierr)
+			if o.strict {
+				stopped = true
+				b.cancel()
+			}
+		}
+		for item := range b.inflight {
+			<-item.done
+			switch {
+			case err != nil, stopped, errors.Is(item.err, context.Canceled):
+			case item.err != nil:
+				fail(item, item.err)
+			default:
+				werr := write(item)
+				switch {
+				case werr == nil, perImage && errors.Is(werr, context.Canceled):
+				case perImage:
+					fail(item, werr)
+				default:
+					err = werr
+					b.cancel()
+				}
+			}
+		}
+		b.written <- err
+	}()
+	return b
+}
+
+// add queues the named encoded image of the given format to be processed,
+// logging with logger, and written at output. data, from getBuffer, is handed
+// back to buffers once decoded. It returns ctx.Err() once the batch is
+// canceled.
+func (b *batch) add(name, format string, data []byte, output string, logger zerolog.Logger) error {
+	if err := b.ctx.Err(); err != nil {
+		return err
+	}
+	item := &batchItem{
+		name:   name,
+		format: format,
+		data:   data,
+		output: output,
+		st:     newStages(b.ctx, logger, 0),
+		done:   make(chan struct{}),
+	}
+	select {
+	case b.inflight <- item:
+	case <-b.ctx.Done():
+		return b.ctx.Err()
+	}
+	b.decodes <- item
+	return nil
+}
+
+// fail queues the failure err of the named image, which could not be read,
+// to be reported in input order. It returns ctx.Err() once the batch is
+// canceled.
+func (b *batch) fail(name string, err error, logger zerolog.Logger) error {
+	item := &batchItem{name: name, err: err, st: newStages(b.ctx, logger, 0), done: make(chan struct{})}
+	close(item.done)
+	select {
+	case b.inflight <- item:
+		return nil
+	case <-b.ctx.Done():
+		return b.ctx.Err()
+	}
+}
+
+// wait waits for the images added to be processed and written, and returns
+// the error of their writing, the failures of the images being in failed.
+func (b *batch) wait() error {
+	close(b.decodes)
+	close(b.inflight)
+	b.workers.Wait()
+	err := <-b.written
+	b.cancel()
+	return err
+}
+
+// batched reports whether the input in is processed by runBatch: the image
+// files of a single page, unless --max-memory bounds the memory of each
+// image, which a batch holding several images at a time would exceed.
+func batched(in input, o *options) bool {
+	return archiveFormat(in.path) == "" && !animated(in.path, o) && o.pages == nil &&
+		dither.FormatOf(in.path) != "tiff" && o.maxMemory <= 0
+}
+
+// runBatch processes the image files inputs with o in a batch, recording
+// their outcome in res. The files are read in input order, and the results
+// written and reported in that order. A failing input is logged and the
+// others carry on, unless --strict stops the processing at the first one.
+func runBatch(cmd *cobra.Command, inputs []input, o *options, res *outcome) error {
+	if len(inputs) == 0 {
+		return nil
+	}
+	if o.decodeWorkers < 1 || o.renderWorkers < 1 {
+		return withExitCode(exitUsage, errors.New("--decode-workers and --render-workers must be at least 1"))
+	}
+	prog := startProgress(len(inputs))
+	defer prog.finish()
+	b := newBatch(cmd.Context(), o, true, func(item *batchItem) error {
+		prog.begin(item.name)
+		defer prog.done()
+		st := item.st
+		st.logger = st.logger.With().Str("output_path", item.output).Logger()
+		err := st.run("write", func() error {
+			st.logger.Info().Str("stage", "write").Msgf("writing result at path %q", item.output)
+			return writeOutput(cmd, item.output, item.res.encoded)
+		})
+		if err != nil {
+			return err
+		}
+		if o.stats || o.metrics || o.compareAlgorithms {
+			fmt.Fprintf(o.reports(cmd), "%s:\n", item.name)
+		}
+		if err := report(cmd, st, item.name, item.output, item.res, o); err != nil {
+			return err
+		}
+		item.res.release()
+		res.processed++
+		return nil
+	})
+	var err error
+	for _, in := range inputs {
+		logger := log.With().Str("file", in.path).Logger()
+		output := o.outputPath(in)
+		// The files are read here, in input order, and decoded by the
+		// workers of the batch.
+		data, rerr := readInput(in.path, output)
+		if rerr != nil {
+			err = b.fail(in.path, rerr, logger)
+		} else {
+			format := dither.FormatOf(in.path)
+			if format == "" {
+				format = dither.SniffFormat(data)
+			}
+			err = b.add(in.path, format, data, output, logger)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if werr := b.wait(); err == nil {
+		err = werr
+	}
+	res.failed = append(res.failed, b.failed...)
+	if errors.Is(err, context.Canceled) && cmd.Context().Err() == nil {
+		// Stopped by --strict.
+		err = nil
+	}
+	return err
+}
+
+// readInput returns the content of the image file at path, in a buffer from
+// getBuffer, once created the directory of its result at output.
+func readInput(path, output string) ([]byte, error) {
+	if dir := filepath.Dir(output); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, withExitCode(exitWrite, fmt.Errorf("creating output directory: %w", err))
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, withExitCode(exitDecode, fmt.Errorf("reading file %q: %w", path, err))
+	}
+	defer file.Close()
+	buf := getBuffer()
+	if _, err := buf.ReadFrom(file); err != nil {
+		putBuffer(buf.Bytes())
+		return nil, withExitCode(exitDecode, fmt.Errorf("reading file %q: %w", path, err))
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// TestBatchFiles checks that the image files of a directory, but the other
+// files, are processed in a batch, the ones failing being skipped, or
+// stopping the batch with --strict once the results of the files before them
+// are written.
+func TestBatchFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in")
+	os.Mkdir(in, 0755)
+	valid := encodeTestPNG(t, 30, 20)
+	for name, data := range map[string][]byte{
+		"a.png":     valid,
+		"b.png":     valid[:len(valid)/2],
+		"c.png":     valid,
+		"d.png":     valid,
+		"notes.txt": []byte("not an image"),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(in, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		args    []string
+		message string
+		want    []string
+	}{
+		{nil, "1 of the 4 inputs could not be processed (decoding: 1)", []string{"a_fls.png", "c_fls.png", "d_fls.png"}},
+		{[]string{"--strict"}, "stopped by --strict after processing 1 of the 4 inputs, 1 failed (decoding: 1)", []string{"a_fls.png"}},
+		{[]string{"--decode-workers", "1", "--render-workers", "1"}, "1 of the 4 inputs", []string{"a_fls.png", "c_fls.png", "d_fls.png"}},
+	} {
+		out := filepath.Join(dir, "out")
+		err := runFls(t, append([]string{in, "--out-dir", out}, tt.args...)...)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%v: error %v, expected %q", tt.args, err, tt.message)
+		}
+		if code := exitCode(err); code != exitDecode {
+			t.Errorf("%v: exit code %d, expected %d", tt.args, code, exitDecode)
+		}
+		var got []string
+		entries, _ := ioutil.ReadDir(out)
+		for _, e := range entries {
+			got = append(got, e.Name())
+		}
+		sort.Strings(got)
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%v: results %v, expected %v", tt.args, got, tt.want)
+		}
+		for _, name := range got {
+			if b := decodeTestPNG(t, filepath.Join(out, name)).Bounds(); b.Dx() != 30 || b.Dy() != 20 {
+				t.Errorf("%v: %s of %v, expected 30x20", tt.args, name, b)
+			}
+		}
+		os.RemoveAll(out)
+	}
+}
+
+// BenchmarkBatch compares the batch and the pool of jobs processing a
+// directory of images.
+func BenchmarkBatch(b *testing.B) {
+	dir, err := ioutil.TempDir("", "fls")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var inputs []input
+	for i := 0; i < 24; i++ {
+		data, err := syntheticImage(400 + 20*i)
+		if err != nil {
+			b.Fatal(err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%02d.png", i))
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			b.Fatal(err)
+		}
+		inputs = append(inputs, input{path: path})
+	}
+	defer func(l zerolog.Logger) { log.Logger = l }(log.Logger)
+	log.Logger = zerolog.Nop()
+	if err := ditherCmd.ParseFlags([]string{"--out-dir", filepath.Join(dir, "out"), "--scale", "0.5"}); err != nil {
+		b.Fatal(err)
+	}
+	defer resetFlags(ditherCmd)
+	o, err := newOptions(ditherCmd.Flags(), modeDither, inputs[0].path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	// run runs f on the inputs from a command with a context.
+	run := func(b *testing.B, f func(cmd *cobra.Command, res *outcome) error) {
+		for i := 0; i < b.N; i++ {
+			res := &outcome{}
+			cmd := &cobra.Command{Use: "batch", RunE: func(cmd *cobra.Command, args []string) error { return f(cmd, res) }}
+			cmd.SetArgs([]string{})
+			if err := cmd.ExecuteContext(context.Background()); err != nil || len(res.failed) > 0 {
+				b.Fatal(err, res.failed)
+			}
+		}
+	}
+	b.Run("batch", func(b *testing.B) {
+		run(b, func(cmd *cobra.Command, res *outcome) error { return runBatch(cmd, inputs, o, res) })
+	})
+	b.Run("jobs", func(b *testing.B) {
+		run(b, func(cmd *cobra.Command, res *outcome) error {
+			runJobs(cmd, inputs, o, res)
+			return nil
+		})
+	})
+}
@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+var benchCmd = &cobra.Command{
+	Use:   "bench [image]",
+	Short: "Measure the duration and allocations of each processing stage",
+	Long: `Run the dither pipeline several times on an image, or on a generated
+photo-like image when none is given, and print the mean, median and 95th
+percentile duration and the allocations of each stage. The image is read once
+beforehand so that the decode stage doesn't include the file reads.`,
+	Args:              usageArgs(cobra.MaximumNArgs(1)),
+	ValidArgsFunction: completeImageFiles,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f := flagReader{fs: cmd.Flags()}
+		runs := f.int("runs")
+		size := f.int("size")
+		if f.err != nil {
+			return f.err
+		}
+		if runs < 1 {
+			return withExitCode(exitUsage, errors.New("--runs must be at least 1"))
+		}
+		o, err := newOptions(cmd.Flags(), modeDither, "")
+		if err != nil {
+			return err
+		}
+
+		var name, format string
+		var data []byte
+		if len(args) == 0 {
+			if size < 1 {
+				return withExitCode(exitUsage, errors.New("--size must be at least 1"))
+			}
+			name, format = fmt.Sprintf("synthetic %dx%d", size, size), "png"
+			if data, err = syntheticImage(size); err != nil {
+				return err
+			}
+		} else {
+			name = filepath.Clean(args[0])
+			if data, err = os.ReadFile(name); err != nil {
+				return withExitCode(exitDecode, fmt.Errorf("reading file %q: %w", name, err))
+			}
+			format = dither.SniffFormat(data)
+		}
+
+		b := &bench{samples: make(map[string][]benchSample)}
+		// The first run warms up the caches and the heap and isn't recorded.
+		for i := 0; i <= runs; i++ {
+			b.recording = i > 0
+			if err := b.run(cmd, name, format, data, o); err != nil {
+				return err
+			}
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "image: %s, %d bytes\n", name, len(data))
+		fmt.Fprintf(out, "options: scale %v, filter %s, algorithm %s, format %s\n", o.Scale, o.Filter, o.Algorithm, o.Format)
+		fmt.Fprintf(out, "system: %s %s/%s, %d CPUs, fls %s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH, runtime.NumCPU(), buildVersion())
+		fmt.Fprintf(out, "runs: %d\n\n", runs)
+		return b.print(out)
+	},
+}
+
+// benchSample holds the measures of one run of a stage.
+type benchSample struct {
+	d      time.Duration
+	allocs uint64
+	bytes  uint64
+}
+
+// bench records the samples of the stages of benchmark runs.
+type bench struct {
+	stages    []string // in order of appearance
+	samples   map[string][]benchSample
+	recording bool
+
+	start    time.Time
+	memStart runtime.MemStats
+}
+
+func (b *bench) begin(string) {
+	runtime.ReadMemStats(&b.memStart)
+	b.start = time.Now()
+}
+
+func (b *bench) done(stage string, d time.Duration, err error) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	if err != nil || !b.recording {
+		return
+	}
+	if _, ok := b.samples[stage]; !ok {
+		b.stages = append(b.stages, stage)
+	}
+	b.samples[stage] = append(b.samples[stage], benchSample{
+		d:      d,
+		allocs: m.Mallocs - b.memStart.Mallocs,
+		bytes:  m.TotalAlloc - b.memStart.TotalAlloc,
+	})
+}
+
+// stage runs the named stage f.
+func (b *bench) stage(name string, f func() error) error {
+	b.begin(name)
+	err := f()
+	b.done(name, time.Since(b.start), err)
+	return err
+}
+
+// run runs the pipeline of o once on the named image of the given format
+// encoded in data.
+func (b *bench) run(cmd *cobra.Command, name, format string, data []byte, o *options) error {
+	var img image.Image
+	err := b.stage("decode", func() (err error) {
+		img, _, err = decode(name, format, bytes.NewReader(data), decodeOptions(o))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	r := &rendered{src: img}
+	p, err := pipeline(o, r)
+	if err != nil {
+		return err
+	}
+	p.Hooks = dither.Hooks{Start: b.begin, Done: b.done}
+	if r.result, err = p.Run(cmd.Context(), img); err != nil {
+		return err
+	}
+	err = b.stage("encode", func() (err error) {
+		r.encoded, err = encode(r.result, o, "bench")
+		return err
+	})
+	// Like the batches, reuse the buffers in the next run.
+	r.release()
+	return err
+}
+
+// print writes the statistics of the samples of each stage as a table.
+func (b *bench) print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "STAGE\tMEAN\tMEDIAN\tP95\tALLOCS\tBYTES\t")
+	for _, stage := range b.stages {
+		s := b.samples[stage]
+		ds := make([]time.Duration, len(s))
+		var total time.Duration
+		var allocs, bytes uint64
+		for i, x := range s {
+			ds[i] = x.d
+			total += x.d
+			allocs += x.allocs
+			bytes += x.bytes
+		}
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		n := uint64(len(s))
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t\n", stage,
+			ms(total/time.Duration(n)), ms(percentile(ds, 50)), ms(percentile(ds, 95)), allocs/n, mib(bytes/n))
+	}
+	return tw.Flush()
+}
+
+// percentile returns the p-th percentile of the sorted durations ds, with the
+// nearest-rank method.
+func percentile(ds []time.Duration, p int) time.Duration {
+	i := (len(ds)*p + 99) / 100
+	if i < 1 {
+		i = 1
+	}
+	return ds[i-1]
+}
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.3f ms", float64(d)/float64(time.Millisecond))
+}
+
+func mib(n uint64) string {
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
+
+// syntheticImage returns a photo-like image of size x size pixels encoded as
+// PNG: smooth color gradients with some noise, not to compress unusually well.
+func syntheticImage(size int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	seed := uint32(1)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			seed = seed*1664525 + 1013904223 // a linear congruential generator
+			noise := int(seed>>28) - 8
+			img.SetRGBA(x, y, color.RGBA{
+				R: clampByte(255*x/size + noise),
+				G: clampByte(255*y/size + noise),
+				B: clampByte(255*(x+y)/(2*size) + noise),
+				A: 255,
+			})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func clampByte(v int) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+func init() {
+	benchCmd.Flags().Int("runs", 10, "Number of recorded runs, after a warm-up run")
+	benchCmd.Flags().Int("size", 1000, "Width and height of the generated image used when none is given")
+	benchCmd.Flags().Float32P("scale", "s", 1., "Scaling coefficient")
+	addFilterFlag(benchCmd)
+	benchCmd.Flags().StringP("algorithm", "a", dither.DefaultOptions().Algorithm, "Dithering algorithm, see the algorithms command")
+	_ = benchCmd.RegisterFlagCompletionFunc("algorithm", completeAlgorithms)
+	addDiffusionFlags(benchCmd)
+	addScreenFlags(benchCmd)
+	benchCmd.Flags().String("format", "", "Output format, see the formats command (default png)")
+	benchCmd.Flags().Int("threads", 0, "Number of threads scaling and quantizing an image, 0 for one per CPU; error diffusion runs on one")
+	addMaxPixelsFlag(benchCmd)
+	addAssumeSRGBFlag(benchCmd)
+	rootCmd.AddCommand(benchCmd)
+}
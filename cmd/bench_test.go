@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBench checks that fls bench prints a row of statistics per stage, on a
+// generated image and on a file.
+func TestBench(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in.png")
+	writeTestPNG(t, in, 40, 30)
+
+	for _, args := range [][]string{
+		{"bench", "--runs", "3", "--size", "32"},
+		{"bench", "--runs", "2", "-s", "0.5", in},
+	} {
+		var out bytes.Buffer
+		rootCmd.SetOut(&out)
+		err := runFls(t, args...)
+		rootCmd.SetOut(nil)
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		rows := map[string]bool{}
+		for _, line := range strings.Split(out.String(), "\n") {
+			if f := strings.Fields(line); len(f) == 10 {
+				rows[f[0]] = true
+			}
+		}
+		for _, stage := range []string{"decode", "dither", "encode"} {
+			if !rows[stage] {
+				t.Errorf("%v: no statistics of stage %s in:\n%s", args, stage, out.String())
+			}
+		}
+	}
+	if err := runFls(t, "bench", "--runs", "0"); exitCode(err) != exitUsage {
+		t.Errorf("--runs 0: error %v, expected a usage error", err)
+	}
+}
+
+// TestPercentile checks the nearest-rank percentiles of the samples.
+func TestPercentile(t *testing.T) {
+	ds := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for _, tt := range []struct {
+		p    int
+		want time.Duration
+	}{{50, 5}, {95, 10}, {10, 1}, {0, 1}, {100, 10}} {
+		if got := percentile(ds, tt.p); got != tt.want {
+			t.Errorf("percentile %d: %d, expected %d", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(ds[:1], 95); got != 1 {
+		t.Errorf("percentile 95 of one sample: %d, expected 1", got)
+	}
+}
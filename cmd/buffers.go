@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// buffers holds the byte buffers of the encoded images, read or produced,
+// reused between the images of a batch.
+var buffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// getBuffer returns an empty buffer from buffers.
+func getBuffer() *bytes.Buffer {
+	buf := buffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer hands the encoded image data, obtained from getBuffer, back to
+// buffers. data must not be used afterwards.
+func putBuffer(data []byte) {
+	if cap(data) > 0 {
+		buffers.Put(bytes.NewBuffer(data[:0]))
+	}
+}
+
+// release hands the images and the encoded result of r back for reuse by the
+// processing of the next images, once r is written and reported.
+func (r *rendered) release() {
+	dither.Release(r.src)
+	if r.result != r.src {
+		dither.Release(r.result)
+	}
+	putBuffer(r.encoded)
+	*r = rendered{}
+}
@@ -0,0 +1,331 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// comparisonColors is the size of the adaptive palette of the original frame
+// of a comparison GIF, the largest a GIF frame can have.
+const comparisonColors = 256
+
+// writeComparison writes at path the looping GIF alternating the source of r,
+// quantized to an adaptive palette, with the result of r and, with
+// --compare-algorithms, the results of the other algorithms, each frame shown
+// for --compare-delay.
+func writeComparison(ctx context.Context, path string, r *rendered, o *options) error {
+	frames := make([]*image.Paletted, 0, 2)
+	if p := dither.MedianCut(r.src, comparisonColors); len(p) > 0 {
+		original, err := dither.Reduce(ctx, r.src, p, "nearest")
+		if err != nil {
+			return err
+		}
+		defer dither.Release(original)
+		frames = append(frames, original)
+	}
+	result := r.result.(*image.Paletted)
+	frames = append(frames, result)
+	if o.compareAlgorithms {
+		for _, alg := range dither.Algorithms() {
+			if alg == o.Algorithm {
+				continue
+			}
+			dst, err := dither.ReduceScreened(ctx, r.src, result.Palette, alg, o.Diffusion, o.Screen)
+			if err != nil {
+				return err
+			}
+			defer dither.Release(dst)
+			frames = append(frames, dst)
+		}
+	}
+
+	// GIF delays are in hundredths of a second.
+	delay := int(o.compareDelay / (10 * time.Millisecond))
+	g := &gif.GIF{Image: frames, Delay: make([]int, len(frames))}
+	for i := range g.Delay {
+		g.Delay[i] = delay
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+var compareCmd = &cobra.Command{
+	Use:   "compare <input>",
+	Short: "Lay out the results of several algorithms and palettes on a contact sheet",
+	Long: `Dither an image with each of the --algorithms, all of them by default, to each
+of the --palettes, black and white by default, and write a contact sheet of
+the scaled source followed by the results, each labeled with its settings, as
+a PNG image in the current directory named after the input, or at
+--output. The scaling and the adjustments are the ones of the dither command,
+and the sheet has --columns columns, about as many as its rows by default.
+
+With --metrics, the mean squared error, the PSNR and the SSIM of each result
+low-pass filtered against the grayscale source are printed too, to pick the
+settings best reproducing the source.`,
+	Args:              usageArgs(cobra.ExactArgs(1)),
+	ValidArgsFunction: completeImageFiles,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+		o, err := newOptions(cmd.Flags(), modeResize, path)
+		if err != nil {
+			return err
+		}
+		if o.Format != "png" {
+			return withExitCode(exitUsage, fmt.Errorf("the contact sheet is a PNG image, not %s", o.Format))
+		}
+		sheet, err := newContactSheet(cmd.Flags())
+		if err != nil {
+			return err
+		}
+
+		file, err := openInput(path)
+		if err != nil {
+			return withExitCode(exitDecode, fmt.Errorf("reading file %q: %w", path, err))
+		}
+		defer file.Close()
+		br := bufio.NewReader(file)
+		img, _, err := decode(path, inputFormat(path, br), br, decodeOptions(o))
+		if err != nil {
+			return err
+		}
+		p, err := pipeline(o, &rendered{})
+		if err != nil {
+			return err
+		}
+		src, err := p.Run(cmd.Context(), img)
+		if err != nil {
+			return err
+		}
+		ms, err := sheet.render(cmd.Context(), src, o.metricsSigma)
+		if err != nil {
+			return err
+		}
+
+		output := o.output
+		if output == "" {
+			output = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + "_compare.png"
+		}
+		data, err := dither.EncodePNG(sheet.img)
+		if err != nil {
+			return withExitCode(exitWrite, fmt.Errorf("encoding contact sheet: %w", err))
+		}
+		log.Info().Msgf("writing contact sheet at path %q", output)
+		if err := writeOutput(cmd, output, data); err != nil {
+			return err
+		}
+		if sheet.metrics {
+			w := cmd.OutOrStdout()
+			if output == stdio {
+				w = stderr
+			}
+			return printComparisonMetrics(w, ms)
+		}
+		return nil
+	},
+}
+
+// A contactSheet lays out the results of combinations of algorithms and
+// palettes next to their source.
+type contactSheet struct {
+	algorithms []string
+	palettes   []color.Palette
+	names      []string // of the palettes
+	diffusion  dither.Diffusion
+	screen     dither.Screen
+	columns    int
+	metrics    bool
+
+	img *image.RGBA
+}
+
+// comparisonMetrics are the metrics of the result of an algorithm to a
+// palette.
+type comparisonMetrics struct {
+	Palette string
+	namedMetrics
+}
+
+// Layout of the cells of a contact sheet, in pixels.
+const (
+	sheetGap   = 8
+	lineHeight = 14
+)
+
+// newContactSheet returns the contact sheet of the flags of the compare
+// command fs.
+func newContactSheet(fs *pflag.FlagSet) (*contactSheet, error) {
+	f := flagReader{fs: fs}
+	s := &contactSheet{
+		diffusion: dither.Diffusion{Strength: f.float64("strength"), Serpentine: f.bool("serpentine")},
+		screen:    dither.Screen{Threshold: f.float64("threshold"), DotSize: f.float64("dot-size"), Angle: f.float64("screen-angle"), Seed: f.int64("seed")},
+		columns:   f.int("columns"),
+		metrics:   f.bool("metrics"),
+	}
+	algorithms, err := fs.GetStringSlice("algorithms")
+	if err != nil {
+		return nil, err
+	}
+	specs, err := fs.GetStringArray("palettes")
+	if err != nil {
+		return nil, err
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(algorithms) == 0 {
+		algorithms = dither.Algorithms()
+	}
+	for _, alg := range algorithms {
+		if _, ok := dither.Lookup(alg); !ok {
+			return nil, withExitCode(exitUsage, fmt.Errorf("unknown algorithm %q, expected one of %v", alg, dither.Algorithms()))
+		}
+	}
+	s.algorithms = algorithms
+	if len(specs) == 0 {
+		specs = []string{"bw"}
+	}
+	for _, spec := range specs {
+		var p color.Palette
+		if levels, err := strconv.Atoi(spec); err == nil {
+			p, err = resolvePalette("", levels)
+			spec = fmt.Sprintf("%d grays", levels)
+		} else {
+			p, err = resolvePalette(spec, 0)
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.palettes = append(s.palettes, p)
+		s.names = append(s.names, spec)
+	}
+	if s.columns < 0 {
+		return nil, withExitCode(exitUsage, fmt.Errorf("invalid --columns %d, must not be negative", s.columns))
+	}
+	return s, nil
+}
+
+// render draws the contact sheet of src and returns the metrics of the
+// results, computed with the low-pass filter of standard deviation sigma
+// when s.metrics.
+func (s *contactSheet) render(ctx context.Context, src image.Image, sigma float64) ([]comparisonMetrics, error) {
+	labels := [][]string{{"source"}}
+	for _, name := range s.names {
+		for _, alg := range s.algorithms {
+			if len(s.names) > 1 {
+				labels = append(labels, []string{alg, name})
+			} else {
+				labels = append(labels, []string{alg})
+			}
+		}
+	}
+	face := basicfont.Face7x13
+	b := src.Bounds()
+	cellW, cellH := b.Dx(), b.Dy()+lineHeight*len(labels[len(labels)-1])
+	for _, l := range labels {
+		for _, line := range l {
+			if w := font.MeasureString(face, line).Ceil(); w > cellW {
+				cellW = w
+			}
+		}
+	}
+	columns := s.columns
+	if columns == 0 {
+		columns = int(math.Ceil(math.Sqrt(float64(len(labels)))))
+	}
+	if columns > len(labels) {
+		columns = len(labels)
+	}
+	rows := (len(labels) + columns - 1) / columns
+	s.img = image.NewRGBA(image.Rect(0, 0, columns*(cellW+sheetGap)+sheetGap, rows*(cellH+sheetGap)+sheetGap))
+	draw.Draw(s.img, s.img.Rect, image.White, image.Point{}, draw.Src)
+
+	// cell draws img, or src when nil, with its label in the i-th cell.
+	cell := func(i int, img image.Image) {
+		at := image.Pt(sheetGap+i%columns*(cellW+sheetGap), sheetGap+i/columns*(cellH+sheetGap))
+		draw.Draw(s.img, b.Sub(b.Min).Add(at), img, b.Min, draw.Over)
+		d := font.Drawer{Dst: s.img, Src: image.Black, Face: face}
+		for j, line := range labels[i] {
+			d.Dot = fixed.P(at.X, at.Y+b.Dy()+lineHeight*j+face.Ascent+1)
+			d.DrawString(line)
+		}
+	}
+	cell(0, src)
+	var ms []comparisonMetrics
+	i := 1
+	for k, p := range s.palettes {
+		for _, alg := range s.algorithms {
+			dst, err := dither.ReduceScreened(ctx, src, p, alg, s.diffusion, s.screen)
+			if err != nil {
+				return nil, err
+			}
+			cell(i, dst)
+			i++
+			if s.metrics {
+				ms = append(ms, comparisonMetrics{s.names[k], namedMetrics{alg, dither.ComputeMetrics(src, dst, sigma)}})
+			}
+			dither.Release(dst)
+		}
+	}
+	return ms, nil
+}
+
+func printComparisonMetrics(w io.Writer, ms []comparisonMetrics) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PALETTE\tALGORITHM\tMSE\tPSNR (dB)\tSSIM")
+	for _, m := range ms {
+		fmt.Fprintf(tw, "%s\t%s\t%.6f\t%.3f\t%.4f\n", m.Palette, m.Algorithm, m.MSE, m.PSNR, m.SSIM)
+	}
+	return tw.Flush()
+}
+
+func init() {
+	compareCmd.Flags().StringSliceP("algorithms", "a", nil, "Algorithms compared, see the algorithms command (default all)")
+	_ = compareCmd.RegisterFlagCompletionFunc("algorithms", completeAlgorithms)
+	compareCmd.Flags().StringArray("palettes", nil, "Palette compared, which may be repeated: a name from the palettes command, hex colors like #ff8000,#000, a palette file or a number of levels of gray (default black and white)")
+	_ = compareCmd.RegisterFlagCompletionFunc("palettes", completePalettes)
+	compareCmd.Flags().Int("columns", 0, "Number of columns of the contact sheet, 0 for about as many as its rows")
+	compareCmd.Flags().Bool("metrics", false, "Print the MSE, PSNR and SSIM of the low-pass filtered results against the grayscale source")
+	compareCmd.Flags().Float64("metrics-sigma", 1., "Standard deviation in pixels of the Gaussian low-pass filter used by --metrics")
+	compareCmd.Flags().Float32P("scale", "s", 1., "Scaling coefficient")
+	compareCmd.Flags().Int("width", 0, "Width of the source in pixels, instead of --scale; the height follows the aspect ratio unless --height is set")
+	compareCmd.Flags().Int("height", 0, "Height of the source in pixels, instead of --scale; the width follows the aspect ratio unless --width is set")
+	compareCmd.Flags().String("fit", "", "Largest size of the source keeping the aspect ratio, as WxH like 800x600, instead of --scale")
+	addFilterFlag(compareCmd)
+	compareCmd.Flags().Float64("brightness", 0, "Brightness added to the scaled image, from -1 to 1")
+	compareCmd.Flags().Float64("contrast", 0, "Contrast change of the scaled image, from -1 for a flat gray to 1 for a threshold")
+	compareCmd.Flags().Float64("gamma", 1, "Gamma correction of the scaled image, lightening its midtones above 1 and darkening them below")
+	compareCmd.Flags().Bool("auto-contrast", false, "Stretch the tones of the scaled image to the full range from black to white, before the other adjustments")
+	addDiffusionFlags(compareCmd)
+	addScreenFlags(compareCmd)
+	compareCmd.Flags().Int("threads", 0, "Number of threads scaling the image, 0 for one per CPU")
+	addMaxPixelsFlag(compareCmd)
+	addAssumeSRGBFlag(compareCmd)
+	compareCmd.Flags().Bool("no-auto-orient", false, "Leave the image as it is encoded instead of turning it according to its EXIF orientation")
+	rootCmd.AddCommand(compareCmd)
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// inputExtensions returns the extensions of the image and archive files fls
+// can read, as offered by shell completion.
+func inputExtensions() []string {
+	var exts []string
+	for _, ext := range dither.Extensions {
+		exts = append(exts, strings.TrimPrefix(ext, "."))
+	}
+	return append(exts, "zip", "tar", "tar.gz", "tgz")
+}
+
+// outputExtensions returns the extensions of the output formats, without
+// their leading dot, for the completion of output file names.
+func outputExtensions() []string {
+	var exts []string
+	for _, ext := range dither.OutputExtensions() {
+		exts = append(exts, strings.TrimPrefix(ext, "."))
+	}
+	return exts
+}
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish|powershell",
+	Short: "Generate the autocompletion script for the specified shell",
+	Long: `Generate the autocompletion script for fls for the specified shell.
+
+Bash:
+  $ source <(fls completion bash)
+  # or, to load completions for each session (Linux):
+  $ fls completion bash > /etc/bash_completion.d/fls
+
+Zsh:
+  $ fls completion zsh > "${fpath[1]}/_fls"
+
+Fish:
+  $ fls completion fish > ~/.config/fish/completions/fls.fish
+
+PowerShell:
+  PS> fls completion powershell | Out-String | Invoke-Expression`,
+	DisableFlagsInUseLine: true,
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	Args:                  cobra.ExactValidArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+		switch args[0] {
+		case "bash":
+			return cmd.Root().GenBashCompletionV2(out, true)
+		case "zsh":
+			return cmd.Root().GenZshCompletion(out)
+		case "fish":
+			return cmd.Root().GenFishCompletion(out, true)
+		default:
+			return cmd.Root().GenPowerShellCompletionWithDesc(out)
+		}
+	},
+}
+
+// completeImageFiles completes positional arguments to the image files fls
+// can read.
+func completeImageFiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return inputExtensions(), cobra.ShellCompDirectiveFilterFileExt
+}
+
+// completeFileExt returns a flag completion function offering the files with
+// one of the given extensions.
+func completeFileExt(exts ...string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return exts, cobra.ShellCompDirectiveFilterFileExt
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+}
@@ -0,0 +1,348 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the fls configuration",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration",
+	Long: `Print the effective configuration obtained by merging, in increasing order of
+precedence, the built-in defaults, the configuration file, the FLS_* environment
+variables, the selected profile and the flags given on the command line.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conf, err := readConfig(cmd)
+		if err != nil {
+			return withExitCode(exitUsage, err)
+		}
+		// The flags of the other commands are not parsed: apply the
+		// configuration to them as well to show all the settings.
+		settings := make(map[string]interface{})
+		var walk func(c *cobra.Command) error
+		walk = func(c *cobra.Command) error {
+			if c != cmd {
+				if err := applyConfig(conf, c); err != nil {
+					return withExitCode(exitUsage, err)
+				}
+			}
+			configurableFlags(c).VisitAll(func(f *pflag.Flag) {
+				if _, ok := settings[f.Name]; !ok {
+					settings[f.Name] = flagValue(f)
+				}
+			})
+			for _, sub := range c.Commands() {
+				if err := walk(sub); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := walk(cmd.Root()); err != nil {
+			return err
+		}
+		out, err := yaml.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		if used := conf.v.ConfigFileUsed(); used != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "# config file: %s\n", used)
+		}
+		if conf.profile != nil {
+			fmt.Fprintf(cmd.OutOrStdout(), "# profile: %s\n", conf.name)
+		}
+		_, err = cmd.OutOrStdout().Write(out)
+		return err
+	},
+}
+
+// configPaths returns the locations searched for a configuration file when
+// --config is not given, in order of preference.
+func configPaths() []string {
+	paths := []string{".fls.yaml"}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".config", "fls", "config.yaml"))
+	}
+	return paths
+}
+
+// configurableFlags returns the flags of cmd that may be set from the
+// configuration file or the environment.
+func configurableFlags(cmd *cobra.Command) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(cmd.Name(), pflag.ContinueOnError)
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Name == "config" || f.Name == "help" {
+			return
+		}
+		fs.AddFlag(f)
+	})
+	return fs
+}
+
+// flagValue returns the value of f with its natural type so that it marshals
+// the way it would be written in a configuration file.
+func flagValue(f *pflag.Flag) interface{} {
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		return sv.GetSlice()
+	}
+	s := f.Value.String()
+	switch f.Value.Type() {
+	case "bool":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	case "int", "int8", "int16", "int32", "int64":
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	case "float32", "float64":
+		if x, err := strconv.ParseFloat(s, 64); err == nil {
+			return x
+		}
+	}
+	return s
+}
+
+// config holds the settings of the configuration file and the environment,
+// and those of the selected profile, which take precedence over them.
+type config struct {
+	v       *viper.Viper
+	name    string       // the name of the profile
+	profile *viper.Viper // nil without a profile
+}
+
+// readConfig reads the configuration of cmd with readConfigFile, and selects
+// the profile named by the --profile flag, or else by the profile setting.
+func readConfig(cmd *cobra.Command) (*config, error) {
+	v, err := readConfigFile(cmd)
+	if err != nil {
+		return nil, err
+	}
+	c := &config{v: v}
+	if c.name, err = cmd.Flags().GetString("profile"); err != nil {
+		return nil, err
+	}
+	if c.name == "" {
+		c.name = v.GetString("profile")
+	}
+	if c.name == "" {
+		return c, nil
+	}
+	// The names are read as map keys instead of with v.Sub, as they may
+	// have dots like "eink-2.13in", and viper lowercases the keys.
+	settings, ok := v.GetStringMap("profiles")[strings.ToLower(c.name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q, expected one of %v", c.name, profileNames(v))
+	}
+	m, ok := stringMap(settings)
+	if !ok {
+		return nil, fmt.Errorf("profile %q is not a map of settings", c.name)
+	}
+	c.profile = viper.New()
+	if err := c.profile.MergeConfigMap(m); err != nil {
+		return nil, fmt.Errorf("reading profile %q: %w", c.name, err)
+	}
+	return c, nil
+}
+
+// readConfigFile reads the configuration file given by the --config flag of
+// cmd, or found at one of the configPaths, and sets up the lookup of the
+// FLS_* environment variables.
+func readConfigFile(cmd *cobra.Command) (*viper.Viper, error) {
+	v := viper.New()
+	v.SetEnvPrefix("FLS")
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+
+	file, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return nil, err
+	}
+	if file == "" {
+		for _, path := range configPaths() {
+			if _, err := os.Stat(path); err == nil {
+				file = path
+				break
+			}
+		}
+	}
+	if file != "" {
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("reading config file %q: %w", file, err)
+		}
+	}
+	return v, nil
+}
+
+// profileNames returns the sorted names of the profiles of the
+// configuration v.
+func profileNames(v *viper.Viper) []string {
+	var names []string
+	for name := range v.GetStringMap("profiles") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stringMap returns the settings of a profile read from the configuration
+// file, whose nested maps YAML decodes with keys of any type.
+func stringMap(settings interface{}) (map[string]interface{}, bool) {
+	switch s := settings.(type) {
+	case map[string]interface{}:
+		return s, true
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(s))
+		for k, v := range s {
+			m[fmt.Sprint(k)] = v
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+// exclusiveFlags are the groups of flags setting the same thing in different
+// ways: one of a group given explicitly keeps the others from being set from
+// the configuration.
+var exclusiveFlags = [][]string{
+	{"scale", "width", "height", "fit"},
+	{"palette", "levels", "colors", "device"},
+}
+
+// overridden reports whether set reports a flag of the exclusive group of
+// the named flag.
+func overridden(name string, set func(name string) bool) bool {
+	for _, group := range exclusiveFlags {
+		for _, n := range group {
+			if n != name {
+				continue
+			}
+			for _, other := range group {
+				if set(other) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// applyConfig sets every flag of cmd that was not given explicitly, nor
+// overridden by one of its exclusiveFlags, from the configuration c: from
+// its profile when the profile sets the flag or one of its group, and else
+// from the configuration file or the environment.
+func applyConfig(c *config, cmd *cobra.Command) error {
+	changed := func(name string) bool {
+		f := cmd.Flags().Lookup(name)
+		return f != nil && f.Changed
+	}
+	var err error
+	configurableFlags(cmd).VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || overridden(f.Name, changed) {
+			return
+		}
+		v, from := c.v, "configuration"
+		if c.profile != nil && (c.profile.IsSet(f.Name) || overridden(f.Name, c.profile.IsSet)) {
+			v, from = c.profile, fmt.Sprintf("profile %q", c.name)
+		}
+		if !v.IsSet(f.Name) {
+			return
+		}
+		if serr := setFromConfig(cmd.Flags(), f, v.Get(f.Name)); serr != nil {
+			err = fmt.Errorf("invalid value for %q from %s: %w", f.Name, from, serr)
+		}
+	})
+	return err
+}
+
+// setFromConfig sets the flag f of fs to the value of a setting of the
+// configuration: a scalar, or a list for the flags taking several values,
+// which replaces their defaults.
+func setFromConfig(fs *pflag.FlagSet, f *pflag.Flag, value interface{}) error {
+	sv, many := f.Value.(pflag.SliceValue)
+	var items []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		items = v
+	case []string:
+		for _, s := range v {
+			items = append(items, s)
+		}
+	default:
+		s, err := configScalar(value)
+		if err != nil {
+			return err
+		}
+		return fs.Set(f.Name, s)
+	}
+	if !many {
+		return fmt.Errorf("a list of %d values where one %s is expected", len(items), f.Value.Type())
+	}
+	values := make([]string, len(items))
+	for i, item := range items {
+		s, err := configScalar(item)
+		if err != nil {
+			return err
+		}
+		values[i] = s
+	}
+	if err := sv.Replace(values); err != nil {
+		return err
+	}
+	f.Changed = true
+	return nil
+}
+
+// configScalar returns the text of a scalar value of the configuration, as
+// it would be given on the command line.
+func configScalar(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("%T is not a value of a flag", value)
+}
+
+// loadConfig applies the configuration file, the FLS_* environment
+// variables and the selected profile to the flags of cmd.
+func loadConfig(cmd *cobra.Command) error {
+	c, err := readConfig(cmd)
+	if err != nil {
+		return err
+	}
+	return applyConfig(c, cmd)
+}
+
+func init() {
+	rootCmd.PersistentFlags().String("config", "", "Path to configuration file (default .fls.yaml or ~/.config/fls/config.yaml)")
+	_ = rootCmd.RegisterFlagCompletionFunc("config", completeFileExt("yaml", "yml"))
+	rootCmd.PersistentFlags().String("profile", "", "Name of the profile of the configuration file whose settings apply, over those of the file and the environment")
+	_ = rootCmd.RegisterFlagCompletionFunc("profile", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		v, err := readConfigFile(cmd)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		return profileNames(v), cobra.ShellCompDirectiveNoFileComp
+	})
+
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+var devicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "List the e-paper panels of --device",
+	Args:  usageArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "DEVICE\tSIZE\tROTATION\tFRAMEBUFFER\tCOLORS\tDESCRIPTION")
+		for _, name := range dither.Devices() {
+			d, _ := dither.LookupDevice(name)
+			w, h := d.FramebufferSize()
+			fmt.Fprintf(tw, "%s\t%dx%d\t%d\t%dx%d, %d plane(s) of %d bit(s)\t%s\t%s\n",
+				name, d.Width, d.Height, d.Rotation, w, h, len(d.Planes), d.Bits, paletteSummary(d.Palette), d.Description)
+		}
+		return tw.Flush()
+	},
+}
+
+// resolveDevice sets the device of the --device flag of o, with the rotation
+// of --rotation if set: its palette, unless another one is set, and its
+// size, unless the result is scaled otherwise.
+func resolveDevice(f *flagReader, o *options) error {
+	name := f.string("device")
+	if name == "" || f.err != nil {
+		return nil
+	}
+	d, ok := dither.LookupDevice(name)
+	if !ok {
+		return withExitCode(exitUsage, fmt.Errorf("unknown device %q, expected one of %v", name, dither.Devices()))
+	}
+	if f.fs.Changed("rotation") {
+		switch r := f.int("rotation"); r {
+		case 0, 90, 180, 270:
+			d.Rotation = r
+		default:
+			return withExitCode(exitUsage, fmt.Errorf("invalid --rotation %d, must be 0, 90, 180 or 270", r))
+		}
+	}
+	if f.string("palette") != "" || f.int("levels") != 0 || o.Colors != 0 {
+		return withExitCode(exitUsage, errors.New("--device sets the palette, it cannot be used with --palette, --levels or --colors"))
+	}
+	o.Palette = d.Palette
+	if o.Scale == 1 && o.Width == 0 && o.Height == 0 {
+		o.Width, o.Height, o.Fit = d.Width, d.Height, true
+	}
+	o.Encoding.Device = &d
+	return nil
+}
+
+func completeDevices(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return dither.Devices(), cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	for _, c := range []*cobra.Command{ditherCmd, quantizeCmd, watchCmd} {
+		c.Flags().String("device", "", "E-paper panel the result is made for, see the devices command: its palette and size, and its framebuffer for the raw, c and go output formats")
+		_ = c.RegisterFlagCompletionFunc("device", completeDevices)
+		c.Flags().Int("rotation", 0, "Clockwise rotation in degrees of the result into the framebuffer of --device, 0, 90, 180 or 270 (default the one of the device)")
+	}
+	rootCmd.AddCommand(devicesCmd)
+}
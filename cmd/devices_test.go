@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDevice checks the size of the framebuffers written with --device,
+// turned by the rotation of the device or the one of --rotation.
+func TestDevice(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writeTestPNG(t, in, 40, 30)
+	for i, tt := range []struct {
+		args []string
+		size int
+	}{
+		// Two planes of 250 rows of 122 pixels, in 16 bytes.
+		{[]string{"--device", "waveshare-2.13b"}, 2 * 250 * 16},
+		// Two planes of 122 rows of 250 pixels, in 32 bytes.
+		{[]string{"--device", "waveshare-2.13b", "--rotation", "0"}, 2 * 122 * 32},
+		{[]string{"--device", "waveshare-5.65f"}, 448 * 300},
+	} {
+		out := filepath.Join(dir, fmt.Sprintf("out%d.raw", i))
+		if err := runFls(t, append([]string{in, "-o", out}, tt.args...)...); err != nil {
+			t.Fatalf("%q: %v", tt.args, err)
+		}
+		data, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != tt.size {
+			t.Errorf("%q: framebuffer of %d bytes, expected %d", tt.args, len(data), tt.size)
+		}
+	}
+
+	for _, tt := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"--device", "waveshare-1"}, `unknown device "waveshare-1"`},
+		{[]string{"--device", "waveshare-2.9", "--rotation", "45"}, "invalid --rotation 45"},
+		{[]string{"--device", "waveshare-2.9", "--levels", "4"}, "--device sets the palette"},
+	} {
+		err := runFls(t, append([]string{in, "-o", filepath.Join(dir, "failed.raw")}, tt.args...)...)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) || exitCode(err) != exitUsage {
+			t.Errorf("%q: error %v of exit code %d, expected %q", tt.args, err, exitCode(err), tt.err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// plan describes what a run would do with one input file.
+type plan struct {
+	Input     string
+	Format    string
+	Bounds    image.Rectangle
+	Output    string
+	OutSize   image.Rectangle
+	OutFormat string
+	Notes     []string // worth knowing but not preventing the run
+	Problems  []string // would make the run fail or lose data
+}
+
+// planFile inspects the header of the image at path, without decoding its
+// pixels, and computes where and at which size the result of its processing
+// with o would be written.
+func planFile(in input, o *options) plan {
+	path := in.path
+	p := plan{Input: path, Output: o.outputPath(in), OutFormat: o.Format}
+	file, err := openInput(path)
+	if err != nil {
+		p.Problems = append(p.Problems, err.Error())
+		return p
+	}
+	defer file.Close()
+	br := bufio.NewReader(file)
+	if inputFormat(path, br) == "" {
+		p.Problems = append(p.Problems, fmt.Sprintf("image type %s not supported", filepath.Ext(path)))
+	}
+	r, ok := file.(io.ReaderAt)
+	if !ok {
+		// The standard input, whose header is read again for its
+		// orientation.
+		data, err := io.ReadAll(br)
+		if err != nil {
+			p.Problems = append(p.Problems, fmt.Sprintf("reading image: %v", err))
+			return p
+		}
+		r = bytes.NewReader(data)
+	}
+	if !p.inspect(r, o) {
+		return p
+	}
+
+	switch {
+	case p.Output == stdio:
+	case abs(p.Output) == abs(p.Input):
+		p.Problems = append(p.Problems, "output would overwrite the input")
+	default:
+		p.checkExisting()
+	}
+	return p
+}
+
+// inspect reads the image header from r to fill in the sizes of p for a
+// result processed with o, the image turned by its EXIF orientation like when
+// it is decoded, and reports whether it succeeded and the image is within the
+// pixel limit of o.
+func (p *plan) inspect(r io.ReaderAt, o *options) bool {
+	cfg, format, err := image.DecodeConfig(io.NewSectionReader(r, 0, math.MaxInt64))
+	if err != nil {
+		p.Problems = append(p.Problems, fmt.Sprintf("reading image header: %v", err))
+		return false
+	}
+	p.Format = format
+	p.Bounds = image.Rect(0, 0, cfg.Width, cfg.Height)
+	if (format == "jpeg" || format == "png") && !o.IgnoreOrientation {
+		if info, err := dither.Inspect(r); err == nil && info.Orientation > 1 {
+			if info.Orientation >= 5 {
+				p.Bounds = image.Rect(0, 0, cfg.Height, cfg.Width)
+			}
+			p.Notes = append(p.Notes, fmt.Sprintf("turned by its EXIF orientation %d", info.Orientation))
+		}
+	}
+	p.OutSize = o.ResultBounds(p.Bounds)
+	if n := int64(cfg.Width) * int64(cfg.Height); o.MaxPixels > 0 && n > o.MaxPixels {
+		p.Problems = append(p.Problems, fmt.Sprintf("%d pixels over the --max-pixels limit of %d", n, o.MaxPixels))
+		return false
+	}
+	return true
+}
+
+// checkExisting notes that the output of p already exists.
+func (p *plan) checkExisting() {
+	if _, err := os.Stat(p.Output); err == nil {
+		p.Notes = append(p.Notes, "overwrites existing output")
+	}
+}
+
+func abs(path string) string {
+	if a, err := filepath.Abs(path); err == nil {
+		return a
+	}
+	return path
+}
+
+// checkCollisions reports the plans writing to the same output file.
+func checkCollisions(plans []plan) {
+	seen := make(map[string]int)
+	for i := range plans {
+		out := abs(plans[i].Output)
+		if j, ok := seen[out]; ok {
+			plans[i].Problems = append(plans[i].Problems, fmt.Sprintf("output collides with the one of %q", plans[j].Input))
+			continue
+		}
+		seen[out] = i
+	}
+}
+
+// printPlans writes plans as a table and returns the number of problems found.
+func printPlans(w io.Writer, plans []plan) (int, error) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INPUT\tFORMAT\tSIZE\tOUTPUT\tOUT SIZE\tOUT FORMAT\tSTATUS")
+	problems := 0
+	for _, p := range plans {
+		status := "ok"
+		if msgs := append(append([]string(nil), p.Problems...), p.Notes...); len(msgs) > 0 {
+			status = strings.Join(msgs, "; ")
+		}
+		problems += len(p.Problems)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			p.Input, orDash(p.Format), size(p.Bounds), p.Output, size(p.OutSize), p.OutFormat, status)
+	}
+	return problems, tw.Flush()
+}
+
+func size(r image.Rectangle) string {
+	if r.Empty() {
+		return "-"
+	}
+	return fmt.Sprintf("%dx%d", r.Dx(), r.Dy())
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
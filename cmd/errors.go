@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// Exit codes returned by fls, documented in the root command help.
+const (
+	exitFailure     = 1
+	exitUsage       = 2
+	exitDecode      = 3
+	exitWrite       = 4
+	exitUnsupported = 5
+
+	exitInterrupted = 130
+)
+
+const exitCodesHelp = `Exit status:
+    0  success
+    1  unspecified failure
+    2  invalid arguments, flags or configuration
+    3  the input image could not be read or decoded
+    4  the output could not be encoded or written
+    5  the format of the input image is not supported
+  130  interrupted by SIGINT or SIGTERM
+
+With several inputs, the failing ones are skipped, or stop the processing
+with --strict, and the status is the one of the first failure.`
+
+// exitError attaches the process exit code to an error.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit code the process should terminate with for err:
+// the one attached with withExitCode or, failing that, the one matching the
+// kind of the library error.
+func exitCode(err error) int {
+	var (
+		e  *exitError
+		de *dither.DecodeError
+		ee *dither.EncodeError
+		ve *dither.ValidationError
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.As(err, &e):
+		return e.code
+	case errors.Is(err, dither.ErrUnsupportedFormat):
+		return exitUnsupported
+	case errors.As(err, &de):
+		return exitDecode
+	case errors.As(err, &ee):
+		return exitWrite
+	case errors.As(err, &ve):
+		return exitUsage
+	}
+	return exitFailure
+}
+
+// usageArgs wraps a positional arguments validator so that its errors are
+// reported as usage errors.
+func usageArgs(args cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, a []string) error {
+		return withExitCode(exitUsage, args(cmd, a))
+	}
+}
+
+// failureKinds name the failures of the inputs by exit code, for the summary
+// of processInputs.
+var failureKinds = map[int]string{
+	exitUsage:       "invalid settings",
+	exitDecode:      "decoding",
+	exitWrite:       "writing",
+	exitUnsupported: "unsupported format",
+}
+
+// failureSummary returns the counts of the kinds of the failures errs, like
+// "decoding: 2, writing: 1", in the order of the exit codes.
+func failureSummary(errs []error) string {
+	counts := make(map[int]int)
+	for _, err := range errs {
+		counts[exitCode(err)]++
+	}
+	codes := make([]int, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		kind, ok := failureKinds[code]
+		if !ok {
+			kind = "other"
+		}
+		parts[i] = fmt.Sprintf("%s: %d", kind, counts[code])
+	}
+	return strings.Join(parts, ", ")
+}
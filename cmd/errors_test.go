@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+func TestExitCode(t *testing.T) {
+	decodeErr := &dither.DecodeError{Path: "in.png", Format: "png", Err: errors.New("truncated")}
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{errors.New("failure"), exitFailure},
+		{withExitCode(exitWrite, errors.New("disk full")), exitWrite},
+		{fmt.Errorf("batch: %w", withExitCode(exitUsage, errors.New("bad flag"))), exitUsage},
+		{fmt.Errorf("reading: %w", decodeErr), exitDecode},
+		{withExitCode(exitUsage, decodeErr), exitUsage},
+		{&dither.EncodeError{Path: "out.png", Err: errors.New("disk full")}, exitWrite},
+		{fmt.Errorf("%w: tga", dither.ErrUnsupportedFormat), exitUnsupported},
+		{&dither.ValidationError{Problems: []string{"invalid scale -1"}}, exitUsage},
+		{fmt.Errorf("processing: %w", context.Canceled), exitInterrupted},
+	} {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, expected %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFailureSummary(t *testing.T) {
+	errs := []error{
+		withExitCode(exitWrite, errors.New("disk full")),
+		&dither.DecodeError{Err: errors.New("truncated")},
+		errors.New("failure"),
+		&dither.DecodeError{Err: errors.New("truncated")},
+	}
+	if got, want := failureSummary(errs), "other: 1, decoding: 2, writing: 1"; got != want {
+		t.Errorf("failureSummary = %q, expected %q", got, want)
+	}
+}
+
+// TestDitherExitCodes checks the exit codes of the failures of the dither
+// command, and that no output is left by a failing one.
+func TestDitherExitCodes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in.png")
+	writeTestPNG(t, in, 16, 8)
+	text := filepath.Join(dir, "notes.txt")
+	if err := ioutil.WriteFile(text, []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.png")
+	for _, tt := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{filepath.Join(dir, "missing.png"), "-o", out}, exitDecode},
+		{[]string{text, "-o", out}, exitUnsupported},
+		{[]string{in, "--bogus"}, exitUsage},
+		{[]string{in, "--scale", "-1", "-o", out}, exitUsage},
+		{[]string{in, "-o", filepath.Join(dir, "out.xyz")}, exitUsage},
+		{[]string{in, "-o", filepath.Join(dir, "missing", "out.png")}, exitWrite},
+	} {
+		err := runFls(t, tt.args...)
+		if err == nil {
+			t.Errorf("%v: no error", tt.args)
+			continue
+		}
+		if got := exitCode(err); got != tt.want {
+			t.Errorf("%v: exit code %d for %v, expected %d", tt.args, got, err, tt.want)
+		}
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Errorf("%v: output left: %v", tt.args, err)
+		}
+	}
+
+	if err := runFls(t, in, "-o", out); err != nil {
+		t.Fatal(err)
+	}
+	if b := decodeTestPNG(t, out).Bounds(); b.Dx() != 16 || b.Dy() != 8 {
+		t.Errorf("result of bounds %v, expected 16x8", b)
+	}
+}
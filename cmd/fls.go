@@ -1,106 +1,177 @@
 package cmd
 
 import (
-	"bytes"
-	"image"
-	"image/color"
-	"image/jpeg"
-	"image/png"
-	"io/ioutil"
+	"context"
+	"errors"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 
-	"golang.org/x/image/draw"
-
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
-var (
-	scale      float32
-	outputPath string
-	verbose    bool
-)
-
 var rootCmd = &cobra.Command{
-	Use:   "fls <input_file>",
-	Short: "fls produces paletted black and white images using the Floyd-Steinberg dithering algorithm.",
-	Long: `fls produces paletted black and white images using the Floyd-Steinberg dithering
-algorithm. It is a simple wrapper around the built-in function of the
-golang.org/x/image/draw package. Rescaling is applied before the dithering with
-the nearest-neighbor algorithm.`,
-	Args: cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-
-		path := filepath.Clean(args[0])
-		log.Info().Msgf("read file %q", path)
-		data, err := ioutil.ReadFile(path)
-		if err != nil {
-			log.Fatal().Err(err).Msgf("reading file %q", path)
-		}
+	Use:   "fls",
+	Short: "fls reduces images to small palettes, dithering them for e-paper panels, retro palettes and print.",
+	Long: `fls reduces images to small palettes: it dithers them with error diffusion,
+Bayer matrices, blue noise or halftone dots, quantizes or resizes them, from
+files, directories, archives or the standard input, and writes paletted
+images, terminal previews or the framebuffers of e-paper panels. The watch
+command processes the images as they are added to directories, serve does it
+over HTTP, and compare lays out the results of several algorithms and
+palettes side by side.
 
-		var img image.Image
+The dither command is the default: "fls photo.jpg" is the same as
+"fls dither photo.jpg".
 
-		switch filepath.Ext(path) {
-		case ".png":
-			img, err = png.Decode(bytes.NewBuffer(data))
-			if err != nil {
-				log.Fatal().Err(err).Msgf("decoding png image %q", path)
-			}
-		case ".jpg", ".jpeg":
-			img, err = jpeg.Decode(bytes.NewBuffer(data))
-			if err != nil {
-				log.Fatal().Err(err).Msgf("decoding jpeg image %q", path)
-			}
-		default:
-			log.Fatal().Err(err).Msgf("image type %s not supported", filepath.Ext(path))
+Flags may also be set from a configuration file (.fls.yaml in the working
+directory or ~/.config/fls/config.yaml, or the file given with --config) whose
+keys mirror the flag names, and from FLS_* environment variables such as
+FLS_SCALE. Explicit flags take precedence over the environment, which takes
+precedence over the configuration file.
+
+The configuration file may also group settings in named profiles, selected by
+--profile, FLS_PROFILE or its profile key, whose settings take precedence over
+those of the file and the environment, but not over the explicit flags:
+
+  levels: 4
+  profiles:
+    eink-2.13in:
+      palette: bw
+      fit: 250x122
+
+The logs are written to stderr, as JSON lines with --log-format json for the
+scripts driving fls. With --verbose, each result is logged as a "processed"
+event with the file, the duration, the input and output dimensions and the
+bytes written. The progress is drawn as a bar when stderr is a terminal and
+the format is console, and logged as "progress" events every few seconds
+otherwise.
+
+` + exitCodesHelp,
+	ValidArgsFunction: completeImageFiles,
+}
+
+// defaultOutputPath returns the path the result for input is written to
+// when no output is given: the input base name with a _fls suffix and the
+// extension ext of the output format, such as _fls.png.
+func defaultOutputPath(input, ext string) string {
+	return strings.TrimSuffix(filepath.Base(input), filepath.Ext(input)) + "_fls" + ext
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringP("output", "o", "", "Path to output file")
+	_ = rootCmd.RegisterFlagCompletionFunc("output", completeFileExt(outputExtensions()...))
+	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "Set verbose execution")
+
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd); err != nil {
+			return withExitCode(exitUsage, err)
 		}
+		return withExitCode(exitUsage, setupLogging(cmd))
+	}
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return withExitCode(exitUsage, err)
+	})
+	rootCmd.SilenceErrors = true
+	rootCmd.SilenceUsage = true
+}
 
-		palette := color.Palette{color.White, color.Black}
-		rect := img.Bounds()
-		if scale != 1. {
-			log.Info().Float32("scale", scale).Msg("resizing")
-			rect = image.Rect(0, 0, int(float32(rect.Dx())*scale), int(float32(rect.Dy())*scale))
-			tmp := image.NewRGBA(rect)
-			draw.NearestNeighbor.Scale(tmp, rect, img, img.Bounds(), draw.Over, nil)
-			img = tmp
+// defaultCommand inserts the dither command in args when they don't name a
+// subcommand, so that "fls photo.jpg -s 0.5" keeps working. The arguments of
+// a completion request are rewritten alike, but for the word being
+// completed when it may be the beginning of a subcommand name.
+func defaultCommand(args []string) []string {
+	if len(args) > 0 && strings.HasPrefix(args[0], cobra.ShellCompRequestCmd) {
+		words := args[1:]
+		if len(words) > 0 && !strings.HasPrefix(words[len(words)-1], "-") {
+			words = words[:len(words)-1]
+		}
+		if len(words) > 0 && isDitherInvocation(words) {
+			return append([]string{args[0], ditherCmd.Name()}, args[1:]...)
 		}
-		dst := image.NewPaletted(rect, palette)
+		return args
+	}
+	if len(args) > 0 && isDitherInvocation(args) {
+		return append([]string{ditherCmd.Name()}, args...)
+	}
+	return args
+}
 
-		log.Info().Msg("applying Floyd-Steinberg dithering...")
-		draw.FloydSteinberg.Draw(dst, img.Bounds(), img, image.Point{})
+// isDitherInvocation reports whether args, which are not empty, are those of
+// the dither command without its name: they name no subcommand and start
+// with an input file or hold a flag of the dither command only.
+func isDitherInvocation(args []string) bool {
+	rootCmd.InitDefaultHelpCmd()
+	rootCmd.InitDefaultHelpFlag()
+	// Find fails on the root command only when given an unknown subcommand,
+	// that is for a legacy invocation with the input file first, and skips
+	// the standard input like a flag.
+	c, _, err := rootCmd.Find(args)
+	if c != rootCmd {
+		return false
+	}
+	return err != nil || hasArg(args, stdio) || hasUnknownFlag(rootCmd, args)
+}
 
-		if outputPath == "" {
-			outputPath = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + "_fls.png"
+// hasUnknownFlag reports whether args hold a flag that c does not define,
+// such as "--algorithm", which is one of the dither command.
+func hasUnknownFlag(c *cobra.Command, args []string) bool {
+	for _, a := range args {
+		if a == "--" {
+			return false
 		}
-		log.Info().Msgf("writing result PNG image at path %q", outputPath)
-		file, err := os.Create(outputPath)
-		if err != nil {
-			log.Fatal().Err(err).Msgf("creating output file %q", outputPath)
-		}
-		defer file.Close()
-		if err := png.Encode(file, dst); err != nil {
-			log.Fatal().Err(err).Msgf("writing png image in %q", outputPath)
+		name := strings.SplitN(strings.TrimLeft(a, "-"), "=", 2)[0]
+		switch {
+		case a == stdio || !strings.HasPrefix(a, "-") || name == "":
+		case strings.HasPrefix(a, "--"):
+			if c.Flags().Lookup(name) == nil {
+				return true
+			}
+		default:
+			if c.Flags().ShorthandLookup(name[:1]) == nil {
+				return true
+			}
 		}
-	},
+	}
+	return false
 }
 
-func init() {
-	rootCmd.PersistentFlags().Float32VarP(&scale, "scale", "s", 1., "Scaling coefficient")
-	rootCmd.PersistentFlags().StringVarP(&outputPath, "output", "o", "", "Path to output file")
-	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Set verbose execution")
-
-	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
-		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
-		if verbose {
-			zerolog.SetGlobalLevel(zerolog.InfoLevel)
+func hasArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
 		}
-		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	}
+	return false
+}
+
+// cancelOnSignal calls cancel on the first SIGINT or SIGTERM, then restores
+// their default handling so that a second one terminates fls immediately.
+func cancelOnSignal(cancel context.CancelFunc) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ch
+		signal.Stop(ch)
+		cancel()
+	}()
 }
 
 func Execute() {
-	cobra.CheckErr(rootCmd.Execute())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelOnSignal(cancel)
+
+	rootCmd.SetArgs(defaultCommand(os.Args[1:]))
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Error().Msg("interrupted")
+		} else {
+			log.Error().Msg(err.Error())
+		}
+		os.Exit(exitCode(err))
+	}
 }
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// TestMain keeps the configuration of the user from the commands run by the
+// tests.
+func TestMain(m *testing.M) {
+	home, err := ioutil.TempDir("", "fls-home")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "FLS_") {
+			os.Unsetenv(strings.SplitN(kv, "=", 2)[0])
+		}
+	}
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+// runFls runs fls with the command line args, quietly, and returns its
+// error. The flags are reset to their defaults afterwards.
+func runFls(t *testing.T, args ...string) error {
+	t.Helper()
+	defer resetFlags(rootCmd)
+	rootCmd.SetArgs(defaultCommand(append(args, "-q")))
+	return rootCmd.ExecuteContext(context.Background())
+}
+
+// resetFlags resets the flags of c and its subcommands to their defaults,
+// as if they were never set.
+func resetFlags(c *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			_ = sv.Replace(nil)
+		} else {
+			_ = f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	c.Flags().VisitAll(reset)
+	c.PersistentFlags().VisitAll(reset)
+	for _, sub := range c.Commands() {
+		resetFlags(sub)
+	}
+}
+
+// encodeTestPNG returns a w x h PNG image of a horizontal gray gradient.
+func encodeTestPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetGray(x, y, color.Gray{uint8(x * 255 / w)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeTestPNG writes the image of encodeTestPNG to path.
+func writeTestPNG(t *testing.T, path string, w, h int) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, encodeTestPNG(t, w, h), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// decodeTestPNG decodes the PNG file path.
+func decodeTestPNG(t *testing.T, path string) image.Image {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", filepath.Base(path), err)
+	}
+	return img
+}
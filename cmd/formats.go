@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+var formatsCmd = &cobra.Command{
+	Use:   "formats",
+	Short: "List the available output formats",
+	Args:  usageArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "FORMAT\tEXTENSIONS\tMEDIA TYPE")
+		for _, f := range dither.OutputFormats() {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Name, strings.Join(f.Extensions, " "), f.MediaType)
+		}
+		return tw.Flush()
+	},
+}
+
+// formatNames returns the names of the registered output formats.
+func formatNames() []string {
+	var names []string
+	for _, f := range dither.OutputFormats() {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func init() {
+	for _, c := range []*cobra.Command{ditherCmd, quantizeCmd, watchCmd} {
+		c.Flags().Bool("plain", false, "Write the plain variant of the pbm and pgm output formats, with ASCII numbers, instead of the binary one")
+		c.Flags().Int("row-align", 0, "Pad the rows of the raw output format to a multiple of this number of bytes")
+	}
+	rootCmd.AddCommand(formatsCmd)
+}
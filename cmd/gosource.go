@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"go/token"
+	"image"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// words splits the base name of path, without extension, on the characters
+// which are not ASCII letters or digits.
+func words(path string) []string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return strings.FieldsFunc(base, func(r rune) bool {
+		return r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// goPackageName returns the default package name of the Go source written at
+// path: its lowercased base name.
+func goPackageName(path string) string {
+	name := strings.ToLower(strings.Join(words(path), ""))
+	if !token.IsIdentifier(name) || unicode.IsDigit(rune(name[0])) {
+		name = "img" + name
+	}
+	return name
+}
+
+// goVarName returns the default prefix of the identifiers declared by the Go
+// source written at path: its base name in CamelCase.
+func goVarName(path string) string {
+	var b strings.Builder
+	for _, w := range words(path) {
+		b.WriteString(strings.ToUpper(w[:1]) + w[1:])
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "Image" + name
+	}
+	return name
+}
+
+// cVarName returns the default prefix of the identifiers declared by the C
+// header written at path: its lowercased base name in snake case.
+func cVarName(path string) string {
+	name := strings.ToLower(strings.Join(words(path), "_"))
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "image_" + name
+	}
+	return strings.TrimSuffix(name, "_")
+}
+
+// encode encodes img in the output format of o for the given output path,
+// framed for the device of o if any. The identifiers of the Go source and
+// C header outputs default to ones derived from path.
+func encode(img image.Image, o *options, path string) ([]byte, error) {
+	p, ok := img.(*image.Paletted)
+	if !ok {
+		return dither.EncodePNG(img)
+	}
+	opts := o.Encoding
+	switch o.Format {
+	case "go":
+		if opts.Package == "" {
+			opts.Package = goPackageName(path)
+		}
+		if opts.Name == "" {
+			opts.Name = goVarName(path)
+		}
+	case "c":
+		if opts.Name == "" {
+			opts.Name = cVarName(path)
+		}
+	}
+	if opts.Device != nil {
+		p = opts.Device.Frame(p)
+	}
+	buf := getBuffer()
+	if err := dither.Encode(buf, p, o.Format, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func init() {
+	for _, c := range []*cobra.Command{ditherCmd, quantizeCmd, watchCmd} {
+		c.Flags().String("go-package", "", "Package name of the Go source output (default derived from the output file name)")
+		c.Flags().String("go-var", "", "Prefix of the identifiers declared by the Go source output (default derived from the output file name)")
+		c.Flags().String("c-name", "", "Prefix of the identifiers declared by the C header output (default derived from the output file name)")
+	}
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+var infoCmd = &cobra.Command{
+	Use:   "info <input_file>...",
+	Short: "Print the properties of images",
+	Long: `Print the format, dimensions, color model, bit depth, palette size, resolution,
+EXIF orientation, ICC profile and frame or page count of images, read from
+their headers without decoding their pixels. GIF images are inspected too.`,
+	Args:              usageArgs(cobra.MinimumNArgs(1)),
+	ValidArgsFunction: completeImageFiles,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			return err
+		}
+		var (
+			infos  []fileInfo
+			failed []error
+		)
+		for _, arg := range args {
+			fi, err := inspectFile(filepath.Clean(arg))
+			if err != nil {
+				fi.Error = err.Error()
+				failed = append(failed, err)
+				if !asJSON && len(args) > 1 {
+					log.Error().Msg(err.Error())
+				}
+			}
+			infos = append(infos, fi)
+		}
+
+		if asJSON {
+			data, err := json.MarshalIndent(infos, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s\n", data)
+		} else if err := printInfos(cmd.OutOrStdout(), infos); err != nil {
+			return err
+		}
+		switch {
+		case len(failed) == 1 && len(args) == 1:
+			return failed[0]
+		case len(failed) > 0:
+			return withExitCode(exitDecode, fmt.Errorf("%d of the %d files could not be inspected", len(failed), len(args)))
+		}
+		return nil
+	},
+}
+
+// fileInfo holds the properties of an image file, as printed by the info
+// command.
+type fileInfo struct {
+	File string `json:"file"`
+	Size int64  `json:"file_size,omitempty"`
+	*dither.ImageInfo
+	Error string `json:"error,omitempty"`
+}
+
+// inspectFile reads the properties of the image file at path. The
+// properties read before an error are kept.
+func inspectFile(path string) (fileInfo, error) {
+	fi := fileInfo{File: path}
+	var r io.ReaderAt
+	if path == stdio {
+		// The standard input is read whole, Inspect seeking in TIFF images.
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fi, withExitCode(exitDecode, fmt.Errorf("reading the standard input: %w", err))
+		}
+		fi.Size, r = int64(len(data)), bytes.NewReader(data)
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return fi, withExitCode(exitDecode, fmt.Errorf("reading file %q: %w", path, err))
+		}
+		defer file.Close()
+		st, err := file.Stat()
+		if err != nil {
+			return fi, withExitCode(exitDecode, fmt.Errorf("reading file %q: %w", path, err))
+		}
+		fi.Size, r = st.Size(), file
+	}
+	info, err := dither.Inspect(r)
+	if de, ok := err.(*dither.DecodeError); ok {
+		de.Path = path
+	}
+	if info.Format != "" {
+		fi.ImageInfo = &info
+	}
+	return fi, err
+}
+
+// orientations describes the EXIF orientations.
+var orientations = [...]string{
+	1: "normal",
+	2: "mirrored",
+	3: "rotated 180°",
+	4: "mirrored vertically",
+	5: "mirrored and rotated 90° counterclockwise",
+	6: "rotated 90° clockwise",
+	7: "mirrored and rotated 90° clockwise",
+	8: "rotated 90° counterclockwise",
+}
+
+// printInfos prints the properties of the files, separated by blank lines,
+// skipping the ones whose format is unknown.
+func printInfos(w io.Writer, infos []fileInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 1, ' ', 0)
+	printed := false
+	for _, fi := range infos {
+		info := fi.ImageInfo
+		if info == nil {
+			continue
+		}
+		if printed {
+			fmt.Fprintln(tw)
+		}
+		printed = true
+		fmt.Fprintf(tw, "file:\t%s\n", fi.File)
+		fmt.Fprintf(tw, "file size:\t%d bytes\n", fi.Size)
+		fmt.Fprintf(tw, "format:\t%s\n", info.Format)
+		if info.Width > 0 {
+			fmt.Fprintf(tw, "size:\t%dx%d\n", info.Width, info.Height)
+		}
+		if info.ColorModel != "" {
+			fmt.Fprintf(tw, "color model:\t%s\n", info.ColorModel)
+		}
+		if info.BitDepth > 0 {
+			fmt.Fprintf(tw, "bit depth:\t%d\n", info.BitDepth)
+		}
+		if info.PaletteSize > 0 {
+			fmt.Fprintf(tw, "palette:\t%d colors\n", info.PaletteSize)
+		}
+		if info.DPIX > 0 {
+			fmt.Fprintf(tw, "resolution:\t%vx%v dpi\n", info.DPIX, info.DPIY)
+		}
+		if info.Orientation > 0 {
+			fmt.Fprintf(tw, "orientation:\t%d, %s\n", info.Orientation, orientations[info.Orientation])
+		}
+		if info.Profile != "" {
+			fmt.Fprintf(tw, "icc profile:\t%s\n", info.Profile)
+		}
+		if info.ColorSpace != "" {
+			fmt.Fprintf(tw, "color space:\t%s\n", info.ColorSpace)
+		}
+		switch {
+		case info.Format == "gif":
+			fmt.Fprintf(tw, "frames:\t%d\n", info.Frames)
+		case info.Format == "tiff":
+			fmt.Fprintf(tw, "pages:\t%d\n", info.Frames)
+		}
+	}
+	return tw.Flush()
+}
+
+func init() {
+	addMaxPixelsFlag(infoCmd)
+	_ = infoCmd.Flags().MarkDeprecated("max-pixels", "info no longer decodes the pixels")
+	infoCmd.Flags().Bool("json", false, "Print the properties as a JSON array")
+	rootCmd.AddCommand(infoCmd)
+}
@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// stdio is the path of the standard input as input and of the standard
+// output as output.
+const stdio = "-"
+
+// openInput opens the input file at path, the standard input for stdio.
+func openInput(path string) (io.ReadCloser, error) {
+	if path == stdio {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// inputFormat returns the format of the input image at path read from r: the
+// one of its extension, or the one sniffed from its first bytes for the
+// standard input and the unknown extensions.
+func inputFormat(path string, r *bufio.Reader) string {
+	if format := dither.FormatOf(path); format != "" {
+		return format
+	}
+	head, _ := r.Peek(dither.SniffLen)
+	return dither.SniffFormat(head)
+}
+
+// input is an image file or archive to process.
+type input struct {
+	path string
+	// rel is the directory of path relative to the directory argument it
+	// was found in, mirrored under the output directory, empty for the files
+	// given directly or by a glob pattern.
+	rel string
+}
+
+// expandInputs returns the inputs named by args: files, directories, whose
+// images are processed, and glob patterns. The subdirectories of the
+// directories are walked when recursive is set. An input named several times
+// is processed once.
+func expandInputs(args []string, recursive bool) ([]input, error) {
+	var inputs []input
+	seen := make(map[string]bool)
+	add := func(in input) {
+		if !seen[in.path] {
+			seen[in.path] = true
+			inputs = append(inputs, in)
+		}
+	}
+	for _, arg := range args {
+		paths := []string{filepath.Clean(arg)}
+		if _, err := os.Stat(arg); err != nil && hasGlobMeta(arg) {
+			if paths, err = filepath.Glob(arg); err != nil {
+				return nil, withExitCode(exitUsage, fmt.Errorf("invalid glob pattern %q: %w", arg, err))
+			}
+			if len(paths) == 0 {
+				return nil, withExitCode(exitUsage, fmt.Errorf("no file matches %q", arg))
+			}
+		}
+		for _, path := range paths {
+			st, err := os.Stat(path)
+			if err != nil || !st.IsDir() {
+				// A missing file fails when it is opened, like a single
+				// input.
+				add(input{path: path})
+				continue
+			}
+			files, err := dirImages(path, recursive)
+			if err != nil {
+				return nil, withExitCode(exitDecode, fmt.Errorf("reading directory %q: %w", path, err))
+			}
+			for _, f := range files {
+				rel, _ := filepath.Rel(path, filepath.Dir(f))
+				if rel == "." {
+					rel = ""
+				}
+				add(input{path: f, rel: rel})
+			}
+		}
+	}
+	return inputs, nil
+}
+
+func hasStdio(inputs []input) bool {
+	for _, in := range inputs {
+		if in.path == stdio {
+			return true
+		}
+	}
+	return false
+}
+
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[`)
+}
+
+// dirImages returns the images, by extension, and the archives in the
+// directory dir and, when recursive, in its subdirectories, sorted by path.
+func dirImages(dir string, recursive bool) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case info.IsDir():
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+		case info.Mode().IsRegular() && (dither.FormatOf(path) != "" || archiveFormat(path) != ""):
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// templateField matches the fields of an output template.
+var templateField = regexp.MustCompile(`\{[^{}]*\}`)
+
+// checkOutputTemplate checks that the fields of the output template t are
+// known.
+func checkOutputTemplate(t string) error {
+	for _, f := range templateField.FindAllString(t, -1) {
+		if f != "{name}" && f != "{ext}" {
+			return withExitCode(exitUsage, fmt.Errorf("unknown field %s in --output-template %q, expected {name} or {ext}", f, t))
+		}
+	}
+	return nil
+}
+
+// outputName returns the name of the result for the input at path: the
+// --output-template with {name} replaced by the base name of path without its
+// extension and {ext} by the extension of the output format, the base name
+// with a _fls suffix by default.
+func (o *options) outputName(path string) string {
+	if o.outputTemplate == "" {
+		return defaultOutputPath(path, o.outputExt())
+	}
+	base := filepath.Base(path)
+	return strings.NewReplacer(
+		"{name}", strings.TrimSuffix(base, filepath.Ext(base)),
+		"{ext}", o.outputExt(),
+	).Replace(o.outputTemplate)
+}
+
+// outputPath returns where the result for in is written: at --output, or
+// under --out-dir in the directory of in relative to its directory argument,
+// the standard output for the standard input.
+func (o *options) outputPath(in input) string {
+	if o.output != "" {
+		return o.output
+	}
+	if in.path == stdio {
+		return stdio
+	}
+	return filepath.Join(o.outDir, in.rel, o.outputName(in.path))
+}
+
+// processInputs processes the image files of inputs with runBatch, and the
+// others with runJobs, once checked that their results are written to
+// distinct paths. The error returned counts the failures by kind and has the
+// exit code of the first one.
+func processInputs(cmd *cobra.Command, inputs []input, o *options) error {
+	switch {
+	case o.output != "":
+		return withExitCode(exitUsage, errors.New("--output names a single result, use --out-dir with several inputs"))
+	case o.statsJSON != "":
+		return withExitCode(exitUsage, errors.New("--stats-json cannot be used with several inputs"))
+	case o.compareGIF != "":
+		return withExitCode(exitUsage, errors.New("--compare-gif cannot be used with several inputs"))
+	case hasStdio(inputs):
+		return withExitCode(exitUsage, errors.New("the standard input cannot be processed with other inputs"))
+	case o.jobs < 1:
+		return withExitCode(exitUsage, fmt.Errorf("invalid --jobs %d, must be at least 1", o.jobs))
+	}
+	log.Info().Str("version", buildVersion()).Msg("fls")
+
+	// The results of the files are planned together, so that the outputs
+	// they share are found, and the archives separately.
+	if o.dryRun {
+		var plans []plan
+		for _, in := range inputs {
+			if archiveFormat(in.path) == "" {
+				plans = append(plans, planFile(in, o))
+			}
+		}
+		err := reportPlans(cmd, plans)
+		for _, in := range inputs {
+			if archiveFormat(in.path) != "" {
+				if aerr := processArchive(cmd, in.path, o); err == nil {
+					err = aerr
+				}
+			}
+		}
+		return err
+	}
+	outputs := make(map[string]string)
+	for _, in := range inputs {
+		if archiveFormat(in.path) != "" {
+			continue
+		}
+		out := abs(o.outputPath(in))
+		if prev, ok := outputs[out]; ok {
+			return withExitCode(exitUsage, fmt.Errorf("%q and %q would both be written to %q, see --output-template", prev, in.path, o.outputPath(in)))
+		}
+		outputs[out] = in.path
+	}
+
+	// The image files are processed in a batch, the archives and the
+	// documents of several images on their own.
+	var files, others []input
+	for _, in := range inputs {
+		if batched(in, o) {
+			files = append(files, in)
+		} else {
+			others = append(others, in)
+		}
+	}
+	res := &outcome{}
+	if err := runBatch(cmd, files, o, res); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	if cmd.Context().Err() == nil && !(o.strict && len(res.failed) > 0) {
+		runJobs(cmd, others, o, res)
+	}
+
+	if err := cmd.Context().Err(); err != nil {
+		log.Warn().Int("inputs", res.processed).Msgf("interrupted after processing %d of the %d inputs", res.processed, len(inputs))
+		return err
+	}
+	switch {
+	case len(res.failed) == 0:
+		return nil
+	case o.strict:
+		return withExitCode(exitCode(res.failed[0]), fmt.Errorf("stopped by --strict after processing %d of the %d inputs, %d failed (%s)",
+			res.processed, len(inputs), len(res.failed), failureSummary(res.failed)))
+	}
+	return withExitCode(exitCode(res.failed[0]), fmt.Errorf("%d of the %d inputs could not be processed (%s)", len(res.failed), len(inputs), failureSummary(res.failed)))
+}
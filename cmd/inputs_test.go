@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// inputTree creates the files of paths, relative to a temporary directory,
+// and returns the directory.
+func inputTree(t *testing.T, paths ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, p := range paths {
+		path := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestExpandInputs(t *testing.T) {
+	dir := inputTree(t,
+		"a.png", "b.jpg", "b[1].png", "notes.txt", "x.zip",
+		"sub/c.png", "sub/deep/d.gif", "sub/deep/a.png",
+	)
+	for _, tt := range []struct {
+		name      string
+		args      []string
+		recursive bool
+		want      []string // the paths relative to dir, with the rel of the inputs after a colon
+		err       string
+	}{
+		{name: "file", args: []string{"a.png"}, want: []string{"a.png"}},
+		{name: "missing file", args: []string{"missing.png"}, want: []string{"missing.png"}},
+		{name: "unclean path", args: []string{"sub/../a.png"}, want: []string{"a.png"}},
+		{name: "glob", args: []string{"*.png"}, want: []string{"a.png", "b[1].png"}},
+		{name: "literal path with glob characters", args: []string{"b[1].png"}, want: []string{"b[1].png"}},
+		{name: "glob of directories", args: []string{"sub/*"}, want: []string{"sub/c.png", "sub/deep/a.png", "sub/deep/d.gif"}},
+		{name: "directory", args: []string{"."}, want: []string{"a.png", "b.jpg", "b[1].png", "x.zip"}},
+		{name: "directory recursively", args: []string{"."}, recursive: true, want: []string{
+			"a.png", "b.jpg", "b[1].png", "sub/c.png:sub", "sub/deep/a.png:sub/deep", "sub/deep/d.gif:sub/deep", "x.zip",
+		}},
+		{name: "subdirectory recursively", args: []string{"sub"}, recursive: true, want: []string{
+			"sub/c.png", "sub/deep/a.png:deep", "sub/deep/d.gif:deep",
+		}},
+		{name: "duplicates", args: []string{"a.png", "*.png", "."}, want: []string{"a.png", "b[1].png", "b.jpg", "x.zip"}},
+		{name: "glob matching nothing", args: []string{"*.webp"}, err: `no file matches "` + filepath.Join(dir, "*.webp")},
+		{name: "bad glob", args: []string{"[a.png"}, err: `invalid glob pattern "` + filepath.Join(dir, "[a.png")},
+		{name: "bad glob after inputs", args: []string{"a.png", "sub/[-"}, err: "invalid glob pattern"},
+	} {
+		args := make([]string, len(tt.args))
+		for i, a := range tt.args {
+			args[i] = filepath.Join(dir, a)
+		}
+		inputs, err := expandInputs(args, tt.recursive)
+		if tt.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) || exitCode(err) != exitUsage {
+				t.Errorf("%s: error %v of exit code %d, expected %q and %d", tt.name, err, exitCode(err), tt.err, exitUsage)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, in := range inputs {
+			rel, _ := filepath.Rel(dir, in.path)
+			if in.rel != "" {
+				rel += ":" + filepath.ToSlash(in.rel)
+			}
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: inputs %q, expected %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	for _, tt := range []struct {
+		template, format string
+		in               input
+		want             string
+	}{
+		{"", "png", input{path: "in/photo.jpg"}, "out/photo_fls.png"},
+		{"", "gif", input{path: "in/photo.tar.jpg", rel: "a/b"}, "out/a/b/photo.tar_fls.gif"},
+		{"{name}{ext}", "png", input{path: "photo.jpg"}, "out/photo.png"},
+		{"{name}{ext}", "png", input{path: "photo.png", rel: "sub"}, "out/sub/photo.png"},
+		{"dithered-{name}-{name}{ext}", "gif", input{path: "x/cat.webp"}, "out/dithered-cat-cat.gif"},
+		{"{name}/result{ext}", "png", input{path: "cat"}, "out/cat/result.png"},
+		{"same.png", "png", input{path: "cat.jpg"}, "out/same.png"},
+		{"", "png", input{path: stdio}, stdio},
+	} {
+		o := &options{Options: dither.DefaultOptions(dither.WithFormat(tt.format)), outDir: "out", outputTemplate: tt.template}
+		if got := filepath.ToSlash(o.outputPath(tt.in)); got != tt.want {
+			t.Errorf("%q of %+v: %q, expected %q", tt.template, tt.in, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		template string
+		err      string
+	}{
+		{"{name}{ext}", ""},
+		{"plain.png", ""},
+		{"{name}.{format}", "unknown field {format}"},
+		{"{Name}.png", "unknown field {Name}"},
+		{"{}{ext}", "unknown field {}"},
+	} {
+		err := checkOutputTemplate(tt.template)
+		if (tt.err == "") != (err == nil) || err != nil && (!strings.Contains(err.Error(), tt.err) || exitCode(err) != exitUsage) {
+			t.Errorf("%q: error %v, expected %q", tt.template, err, tt.err)
+		}
+	}
+}
+
+// TestOutputCollisions checks that no input is processed when the results
+// of two of them would be written to the same path.
+func TestOutputCollisions(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		args []string
+		err  string // the beginning of the error, of the directory %[1]s, empty when the inputs are processed
+	}{
+		{"same names in two directories", []string{"one/a.png", "two/a.png"}, `"%[1]s/one/a.png" and "%[1]s/two/a.png" would both be written to "%[1]s/out/a_fls.png"`},
+		{"same names in subdirectories", []string{"--recursive", "one", "two"}, `"%[1]s/one/a.png" and "%[1]s/two/a.png" would both be written`},
+		{"template without name", []string{"one", "--output-template", "result{ext}"}, `"%[1]s/one/a.png" and "%[1]s/one/b.png" would both be written to "%[1]s/out/result.png"`},
+		{"mirrored subdirectories", []string{"--recursive", "one"}, ""},
+		{"template with name", []string{"one", "--output-template", "{name}{ext}"}, ""},
+	} {
+		dir := t.TempDir()
+		for _, p := range []string{"one/a.png", "one/b.png", "one/sub/a.png", "two/a.png"} {
+			path := filepath.Join(dir, filepath.FromSlash(p))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeTestPNG(t, path, 4, 2)
+		}
+		var args []string
+		for _, a := range tt.args {
+			if !strings.HasPrefix(a, "-") && !strings.Contains(a, "{") {
+				a = filepath.Join(dir, a)
+			}
+			args = append(args, a)
+		}
+		out := filepath.Join(dir, "out")
+		err := runFls(t, append(args, "--out-dir", out)...)
+		results, _ := filepath.Glob(filepath.Join(out, "*"))
+		if tt.err == "" {
+			if err != nil || len(results) == 0 {
+				t.Errorf("%s: results %q, error %v", tt.name, results, err)
+			}
+			continue
+		}
+		want := fmt.Sprintf(tt.err, dir)
+		if err == nil || !strings.HasPrefix(err.Error(), want) || exitCode(err) != exitUsage || len(results) > 0 {
+			t.Errorf("%s: results %q, error %v of exit code %d, expected %q", tt.name, results, err, exitCode(err), want)
+		}
+	}
+}
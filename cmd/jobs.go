@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// job is the processing of an input by runJobs.
+type job struct {
+	in              input
+	reports, timing bytes.Buffer // printed once the job is done
+	err             error
+	done            chan struct{} // closed once err is set
+}
+
+// outcome counts the inputs of processInputs processed, and holds the errors
+// of the ones failing in input order.
+type outcome struct {
+	processed int
+	failed    []error
+}
+
+// runJobs processes the inputs with o on --jobs concurrent workers, recording
+// their outcome in res. The reports of each input are buffered and printed in
+// input order once it is processed. A failing input is logged and the others
+// carry on, unless --strict stops the processing at the first one. Once the
+// command is canceled or stopped, the inputs not started are skipped.
+func runJobs(cmd *cobra.Command, inputs []input, o *options, res *outcome) {
+	ctx, stop := context.WithCancel(cmd.Context())
+	defer stop()
+	jobs := make([]*job, len(inputs))
+	for i, in := range inputs {
+		jobs[i] = &job{in: in, done: make(chan struct{})}
+	}
+
+	queue := make(chan *job)
+	var workers sync.WaitGroup
+	for i := 0; i < o.jobs && i < len(jobs); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for j := range queue {
+				jo := *o
+				if o.jobs > 1 {
+					jo.reportOut, jo.timingsOut = &j.reports, &j.timing
+				}
+				if archiveFormat(j.in.path) == "" && (o.stats || o.metrics || o.compareAlgorithms) {
+					fmt.Fprintf(jo.reports(cmd), "%s:\n", j.in.path)
+				}
+				j.err = processInput(cmd, j.in, &jo)
+				close(j.done)
+			}
+		}()
+	}
+	go func() {
+		defer close(queue)
+		for i, j := range jobs {
+			select {
+			case queue <- j:
+			case <-ctx.Done():
+				for _, j := range jobs[i:] {
+					j.err = ctx.Err()
+					close(j.done)
+				}
+				return
+			}
+		}
+	}()
+
+	prog := startProgress(len(jobs))
+	defer prog.finish()
+	for _, j := range jobs {
+		prog.begin(j.in.path)
+		<-j.done
+		cmd.OutOrStdout().Write(j.reports.Bytes())
+		stderr.Write(j.timing.Bytes())
+		switch {
+		case errors.Is(j.err, context.Canceled):
+		case j.err != nil:
+			log.Error().Msg(j.err.Error())
+			res.failed = append(res.failed, j.err)
+			if o.strict {
+				stop()
+			}
+		default:
+			res.processed++
+		}
+		prog.done()
+	}
+	workers.Wait()
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var logFormats = []string{"console", "json"}
+
+// setupLogging configures the global logger from the verbosity and log format
+// flags of cmd. Warnings are shown unless --quiet is given, info messages only
+// with --verbose.
+func setupLogging(cmd *cobra.Command) error {
+	f := flagReader{fs: cmd.Flags()}
+	quiet, verbose, logFormat := f.bool("quiet"), f.bool("verbose"), f.string("log-format")
+	if f.err != nil {
+		return f.err
+	}
+
+	switch {
+	case quiet:
+		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	case verbose:
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	default:
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+
+	switch logFormat {
+	case "console":
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: stderr})
+	case "json":
+		log.Logger = zerolog.New(stderr).With().Timestamp().Logger()
+	default:
+		return fmt.Errorf("unknown log format %q, expected one of %v", logFormat, logFormats)
+	}
+	stderr.bars = stderr.tty && !quiet && logFormat == "console"
+	return nil
+}
+
+func init() {
+	// Used until the flags are parsed, to report errors doing so.
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: stderr})
+
+	rootCmd.PersistentFlags().BoolP("quiet", "q", false, "Only report errors")
+	rootCmd.PersistentFlags().String("log-format", "console", "Log output format: console or json")
+	_ = rootCmd.RegisterFlagCompletionFunc("log-format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return logFormats, cobra.ShellCompDirectiveNoFileComp
+	})
+}
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// bandRows is the height of the bands images are processed in when the
+// in-memory processing would exceed --max-memory.
+const bandRows = 256
+
+// imageBytes returns an estimate of the memory in bytes used by the pixels of
+// img.
+func imageBytes(img image.Image) int64 {
+	switch m := img.(type) {
+	case *image.RGBA:
+		return int64(len(m.Pix))
+	case *image.NRGBA:
+		return int64(len(m.Pix))
+	case *image.RGBA64:
+		return int64(len(m.Pix))
+	case *image.NRGBA64:
+		return int64(len(m.Pix))
+	case *image.Gray:
+		return int64(len(m.Pix))
+	case *image.Gray16:
+		return int64(len(m.Pix))
+	case *image.Paletted:
+		return int64(len(m.Pix))
+	case *image.YCbCr:
+		return int64(len(m.Y) + len(m.Cb) + len(m.Cr))
+	case *image.CMYK:
+		return int64(len(m.Pix))
+	case *dither.Shrunk:
+		return int64(len(m.Pix))
+	}
+	b := img.Bounds()
+	return 8 * int64(b.Dx()) * int64(b.Dy())
+}
+
+// memoryNeeded returns an estimate of the memory in bytes needed by the
+// in-memory processing of img with o, img included: the scaled image, the
+// adjusted one and the result.
+func memoryNeeded(img image.Image, o *options) int64 {
+	n := imageBytes(img)
+	b := img.Bounds()
+	px := int64(4)
+	switch img.(type) {
+	case *image.Gray, *image.Gray16, *image.Paletted:
+		if !o.Scales() || o.Filter == "" || o.Filter == "nearest" {
+			px = 1 // scaled and adjusted to a Gray or Paletted image
+		}
+	}
+	if o.Scales() {
+		b = o.ScaledBounds(dither.SourceBounds(img))
+		n += px * int64(b.Dx()) * int64(b.Dy())
+	}
+	if o.Adjusts() {
+		n += px * int64(b.Dx()) * int64(b.Dy())
+	}
+	if o.mode != modeResize {
+		n += int64(b.Dx()) * int64(b.Dy())
+	}
+	return n
+}
+
+// banded reports whether img is to be processed in bands to stay within
+// --max-memory. It warns when the limit cannot be honored.
+func banded(st *stages, img image.Image, o *options) bool {
+	if o.maxMemory <= 0 {
+		return false
+	}
+	need := memoryNeeded(img, o)
+	if need <= o.maxMemory {
+		return false
+	}
+	var reason string
+	d, _ := dither.Lookup(o.Algorithm)
+	switch {
+	case o.mode == modeResize:
+		reason = "the resize command has no banded processing"
+	case o.Format != "png":
+		reason = fmt.Sprintf("%s output has no banded encoding", o.Format)
+	case o.stats || o.statsJSON != "" || o.metrics || o.compareAlgorithms || o.compareGIF != "":
+		reason = "statistics, metrics and comparisons need the whole result"
+	case o.Encoding.Device != nil:
+		reason = "the picture of --device needs the whole result"
+	case o.Geometry != (dither.Geometry{}):
+		reason = "the crop, rotation, flips and padding need the whole image"
+	case !dither.Bandable(d):
+		reason = fmt.Sprintf("algorithm %q cannot process images in bands", o.Algorithm)
+	}
+	if reason != "" {
+		st.logger.Warn().Int64("needed", need).Int64("max_memory", o.maxMemory).Msgf("processing in memory beyond --max-memory: %s", reason)
+		return false
+	}
+	if n := imageBytes(img); n > o.maxMemory {
+		st.logger.Warn().Int64("decoded", n).Int64("max_memory", o.maxMemory).Msg("the decoded image alone exceeds --max-memory")
+	}
+	return true
+}
+
+// renderBands processes img in bands of bandRows rows and encodes the result
+// as PNG as the bands are produced, without holding the scaled image or the
+// whole result.
+func renderBands(st *stages, img image.Image, o *options) (*rendered, error) {
+	st.logger.Info().Float32("scale", o.Scale).Str("filter", o.Filter).Str("algorithm", o.Algorithm).Int("band_rows", bandRows).Msg("processing in bands...")
+	var buf bytes.Buffer
+	err := st.run(o.mode.stage(), func() error {
+		return dither.TransformBands(st.ctx, &buf, img, o.Options, bandRows)
+	})
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if o.Scales() {
+		b = o.ScaledBounds(dither.SourceBounds(img))
+	}
+	return &rendered{bounds: b, encoded: buf.Bytes()}, nil
+}
+
+// streamRows returns the PNG image read from file by br, of the given format,
+// as a stream to render with renderStream when even its decoded image and the
+// banded processing would exceed --max-memory, and nil otherwise, with file
+// and br rewound to decode the image as a whole.
+func streamRows(st *stages, format string, file io.Reader, br *bufio.Reader, o *options) (*dither.Stream, dither.Metadata, error) {
+	seeker, ok := file.(io.Seeker)
+	if o.maxMemory <= 0 || !ok || format != "png" || o.page > 0 || o.mode == modeResize ||
+		o.Format != "png" || o.stats || o.statsJSON != "" || o.metrics || o.compareAlgorithms || o.compareGIF != "" || o.Encoding.Device != nil {
+		return nil, dither.Metadata{}, nil
+	}
+	if err := dither.Streamable(o.Options); err != nil {
+		st.logger.Debug().Err(err).Msg("decoding the image as a whole")
+		return nil, dither.Metadata{}, nil
+	}
+	s, md, err := dither.DecodeStream(br, decodeOptions(o))
+	if err == nil {
+		// The banded processing of the decoded image would hold it, and a
+		// band of the scaled image and of the result.
+		b := s.Bounds()
+		if o.Scales() {
+			b = o.ScaledBounds(b)
+		}
+		need := s.Bytes() + 5*int64(b.Dx())*bandRows
+		if need > o.maxMemory {
+			return s, md, nil
+		}
+		s.Close()
+	}
+	if errors.Is(err, dither.ErrNotStreamable) {
+		st.logger.Debug().Err(err).Msg("decoding the image as a whole")
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return nil, dither.Metadata{}, withExitCode(exitDecode, fmt.Errorf("rewinding the image: %w", err))
+	}
+	br.Reset(file)
+	return nil, dither.Metadata{}, nil
+}
+
+// renderStream processes the rows of s, of the named image, as they are decoded, in bands of
+// bandRows rows, and encodes the result as PNG as the bands are produced, so
+// that the memory needed is proportional to the width of the image.
+func renderStream(st *stages, name string, s *dither.Stream, md dither.Metadata, o *options) (*rendered, error) {
+	defer s.Close()
+	logProfile(st.logger, md.Profile)
+	b := s.Bounds()
+	st.logger.Info().Int("width", b.Dx()).Int("height", b.Dy()).Int64("decoded", s.Bytes()).Int64("max_memory", o.maxMemory).
+		Msg("decoding the rows as they are processed")
+	o = o.withMetadata(md)
+	st.logger.Info().Float32("scale", o.Scale).Str("filter", o.Filter).Str("algorithm", o.Algorithm).Int("band_rows", bandRows).Msg("processing in bands...")
+	var buf bytes.Buffer
+	err := st.run(o.mode.stage(), func() error {
+		err := dither.TransformStream(st.ctx, &buf, s, o.Options, bandRows)
+		var de *dither.DecodeError
+		if errors.As(err, &de) {
+			de.Path = name
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	r := b
+	if o.Scales() {
+		r = o.ScaledBounds(b)
+	}
+	return &rendered{input: b, bounds: r, encoded: buf.Bytes()}, nil
+}
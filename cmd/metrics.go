@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// namedMetrics associates quality metrics to the algorithm they measure.
+type namedMetrics struct {
+	Algorithm string
+	dither.Metrics
+}
+
+func printMetrics(w io.Writer, ms []namedMetrics) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ALGORITHM\tPSNR (dB)\tSSIM")
+	for _, m := range ms {
+		fmt.Fprintf(tw, "%s\t%.3f\t%.4f\n", m.Algorithm, m.PSNR, m.SSIM)
+	}
+	return tw.Flush()
+}
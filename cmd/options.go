@@ -0,0 +1,385 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+	"image"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// options holds the settings of one invocation of a processing command. It is
+// read from the parsed flags before the processing starts and not modified
+// afterwards.
+type options struct {
+	dither.Options
+	mode   mode
+	output string
+	pages  []pageRange // nil for all the pages
+	page   int         // the page processed from 1, 0 for a single image
+
+	// outDir and outputTemplate give the output paths when output is empty,
+	// see outputPath.
+	outDir         string
+	outputTemplate string
+	recursive      bool
+	jobs           int
+	strict         bool
+	// reportOut and timingsOut, when set, receive the reports printed on
+	// the command output and the timings printed on stderr, for the inputs
+	// processed concurrently.
+	reportOut, timingsOut io.Writer
+
+	dryRun         bool
+	sidecar        bool
+	keepMetadata   bool
+	timings        bool
+	maxArchiveSize int64
+	decodeWorkers  int
+	renderWorkers  int
+	maxMemory      int64
+	// columns is the width of the terminal the text results are fitted to,
+	// zero when they aren't, see fitted.
+	columns int
+
+	stats             bool
+	statsJSON         string
+	metrics           bool
+	metricsSigma      float64
+	compareAlgorithms bool
+	compareGIF        string
+	compareDelay      time.Duration
+}
+
+// newOptions reads the options of a command running in mode m on input from
+// its parsed flags fs.
+func newOptions(fs *pflag.FlagSet, m mode, input string) (*options, error) {
+	f := flagReader{fs: fs}
+	alg := dither.DefaultOptions().Algorithm
+	diff := dither.DefaultOptions().Diffusion
+	screen := dither.DefaultOptions().Screen
+	switch m {
+	case modeDither:
+		alg = f.string("algorithm")
+		if f.defined("strength") {
+			diff = dither.Diffusion{Strength: f.float64("strength"), Serpentine: f.bool("serpentine")}
+		}
+		if f.defined("threshold") {
+			screen = dither.Screen{Threshold: f.float64("threshold"), DotSize: f.float64("dot-size"), Angle: f.float64("screen-angle"), Seed: f.int64("seed")}
+			if err := checkScreenFlags(fs, alg); err != nil {
+				return nil, err
+			}
+		}
+	case modeQuantize:
+		alg = "nearest"
+	}
+	o := &options{
+		Options: dither.DefaultOptions(
+			dither.WithScale(f.float32("scale")),
+			dither.WithSize(f.int("width"), f.int("height")),
+			dither.WithFilter(f.string("filter")),
+			dither.WithAdjustments(dither.Adjustments{
+				AutoContrast: f.bool("auto-contrast"),
+				Brightness:   f.float64("brightness"),
+				Contrast:     f.float64("contrast"),
+				Gamma:        f.float64("gamma"),
+			}),
+			dither.WithAlgorithm(alg),
+			dither.WithDiffusion(diff),
+			dither.WithScreen(screen),
+			dither.WithColors(f.int("colors")),
+			dither.WithFormat(f.string("format")),
+			dither.WithThreads(f.int("threads")),
+			dither.WithMaxPixels(f.int64("max-pixels")),
+			dither.WithAssumeSRGB(f.bool("assume-srgb")),
+			dither.WithIgnoreOrientation(f.bool("no-auto-orient")),
+		),
+		mode:   m,
+		output: f.string("output"),
+
+		outDir:         f.string("out-dir"),
+		outputTemplate: f.string("output-template"),
+		recursive:      f.bool("recursive"),
+		jobs:           f.int("jobs"),
+		strict:         f.bool("strict"),
+
+		dryRun:         f.bool("dry-run"),
+		sidecar:        f.bool("sidecar"),
+		keepMetadata:   f.bool("keep-metadata"),
+		timings:        f.bool("timings"),
+		maxArchiveSize: f.int64("max-archive-size"),
+		decodeWorkers:  f.int("decode-workers"),
+		renderWorkers:  f.int("render-workers"),
+		maxMemory:      f.int64("max-memory"),
+
+		stats:             f.bool("stats"),
+		statsJSON:         f.string("stats-json"),
+		metrics:           f.bool("metrics"),
+		metricsSigma:      f.float64("metrics-sigma"),
+		compareAlgorithms: f.bool("compare-algorithms"),
+		compareGIF:        f.string("compare-gif"),
+		compareDelay:      f.duration("compare-delay"),
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	var err error
+	if o.Palette, err = resolvePalette(f.string("palette"), f.int("levels")); err != nil {
+		return nil, err
+	}
+	if o.Colors != 0 && (f.string("palette") != "" || f.int("levels") != 0) {
+		return nil, withExitCode(exitUsage, errors.New("--colors cannot be used with --palette or --levels"))
+	}
+	if fit := f.string("fit"); fit != "" {
+		if o.Width != 0 || o.Height != 0 {
+			return nil, withExitCode(exitUsage, errors.New("--fit cannot be used with --width or --height"))
+		}
+		if o.Width, o.Height, err = parseSize(fit); err != nil {
+			return nil, withExitCode(exitUsage, fmt.Errorf("invalid --fit: %w", err))
+		}
+		o.Fit = true
+	}
+	if o.Geometry, err = readGeometry(&f); err != nil {
+		return nil, err
+	}
+	o.Encoding = dither.EncodeOptions{
+		Package:  f.string("go-package"),
+		Name:     f.string("go-var"),
+		Plain:    f.bool("plain"),
+		RowAlign: f.int("row-align"),
+	}
+	pages := f.string("pages")
+	if f.err != nil {
+		return nil, f.err
+	}
+	if pages != "" {
+		if o.pages, err = parsePages(pages); err != nil {
+			return nil, withExitCode(exitUsage, err)
+		}
+	}
+	if err := checkOutputTemplate(o.outputTemplate); err != nil {
+		return nil, err
+	}
+	if err := o.resolveFormat(input); err != nil {
+		return nil, err
+	}
+	if o.Format == "c" {
+		o.Encoding.Name = f.string("c-name")
+	}
+	if err := resolveDevice(&f, o); err != nil {
+		return nil, err
+	}
+	if _, ok := textFormats[o.Format]; ok && o.Scale == 1 && o.Width == 0 && o.Height == 0 {
+		o.columns = terminalColumns()
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	if o.compareGIF != "" && o.compareDelay < 10*time.Millisecond {
+		return nil, withExitCode(exitUsage, fmt.Errorf("invalid --compare-delay %v, must be at least 10ms", o.compareDelay))
+	}
+	if o.Format == "c" {
+		if id := o.Encoding.Name; id != "" && !dither.IsCIdentifier(id) {
+			return nil, withExitCode(exitUsage, fmt.Errorf("invalid C identifier %q", id))
+		}
+	} else {
+		for _, id := range []string{o.Encoding.Package, o.Encoding.Name} {
+			if id != "" && !token.IsIdentifier(id) {
+				return nil, withExitCode(exitUsage, fmt.Errorf("invalid Go identifier %q", id))
+			}
+		}
+	}
+	return o, nil
+}
+
+// resolveFormat sets the output format of o, when not given, from the
+// extension of the output file of a single image input, and checks that the
+// format is registered and suits the mode.
+func (o *options) resolveFormat(input string) error {
+	if o.Format == "" {
+		o.Format = "png"
+		name := o.output
+		if name == "" {
+			name = o.outputTemplate
+		}
+		if ext := filepath.Ext(name); ext != "" && !strings.Contains(ext, "{") && archiveFormat(input) == "" {
+			f, ok := dither.EncoderForExtension(ext)
+			if !ok {
+				return withExitCode(exitUsage, fmt.Errorf("no output format for the extension %s of %q, expected one of %v", ext, name, dither.OutputExtensions()))
+			}
+			o.Format = f.Name
+		}
+	}
+	if _, ok := dither.LookupEncoder(o.Format); !ok {
+		return withExitCode(exitUsage, fmt.Errorf("unknown output format %q, expected one of %v", o.Format, formatNames()))
+	}
+	if o.mode == modeResize && o.Format != "png" {
+		return withExitCode(exitUsage, fmt.Errorf("%s output needs a paletted image, use the dither or quantize command", o.Format))
+	}
+	return nil
+}
+
+// parseSize parses dimensions written WxH, like 800x600.
+func parseSize(s string) (width, height int, err error) {
+	i := strings.IndexAny(s, "xX")
+	if i < 0 {
+		return 0, 0, fmt.Errorf("%q is not a size like 800x600", s)
+	}
+	width, werr := strconv.Atoi(s[:i])
+	height, herr := strconv.Atoi(s[i+1:])
+	if werr != nil || herr != nil || width < 1 || height < 1 {
+		return 0, 0, fmt.Errorf("%q is not a size like 800x600", s)
+	}
+	return width, height, nil
+}
+
+// readGeometry returns the geometric transforms of the --crop, --rotate,
+// --flip and --pad flags read by f.
+func readGeometry(f *flagReader) (dither.Geometry, error) {
+	g := dither.Geometry{Rotate: f.int("rotate")}
+	crop, flip, pad := f.string("crop"), f.string("flip"), f.string("pad")
+	if f.err != nil {
+		return g, f.err
+	}
+	if crop != "" {
+		r, err := parseCrop(crop)
+		if err != nil {
+			return g, withExitCode(exitUsage, fmt.Errorf("invalid --crop: %w", err))
+		}
+		g.Crop = r
+	}
+	switch flip {
+	case "":
+	case "h":
+		g.FlipH = true
+	case "v":
+		g.FlipV = true
+	default:
+		return g, withExitCode(exitUsage, fmt.Errorf("invalid --flip %q, must be h or v", flip))
+	}
+	if pad != "" {
+		size := pad
+		if i := strings.IndexByte(pad, ':'); i >= 0 {
+			c, err := dither.ParseHexColor(pad[i+1:])
+			if err != nil {
+				return g, withExitCode(exitUsage, fmt.Errorf("invalid --pad: %w", err))
+			}
+			size, g.PadColor = pad[:i], c
+		}
+		var err error
+		if g.PadWidth, g.PadHeight, err = parseSize(size); err != nil {
+			return g, withExitCode(exitUsage, fmt.Errorf("invalid --pad: %w", err))
+		}
+	}
+	return g, nil
+}
+
+// parseCrop parses a rectangle written x,y,w,h, like 10,20,800,600 for 800x600
+// pixels from the point 10,20.
+func parseCrop(s string) (image.Rectangle, error) {
+	fields := strings.Split(s, ",")
+	var v [4]int
+	if len(fields) != len(v) {
+		return image.Rectangle{}, fmt.Errorf("%q is not a rectangle like 10,20,800,600", s)
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 0 || (i >= 2 && n < 1) {
+			return image.Rectangle{}, fmt.Errorf("%q is not a rectangle like 10,20,800,600", s)
+		}
+		v[i] = n
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+// reports returns where the reports of the processing with o are printed.
+func (o *options) reports(cmd *cobra.Command) io.Writer {
+	if o.reportOut != nil {
+		return o.reportOut
+	}
+	return cmd.OutOrStdout()
+}
+
+// timingsWriter returns where the timings of the processing with o are printed.
+func (o *options) timingsWriter() io.Writer {
+	if o.timingsOut != nil {
+		return o.timingsOut
+	}
+	return stderr
+}
+
+// outputExt returns the extension of the files written in the output format
+// of o.
+func (o *options) outputExt() string {
+	f, _ := dither.LookupEncoder(o.Format)
+	return f.Extensions[0]
+}
+
+// flagReader reads typed flag values, keeping the first error. Flags the
+// command doesn't define read as the zero value.
+type flagReader struct {
+	fs  *pflag.FlagSet
+	err error
+}
+
+func (r *flagReader) defined(name string) bool {
+	return r.err == nil && r.fs.Lookup(name) != nil
+}
+
+func (r *flagReader) bool(name string) (v bool) {
+	if r.defined(name) {
+		v, r.err = r.fs.GetBool(name)
+	}
+	return v
+}
+
+func (r *flagReader) string(name string) (v string) {
+	if r.defined(name) {
+		v, r.err = r.fs.GetString(name)
+	}
+	return v
+}
+
+func (r *flagReader) int(name string) (v int) {
+	if r.defined(name) {
+		v, r.err = r.fs.GetInt(name)
+	}
+	return v
+}
+
+func (r *flagReader) int64(name string) (v int64) {
+	if r.defined(name) {
+		v, r.err = r.fs.GetInt64(name)
+	}
+	return v
+}
+
+func (r *flagReader) float32(name string) (v float32) {
+	if r.defined(name) {
+		v, r.err = r.fs.GetFloat32(name)
+	}
+	return v
+}
+
+func (r *flagReader) float64(name string) (v float64) {
+	if r.defined(name) {
+		v, r.err = r.fs.GetFloat64(name)
+	}
+	return v
+}
+
+func (r *flagReader) duration(name string) (v time.Duration) {
+	if r.defined(name) {
+		v, r.err = r.fs.GetDuration(name)
+	}
+	return v
+}
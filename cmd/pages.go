@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// A pageRange is a range of pages selected by --pages, from first to last
+// included, numbered from 1.
+type pageRange struct {
+	first, last int
+}
+
+// parsePages parses the --pages selector, a comma-separated list of page
+// numbers and ranges of pages like 3-5.
+func parsePages(s string) ([]pageRange, error) {
+	var ranges []pageRange
+	for _, part := range strings.Split(s, ",") {
+		first, last := part, part
+		if i := strings.IndexByte(part, '-'); i >= 0 {
+			first, last = part[:i], part[i+1:]
+		}
+		a, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil || a < 1 {
+			return nil, fmt.Errorf("invalid page %q in --pages %q, expected numbers and ranges like 1,3-5", first, s)
+		}
+		b, err := strconv.Atoi(strings.TrimSpace(last))
+		if err != nil || b < a {
+			return nil, fmt.Errorf("invalid page range %q in --pages %q, expected numbers and ranges like 1,3-5", part, s)
+		}
+		ranges = append(ranges, pageRange{a, b})
+	}
+	return ranges, nil
+}
+
+// selectPages returns the numbers of the pages selected by ranges, all of n
+// when nil, in order and without repetition. It fails when ranges selects a
+// page beyond n.
+func selectPages(ranges []pageRange, n int) ([]int, error) {
+	if ranges == nil {
+		ranges = []pageRange{{1, n}}
+	}
+	selected := make([]bool, n+1)
+	for _, r := range ranges {
+		if r.last > n {
+			return nil, fmt.Errorf("page %d selected by --pages, the image has %d", r.last, n)
+		}
+		for p := r.first; p <= r.last; p++ {
+			selected[p] = true
+		}
+	}
+	var pages []int
+	for p, ok := range selected {
+		if ok {
+			pages = append(pages, p)
+		}
+	}
+	return pages, nil
+}
+
+// pageOutputPath returns the path of the output of the page of a multi-page
+// input whose single output would be at output: doc_fls_003.png for the
+// page 3 of doc_fls.png.
+func pageOutputPath(output string, page int) string {
+	ext := filepath.Ext(output)
+	return fmt.Sprintf("%s_%03d%s", strings.TrimSuffix(output, ext), page, ext)
+}
+
+// processPages runs the pipeline on the pages of the image file at path
+// selected by --pages, each written to its numbered output when the image has
+// several pages. The pages are decoded, and scaled, independently of each
+// other.
+func processPages(cmd *cobra.Command, path, output string, o *options) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return withExitCode(exitDecode, fmt.Errorf("reading file %q: %w", path, err))
+	}
+	n, err := dither.Pages(file, dither.FormatOf(path))
+	file.Close()
+	if de, ok := err.(*dither.DecodeError); ok {
+		de.Path = path
+	}
+	if err != nil {
+		return err
+	}
+	pages, err := selectPages(o.pages, n)
+	if err != nil {
+		return withExitCode(exitUsage, fmt.Errorf("%q: %w", path, err))
+	}
+	if n > 1 {
+		log.Info().Str("file", path).Int("pages", n).Int("selected", len(pages)).Msg("multi-page image")
+	}
+
+	for _, page := range pages {
+		po := *o
+		pageOutput := output
+		if n > 1 {
+			// The other files written for an output are numbered like it.
+			po.page = page
+			pageOutput = pageOutputPath(output, page)
+			if o.statsJSON != "" {
+				po.statsJSON = pageOutputPath(o.statsJSON, page)
+			}
+			if o.compareGIF != "" {
+				po.compareGIF = pageOutputPath(o.compareGIF, page)
+			}
+		}
+		if err := processFile(cmd, path, pageOutput, &po); err != nil {
+			return err
+		}
+	}
+	return nil
+}
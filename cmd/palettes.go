@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+var palettesCmd = &cobra.Command{
+	Use:   "palettes",
+	Short: "List the named palettes of --palette",
+	Args:  usageArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "PALETTE\tCOLORS")
+		for _, name := range dither.Palettes() {
+			p, _ := dither.LookupPalette(name)
+			fmt.Fprintf(tw, "%s\t%s\n", name, paletteSummary(p))
+		}
+		return tw.Flush()
+	},
+}
+
+// paletteSummary returns the hex colors of p, or their count for the large
+// palettes.
+func paletteSummary(p color.Palette) string {
+	if len(p) > 16 {
+		return fmt.Sprintf("%d colors", len(p))
+	}
+	colors := make([]string, len(p))
+	for i, c := range p {
+		colors[i] = dither.HexColor(c)
+	}
+	return strings.Join(colors, " ")
+}
+
+// resolvePalette returns the palette of the --palette and --levels flags:
+// the palette of levels grays if levels is not 0, otherwise the named palette,
+// the list of hex colors or the palette file spec, black and white if spec is
+// empty.
+func resolvePalette(spec string, levels int) (color.Palette, error) {
+	switch {
+	case levels != 0 && spec != "":
+		return nil, withExitCode(exitUsage, errors.New("--levels and --palette are mutually exclusive"))
+	case levels != 0:
+		if levels < 2 || levels > 256 {
+			return nil, withExitCode(exitUsage, fmt.Errorf("invalid --levels %d, must be from 2 to 256", levels))
+		}
+		return dither.Grays(levels), nil
+	case spec == "":
+		return dither.BlackAndWhite, nil
+	}
+	if p, ok := dither.LookupPalette(spec); ok {
+		return p, nil
+	}
+	if p, err := dither.ParsePalette(spec); err == nil {
+		return p, nil
+	}
+	file, err := os.Open(spec)
+	if err != nil {
+		return nil, withExitCode(exitUsage, fmt.Errorf("invalid --palette %q, expected one of %v, hex colors like #ff8000,#000 or a palette file", spec, dither.Palettes()))
+	}
+	defer file.Close()
+	p, err := dither.ReadPalette(file)
+	if err != nil {
+		return nil, withExitCode(exitUsage, fmt.Errorf("reading palette %q: %w", spec, err))
+	}
+	return p, nil
+}
+
+func completePalettes(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return dither.Palettes(), cobra.ShellCompDirectiveDefault
+}
+
+func init() {
+	rootCmd.AddCommand(palettesCmd)
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// setInput makes s preview the image at path, whose format is found from its
+// extension or else its first bytes.
+func (s *server) setInput(path string) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return withExitCode(exitDecode, err)
+	}
+	if st.IsDir() {
+		return withExitCode(exitUsage, fmt.Errorf("%q is a directory, serve previews a single image", path))
+	}
+	s.input = path
+	return nil
+}
+
+// previewParams returns the flags set from the query parameters of the
+// preview, those of /dither without the output format ones: the preview is a
+// PNG image.
+func previewParams() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("preview", pflag.ContinueOnError)
+	fs.SortFlags = false
+	params := queryParams()
+	params.SortFlags = false
+	params.VisitAll(func(f *pflag.Flag) {
+		switch f.Name {
+		case "format", "go-package", "go-var", "c-name", "plain", "row-align":
+			return
+		}
+		fs.AddFlag(f)
+	})
+	return fs
+}
+
+// previewFlags reads the query parameters of a preview request r to the
+// flags of previewParams. The empty parameters, left by the form, are
+// ignored.
+func previewFlags(r *http.Request) (*pflag.FlagSet, error) {
+	query := r.URL.Query()
+	for name, values := range query {
+		var set []string
+		for _, v := range values {
+			if v != "" {
+				set = append(set, v)
+			}
+		}
+		query[name] = set
+	}
+	if err := checkPalette(query); err != nil {
+		return nil, err
+	}
+	fs := previewParams()
+	return fs, setParams(fs, query)
+}
+
+// preview responds with the PNG result of the processing of the input with
+// the settings of the query parameters.
+func (s *server) preview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		fail(w, withStatus(http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	if err := s.acquire(ctx); err != nil {
+		fail(w, err)
+		return
+	}
+	defer s.release()
+
+	out, contentType, err := s.renderPreview(ctx, r)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(out)
+}
+
+// renderPreview returns the result of the preview request r and its content
+// type.
+func (s *server) renderPreview(ctx context.Context, r *http.Request) ([]byte, string, error) {
+	fs, err := previewFlags(r)
+	if err != nil {
+		return nil, "", err
+	}
+	o, err := newOptions(fs, modeDither, "")
+	if err != nil {
+		return nil, "", err
+	}
+	o.MaxPixels = s.maxPixels
+	data, err := os.ReadFile(s.input)
+	if err != nil {
+		return nil, "", withStatus(http.StatusInternalServerError, err)
+	}
+	format := dither.FormatOf(s.input)
+	if format == "" {
+		format = dither.SniffFormat(data)
+	}
+	return processData(ctx, data, format, o)
+}
+
+// previewField is an input of the form of the preview page.
+type previewField struct {
+	Name  string
+	Value string
+	// Bool fields are checkboxes, the others with Choices are drop-down
+	// lists, and those with Suggestions text inputs suggesting them.
+	Bool        bool
+	Choices     []string
+	Suggestions []string
+}
+
+// previewPage responds with the page of the preview, its form showing the
+// settings of the query parameters.
+func (s *server) previewPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	fs, err := previewFlags(r)
+	page := struct {
+		Input  string
+		Source template.URL
+		Error  string
+		Fields []previewField
+	}{Input: s.input, Source: template.URL("/preview?" + r.URL.Query().Encode())}
+	if err != nil {
+		page.Error = err.Error()
+	}
+	fs.VisitAll(func(f *pflag.Flag) {
+		field := previewField{Name: f.Name, Value: f.Value.String(), Bool: f.Value.Type() == "bool"}
+		switch f.Name {
+		case "algorithm":
+			field.Choices = dither.Algorithms()
+		case "filter":
+			field.Choices = dither.Filters()
+		case "palette":
+			field.Suggestions = dither.Palettes()
+		}
+		page.Fields = append(page.Fields, field)
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := previewTemplate.Execute(w, page); err != nil {
+		log.Error().Err(err).Msg("writing the preview page")
+	}
+}
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>fls · {{.Input}}</title>
+<style>
+body { font-family: sans-serif; margin: 0; display: flex; }
+form { padding: 1em; min-width: 14em; }
+label { display: block; margin-bottom: 0.6em; font-size: 0.9em; }
+label input:not([type=checkbox]), label select { display: block; width: 100%; }
+main { padding: 1em; overflow: auto; }
+img { image-rendering: pixelated; }
+#error { color: #b00; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<form action="/">
+{{range .Fields}}<label>{{.Name}}
+{{if .Bool}}<input type="checkbox" name="{{.Name}}" value="true"{{if eq .Value "true"}} checked{{end}}>
+{{else if .Choices}}{{$value := .Value}}<select name="{{.Name}}">{{range .Choices}}<option{{if eq . $value}} selected{{end}}>{{.}}</option>{{end}}</select>
+{{else}}<input name="{{.Name}}" value="{{.Value}}"{{if .Suggestions}} list="{{.Name}}-list"><datalist id="{{.Name}}-list">{{range .Suggestions}}<option>{{.}}</option>{{end}}</datalist{{end}}>
+{{end}}</label>
+{{end}}<button>Render</button>
+</form>
+<main>
+<p id="error">{{.Error}}</p>
+<img id="preview" src="{{.Source}}" alt="{{.Input}}">
+</main>
+<script>
+const form = document.forms[0];
+const preview = document.getElementById("preview");
+const error = document.getElementById("error");
+let rendering = 0;
+
+// render shows the preview of the settings of the form, or the error
+// rendering it.
+async function render() {
+	const query = new URLSearchParams(new FormData(form)).toString();
+	history.replaceState(null, "", "?" + query);
+	const n = ++rendering;
+	const resp = await fetch("/preview?" + query);
+	const body = await (resp.ok ? resp.blob() : resp.text());
+	if (n !== rendering) {
+		return;
+	}
+	if (!resp.ok) {
+		error.textContent = body;
+		return;
+	}
+	error.textContent = "";
+	if (preview.src.startsWith("blob:")) {
+		URL.revokeObjectURL(preview.src);
+	}
+	preview.src = URL.createObjectURL(body);
+}
+
+form.addEventListener("change", render);
+form.addEventListener("submit", e => {
+	e.preventDefault();
+	render();
+});
+</script>
+</body>
+</html>
+`))
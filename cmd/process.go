@@ -0,0 +1,641 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// mode selects the stages a processing command runs.
+type mode int
+
+const (
+	modeDither   mode = iota // scale, then dither to the palette
+	modeQuantize             // scale, then map each pixel to the nearest palette color
+	modeResize               // scale only
+)
+
+var ditherCmd = &cobra.Command{
+	Use:   "dither <input>...",
+	Short: "Dither images to a palette, black and white by default",
+	Long: `Dither images to a palette, black and white by default, with the
+Floyd-Steinberg algorithm unless another one of the algorithms command is
+selected by --algorithm. Rescaling is applied before the
+dithering, by --scale or to the size set by --width, --height or --fit, with
+the nearest-neighbor algorithm unless another filter is selected by --filter.
+Other palettes are selected by --palette, as a name from the palettes command,
+a list of hex colors or a palette file, or by --levels for levels of gray,
+and --colors extracts a palette of that many colors from the scaled image by
+median cut, turning photos into paletted images of their own colors. The
+frames of an animation share the palette extracted from all of them.
+
+The error diffusion of the algorithms diffusing the quantization error, like
+Floyd-Steinberg, is attenuated by --strength for a softer dithering, and
+--serpentine alternates the direction of the rows against its worm artifacts.
+
+Instead of dithering, the threshold algorithm maps the pixels darker than
+--threshold to the darkest color of the palette and the others to the
+lightest one, and otsu finds the threshold best separating the dark and light
+pixels of each image. The halftone algorithm draws the round dots of a
+newspaper screen, --dot-size pixels apart in rows at --screen-angle degrees.
+
+The blue-noise algorithm spreads the dots of each level evenly, without the
+patterns of the error diffusion and of Bayer matrices, with the thresholds of
+a 64x64 mask generated from --seed: results are the same for the same seed,
+and the ones whose sizes are multiples of 64 tile seamlessly.
+
+With --max-memory, an image whose processing in memory would need more is
+dithered in bands of rows, and the rows of a non-interlaced PNG file are even
+decoded as they are dithered, so that very large scans are processed in
+memory proportional to their width. The rows are only streamed with the
+nearest-neighbor filter, without --auto-contrast or --colors, for a PNG result.
+
+The tones of the scaled image are adjusted before the dithering by
+--auto-contrast, stretching them to the full range, then by --brightness,
+--contrast and --gamma, for instance to keep a dark photo from turning black.
+
+Before the scaling, --crop keeps a part of the source, in the pixels of the
+source turned by its EXIF orientation, --rotate turns it clockwise and --flip
+mirrors it. After the adjustments, --pad centers the image on a color, white
+unless set, to the exact size of the result, for instance with --fit to
+letterbox photos of any aspect ratio to the size of a screen.
+
+Several inputs may be given: image files, archives, directories, whose images
+are processed, and glob patterns. The results are written under --out-dir and
+named after --output-template, the inputs that fail being reported once the
+others are processed.
+
+The frames of an animated GIF file are each reduced when the result is a GIF
+too, keeping their delays, disposal and the loop count; only the first one is
+processed otherwise.
+
+The ansi format writes the result as text for the terminal, two pixels per
+character with the colored half blocks of 24-bit ANSI colors, and the ascii
+format as braille patterns of 2x4 pixels, raised for the light ones. Their
+results are scaled down to the width of the terminal unless --scale, --width,
+--height or --fit is set, and the one of a single input is written to the
+standard output unless --output, --out-dir or --output-template is set, for
+a quick preview over SSH.
+
+The --device flag makes the result for an e-paper panel of the devices
+command: dithered to its palette, fitted to its size unless scaled otherwise
+and centered on its lightest color. The raw output format is then the packed
+framebuffer its driver expects, turned by the rotation of the panel or
+--rotation, and the c and go formats embed the framebuffer in a C header or a
+Go byte slice.
+
+The input - is the standard input, whose format is sniffed from its first
+bytes, and so is the format of the files with an unknown extension. Its result
+is written to the standard output, like the one of --output -, the reports
+being printed on stderr with the logs.`,
+	Args:              usageArgs(cobra.MinimumNArgs(1)),
+	ValidArgsFunction: completeImageFiles,
+	RunE:              runner(modeDither),
+}
+
+var quantizeCmd = &cobra.Command{
+	Use:   "quantize <input>...",
+	Short: "Reduce images to a palette, black and white by default, without dithering",
+	Long: `Reduce images to a palette, black and white by default, by mapping each pixel
+to the nearest palette color, without diffusing the quantization error. Rescaling is applied before
+like with the dither command, and other palettes are selected by --palette,
+--levels or --colors.`,
+	Args:              usageArgs(cobra.MinimumNArgs(1)),
+	ValidArgsFunction: completeImageFiles,
+	RunE:              runner(modeQuantize),
+}
+
+var resizeCmd = &cobra.Command{
+	Use:               "resize <input>...",
+	Short:             "Rescale an image, with the nearest-neighbor algorithm unless --filter is set",
+	Args:              usageArgs(cobra.MinimumNArgs(1)),
+	ValidArgsFunction: completeImageFiles,
+	RunE:              runner(modeResize),
+}
+
+// stage returns the name of the stage reducing the image to the palette.
+func (m mode) stage() string {
+	if m == modeQuantize {
+		return "quantize"
+	}
+	return "dither"
+}
+
+func runner(m mode) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		o, err := newOptions(cmd.Flags(), m, args[0])
+		if err != nil {
+			return err
+		}
+		inputs, err := expandInputs(args, o.recursive)
+		if err != nil {
+			return err
+		}
+		if len(args) == 1 && len(inputs) == 1 && inputs[0].path == filepath.Clean(args[0]) {
+			return process(cmd, inputs[0], o)
+		}
+		return processInputs(cmd, inputs, o)
+	}
+}
+
+func process(cmd *cobra.Command, in input, o *options) error {
+	log.Info().Str("version", buildVersion()).Msg("fls")
+	if _, ok := textFormats[o.Format]; ok && o.output == "" && o.outDir == "" && o.outputTemplate == "" && archiveFormat(in.path) == "" {
+		// A single text result is shown on the terminal.
+		so := *o
+		so.output = stdio
+		o = &so
+	}
+	if o.dryRun && archiveFormat(in.path) == "" {
+		return reportPlans(cmd, []plan{planFile(in, o)})
+	}
+	return processInput(cmd, in, o)
+}
+
+// processInput runs the pipeline on the image file or the archive in, writing
+// the result at its output path.
+func processInput(cmd *cobra.Command, in input, o *options) error {
+	path := in.path
+	if archiveFormat(path) != "" {
+		return processArchive(cmd, path, o)
+	}
+	output := o.outputPath(in)
+	if output == stdio {
+		// The reports are printed on stderr, along with the logs.
+		so := *o
+		so.reportOut = stderr
+		o = &so
+	}
+	if path == stdio && o.pages != nil {
+		return withExitCode(exitUsage, errors.New("--pages cannot be used with the standard input"))
+	}
+	if dir := filepath.Dir(output); o.output == "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return withExitCode(exitWrite, fmt.Errorf("creating output directory: %w", err))
+		}
+	}
+	if animated(path, o) {
+		return processAnimation(cmd, path, output, o)
+	}
+	if o.pages != nil || dither.FormatOf(path) == "tiff" {
+		return processPages(cmd, path, output, o)
+	}
+	return processFile(cmd, path, output, o)
+}
+
+// processFile runs the pipeline on the image file at path, or on its page
+// o.page, writing the result at output.
+func processFile(cmd *cobra.Command, path, output string, o *options) error {
+	logger := log.With().Str("file", path).Logger()
+	if o.page > 0 {
+		logger = logger.With().Int("page", o.page).Logger()
+	}
+	st := newStages(cmd.Context(), logger, stageCount(o))
+	defer st.finish()
+
+	var file io.ReadCloser
+	err := st.run("open", func() (err error) {
+		logger.Info().Str("stage", "open").Msgf("opening file %q", path)
+		file, err = openInput(path)
+		if err != nil {
+			return withExitCode(exitDecode, fmt.Errorf("reading file %q: %w", path, err))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	br := bufio.NewReader(file)
+	format := inputFormat(path, br)
+	var r *rendered
+	s, md, err := streamRows(st, format, file, br, o)
+	switch {
+	case err != nil:
+		return err
+	case s != nil:
+		r, err = renderStream(st, path, s, md, o)
+	default:
+		r, err = render(st, path, format, br, o, output)
+	}
+	if err != nil {
+		return err
+	}
+
+	st.logger = logger.With().Str("output_path", output).Logger()
+	err = st.run("write", func() error {
+		st.logger.Info().Str("stage", "write").Msgf("writing result at path %q", output)
+		return writeOutput(cmd, output, r.encoded)
+	})
+	if err != nil {
+		return err
+	}
+	st.finish()
+	sidecarTo := output
+	if output == stdio {
+		sidecarTo = ""
+	}
+	if err := report(cmd, st, path, sidecarTo, r, o); err != nil {
+		return err
+	}
+	r.release()
+	return nil
+}
+
+// writeOutput writes the encoded result data at output, or to the standard
+// output for stdio.
+func writeOutput(cmd *cobra.Command, output string, data []byte) error {
+	if output == stdio {
+		if _, err := cmd.OutOrStdout().Write(data); err != nil {
+			return withExitCode(exitWrite, fmt.Errorf("writing result to the standard output: %w", err))
+		}
+		return nil
+	}
+	return dither.WriteFile(output, data)
+}
+
+// reportPlans prints the dry-run report of plans.
+func reportPlans(cmd *cobra.Command, plans []plan) error {
+	checkCollisions(plans)
+	n, err := printPlans(cmd.OutOrStdout(), plans)
+	if err != nil {
+		return fmt.Errorf("printing dry-run report: %w", err)
+	}
+	if n > 0 {
+		return fmt.Errorf("dry run found %d problem(s)", n)
+	}
+	return nil
+}
+
+// stageCount returns the number of stages run by process with o.
+func stageCount(o *options) int {
+	count := 4 // open, decode, encode and write
+	if o.Reframes() {
+		count++
+	}
+	if o.Pads() {
+		count++
+	}
+	if o.Scales() || o.columns > 0 {
+		count++
+	}
+	if o.Adjusts() {
+		count++
+	}
+	if o.mode != modeResize {
+		count++
+	}
+	return count
+}
+
+// rendered holds the outcome of the processing of an image.
+type rendered struct {
+	input   image.Rectangle // the bounds of the decoded image
+	src     image.Image     // the decoded and scaled source, nil when banded
+	result  image.Image     // nil when banded
+	bounds  image.Rectangle
+	encoded []byte
+}
+
+// render decodes the named image of the given format read from in, runs the
+// pipeline of o on it and encodes the result to be written at output.
+func render(st *stages, name, format string, in io.Reader, o *options, output string) (*rendered, error) {
+	img, md, err := decodeStage(st, name, format, in, o)
+	if err != nil {
+		return nil, err
+	}
+	return renderImage(st, img, o.withMetadata(md), output)
+}
+
+// decodeStage runs the decode stage of the named image of the given format
+// read from in, and returns it with its metadata.
+func decodeStage(st *stages, name, format string, in io.Reader, o *options) (image.Image, dither.Metadata, error) {
+	var (
+		img image.Image
+		md  dither.Metadata
+	)
+	err := st.run("decode", func() (err error) {
+		img, md, err = decode(name, format, in, decodeOptions(o))
+		return err
+	})
+	if err != nil {
+		return nil, md, err
+	}
+	logProfile(st.logger, md.Profile)
+	b := dither.SourceBounds(img)
+	st.logger.Info().Int("width", b.Dx()).Int("height", b.Dy()).Msg("decoded")
+	if md.Orientation > 1 && !o.IgnoreOrientation {
+		st.logger.Info().Int("orientation", md.Orientation).Msg("turned according to the EXIF orientation")
+	}
+	if s, ok := img.(*dither.Shrunk); ok {
+		st.logger.Info().Int("factor", s.Factor).Int("shrunk_width", s.Rect.Dx()).Int("shrunk_height", s.Rect.Dy()).
+			Msgf("shrunk by %d ahead of the scaling by %v", s.Factor, o.Scale)
+	}
+	return img, md, nil
+}
+
+// withMetadata returns the options encoding the results of an image of
+// metadata md: o itself, unless --keep-metadata makes them keep md.
+func (o *options) withMetadata(md dither.Metadata) *options {
+	if !o.keepMetadata {
+		return o
+	}
+	mo := *o
+	mo.Encoding.Metadata = md
+	return &mo
+}
+
+// renderImage runs the pipeline of o on the decoded image img and encodes
+// the result to be written at output.
+func renderImage(st *stages, img image.Image, o *options, output string) (*rendered, error) {
+	o = o.fitted(img)
+	if banded(st, img, o) {
+		r, err := renderBands(st, img, o)
+		if err != nil {
+			return nil, err
+		}
+		r.input = dither.SourceBounds(img)
+		return r, nil
+	}
+
+	r := &rendered{input: dither.SourceBounds(img), src: img}
+	p, err := pipeline(o, r)
+	if err != nil {
+		return nil, err
+	}
+	p.Hooks = st.hooks()
+	st.logger.Info().Float32("scale", o.Scale).Str("filter", o.Filter).Str("algorithm", o.Algorithm).Msg("processing...")
+	if r.result, err = p.Run(st.ctx, img); err != nil {
+		return nil, err
+	}
+	if o.mode == modeResize {
+		r.src = r.result
+	}
+	r.bounds = r.result.Bounds()
+
+	err = st.run("encode", func() (err error) {
+		r.encoded, err = encode(r.result, o, output)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// pipeline returns the pipeline of o. The image reduced to the palette is
+// recorded as the source of r.
+func pipeline(o *options, r *rendered) (*dither.Pipeline, error) {
+	p, err := dither.NewPipeline(o.Options)
+	if err != nil {
+		return nil, err
+	}
+	// The reduction is the last stage of the standard pipeline.
+	last := len(p.Stages) - 1
+	if o.mode == modeResize {
+		p.Stages = p.Stages[:last]
+		return p, nil
+	}
+	reduce := p.Stages[last]
+	p.Stages[last] = dither.NewStage(o.mode.stage(), func(ctx context.Context, img image.Image) (image.Image, error) {
+		r.src = img
+		return reduce.Apply(ctx, img)
+	})
+	return p, nil
+}
+
+// report prints and writes the timings, statistics, metrics and sidecar
+// requested for the result r of the processing of input written at output.
+// The sidecar is not written when output is empty.
+func report(cmd *cobra.Command, st *stages, input, output string, r *rendered, o *options) error {
+	st.processed(r.input, r.bounds, len(r.encoded))
+	if o.timings {
+		if err := printTimings(o.timingsWriter(), st.timings); err != nil {
+			return err
+		}
+	}
+
+	var stats *dither.Stats
+	if dst, ok := r.result.(*image.Paletted); ok && (o.stats || o.statsJSON != "") {
+		s := dither.ComputeStats(r.src, dst)
+		stats = &s
+		if o.stats {
+			if err := printStats(o.reports(cmd), s); err != nil {
+				return err
+			}
+		}
+		if o.statsJSON != "" {
+			st.logger.Info().Msgf("writing statistics at path %q", o.statsJSON)
+			if err := writeStats(o.statsJSON, s); err != nil {
+				return withExitCode(exitWrite, fmt.Errorf("writing statistics %q: %w", o.statsJSON, err))
+			}
+		}
+	}
+
+	var metrics *dither.Metrics
+	if o.mode != modeResize && (o.metrics || o.compareAlgorithms) {
+		var ms []namedMetrics
+		for _, alg := range dither.Algorithms() {
+			dst := r.result
+			if alg != o.Algorithm {
+				if !o.compareAlgorithms {
+					continue
+				}
+				var err error
+				p := r.result.(*image.Paletted).Palette
+				if dst, err = dither.ReduceScreened(cmd.Context(), r.src, p, alg, o.Diffusion, o.Screen); err != nil {
+					return err
+				}
+			}
+			ms = append(ms, namedMetrics{alg, dither.ComputeMetrics(r.src, dst, o.metricsSigma)})
+			if alg == o.Algorithm {
+				metrics = &ms[len(ms)-1].Metrics
+			}
+		}
+		if err := printMetrics(o.reports(cmd), ms); err != nil {
+			return err
+		}
+	}
+
+	if o.compareGIF != "" {
+		st.logger.Info().Msgf("writing comparison at path %q", o.compareGIF)
+		if err := writeComparison(cmd.Context(), o.compareGIF, r, o); err != nil {
+			return withExitCode(exitWrite, fmt.Errorf("writing comparison %q: %w", o.compareGIF, err))
+		}
+	}
+
+	if o.sidecar && output != "" {
+		p := sidecarPath(output)
+		st.logger.Info().Str("stage", "sidecar").Msgf("writing sidecar at path %q", p)
+		sc := sidecar{
+			Version: buildVersion(),
+			Input:   input,
+			Output:  output,
+			Scale:   o.Scale,
+			Filter:  o.Filter,
+			Colors:  o.Colors,
+			Width:   r.bounds.Dx(),
+			Height:  r.bounds.Dy(),
+			Timings: st.timings,
+			Stats:   stats,
+			Metrics: metrics,
+		}
+		if o.Adjusts() {
+			sc.Adjust = &o.Adjust
+		}
+		if rss, ok := peakRSS(); ok {
+			sc.PeakRSS = rss
+		}
+		if err := writeSidecar(p, sc); err != nil {
+			return withExitCode(exitWrite, fmt.Errorf("writing sidecar %q: %w", p, err))
+		}
+	}
+	return nil
+}
+
+// decode decodes the named image of the given format read from r with opts.
+func decode(name, format string, r io.Reader, opts dither.DecodeOptions) (image.Image, dither.Metadata, error) {
+	switch {
+	case format == "" && name == stdio:
+		return nil, dither.Metadata{}, &dither.DecodeError{Path: name, Err: dither.ErrUnsupportedFormat}
+	case format == "":
+		return nil, dither.Metadata{}, withExitCode(exitUnsupported, fmt.Errorf("image type %q of %q: %w", filepath.Ext(name), name, dither.ErrUnsupportedFormat))
+	}
+	img, md, err := dither.DecodeWithMetadata(r, format, opts)
+	if de, ok := err.(*dither.DecodeError); ok {
+		de.Path = name
+	}
+	if errors.Is(err, dither.ErrTooLarge) {
+		return nil, md, fmt.Errorf("%w (see --max-pixels)", err)
+	}
+	return img, md, err
+}
+
+// decodeOptions returns the settings of the decoding of the images processed
+// with o.
+func decodeOptions(o *options) dither.DecodeOptions {
+	opts := dither.DecodeOptions{
+		MaxPixels:         o.MaxPixels,
+		Shrink:            dither.ShrinkFactor(o.Scale),
+		AssumeSRGB:        o.AssumeSRGB,
+		IgnoreOrientation: o.IgnoreOrientation,
+	}
+	if o.page > 0 {
+		opts.Page = o.page - 1
+	}
+	return opts
+}
+
+// logProfile logs how the colors of an image with the color profile p, nil
+// if it has none, were read.
+func logProfile(logger zerolog.Logger, p *dither.Profile) {
+	switch {
+	case p == nil:
+	case p.Space == "":
+		logger.Warn().Str("profile", p.Description).Msg("unknown color profile, assuming sRGB (see --assume-srgb)")
+	case p.Space == dither.SpaceSRGB:
+		logger.Debug().Str("profile", p.Description).Msg("sRGB color profile")
+	default:
+		logger.Info().Str("profile", p.Description).Msgf("converted the colors from %s to sRGB", p.Space)
+	}
+}
+
+// addMaxPixelsFlag defines the --max-pixels flag of the commands decoding
+// images.
+func addMaxPixelsFlag(c *cobra.Command) {
+	c.Flags().Int64("max-pixels", dither.DefaultMaxPixels, "Largest number of pixels of the images decoded, 0 for no limit")
+}
+
+// addProcessFlags defines the flags shared by the commands producing an image.
+func addProcessFlags(c *cobra.Command) {
+	c.Flags().Float32P("scale", "s", 1., "Scaling coefficient")
+	c.Flags().Int("width", 0, "Width of the result in pixels, instead of --scale; the height follows the aspect ratio unless --height is set")
+	c.Flags().Int("height", 0, "Height of the result in pixels, instead of --scale; the width follows the aspect ratio unless --width is set")
+	c.Flags().String("fit", "", "Largest size of the result keeping the aspect ratio, as WxH like 800x600, instead of --scale")
+	c.Flags().String("crop", "", "Part of the source kept before the scaling, as x,y,w,h like 10,20,800,600 in its pixels")
+	c.Flags().Int("rotate", 0, "Clockwise rotation in degrees of the cropped source, 0, 90, 180 or 270")
+	c.Flags().String("flip", "", "Mirror the rotated source horizontally with h or vertically with v")
+	c.Flags().String("pad", "", "Exact size of the result, as WxH[:color] like 800x600:#000, the scaled image centered on the color, white by default")
+	addFilterFlag(c)
+	c.Flags().Float64("brightness", 0, "Brightness added to the scaled image, from -1 to 1")
+	c.Flags().Float64("contrast", 0, "Contrast change of the scaled image, from -1 for a flat gray to 1 for a threshold")
+	c.Flags().Float64("gamma", 1, "Gamma correction of the scaled image, lightening its midtones above 1 and darkening them below")
+	c.Flags().Bool("auto-contrast", false, "Stretch the tones of the scaled image to the full range from black to white, before the other adjustments")
+	c.Flags().String("out-dir", "", "Directory the results are written to, mirroring the subdirectories of the input directories")
+	c.Flags().String("output-template", "", `Name of the results, where {name} is the input name without extension and {ext} the output one (default "{name}_fls{ext}")`)
+	c.Flags().BoolP("recursive", "r", false, "Process the images of the subdirectories of the input directories too")
+	c.Flags().IntP("jobs", "j", runtime.GOMAXPROCS(0), "Number of archives, animations and documents of several pages processed at the same time, see --decode-workers for the image files")
+	c.Flags().Bool("strict", false, "Stop at the first input, or image of an archive, that fails instead of processing the others")
+	c.Flags().Bool("dry-run", false, "Report what would be done without decoding or writing images")
+	c.Flags().Bool("sidecar", false, "Write a JSON description of the result next to the output file")
+	c.Flags().Bool("timings", false, "Print the duration of each processing stage")
+	c.Flags().Int("threads", 0, "Number of threads scaling and quantizing an image, 0 for one per CPU; error diffusion runs on one")
+	addMaxPixelsFlag(c)
+	addAssumeSRGBFlag(c)
+	c.Flags().Bool("no-auto-orient", false, "Leave the images as they are encoded instead of turning them according to their EXIF orientation")
+	c.Flags().Bool("keep-metadata", false, "Keep the resolution and the ICC profile of the sources in the PNG results, the profile only when the colors were not converted")
+	c.Flags().String("pages", "", "Pages of a multi-page TIFF input to process, as numbers and ranges like 1,3-5 (default all)")
+	c.Flags().Int64("max-memory", 0, "Memory in bytes above which an image is dithered in bands of rows, and a PNG file decoded row by row, instead of as a whole, 0 for no limit")
+	c.Flags().String("format", "", "Output format, see the formats command (default from the output file extension, png otherwise)")
+	_ = c.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return formatNames(), cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+// addFilterFlag defines the --filter flag of the commands scaling images.
+func addFilterFlag(c *cobra.Command) {
+	c.Flags().String("filter", "nearest", fmt.Sprintf("Interpolation scaling the images, one of %s", strings.Join(dither.Filters(), ", ")))
+	_ = c.RegisterFlagCompletionFunc("filter", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return dither.Filters(), cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+// addAssumeSRGBFlag defines the --assume-srgb flag of the commands decoding
+// images.
+func addAssumeSRGBFlag(c *cobra.Command) {
+	c.Flags().Bool("assume-srgb", false, "Take the colors of the images as sRGB ones instead of converting them from their embedded color profile")
+}
+
+// addPaletteFlags defines the flags of the commands producing a paletted
+// image.
+func addPaletteFlags(c *cobra.Command) {
+	c.Flags().String("palette", "", "Palette of the result: a name from the palettes command, hex colors like #ff8000,#000 or a palette file (default black and white)")
+	_ = c.RegisterFlagCompletionFunc("palette", completePalettes)
+	c.Flags().Int("levels", 0, "Number of levels of gray of the result, from 2 to 256, instead of --palette")
+	c.Flags().Int("colors", 0, "Number of colors, from 2 to 256, of a palette extracted from the scaled image by median cut, instead of --palette")
+	c.Flags().Bool("stats", false, "Print statistics on the palette usage and tonal content of the result")
+	c.Flags().String("stats-json", "", "Write the statistics as JSON to this file")
+	_ = c.RegisterFlagCompletionFunc("stats-json", completeFileExt("json"))
+	c.Flags().Bool("metrics", false, "Print the PSNR and SSIM of the low-pass filtered result against the grayscale source")
+	c.Flags().Float64("metrics-sigma", 1., "Standard deviation in pixels of the Gaussian low-pass filter used by --metrics")
+	c.Flags().Bool("compare-algorithms", false, "Print the metrics of every algorithm, and add its result to --compare-gif")
+	c.Flags().String("compare-gif", "", "Write to this file a looping GIF alternating the scaled source and the result")
+	_ = c.RegisterFlagCompletionFunc("compare-gif", completeFileExt("gif"))
+	c.Flags().Duration("compare-delay", time.Second, "Duration each frame of --compare-gif is shown")
+}
+
+func init() {
+	for _, c := range []*cobra.Command{ditherCmd, quantizeCmd, resizeCmd} {
+		addProcessFlags(c)
+		rootCmd.AddCommand(c)
+	}
+	addProcessFlags(watchCmd)
+	addPaletteFlags(ditherCmd)
+	addPaletteFlags(watchCmd)
+	addPaletteFlags(quantizeCmd)
+}
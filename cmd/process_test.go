@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// writeOrientedPNG writes to path the image of encodeTestPNG with EXIF
+// metadata of the orientation o, in a little-endian TIFF structure.
+func writeOrientedPNG(t *testing.T, path string, w, h, o int) {
+	t.Helper()
+	exif := []byte("II*\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	exif[18] = byte(o)
+	var chunk bytes.Buffer
+	binary.Write(&chunk, binary.BigEndian, uint32(len(exif)))
+	chunk.WriteString("eXIf")
+	chunk.Write(exif)
+	binary.Write(&chunk, binary.BigEndian, crc32.ChecksumIEEE(chunk.Bytes()[4:]))
+	// The chunk follows the signature and the header.
+	data := encodeTestPNG(t, w, h)
+	data = append(append(data[:33:33], chunk.Bytes()...), data[33:]...)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAutoOrient checks that the images are turned according to their EXIF
+// orientation unless --no-auto-orient.
+func TestAutoOrient(t *testing.T) {
+	dir := t.TempDir()
+	for i, tt := range []struct {
+		o          int
+		noOrient   bool
+		w, h       int
+		blackFirst bool // whether the top left pixel, of the dark side of the gradient, is black
+	}{
+		{1, false, 8, 2, true},
+		{3, false, 8, 2, false},
+		{6, false, 2, 8, true},
+		{8, false, 2, 8, false},
+		{3, true, 8, 2, true},
+		{6, true, 8, 2, true},
+	} {
+		in := filepath.Join(dir, fmt.Sprintf("in%d.png", i))
+		out := filepath.Join(dir, fmt.Sprintf("out%d.png", i))
+		writeOrientedPNG(t, in, 8, 2, tt.o)
+		args := []string{in, "-o", out}
+		if tt.noOrient {
+			args = append(args, "--no-auto-orient")
+		}
+		if err := runFls(t, args...); err != nil {
+			t.Fatal(err)
+		}
+		img := decodeTestPNG(t, out)
+		b := img.Bounds()
+		l, _, _, _ := img.At(b.Min.X, b.Min.Y).RGBA()
+		if b.Dx() != tt.w || b.Dy() != tt.h || (l == 0) != tt.blackFirst {
+			t.Errorf("orientation %d, --no-auto-orient %v: %dx%d image whose top left pixel is of level %#04x, expected %dx%d and black %v",
+				tt.o, tt.noOrient, b.Dx(), b.Dy(), l, tt.w, tt.h, tt.blackFirst)
+		}
+	}
+}
+
+// withStdin makes the standard input read data until the end of the test.
+func withStdin(t *testing.T, data []byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		f.Close()
+	})
+}
+
+// TestStdinFormat checks that the format of the standard input is sniffed
+// from its magic bytes.
+func TestStdinFormat(t *testing.T) {
+	src := decodeTestPNG(t, func() string {
+		path := filepath.Join(t.TempDir(), "in.png")
+		writeTestPNG(t, path, 8, 4)
+		return path
+	}())
+	var gifData, jpegData bytes.Buffer
+	if err := gif.Encode(&gifData, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegData, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for i, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"png", encodeTestPNG(t, 8, 4)},
+		{"gif", gifData.Bytes()},
+		{"jpeg", jpegData.Bytes()},
+	} {
+		withStdin(t, tt.data)
+		out := filepath.Join(dir, fmt.Sprintf("out%d.png", i))
+		if err := runFls(t, "-", "-o", out); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if b := decodeTestPNG(t, out).Bounds(); b != image.Rect(0, 0, 8, 4) {
+			t.Errorf("%s: result of bounds %v", tt.name, b)
+		}
+	}
+
+	withStdin(t, []byte("not an image, whatever its length"))
+	err := runFls(t, "-", "-o", filepath.Join(dir, "text.png"))
+	if err == nil || !errors.Is(err, dither.ErrUnsupportedFormat) || exitCode(err) != exitUnsupported {
+		t.Errorf("text on the standard input: error %v of exit code %d", err, exitCode(err))
+	}
+}
+
+// TestStdout checks that with -o - the standard output holds the encoded
+// result alone, the logs and the reports going to stderr.
+func TestStdout(t *testing.T) {
+	withStdin(t, encodeTestPNG(t, 8, 4))
+	var stdout, logs bytes.Buffer
+	out := stderr.out
+	stderr.out = &logs
+	rootCmd.SetOut(&stdout)
+	defer func() {
+		stderr.out = out
+		rootCmd.SetOut(nil)
+		resetFlags(rootCmd)
+	}()
+	rootCmd.SetArgs(defaultCommand([]string{"-", "-o", "-", "-v", "--stats", "--timings"}))
+	if err := rootCmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data := stdout.Bytes()
+	if !bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) || !bytes.HasSuffix(data, []byte("IEND\xaeB`\x82")) {
+		t.Fatalf("standard output of %d bytes not a PNG image alone: %.40q...", len(data), data)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b != image.Rect(0, 0, 8, 4) {
+		t.Errorf("result of bounds %v", b)
+	}
+	for _, s := range []string{"version=", "total", "palette usage:"} {
+		if !strings.Contains(logs.String(), s) {
+			t.Errorf("%q not on stderr: %q", s, logs.String())
+		}
+	}
+}
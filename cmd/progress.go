@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// stderr is where both logs and progress are written. Logs written while a
+// progress bar is displayed erase it first and redraw it afterwards so the
+// two never end up interleaved on the same line.
+var stderr = &termWriter{out: os.Stderr, tty: isTerminal(os.Stderr)}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+type termWriter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	tty    bool
+	bars   bool // whether progress bars are displayed, set with the logging
+	status string
+}
+
+const eraseLine = "\r\x1b[K"
+
+func (w *termWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == "" {
+		return w.out.Write(p)
+	}
+	io.WriteString(w.out, eraseLine)
+	n, err := w.out.Write(p)
+	io.WriteString(w.out, w.status)
+	return n, err
+}
+
+// setStatus replaces the status line displayed below the logs.
+func (w *termWriter) setStatus(s string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status != "" {
+		io.WriteString(w.out, eraseLine)
+	}
+	w.status = s
+	io.WriteString(w.out, s)
+}
+
+// progressLogInterval is the minimum delay between two progress log lines
+// when no progress bar can be displayed.
+const progressLogInterval = 5 * time.Second
+
+// progress tracks the completion of total units of work, files in batch mode
+// or pipeline stages for a single image. The total may be zero when unknown.
+// It draws a progress bar when stderr is a terminal and logs periodically
+// otherwise.
+type progress struct {
+	bar       bool
+	total     int
+	completed int
+	current   string
+	start     time.Time
+	lastLog   time.Time
+}
+
+func startProgress(total int) *progress {
+	now := time.Now()
+	return &progress{
+		bar:     stderr.bars,
+		total:   total,
+		start:   now,
+		lastLog: now,
+	}
+}
+
+// begin reports that work on the named unit started.
+func (p *progress) begin(name string) {
+	p.current = name
+	p.report()
+}
+
+// done reports that the current unit is complete.
+func (p *progress) done() {
+	p.completed++
+	p.report()
+}
+
+// finish removes the progress bar, if any.
+func (p *progress) finish() {
+	if p.bar {
+		stderr.setStatus("")
+	}
+}
+
+func (p *progress) eta() time.Duration {
+	if p.completed == 0 || p.total <= 0 {
+		return 0
+	}
+	elapsed := time.Since(p.start)
+	return (elapsed / time.Duration(p.completed) * time.Duration(p.total-p.completed)).Round(time.Second)
+}
+
+func (p *progress) report() {
+	if !p.bar {
+		if time.Since(p.lastLog) < progressLogInterval && (p.total <= 0 || p.completed < p.total) {
+			return
+		}
+		p.lastLog = time.Now()
+		log.Info().Int("completed", p.completed).Int("total", p.total).
+			Str("current", p.current).Dur("eta_ms", p.eta()).Msg("progress")
+		return
+	}
+
+	if p.total <= 0 {
+		stderr.setStatus(fmt.Sprintf("%d done %s", p.completed, p.current))
+		return
+	}
+	const width = 30
+	filled := width * p.completed / p.total
+	line := fmt.Sprintf("[%s%s] %d/%d %3d%% %s", strings.Repeat("#", filled), strings.Repeat(".", width-filled),
+		p.completed, p.total, 100*p.completed/p.total, p.current)
+	if p.completed > 0 && p.completed < p.total {
+		line += fmt.Sprintf(" ETA %s", p.eta())
+	}
+	stderr.setStatus(line)
+}
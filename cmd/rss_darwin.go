@@ -0,0 +1,12 @@
+package cmd
+
+import "syscall"
+
+// peakRSS returns the maximum resident set size of the process in bytes.
+func peakRSS() (uint64, bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, false
+	}
+	return uint64(ru.Maxrss), true // bytes on macOS
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package cmd
+
+// peakRSS is not implemented on this platform.
+func peakRSS() (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,449 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+const (
+	// shutdownTimeout is how long the server waits for the requests in
+	// flight to complete once asked to stop.
+	shutdownTimeout = 30 * time.Second
+	// writeTimeout is how long a response may take to be written once the
+	// request is processed.
+	writeTimeout = 10 * time.Second
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [input]",
+	Short: "Serve the dithering of images over HTTP",
+	Long: `Serve the dithering of images over HTTP.
+
+With an input image, GET / is a page previewing its dithering, with a form of
+the settings re-rendering it as they change; open http://localhost:8080/ in a
+browser. The input is read again for each rendering, so that its changes show
+too. The page takes the query parameters of /dither but the output format
+ones, and GET /preview responds with the PNG result.
+
+POST /dither takes the image in the request body, or with --allow-url fetches
+it from the url query parameter, and responds with the result with the media
+type of its format. The scale, width, height, fit, crop, rotate, flip, pad,
+filter, brightness, contrast, gamma, auto-contrast, algorithm, palette,
+levels, colors, device, rotation, strength, serpentine, threshold, dot-size,
+screen-angle, seed, format, go-package, go-var, c-name, plain, row-align,
+assume-srgb, no-auto-orient and keep-metadata query parameters have the
+meaning of the flags of the dither command, but for palette files, which are
+not read, for instance:
+
+  curl --data-binary @photo.jpg 'localhost:8080/dither?scale=0.5&palette=cga' -o out.png
+
+GET /healthz responds with 200 while the server runs. Requests are logged with
+--verbose. The server stops gracefully on SIGINT or SIGTERM, letting the
+requests in flight complete.`,
+	Args: usageArgs(cobra.MaximumNArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f := flagReader{fs: cmd.Flags()}
+		s := &server{
+			maxBody:   f.int64("max-body"),
+			maxPixels: f.int64("max-pixels"),
+			timeout:   f.duration("timeout"),
+			sem:       make(chan struct{}, f.int("max-concurrent")),
+			allowURL:  f.bool("allow-url"),
+		}
+		addr := f.string("listen")
+		if f.err != nil {
+			return f.err
+		}
+		if cap(s.sem) < 1 {
+			return withExitCode(exitUsage, errors.New("--max-concurrent must be at least 1"))
+		}
+		if len(args) > 0 {
+			if err := s.setInput(args[0]); err != nil {
+				return err
+			}
+		}
+		return s.listenAndServe(cmd.Context(), addr)
+	},
+}
+
+// server processes the images posted to it.
+type server struct {
+	maxBody   int64
+	maxPixels int64
+	timeout   time.Duration
+	sem       chan struct{} // holds a token per request being processed
+	allowURL  bool
+	client    http.Client
+	input     string // the image previewed, if any
+}
+
+func (s *server) listenAndServe(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dither", s.dither)
+	if s.input != "" {
+		mux.HandleFunc("/", s.previewPage)
+		mux.HandleFunc("/preview", s.preview)
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+	// A request must be received within --timeout, and its response written
+	// soon after.
+	srv := &http.Server{
+		Handler:           accessLog(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       s.timeout,
+		WriteTimeout:      s.timeout + writeTimeout,
+	}
+	s.client.Timeout = s.timeout
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return withExitCode(exitUsage, err)
+	}
+	log.Info().Str("address", l.Addr().String()).Msg("listening")
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(l) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	log.Info().Msg("shutting down")
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(sctx)
+}
+
+// httpError is an error with the HTTP status it is reported with.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string { return e.err.Error() }
+func (e *httpError) Unwrap() error { return e.err }
+
+func withStatus(status int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &httpError{status: status, err: err}
+}
+
+// status returns the HTTP status reporting err.
+func status(err error) int {
+	var (
+		he *httpError
+		de *dither.DecodeError
+		ve *dither.ValidationError
+	)
+	switch {
+	case errors.As(err, &he):
+		return he.status
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, dither.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, dither.ErrUnsupportedFormat):
+		return http.StatusUnsupportedMediaType
+	case errors.As(err, &de), errors.As(err, &ve), exitCode(err) == exitUsage:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// queryParams returns the flags set from the query parameters of a /dither
+// request, named after those of the dither command.
+func queryParams() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("dither", pflag.ContinueOnError)
+	fs.Float32("scale", 1., "")
+	fs.Int("width", 0, "")
+	fs.Int("height", 0, "")
+	fs.String("fit", "", "")
+	fs.String("crop", "", "")
+	fs.Int("rotate", 0, "")
+	fs.String("flip", "", "")
+	fs.String("pad", "", "")
+	fs.String("filter", "nearest", "")
+	fs.Float64("brightness", 0, "")
+	fs.Float64("contrast", 0, "")
+	fs.Float64("gamma", 1, "")
+	fs.Bool("auto-contrast", false, "")
+	fs.String("algorithm", dither.DefaultOptions().Algorithm, "")
+	fs.String("palette", "", "")
+	fs.Int("levels", 0, "")
+	fs.Int("colors", 0, "")
+	fs.String("device", "", "")
+	fs.Int("rotation", 0, "")
+	fs.Float64("strength", 1, "")
+	fs.Bool("serpentine", false, "")
+	fs.Float64("threshold", 0.5, "")
+	fs.Float64("dot-size", 6, "")
+	fs.Float64("screen-angle", 45, "")
+	fs.Int64("seed", 0, "")
+	fs.String("format", "", "")
+	fs.String("go-package", "", "")
+	fs.String("go-var", "", "")
+	fs.String("c-name", "", "")
+	fs.Bool("plain", false, "")
+	fs.Int("row-align", 0, "")
+	fs.Bool("assume-srgb", false, "")
+	fs.Bool("no-auto-orient", false, "")
+	fs.Bool("keep-metadata", false, "")
+	return fs
+}
+
+func (s *server) dither(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		fail(w, withStatus(http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	// The image is received before waiting for a slot, which a slow upload
+	// would otherwise hold.
+	data, o, err := s.receive(ctx, r)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	if err := s.acquire(ctx); err != nil {
+		fail(w, err)
+		return
+	}
+	defer s.release()
+
+	out, contentType, err := processData(ctx, data, dither.SniffFormat(data), o)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(out)
+}
+
+// acquire waits for a processing slot, until ctx is done. The slot is then
+// given back with release.
+func (s *server) acquire(ctx context.Context) error {
+	select {
+	case s.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return withStatus(http.StatusServiceUnavailable, errors.New("too many concurrent requests"))
+	}
+}
+
+func (s *server) release() { <-s.sem }
+
+// checkPalette refuses the palette query parameters naming neither a preset
+// nor hex colors: the palette files of the server are not read for its
+// clients.
+func checkPalette(query url.Values) error {
+	for _, p := range query["palette"] {
+		if _, ok := dither.LookupPalette(p); ok {
+			continue
+		}
+		if _, err := dither.ParsePalette(p); err != nil {
+			return withStatus(http.StatusBadRequest, fmt.Errorf("invalid palette %q, expected one of %v or hex colors like #ff8000,#000", p, dither.Palettes()))
+		}
+	}
+	return nil
+}
+
+// receive returns the encoded image of the /dither request r, from its body
+// or its url, and the options of its query parameters.
+func (s *server) receive(ctx context.Context, r *http.Request) ([]byte, *options, error) {
+	query := r.URL.Query()
+	source := query.Get("url")
+	query.Del("url")
+	fs := queryParams()
+	if err := setParams(fs, query); err != nil {
+		return nil, nil, err
+	}
+	if err := checkPalette(query); err != nil {
+		return nil, nil, err
+	}
+	o, err := newOptions(fs, modeDither, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	o.MaxPixels = s.maxPixels
+
+	var data []byte
+	if source == "" {
+		data, err = s.read(r.Body)
+	} else {
+		data, err = s.fetch(ctx, source)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, o, nil
+}
+
+// setParams sets the flags fs from the query parameters of the same name.
+func setParams(fs *pflag.FlagSet, query url.Values) error {
+	for name, values := range query {
+		if fs.Lookup(name) == nil {
+			return withStatus(http.StatusBadRequest, fmt.Errorf("unknown query parameter %q", name))
+		}
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return withStatus(http.StatusBadRequest, fmt.Errorf("invalid query parameter %q: %w", name, err))
+			}
+		}
+	}
+	return nil
+}
+
+// processData returns the result of the processing with o of the image of the
+// given format encoded in data, and its content type.
+func processData(ctx context.Context, data []byte, format string, o *options) ([]byte, string, error) {
+	if format == "" {
+		return nil, "", &dither.DecodeError{Err: dither.ErrUnsupportedFormat}
+	}
+	img, md, err := dither.DecodeWithMetadata(bytes.NewReader(data), format, decodeOptions(o))
+	if err != nil {
+		return nil, "", err
+	}
+	logProfile(log.Logger, md.Profile)
+	o = o.withMetadata(md)
+	dst, err := dither.Process(ctx, img, o.Options)
+	if err != nil {
+		return nil, "", err
+	}
+	out, err := encode(dst, o, "")
+	if err != nil {
+		return nil, "", err
+	}
+	f, _ := dither.LookupEncoder(o.Format)
+	return out, f.MediaType, nil
+}
+
+// read reads r up to the maximum body size.
+func (s *server) read(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, s.maxBody+1))
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return nil, withStatus(http.StatusRequestTimeout, fmt.Errorf("reading request body: %w", err))
+	}
+	if err != nil {
+		return nil, withStatus(http.StatusBadRequest, fmt.Errorf("reading request body: %w", err))
+	}
+	if int64(len(data)) > s.maxBody {
+		return nil, withStatus(http.StatusRequestEntityTooLarge, fmt.Errorf("image larger than %d bytes", s.maxBody))
+	}
+	return data, nil
+}
+
+// fetch downloads the image at the given URL.
+func (s *server) fetch(ctx context.Context, source string) ([]byte, error) {
+	if !s.allowURL {
+		return nil, withStatus(http.StatusForbidden, errors.New("url inputs are disabled, see --allow-url"))
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		return nil, withStatus(http.StatusBadRequest, fmt.Errorf("invalid url %q", source))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, withStatus(http.StatusBadRequest, err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, withStatus(http.StatusBadGateway, fmt.Errorf("fetching %q: %w", source, err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, withStatus(http.StatusBadGateway, fmt.Errorf("fetching %q: %s", source, resp.Status))
+	}
+	return s.read(resp.Body)
+}
+
+// fail responds to a request with err.
+func fail(w http.ResponseWriter, err error) {
+	if sw, ok := w.(*statusWriter); ok {
+		sw.err = err
+	}
+	http.Error(w, err.Error(), status(err))
+}
+
+// statusWriter records the response to a request for its access log.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+	err    error
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += n
+	return n, err
+}
+
+// accessLog logs the requests served by h.
+func accessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		e := log.Info()
+		if sw.status >= http.StatusInternalServerError {
+			e = log.Error()
+		}
+		if sw.err != nil {
+			e = e.Str("error", sw.err.Error())
+		}
+		remote := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(remote); err == nil {
+			remote = host
+		}
+		e.Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Str("query", r.URL.RawQuery).
+			Str("remote", remote).
+			Int("status", sw.status).
+			Int("bytes", sw.bytes).
+			Dur("duration_ms", time.Since(start)).
+			Msg("request")
+	})
+}
+
+func init() {
+	serveCmd.Flags().String("listen", "127.0.0.1:8080", "Address to listen on, :8080 for instance to serve on every interface")
+	serveCmd.Flags().Int64("max-body", 32<<20, "Maximum size in bytes of the images received or fetched")
+	addMaxPixelsFlag(serveCmd)
+	serveCmd.Flags().Duration("timeout", 30*time.Second, "Maximum duration of a request, including the receiving of its image and the wait for a slot")
+	serveCmd.Flags().Int("max-concurrent", runtime.NumCPU(), "Maximum number of requests processed at the same time")
+	serveCmd.Flags().Bool("allow-url", false, "Allow fetching the image to process from the url query parameter")
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestServePalettes checks that the palette query parameters of /dither and
+// /preview take presets and hex colors but no file of the server.
+func TestServePalettes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in.png")
+	writeTestPNG(t, in, 16, 8)
+	secret := filepath.Join(dir, "secret.txt")
+	if err := ioutil.WriteFile(secret, []byte("the first line of a secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "palette.txt")
+	if err := ioutil.WriteFile(file, []byte("#000\n#fff\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &server{maxBody: 1 << 20, timeout: 10 * time.Second, sem: make(chan struct{}, 1), input: in}
+	data := encodeTestPNG(t, 16, 8)
+
+	for _, tt := range []struct {
+		palette string
+		status  int
+	}{
+		{"cga", http.StatusOK},
+		{"#ff8000,#000", http.StatusOK},
+		{secret, http.StatusBadRequest},
+		{file, http.StatusBadRequest},
+		{"/etc/passwd", http.StatusBadRequest},
+	} {
+		query := "?palette=" + url.QueryEscape(tt.palette)
+		for _, r := range []*http.Request{
+			httptest.NewRequest(http.MethodPost, "/dither"+query, bytes.NewReader(data)),
+			httptest.NewRequest(http.MethodGet, "/preview"+query, nil),
+		} {
+			w := httptest.NewRecorder()
+			if r.URL.Path == "/dither" {
+				s.dither(w, r)
+			} else {
+				s.preview(w, r)
+			}
+			if w.Code != tt.status {
+				t.Errorf("%s with palette %q: status %d, expected %d: %s", r.URL.Path, tt.palette, w.Code, tt.status, w.Body)
+			}
+			if strings.Contains(w.Body.String(), "first line") {
+				t.Errorf("%s with palette %q: the file is quoted in the response %q", r.URL.Path, tt.palette, w.Body)
+			}
+		}
+	}
+}
+
+func TestServeListensLocally(t *testing.T) {
+	if got := serveCmd.Flags().Lookup("listen").DefValue; !strings.HasPrefix(got, "127.0.0.1:") {
+		t.Errorf("default --listen %q, expected a loopback address", got)
+	}
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// sidecar describes a produced image. It is written next to the output, with
+// a .json extension, when --sidecar is set so that generated assets can be
+// traced back to the binary and settings that produced them.
+type sidecar struct {
+	Version string              `json:"version"`
+	Input   string              `json:"input"`
+	Output  string              `json:"output"`
+	Scale   float32             `json:"scale"`
+	Filter  string              `json:"filter,omitempty"`
+	Adjust  *dither.Adjustments `json:"adjust,omitempty"`
+	Colors  int                 `json:"colors,omitempty"`
+	Width   int                 `json:"width"`
+	Height  int                 `json:"height"`
+
+	Timings []stageTiming   `json:"timings,omitempty"`
+	PeakRSS uint64          `json:"peak_rss_bytes,omitempty"`
+	Stats   *dither.Stats   `json:"stats,omitempty"`
+	Metrics *dither.Metrics `json:"metrics,omitempty"`
+}
+
+func sidecarPath(output string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + ".json"
+}
+
+func writeSidecar(path string, s sidecar) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
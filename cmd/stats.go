@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// printStats writes s in a human readable form.
+func printStats(w io.Writer, s dither.Stats) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 1, ' ', 0)
+	fmt.Fprintln(tw, "palette usage:")
+	for _, p := range s.Palette {
+		fmt.Fprintf(tw, "  %s\t%6.2f%%\n", p.Color, 100*p.Fraction)
+	}
+	fmt.Fprintf(tw, "mean luminance:\t%.4f before, %.4f after\n", s.MeanBefore, s.MeanAfter)
+	if s.Runs != nil {
+		fmt.Fprintf(tw, "longest runs:\t%d black, %d white\n", s.Runs.MaxBlack, s.Runs.MaxWhite)
+	}
+	fmt.Fprintln(tw, "luminance histogram:")
+	for i, f := range s.Histogram {
+		fmt.Fprintf(tw, "  %.4f-%.4f\t%6.2f%% %s\n", float64(i)/dither.HistogramBuckets, float64(i+1)/dither.HistogramBuckets,
+			100*f, strings.Repeat("#", int(f*50+.5)))
+	}
+	return tw.Flush()
+}
+
+func writeStats(path string, s dither.Stats) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
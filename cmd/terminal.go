@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"image"
+	"os"
+	"strconv"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// textFormats are the pixels per character column of the output formats
+// written as text for a terminal, whose results are fitted to its width and
+// written to the standard output by default.
+var textFormats = map[string]int{
+	"ansi":  1, // half blocks
+	"ascii": 2, // braille patterns
+}
+
+// defaultColumns is the width of the terminal when it is unknown.
+const defaultColumns = 80
+
+// terminalColumns returns the width of the terminal of the standard output,
+// or else of stderr, or else $COLUMNS, or else defaultColumns.
+func terminalColumns() int {
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		if n, ok := terminalWidth(f); ok {
+			return n
+		}
+	}
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return defaultColumns
+}
+
+// fitted returns the options processing img with o: o itself, unless the
+// text result is fitted to the terminal and img is wider than it, scaled
+// down to its width.
+func (o *options) fitted(img image.Image) *options {
+	if o.columns == 0 {
+		return o
+	}
+	width := o.columns * textFormats[o.Format]
+	if o.ResultBounds(dither.SourceBounds(img)).Dx() <= width {
+		return o
+	}
+	fo := *o
+	fo.Width = width
+	return &fo
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package cmd
+
+import "os"
+
+// terminalWidth is not implemented on this platform.
+func terminalWidth(f *os.File) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// terminalWidth returns the number of columns of the terminal f, if it is
+// one.
+func terminalWidth(f *os.File) (int, bool) {
+	var ws struct{ rows, cols, xpixels, ypixels uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	return int(ws.cols), errno == 0 && ws.cols > 0
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// stageTiming records how long a pipeline stage took.
+type stageTiming struct {
+	Stage    string  `json:"stage"`
+	Duration float64 `json:"duration_ms"`
+}
+
+// stages runs the stages of the processing of one file, reporting their
+// progress and logging and recording their durations.
+type stages struct {
+	ctx     context.Context
+	logger  zerolog.Logger
+	prog    *progress
+	timings []stageTiming
+}
+
+// newStages returns the stages of the processing of one file, displaying
+// their progress unless count is zero. No stage starts once ctx is done.
+func newStages(ctx context.Context, logger zerolog.Logger, count int) *stages {
+	s := &stages{ctx: ctx, logger: logger}
+	if count > 0 {
+		s.prog = startProgress(count)
+	}
+	return s
+}
+
+// run runs the named stage.
+func (s *stages) run(name string, f func() error) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	s.begin(name)
+	start := time.Now()
+	err := f()
+	s.done(name, time.Since(start), err)
+	return err
+}
+
+// hooks returns the hooks reporting the stages of a pipeline the way run
+// does.
+func (s *stages) hooks() dither.Hooks {
+	return dither.Hooks{Start: s.begin, Done: s.done}
+}
+
+func (s *stages) begin(name string) {
+	if s.prog != nil {
+		s.prog.begin(name)
+	}
+}
+
+func (s *stages) done(name string, d time.Duration, err error) {
+	s.timings = append(s.timings, stageTiming{Stage: name, Duration: float64(d) / float64(time.Millisecond)})
+	if err != nil {
+		return
+	}
+	s.logger.Info().Str("stage", name).Dur("duration_ms", d).Msgf("%s done", name)
+	if s.prog != nil {
+		s.prog.done()
+	}
+}
+
+// processed logs the summary of the processing of an image of bounds input
+// into a result of bounds output, encoded in the given number of bytes: its
+// total duration, its dimensions and size.
+func (s *stages) processed(input, output image.Rectangle, bytes int) {
+	var total float64
+	for _, t := range s.timings {
+		total += t.Duration
+	}
+	s.logger.Info().Float64("duration_ms", total).
+		Int("input_width", input.Dx()).Int("input_height", input.Dy()).
+		Int("output_width", output.Dx()).Int("output_height", output.Dy()).
+		Int("bytes", bytes).Msg("processed")
+}
+
+func (s *stages) finish() {
+	if s.prog != nil {
+		s.prog.finish()
+	}
+}
+
+// printTimings writes the stage durations and the peak memory usage as a
+// compact table.
+func printTimings(w io.Writer, timings []stageTiming) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	var total float64
+	for _, t := range timings {
+		fmt.Fprintf(tw, "%s\t%.3f ms\t\n", t.Stage, t.Duration)
+		total += t.Duration
+	}
+	fmt.Fprintf(tw, "total\t%.3f ms\t\n", total)
+	if rss, ok := peakRSS(); ok {
+		fmt.Fprintf(tw, "peak RSS\t%.1f MiB\t\n", float64(rss)/(1<<20))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+// Build information, set at link time with
+//
+//	-ldflags "-X github.com/sub-mersion/fls/cmd.version=... -X ..."
+var (
+	version string
+	commit  string
+	date    string
+)
+
+// buildVersion returns the semantic version of the binary, falling back to
+// the module version recorded by the go tool for `go install` builds.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+func versionInfo() string {
+	s := fmt.Sprintf("fls %s\n", buildVersion())
+	if commit != "" {
+		s += fmt.Sprintf("commit:     %s\n", commit)
+	}
+	if date != "" {
+		s += fmt.Sprintf("built:      %s\n", date)
+	}
+	s += fmt.Sprintf("go version: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	for _, d := range dither.OptionalDecoders() {
+		lib := d.Library
+		if lib == "" {
+			lib = fmt.Sprintf("not built in, see the %s build tag", d.Tag)
+		}
+		s += fmt.Sprintf("%-12s%s\n", d.Format+":", lib)
+	}
+	return s
+}
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print version and build information",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Fprint(cmd.OutOrStdout(), versionInfo())
+	},
+}
+
+func init() {
+	rootCmd.Version = buildVersion()
+	rootCmd.SetVersionTemplate(versionInfo())
+	rootCmd.AddCommand(versionCmd)
+}
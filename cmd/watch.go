@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sub-mersion/fls/pkg/dither"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch <dir>",
+	Short: "Dither the images added to or modified in a directory as they change",
+	Long: `Watch a directory, and its subdirectories with --recursive, and dither the
+images and archives added to it or modified into --out-dir, with the settings
+of the dither command, for instance to feed a printer from a hot folder. An
+image is processed once it has not changed for --debounce, so that the files
+being copied are processed whole, and the files whose name or path relative
+to the directory match an --ignore pattern are left alone, like --out-dir
+when it is inside the directory. With --existing, the images already in the
+directory are processed first.
+
+A failing image is logged and the watch carries on. It stops on SIGINT or
+SIGTERM, once the image being processed is done.`,
+	Args:              usageArgs(cobra.ExactArgs(1)),
+	ValidArgsFunction: completeDirs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := filepath.Clean(args[0])
+		if st, err := os.Stat(dir); err != nil || !st.IsDir() {
+			return withExitCode(exitUsage, fmt.Errorf("%q is not a directory", dir))
+		}
+		o, err := newOptions(cmd.Flags(), modeDither, "")
+		if err != nil {
+			return err
+		}
+		switch {
+		case o.output != "":
+			return withExitCode(exitUsage, errors.New("--output names a single result, use --out-dir"))
+		case o.outDir == "":
+			return withExitCode(exitUsage, errors.New("--out-dir is required, for the results not to be written into the watched directory"))
+		}
+		w := &watcher{cmd: cmd, dir: dir, o: o}
+		f := flagReader{fs: cmd.Flags()}
+		w.debounce = f.duration("debounce")
+		existing := f.bool("existing")
+		if f.err != nil {
+			return f.err
+		}
+		if w.ignore, err = cmd.Flags().GetStringArray("ignore"); err != nil {
+			return err
+		}
+		for _, p := range w.ignore {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return withExitCode(exitUsage, fmt.Errorf("invalid --ignore pattern %q: %w", p, err))
+			}
+		}
+		if w.debounce < 0 {
+			return withExitCode(exitUsage, fmt.Errorf("invalid --debounce %v, must not be negative", w.debounce))
+		}
+		return w.run(cmd.Context(), existing)
+	},
+}
+
+// watcher processes the images of a watched directory as they change.
+type watcher struct {
+	cmd      *cobra.Command
+	dir      string
+	o        *options
+	debounce time.Duration
+	ignore   []string
+
+	fs *fsnotify.Watcher
+	// pending holds the timers of the changed files, reset by their
+	// changes, which send the files to ready once they fire.
+	pending map[string]*time.Timer
+	ready   chan string
+}
+
+// run watches the directory until ctx is done, first processing the images
+// already in it when existing is set.
+func (w *watcher) run(ctx context.Context, existing bool) error {
+	var err error
+	if w.fs, err = fsnotify.NewWatcher(); err != nil {
+		return fmt.Errorf("watching directory %q: %w", w.dir, err)
+	}
+	defer w.fs.Close()
+	if err := w.add(w.dir); err != nil {
+		return withExitCode(exitDecode, fmt.Errorf("watching directory %q: %w", w.dir, err))
+	}
+	log.Info().Str("version", buildVersion()).Str("dir", w.dir).Str("out_dir", w.o.outDir).Msg("watching")
+	if existing {
+		files, err := dirImages(w.dir, w.o.recursive)
+		if err != nil {
+			return withExitCode(exitDecode, fmt.Errorf("reading directory %q: %w", w.dir, err))
+		}
+		for _, path := range files {
+			if ctx.Err() != nil {
+				break
+			}
+			if !w.ignored(path) {
+				w.process(path)
+			}
+		}
+	}
+
+	w.pending, w.ready = make(map[string]*time.Timer), make(chan string)
+	for {
+		select {
+		case <-ctx.Done():
+			for _, t := range w.pending {
+				t.Stop()
+			}
+			log.Info().Str("dir", w.dir).Msg("stopped watching")
+			return nil
+		case err := <-w.fs.Errors:
+			log.Warn().Str("dir", w.dir).Msgf("watching: %v", err)
+		case path := <-w.ready:
+			delete(w.pending, path)
+			w.process(path)
+		case e := <-w.fs.Events:
+			path := filepath.Clean(e.Name)
+			switch {
+			case w.ignored(path):
+			case e.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				if t, ok := w.pending[path]; ok {
+					t.Stop()
+					delete(w.pending, path)
+				}
+			case e.Op&(fsnotify.Create|fsnotify.Write) != 0:
+				if st, err := os.Stat(path); err == nil && st.IsDir() {
+					if w.o.recursive && e.Op&fsnotify.Create != 0 {
+						w.addCreated(ctx, path)
+					}
+					continue
+				}
+				w.schedule(ctx, path)
+			}
+		}
+	}
+}
+
+// add watches dir and, with --recursive, its subdirectories but the ignored
+// ones.
+func (w *watcher) add(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case !info.IsDir():
+			return nil
+		case path != dir && (!w.o.recursive || w.ignored(path)):
+			return filepath.SkipDir
+		}
+		return w.fs.Add(path)
+	})
+}
+
+// addCreated watches the directory created at dir, and schedules the images
+// it already holds, moved or copied along with it.
+func (w *watcher) addCreated(ctx context.Context, dir string) {
+	if err := w.add(dir); err != nil {
+		log.Warn().Str("dir", dir).Msgf("watching: %v", err)
+		return
+	}
+	files, _ := dirImages(dir, true)
+	for _, path := range files {
+		if !w.ignored(path) {
+			w.schedule(ctx, path)
+		}
+	}
+}
+
+// schedule processes the image at path once it has not changed for
+// --debounce.
+func (w *watcher) schedule(ctx context.Context, path string) {
+	if dither.FormatOf(path) == "" && archiveFormat(path) == "" {
+		return
+	}
+	if t, ok := w.pending[path]; ok {
+		t.Reset(w.debounce)
+		return
+	}
+	w.pending[path] = time.AfterFunc(w.debounce, func() {
+		select {
+		case w.ready <- path:
+		case <-ctx.Done():
+		}
+	})
+}
+
+// ignored reports whether the file or directory at path is left alone: when
+// it matches an --ignore pattern, or it is --out-dir or inside it.
+func (w *watcher) ignored(path string) bool {
+	if within(path, w.o.outDir) {
+		return true
+	}
+	rel, err := filepath.Rel(w.dir, path)
+	if err != nil {
+		return false
+	}
+	for _, p := range w.ignore {
+		if ok, _ := filepath.Match(p, filepath.Base(path)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether path is dir or inside it.
+func within(path, dir string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// process processes the image at path, logging its failure.
+func (w *watcher) process(path string) {
+	if st, err := os.Stat(path); err != nil || !st.Mode().IsRegular() {
+		// Removed or replaced since it changed.
+		return
+	}
+	rel, _ := filepath.Rel(w.dir, filepath.Dir(path))
+	if rel == "." {
+		rel = ""
+	}
+	if err := processInput(w.cmd, input{path: path, rel: rel}, w.o); err != nil && !errors.Is(err, context.Canceled) {
+		log.Error().Msg(err.Error())
+	}
+}
+
+func completeDirs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return nil, cobra.ShellCompDirectiveFilterDirs
+}
+
+func init() {
+	watchCmd.Flags().Duration("debounce", 500*time.Millisecond, "Time an image must not change for before it is processed")
+	watchCmd.Flags().StringArray("ignore", nil, "Glob pattern of the names or relative paths of the files and directories not to process, which may be repeated")
+	watchCmd.Flags().Bool("existing", false, "Process the images already in the directory before watching it")
+	rootCmd.AddCommand(watchCmd)
+}
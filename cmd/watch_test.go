@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestWatcher returns a watcher of dir, with the debounce d, not watching
+// the file system.
+func newTestWatcher(dir string, d time.Duration, ignore ...string) *watcher {
+	return &watcher{
+		dir: dir, o: &options{outDir: filepath.Join(dir, "out")}, debounce: d, ignore: ignore,
+		pending: make(map[string]*time.Timer), ready: make(chan string),
+	}
+}
+
+// readyPaths returns the paths w sends as ready until none is sent for wait.
+func readyPaths(w *watcher, wait time.Duration) []string {
+	var paths []string
+	for {
+		select {
+		case path := <-w.ready:
+			delete(w.pending, path)
+			paths = append(paths, path)
+		case <-time.After(wait):
+			return paths
+		}
+	}
+}
+
+func TestWatchSchedule(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		changes []string
+		want    []string
+	}{
+		{"image", []string{"a.png"}, []string{"a.png"}},
+		{"archive", []string{"x.zip"}, []string{"x.zip"}},
+		{"not an image", []string{"notes.txt", "a.png.part"}, nil},
+		{"repeated changes", []string{"a.png", "a.png", "a.png"}, []string{"a.png"}},
+		{"several images", []string{"a.png", "sub/b.jpg", "a.png"}, []string{"a.png", "sub/b.jpg"}},
+	} {
+		w := newTestWatcher("watched", 20*time.Millisecond)
+		for _, c := range tt.changes {
+			w.schedule(context.Background(), c)
+		}
+		got := readyPaths(w, 100*time.Millisecond)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) || len(w.pending) > 0 {
+			t.Errorf("%s: ready %q with %d pending, expected %q", tt.name, got, len(w.pending), tt.want)
+		}
+	}
+}
+
+// TestWatchDebounce checks that a change of a pending image resets its
+// debounce.
+func TestWatchDebounce(t *testing.T) {
+	const debounce = 150 * time.Millisecond
+	w := newTestWatcher("watched", debounce)
+	ctx := context.Background()
+	start := time.Now()
+	w.schedule(ctx, "a.png")
+	time.Sleep(debounce / 2)
+	w.schedule(ctx, "a.png")
+	// Unreset, the image would be ready by now.
+	select {
+	case path := <-w.ready:
+		t.Fatalf("%s ready after %v, before its debounce was over", path, time.Since(start))
+	case <-time.After(debounce/2 + debounce/4):
+	}
+	select {
+	case <-w.ready:
+		if d := time.Since(start); d < debounce*3/2 {
+			t.Errorf("ready after %v, expected %v", d, debounce*3/2)
+		}
+	case <-time.After(10 * debounce):
+		t.Fatal("not ready")
+	}
+}
+
+func TestWatchIgnored(t *testing.T) {
+	for _, tt := range []struct {
+		ignore []string
+		outDir string // relative to the watched directory, out by default
+		path   string // relative to the watched directory
+		want   bool
+	}{
+		{nil, "", "a.png", false},
+		{[]string{"*.tmp"}, "", "a.tmp", true},
+		{[]string{"*.tmp"}, "", "sub/a.tmp", true},
+		{[]string{"*.tmp"}, "", "a.png", false},
+		{[]string{"sub/*.png"}, "", "sub/a.png", true},
+		{[]string{"sub/*.png"}, "", "other/sub/a.png", false},
+		{[]string{"sub/*.png"}, "", "sub/deep/a.png", false},
+		{[]string{"raw"}, "", "raw", true},
+		{[]string{"raw"}, "", "a/raw", true},
+		{[]string{"raw"}, "", "raw.png", false},
+		{[]string{"*.tmp", "drafts"}, "", "drafts", true},
+		{nil, "", "out", true},
+		{nil, "", "out/a.png", true},
+		{nil, "", "out/sub/a.png", true},
+		{nil, "", "outside.png", false},
+		{nil, "", "out2/a.png", false},
+		{nil, "results/new", "results/new/a.png", true},
+		{nil, "results/new", "results/a.png", false},
+		{nil, "../results", "results/a.png", false},
+	} {
+		w := newTestWatcher("watched", 0, tt.ignore...)
+		if tt.outDir != "" {
+			w.o.outDir = filepath.Join("watched", tt.outDir)
+		}
+		if got := w.ignored(filepath.Join("watched", tt.path)); got != tt.want {
+			t.Errorf("%s with --ignore %q and --out-dir %s: ignored %v, expected %v", tt.path, tt.ignore, w.o.outDir, got, tt.want)
+		}
+	}
+}
+
+func TestWithin(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path, dir string
+		want      bool
+	}{
+		{"a", "a", true},
+		{"a/b", "a", true},
+		{"a/b/c", "a", true},
+		{"a/./b", "a/", true},
+		{"a/../b", "a", false},
+		{"ab", "a", false},
+		{"a", "a/b", false},
+		{"..a", ".", true},
+		{"../a", ".", false},
+		{filepath.Join(wd, "a/b"), "a", true},
+		{"a/b", filepath.Join(wd, "a"), true},
+		{"/elsewhere/a", "a", false},
+	} {
+		if got := within(filepath.FromSlash(tt.path), filepath.FromSlash(tt.dir)); got != tt.want {
+			t.Errorf("within(%q, %q) = %v, expected %v", tt.path, tt.dir, got, tt.want)
+		}
+	}
+}
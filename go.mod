@@ -1,9 +1,13 @@
 module github.com/sub-mersion/fls
 
-go 1.15
+go 1.16
 
 require (
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/rs/zerolog v1.24.0
 	github.com/spf13/cobra v1.2.1
+	github.com/spf13/pflag v1.0.5
+	github.com/spf13/viper v1.8.1
 	golang.org/x/image v0.0.0-20190802002840-cff245a6509b
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,241 @@
+package dither
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Adjustments are the tone adjustments of the scaled image before it is
+// reduced to the palette, applied to the components of the colors in the
+// order of the fields. The zero value adjusts nothing.
+type Adjustments struct {
+	// AutoContrast stretches the tones so that the darkest and the
+	// lightest lumas of the image, once autoContrastClip of the pixels are
+	// ignored at each end, become black and white.
+	AutoContrast bool `json:"auto_contrast,omitempty"`
+	// Brightness is added to the components, from -1 to 1.
+	Brightness float64 `json:"brightness,omitempty"`
+	// Contrast spreads the components away from the mid-gray, from -1 for a
+	// flat gray to 1 for a threshold at the mid-gray.
+	Contrast float64 `json:"contrast,omitempty"`
+	// Gamma lightens the midtones above 1 and darkens them below, the
+	// components being raised to the power 1/Gamma. It leaves them as they
+	// are when 0 or 1.
+	Gamma float64 `json:"gamma,omitempty"`
+}
+
+// autoContrastClip is the fraction of the pixels ignored at each end of the
+// histogram by Adjustments.AutoContrast, so that a few stray pixels don't
+// prevent the stretching.
+const autoContrastClip = 0.005
+
+// adjustBandRows is the number of rows Adjust produces between two checks of
+// its context.
+const adjustBandRows = 256
+
+// none reports whether a adjusts nothing.
+func (a Adjustments) none() bool {
+	return !a.AutoContrast && a.Brightness == 0 && a.Contrast == 0 && (a.Gamma == 0 || a.Gamma == 1)
+}
+
+// problems returns the descriptions of the invalid settings of a.
+func (a Adjustments) problems() []string {
+	var problems []string
+	if !(a.Brightness >= -1 && a.Brightness <= 1) {
+		problems = append(problems, fmt.Sprintf("invalid brightness %v, must be from -1 to 1", a.Brightness))
+	}
+	if !(a.Contrast >= -1 && a.Contrast <= 1) {
+		problems = append(problems, fmt.Sprintf("invalid contrast %v, must be from -1 to 1", a.Contrast))
+	}
+	if !(a.Gamma >= 0) || math.IsInf(a.Gamma, 1) {
+		problems = append(problems, fmt.Sprintf("invalid gamma %v, must be positive", a.Gamma))
+	}
+	return problems
+}
+
+// Adjust applies the tone adjustments a to img, on GOMAXPROCS goroutines. It
+// returns img itself when a adjusts nothing, and ctx.Err() if ctx is done
+// before the adjustment completes.
+func Adjust(ctx context.Context, img image.Image, a Adjustments) (image.Image, error) {
+	return adjust(ctx, img, a, 0)
+}
+
+// adjust is Adjust on up to threads goroutines.
+func adjust(ctx context.Context, img image.Image, a Adjustments, threads int) (image.Image, error) {
+	if a.none() {
+		return img, ctx.Err()
+	}
+	c := a.curve(img)
+	r := img.Bounds()
+	dst := c.newImage(img, getPix(adjustedBytes(img)*r.Dx()*r.Dy()), r)
+	err := parallelRows(r, threads, func(rows image.Rectangle) error {
+		for y := rows.Min.Y; y < rows.Max.Y; y += adjustBandRows {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			c.apply(dst, img, image.Rect(rows.Min.X, y, rows.Max.X, y+adjustBandRows).Intersect(rows))
+		}
+		return nil
+	})
+	if err != nil {
+		Release(dst)
+		return nil, err
+	}
+	return dst, nil
+}
+
+// curve returns the tone curve of a for img, whose histogram is read for
+// the auto-contrast.
+func (a Adjustments) curve(img image.Image) *toneCurve {
+	var h histogram
+	if a.AutoContrast {
+		h.add(img, img.Bounds())
+	}
+	return a.curveOf(&h)
+}
+
+// curveOf returns the tone curve of a for an image of histogram h, only read
+// for the auto-contrast.
+func (a Adjustments) curveOf(h *histogram) *toneCurve {
+	low, high := 0., 255.
+	if a.AutoContrast {
+		if lo, hi := h.levels(autoContrastClip); lo < hi {
+			low, high = float64(lo), float64(hi)
+		}
+	}
+	contrast := math.Tan((a.Contrast + 1) * math.Pi / 4)
+	var c toneCurve
+	for v := range c {
+		x := (float64(v)-low)/(high-low) + a.Brightness
+		x = (x-0.5)*contrast + 0.5
+		x = math.Max(0, math.Min(1, x))
+		if a.Gamma > 0 && a.Gamma != 1 {
+			x = math.Pow(x, 1/a.Gamma)
+		}
+		c[v] = uint8(math.Round(x * 255))
+	}
+	return &c
+}
+
+// toneCurve maps the 8-bit components of the colors to their adjusted value.
+type toneCurve [256]uint8
+
+// adjustedBytes returns the number of bytes per pixel of the image img is
+// adjusted to. Like for the scaling, the Gray, Gray16 and Paletted images are
+// adjusted to Gray and Paletted ones.
+func adjustedBytes(img image.Image) int {
+	switch img.(type) {
+	case *image.Gray, *image.Gray16, *image.Paletted:
+		return 1
+	}
+	return 4
+}
+
+// newImage returns the image of bounds r with the zeroed pixels pix that img
+// is adjusted to with c, the Paletted images keeping their pixels with an
+// adjusted palette.
+func (c *toneCurve) newImage(img image.Image, pix []uint8, r image.Rectangle) draw.Image {
+	switch m := img.(type) {
+	case *image.Gray, *image.Gray16:
+		return &image.Gray{Pix: pix, Stride: r.Dx(), Rect: r}
+	case *image.Paletted:
+		p := make(color.Palette, len(m.Palette))
+		for i, col := range m.Palette {
+			p[i] = c.color(color.NRGBAModel.Convert(col).(color.NRGBA))
+		}
+		return &image.Paletted{Pix: pix, Stride: r.Dx(), Rect: r, Palette: p}
+	}
+	return &image.RGBA{Pix: pix, Stride: 4 * r.Dx(), Rect: r}
+}
+
+// color returns the adjusted color of col, keeping its alpha.
+func (c *toneCurve) color(col color.NRGBA) color.NRGBA {
+	return color.NRGBA{c[col.R], c[col.G], c[col.B], col.A}
+}
+
+// apply adjusts the rows band of img to dst, from newImage.
+func (c *toneCurve) apply(dst draw.Image, img image.Image, band image.Rectangle) {
+	switch d := dst.(type) {
+	case *image.Paletted:
+		s := img.(*image.Paletted)
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			copy(d.Pix[d.PixOffset(band.Min.X, y):d.PixOffset(band.Max.X, y)], s.Pix[s.PixOffset(band.Min.X, y):])
+		}
+	case *image.Gray:
+		pixel := pixelReader(img)
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			row := d.Pix[d.PixOffset(band.Min.X, y):d.PixOffset(band.Max.X, y)]
+			for i := range row {
+				v, _, _, _ := pixel(band.Min.X+i, y)
+				row[i] = c[v>>8]
+			}
+		}
+	case *image.RGBA:
+		pixel := pixelReader(img)
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			row := d.Pix[d.PixOffset(band.Min.X, y):d.PixOffset(band.Max.X, y)]
+			for i := 0; i < len(row); i += 4 {
+				r, g, b, a := pixel(band.Min.X+i/4, y)
+				p := row[i : i+4 : i+4]
+				switch a {
+				case 0:
+				case 0xffff:
+					p[0], p[1], p[2], p[3] = c[r>>8], c[g>>8], c[b>>8], 0xff
+				default:
+					// The curve applies to the colors, not to their
+					// premultiplied components.
+					col := color.NRGBA{uint8(r * 0xff / a), uint8(g * 0xff / a), uint8(b * 0xff / a), uint8(a >> 8)}
+					pr, pg, pb, pa := c.color(col).RGBA()
+					p[0], p[1], p[2], p[3] = uint8(pr>>8), uint8(pg>>8), uint8(pb>>8), uint8(pa>>8)
+				}
+			}
+		}
+	}
+}
+
+// histogram counts the pixels of an image by luma.
+type histogram [256]int64
+
+// add counts the pixels of img in r, ignoring the transparent ones.
+func (h *histogram) add(img image.Image, r image.Rectangle) {
+	pixel := pixelReader(img)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			pr, pg, pb, a := pixel(x, y)
+			if a == 0 {
+				continue
+			}
+			// The luma of color.GrayModel, of the unpremultiplied color.
+			l := (19595*int64(pr) + 38470*int64(pg) + 7471*int64(pb) + 1<<15) >> 16
+			h[l*0xffff/int64(a)>>8]++
+		}
+	}
+}
+
+// levels returns the darkest and lightest lumas of h once the fraction clip
+// of the pixels is ignored at each end.
+func (h *histogram) levels(clip float64) (low, high int) {
+	var n int64
+	for _, c := range h {
+		n += c
+	}
+	skip := int64(clip * float64(n))
+	var sum int64
+	for low = 0; low < 255; low++ {
+		if sum += h[low]; sum > skip {
+			break
+		}
+	}
+	sum = 0
+	for high = 255; high > 0; high-- {
+		if sum += h[high]; sum > skip {
+			break
+		}
+	}
+	return low, high
+}
@@ -0,0 +1,180 @@
+package dither
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// DecodeAnimation decodes all the frames of the GIF image read from r, failing
+// like DecodeLimit when its canvas has more than maxPixels pixels.
+func DecodeAnimation(r io.Reader, maxPixels int64) (*gif.GIF, error) {
+	var g *gif.GIF
+	_, err := decodeLimit(r, "gif", maxPixels, func(r io.Reader) (image.Image, error) {
+		var err error
+		if g, err = gif.DecodeAll(r); err != nil {
+			return nil, &DecodeError{Format: "gif", Err: err}
+		}
+		return g.Image[0], nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// ProcessAnimation processes each frame of the animated GIF g like Process,
+// keeping the delays and disposal methods of the frames and the loop count of
+// g, whose background color becomes the nearest one of the palette. A frame
+// is scaled with the mapping of the whole canvas, so that the frames keep
+// covering the same areas, or as a whole with opts.Geometry, and its
+// transparent pixels are kept with a transparent color added to the palette.
+// With opts.Colors, the palette is extracted from the opaque pixels of all
+// the scaled and adjusted frames, leaving room for the transparent color. It
+// returns ctx.Err() if ctx is done before all the frames are processed.
+func ProcessAnimation(ctx context.Context, g *gif.GIF, opts Options) (*gif.GIF, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	canvas := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	scaled := opts.ResultBounds(canvas)
+	if scaled.Empty() {
+		return nil, fmt.Errorf("dither: animation of %dx%d pixels scaled to nothing", canvas.Dx(), canvas.Dy())
+	}
+	if opts.Colors != 0 {
+		p, err := animationPalette(ctx, g, opts)
+		if err != nil {
+			return nil, err
+		}
+		opts.Palette, opts.Colors = p, 0
+	}
+	out := &gif.GIF{
+		Delay:     g.Delay,
+		Disposal:  g.Disposal,
+		LoopCount: g.LoopCount,
+		Config:    image.Config{ColorModel: opts.Palette, Width: scaled.Dx(), Height: scaled.Dy()},
+	}
+	if p, ok := g.Config.ColorModel.(color.Palette); ok && int(g.BackgroundIndex) < len(p) {
+		out.BackgroundIndex = uint8(opts.Palette.Index(p[g.BackgroundIndex]))
+	}
+	src := image.NewNRGBA(canvas)
+	for i, frame := range g.Image {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		r := drawFrame(src, frame)
+
+		fo := opts
+		if transparent(src, r) {
+			if len(opts.Palette) == 256 {
+				return nil, fmt.Errorf("dither: frame %d has transparent pixels, which a palette of 256 colors leaves no room for", i+1)
+			}
+			fo.Palette = append(append(color.Palette(nil), opts.Palette...), color.Transparent)
+		}
+		// Unlike with Process, the options aren't validated again, the
+		// palette extracted may have a single color.
+		res, err := newPipeline(fo).Run(ctx, src)
+		if err != nil {
+			return nil, err
+		}
+		dst := res.(*image.Paletted)
+		if !opts.Geometry.none() {
+			out.Image = append(out.Image, dst)
+			continue
+		}
+		sr := image.Rect(
+			scaledEdge(r.Min.X, canvas.Dx(), scaled.Dx()), scaledEdge(r.Min.Y, canvas.Dy(), scaled.Dy()),
+			scaledEdge(r.Max.X, canvas.Dx(), scaled.Dx()), scaledEdge(r.Max.Y, canvas.Dy(), scaled.Dy()),
+		)
+		// The frames shrunk to nothing still take their delay with a pixel.
+		if sr.Dx() == 0 {
+			if sr.Min.X == scaled.Dx() {
+				sr.Min.X--
+			}
+			sr.Max.X = sr.Min.X + 1
+		}
+		if sr.Dy() == 0 {
+			if sr.Min.Y == scaled.Dy() {
+				sr.Min.Y--
+			}
+			sr.Max.Y = sr.Min.Y + 1
+		}
+		f := image.NewPaletted(sr, fo.Palette)
+		for y := sr.Min.Y; y < sr.Max.Y; y++ {
+			copy(f.Pix[f.PixOffset(sr.Min.X, y):f.PixOffset(sr.Max.X, y)], dst.Pix[dst.PixOffset(sr.Min.X, y):])
+		}
+		Release(dst)
+		out.Image = append(out.Image, f)
+	}
+	return out, nil
+}
+
+// drawFrame draws frame alone over the transparent canvas, and returns the
+// area it covers.
+func drawFrame(canvas *image.NRGBA, frame *image.Paletted) image.Rectangle {
+	for i := range canvas.Pix {
+		canvas.Pix[i] = 0
+	}
+	r := frame.Rect.Intersect(canvas.Rect)
+	draw.Draw(canvas, r, frame, r.Min, draw.Src)
+	return r
+}
+
+// animationPalette returns the palette of at most opts.Colors colors of the
+// opaque pixels of the frames of g, scaled and adjusted like by
+// ProcessAnimation, one less when a frame has transparent pixels and all the
+// 256 colors would be taken.
+func animationPalette(ctx context.Context, g *gif.GIF, opts Options) (color.Palette, error) {
+	src := image.NewNRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	counts := make(colorCounts)
+	n := opts.Colors
+	for _, frame := range g.Image {
+		r := drawFrame(src, frame)
+		if n == 256 && transparent(src, r) {
+			n--
+		}
+		img, err := prepare(ctx, src, opts)
+		if err != nil {
+			return nil, err
+		}
+		counts.add(img, img.Bounds(), true)
+		Release(img)
+	}
+	p := counts.medianCut(n)
+	if len(p) == 0 {
+		return nil, errors.New("dither: animation without opaque pixels to extract a palette from")
+	}
+	return p, nil
+}
+
+// transparent reports whether img has a transparent pixel in r.
+func transparent(img *image.NRGBA, r image.Rectangle) bool {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(r.Min.X, y):img.PixOffset(r.Max.X, y)]
+		for i := 3; i < len(row); i += 4 {
+			if row[i] == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scaledEdge returns the first column, or row, of an image of size scaled
+// whose nearest-neighbor source in the image of size size is at or after
+// the column x, with the mapping of draw.NearestNeighbor: the source of d is
+// (2d+1)*size/(2*scaled).
+func scaledEdge(x, size, scaled int) int {
+	// The smallest d with (2d+1)*size >= 2*scaled*x.
+	t := (2*scaled*x + size - 1) / size
+	if d := t / 2; d < scaled {
+		return d
+	}
+	return scaled
+}
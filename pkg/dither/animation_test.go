@@ -0,0 +1,151 @@
+package dither
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testAnimation returns an animation of 8x6 pixels on a black background: a
+// gray gradient covering the canvas, a white frame at 2,1 and a frame at 4,2
+// of red pixels with a transparent column.
+func testAnimation() *gif.GIF {
+	global := color.Palette{color.White, color.Black}
+	gradient := image.NewPaletted(image.Rect(0, 0, 8, 6), Grays(8))
+	for i := range gradient.Pix {
+		gradient.Pix[i] = uint8(i % 8)
+	}
+	white := image.NewPaletted(image.Rect(2, 1, 6, 5), global)
+	red := image.NewPaletted(image.Rect(4, 2, 8, 6), color.Palette{color.RGBA{0xff, 0, 0, 0xff}, color.Transparent})
+	for y := 2; y < 6; y++ {
+		red.SetColorIndex(5, y, 1)
+	}
+	return &gif.GIF{
+		Image:           []*image.Paletted{gradient, white, red},
+		Delay:           []int{10, 20, 30},
+		Disposal:        []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious},
+		LoopCount:       3,
+		BackgroundIndex: 1,
+		Config:          image.Config{ColorModel: global, Width: 8, Height: 6},
+	}
+}
+
+func TestProcessAnimation(t *testing.T) {
+	g := testAnimation()
+	out, err := ProcessAnimation(context.Background(), g, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.Delay, g.Delay) || !bytes.Equal(out.Disposal, g.Disposal) || out.LoopCount != 3 {
+		t.Errorf("delays %v, disposals %v and loop count %d, expected %v, %v and 3", out.Delay, out.Disposal, out.LoopCount, g.Delay, g.Disposal)
+	}
+	if c := out.Config; c.Width != 8 || c.Height != 6 || c.ColorModel.(color.Palette)[out.BackgroundIndex] != BlackAndWhite[1] {
+		t.Errorf("canvas of %dx%d with the background index %d, expected 8x6 and black", c.Width, c.Height, out.BackgroundIndex)
+	}
+	for i, want := range []image.Rectangle{image.Rect(0, 0, 8, 6), image.Rect(2, 1, 6, 5), image.Rect(4, 2, 8, 6)} {
+		if len(out.Image) != 3 {
+			t.Fatalf("%d frames", len(out.Image))
+		}
+		if out.Image[i].Rect != want {
+			t.Errorf("frame %d at %v, expected %v", i+1, out.Image[i].Rect, want)
+		}
+	}
+	for i, f := range out.Image[:2] {
+		if len(f.Palette) != 2 {
+			t.Errorf("opaque frame %d of %d colors", i+1, len(f.Palette))
+		}
+	}
+	if w := whiteShare(out.Image[1]); w != 1 {
+		t.Errorf("white frame of %.2f white pixels", w)
+	}
+	red := out.Image[2]
+	if len(red.Palette) != 3 || red.Palette[2] != color.Transparent {
+		t.Fatalf("frame of palette %v, expected the transparent color after black and white", red.Palette)
+	}
+	for y := 2; y < 6; y++ {
+		for x := 4; x < 8; x++ {
+			if transparent := red.ColorIndexAt(x, y) == 2; transparent != (x == 5) {
+				t.Errorf("pixel %d,%d transparent %v", x, y, transparent)
+			}
+		}
+	}
+
+	// The result is a valid GIF image.
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, out); err != nil {
+		t.Fatal(err)
+	}
+	back, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back.Delay, g.Delay) || back.LoopCount != 3 || back.BackgroundIndex != out.BackgroundIndex {
+		t.Errorf("decoded delays %v, loop count %d and background index %d", back.Delay, back.LoopCount, back.BackgroundIndex)
+	}
+}
+
+// TestProcessAnimationScaled checks that the frames, scaled with the mapping
+// of the canvas, keep covering the same areas, and that those shrunk to
+// nothing keep a pixel.
+func TestProcessAnimationScaled(t *testing.T) {
+	for _, tt := range []struct {
+		opts Options
+		want []image.Rectangle
+	}{
+		{DefaultOptions(WithScale(2)), []image.Rectangle{image.Rect(0, 0, 16, 12), image.Rect(4, 2, 12, 10), image.Rect(8, 4, 16, 12)}},
+		{DefaultOptions(WithSize(4, 3)), []image.Rectangle{image.Rect(0, 0, 4, 3), image.Rect(1, 0, 3, 2), image.Rect(2, 1, 4, 3)}},
+		{DefaultOptions(WithSize(1, 1)), []image.Rectangle{image.Rect(0, 0, 1, 1), image.Rect(0, 0, 1, 1), image.Rect(0, 0, 1, 1)}},
+		// With a geometry, the frames are processed as a whole.
+		{DefaultOptions(WithGeometry(Geometry{Rotate: 90})), []image.Rectangle{image.Rect(0, 0, 6, 8), image.Rect(0, 0, 6, 8), image.Rect(0, 0, 6, 8)}},
+	} {
+		out, err := ProcessAnimation(context.Background(), testAnimation(), tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []image.Rectangle
+		for _, f := range out.Image {
+			got = append(got, f.Rect)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("scale %v, size %dx%d, rotation %d: frames at %v, expected %v",
+				tt.opts.Scale, tt.opts.Width, tt.opts.Height, tt.opts.Geometry.Rotate, got, tt.want)
+		}
+	}
+}
+
+// TestProcessAnimationPalettes checks that the palettes extracted from the
+// frames leave room for the transparent color, and that a palette of 256
+// colors fails with the frames with transparent pixels.
+func TestProcessAnimationPalettes(t *testing.T) {
+	for _, colors := range []int{4, 256} {
+		out, err := ProcessAnimation(context.Background(), testAnimation(), DefaultOptions(WithColors(colors)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		global := out.Config.ColorModel.(color.Palette)
+		if len(global) > colors || len(global) < 2 {
+			t.Errorf("%d colors: palette of %d colors", colors, len(global))
+		}
+		red := out.Image[2]
+		if n := len(red.Palette); n != len(global)+1 || n > 256 || red.Palette[n-1] != color.Transparent {
+			t.Errorf("%d colors: frame with transparent pixels of %d colors, %d in the palette", colors, n, len(global))
+		}
+	}
+
+	_, err := ProcessAnimation(context.Background(), testAnimation(), DefaultOptions(WithPalette(palette.Plan9)))
+	if err == nil || !strings.Contains(err.Error(), "frame 3 has transparent pixels, which a palette of 256 colors leaves no room for") {
+		t.Errorf("error %v with a palette of 256 colors", err)
+	}
+	// The opaque animations may use all the 256 colors.
+	g := testAnimation()
+	g.Image = g.Image[:2]
+	if _, err := ProcessAnimation(context.Background(), g, DefaultOptions(WithPalette(palette.Plan9))); err != nil {
+		t.Error(err)
+	}
+}
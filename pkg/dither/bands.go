@@ -0,0 +1,187 @@
+package dither
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// ProcessBands processes img like Process, but produces the result in
+// horizontal bands of at most rows rows, passed to emit from top to bottom.
+// Only one band of the scaled image and of the result is held at a time, so
+// that the memory needed besides img is proportional to rows instead of the
+// size of the result. A band is only valid until emit returns. The ditherer
+// of opts must be Bandable, and opts must have no Geometry. The bands are
+// scaled once more for the histogram of the scaled image with the
+// auto-contrast, and for the colors of the palette with opts.Colors, which
+// are all counted in memory. ProcessBands returns ctx.Err() if ctx is done
+// before all the bands are emitted.
+func ProcessBands(ctx context.Context, img image.Image, opts Options, rows int, emit func(band *image.Paletted) error) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	d, _ := Lookup(opts.Algorithm)
+	d = tuned(d, opts.Diffusion, opts.Screen)
+	if !Bandable(d) {
+		return fmt.Errorf("dither: algorithm %q cannot process images in bands", opts.Algorithm)
+	}
+	if !opts.Geometry.none() {
+		return errors.New("dither: the crop, rotation, flips and padding cannot be applied to images in bands")
+	}
+	if rows < 1 {
+		return fmt.Errorf("dither: invalid band height %d", rows)
+	}
+
+	r, scaling := scaling(img, opts)
+	f, _ := filter(opts.Filter)
+	var (
+		scaled  []uint8
+		bytes   = scaledBytes(img, f)
+		palette = scaledPalette(img, f)
+	)
+	if scaling {
+		scaled = getPix(bytes * r.Dx() * rows)
+		defer putPix(scaled)
+	}
+	// scaledBand returns the band of the scaled image.
+	scaledBand := func(band image.Rectangle) (image.Image, error) {
+		if !scaling {
+			return img, nil
+		}
+		// Like Scale, the band is scaled with the mapping of the whole
+		// image, over a transparent background.
+		pix := scaled[:bytes*band.Dx()*band.Dy()]
+		for i := range pix {
+			pix[i] = 0
+		}
+		b := newScaled(img, f, pix, band, palette)
+		if err := scaleRows(ctx, b, r, img, f, opts.Threads); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+
+	return processBands(ctx, r, scaledBand, opts, d, rows, emit)
+}
+
+// processBands is ProcessBands dithering with d the bands of the scaled image
+// of bounds r returned by scaledBand, which is called once more per band for
+// the histogram of the auto-contrast and for the colors of opts.Colors.
+func processBands(ctx context.Context, r image.Rectangle, scaledBand func(band image.Rectangle) (image.Image, error), opts Options, d Ditherer, rows int, emit func(band *image.Paletted) error) error {
+	pix := getPix(r.Dx() * rows)
+	defer putPix(pix)
+
+	var (
+		curve    *toneCurve
+		adjusted []uint8
+	)
+	if opts.Adjusts() {
+		var h histogram
+		for y := r.Min.Y; opts.Adjust.AutoContrast && y < r.Max.Y; y += rows {
+			band := image.Rect(r.Min.X, y, r.Max.X, y+rows).Intersect(r)
+			src, err := scaledBand(band)
+			if err != nil {
+				return err
+			}
+			h.add(src, band)
+		}
+		curve = opts.Adjust.curveOf(&h)
+		adjusted = getPix(4 * r.Dx() * rows)
+		defer putPix(adjusted)
+	}
+
+	// preparedBand returns the band of the scaled and adjusted image.
+	preparedBand := func(band image.Rectangle) (image.Image, error) {
+		src, err := scaledBand(band)
+		if err != nil || curve == nil {
+			return src, err
+		}
+		a := curve.newImage(src, adjusted[:adjustedBytes(src)*band.Dx()*band.Dy()], band)
+		curve.apply(a, src, band)
+		return a, nil
+	}
+
+	p := opts.Palette
+	if opts.Colors != 0 {
+		counts := make(colorCounts)
+		for y := r.Min.Y; y < r.Max.Y; y += rows {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			band := image.Rect(r.Min.X, y, r.Max.X, y+rows).Intersect(r)
+			src, err := preparedBand(band)
+			if err != nil {
+				return err
+			}
+			counts.add(src, band, false)
+		}
+		p = counts.medianCut(opts.Colors)
+	}
+	dither := rowsDitherer(d, r.Dx(), p, opts.Threads)
+
+	for y := r.Min.Y; y < r.Max.Y; y += rows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		band := image.Rect(r.Min.X, y, r.Max.X, y+rows).Intersect(r)
+		src, err := preparedBand(band)
+		if err != nil {
+			return err
+		}
+		dst := &image.Paletted{Pix: pix[:band.Dx()*band.Dy()], Stride: band.Dx(), Rect: band, Palette: p}
+		if err := dither(dst, src); err != nil {
+			return err
+		}
+		if err := emit(dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bandable reports whether ProcessBands accepts d: whether it is a
+// BandDitherer or a parallel ditherer.
+func Bandable(d Ditherer) bool {
+	_, ok := d.(BandDitherer)
+	return ok || parallel(d)
+}
+
+// TransformBands processes img with ProcessBands and encodes the result as
+// PNG to w as the bands are produced.
+func TransformBands(ctx context.Context, w io.Writer, img image.Image, opts Options, rows int) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	r, _ := scaling(img, opts)
+	return encodeBands(w, r, opts, func(emit func(band *image.Paletted) error) error {
+		return ProcessBands(ctx, img, opts, rows, emit)
+	})
+}
+
+// encodeBands encodes as PNG to w the result of bounds r whose bands process
+// passes to emit.
+func encodeBands(w io.Writer, r image.Rectangle, opts Options, process func(emit func(band *image.Paletted) error) error) error {
+	// The header is written with the first band, whose palette may be
+	// extracted from the image.
+	var pw *PNGWriter
+	err := process(func(band *image.Paletted) error {
+		if pw == nil {
+			var err error
+			if pw, err = newPNGWriter(w, r, band.Palette, opts.Encoding.Metadata); err != nil {
+				return err
+			}
+		}
+		return pw.WriteBand(band)
+	})
+	if err != nil {
+		return err
+	}
+	if pw == nil {
+		// No band for an empty result, which NewPNGWriter refuses.
+		_, err = NewPNGWriter(w, r, opts.Palette)
+		return err
+	}
+	return pw.Close()
+}
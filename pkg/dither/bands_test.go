@@ -0,0 +1,70 @@
+package dither
+
+import (
+	"context"
+	"image"
+	"testing"
+)
+
+// TestProcessBandsMatchesProcess checks that the bands of ProcessBands make
+// up the result of Process, for every bandable algorithm, with and without
+// scaling, and bands which do not divide the height.
+func TestProcessBandsMatchesProcess(t *testing.T) {
+	ctx := context.Background()
+	src := testImages(t, 61, 47)["rgba"]
+	for _, alg := range Algorithms() {
+		d, _ := Lookup(alg)
+		if !Bandable(d) {
+			continue
+		}
+		for _, opts := range []Options{
+			DefaultOptions(WithAlgorithm(alg), WithPalette(Grays(4))),
+			DefaultOptions(WithAlgorithm(alg), WithScale(0.7)),
+			DefaultOptions(WithAlgorithm(alg), WithSize(90, 0), WithFilter("bilinear"), WithAdjustments(Adjustments{AutoContrast: true, Gamma: 1.2})),
+			DefaultOptions(WithAlgorithm(alg), WithColors(8)),
+		} {
+			want, err := Process(ctx, src, opts)
+			if err != nil {
+				t.Fatalf("%s: %v", alg, err)
+			}
+			for _, rows := range []int{1, 7, 1000} {
+				got := image.NewPaletted(want.Rect, want.Palette)
+				next := want.Rect.Min.Y
+				err := ProcessBands(ctx, src, opts, rows, func(band *image.Paletted) error {
+					if band.Rect.Min.Y != next || band.Rect.Dy() > rows {
+						t.Fatalf("%s: band %v after row %d, expected at most %d rows", alg, band.Rect, next, rows)
+					}
+					next = band.Rect.Max.Y
+					got.Palette = band.Palette
+					for y := band.Rect.Min.Y; y < band.Rect.Max.Y; y++ {
+						copy(got.Pix[got.PixOffset(band.Rect.Min.X, y):], band.Pix[band.PixOffset(band.Rect.Min.X, y):band.PixOffset(band.Rect.Max.X, y)])
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("%s in bands of %d rows: %v", alg, rows, err)
+				}
+				if next != want.Rect.Max.Y {
+					t.Fatalf("%s in bands of %d rows: bands up to row %d of %d", alg, rows, next, want.Rect.Max.Y)
+				}
+				if len(got.Palette) != len(want.Palette) {
+					t.Fatalf("%s in bands of %d rows: palette of %d colors, expected %d", alg, rows, len(got.Palette), len(want.Palette))
+				}
+				if n, at := diffPixels(got, want); n > 0 {
+					t.Errorf("%s in bands of %d rows with %+v: %d pixels differ from Process, the first at %v", alg, rows, opts.Adjust, n, at)
+				}
+			}
+		}
+	}
+}
+
+// TestProcessBandsRefusesGeometry checks that the geometric transforms,
+// which need the whole image, are refused.
+func TestProcessBandsRefusesGeometry(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 8, 8))
+	opts := DefaultOptions(WithGeometry(Geometry{Rotate: 90}))
+	err := ProcessBands(context.Background(), src, opts, 4, func(*image.Paletted) error { return nil })
+	if err == nil {
+		t.Fatal("ProcessBands succeeded with a rotation")
+	}
+}
@@ -0,0 +1,95 @@
+package dither
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"testing"
+)
+
+// benchSizes are the sizes of the images of the benchmarks, of about 1 and
+// 12 megapixels.
+var benchSizes = []struct {
+	name string
+	w, h int
+}{
+	{"1MP", 1152, 864},
+	{"12MP", 4000, 3000},
+}
+
+// BenchmarkDecode measures the decoding of a 12 megapixels PNG image.
+func BenchmarkDecode(b *testing.B) {
+	data, err := EncodePNG(testRGBA(4000, 3000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		img, err := Decode(bytes.NewReader(data), "png")
+		if err != nil {
+			b.Fatal(err)
+		}
+		Release(img)
+	}
+}
+
+// BenchmarkScale measures the halving of a 12 megapixels image with every
+// filter.
+func BenchmarkScale(b *testing.B) {
+	ctx := context.Background()
+	src := testRGBA(4000, 3000)
+	for _, name := range Filters() {
+		opts := DefaultOptions(WithScale(0.5), WithFilter(name))
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				img, err := scale(ctx, src, opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				Release(img)
+			}
+		})
+	}
+}
+
+// BenchmarkDither measures every algorithm at 1 and 12 megapixels, to black
+// and white.
+func BenchmarkDither(b *testing.B) {
+	for _, size := range benchSizes {
+		src := testRGBA(size.w, size.h)
+		for _, alg := range Algorithms() {
+			d, _ := Lookup(alg)
+			dst := image.NewPaletted(src.Rect, BlackAndWhite)
+			b.Run(fmt.Sprintf("%s/%s", alg, size.name), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if err := d.Dither(dst, src); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkEncodePNG measures the encoding of a 12 megapixels image dithered
+// to black and white, and to 16 colors.
+func BenchmarkEncodePNG(b *testing.B) {
+	ctx := context.Background()
+	src := testRGBA(4000, 3000)
+	for _, colors := range []int{2, 16} {
+		m, err := Process(ctx, src, DefaultOptions(WithColors(colors)))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("%d-colors", colors), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := Encode(ioutil.Discard, m, "png", EncodeOptions{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
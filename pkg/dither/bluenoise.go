@@ -0,0 +1,172 @@
+package dither
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// blueNoise reduces images to a palette like ordered, with the thresholds
+// of a blue-noise mask rather than of a Bayer matrix: the dots of each gray
+// level are spread evenly without a visible pattern, and the mask tiles
+// seamlessly, so that a texture whose size is a multiple of the mask one
+// tiles too. The mask is generated by the void-and-cluster method from the
+// seed, the same seed giving the same mask on every platform.
+type blueNoise struct {
+	seed int64
+}
+
+func (n blueNoise) Dither(dst *image.Paletted, src image.Image) error {
+	return n.Bands(dst.Bounds().Dx(), dst.Palette)(dst, src)
+}
+
+// Parallel reports that the pixels are dithered independently.
+func (n blueNoise) Parallel() bool { return true }
+
+// Screened returns the blue noise of the mask of s.Seed.
+func (n blueNoise) Screened(s Screen) Ditherer {
+	return blueNoise{s.Seed}
+}
+
+func (n blueNoise) Bands(width int, p color.Palette) DithererFunc {
+	return blueNoiseMask(n.seed).Bands(width, p)
+}
+
+const (
+	// blueNoiseSize is the size of the side of the blue-noise masks.
+	blueNoiseSize = 64
+	// blueNoiseSigma is the standard deviation, in pixels, of the Gaussian
+	// filter measuring how clustered the pixels of a mask are.
+	blueNoiseSigma = 1.5
+	// blueNoiseCached is the number of masks kept for the next ditherings.
+	blueNoiseCached = 8
+)
+
+var blueNoiseMasks = struct {
+	sync.Mutex
+	m map[int64]ordered
+}{m: make(map[int64]ordered)}
+
+// blueNoiseMask returns the blue-noise mask of the seed, generated by the
+// first call.
+func blueNoiseMask(seed int64) ordered {
+	blueNoiseMasks.Lock()
+	defer blueNoiseMasks.Unlock()
+	if o, ok := blueNoiseMasks.m[seed]; ok {
+		return o
+	}
+	if len(blueNoiseMasks.m) >= blueNoiseCached {
+		for s := range blueNoiseMasks.m {
+			delete(blueNoiseMasks.m, s)
+			break
+		}
+	}
+	o := ordered{matrix: voidAndCluster(blueNoiseSize, uint64(seed)), size: blueNoiseSize}
+	blueNoiseMasks.m[seed] = o
+	return o
+}
+
+// voidAndCluster returns the ranks, from 0 to size²-1 by row, of the pixels
+// of the blue-noise mask of the given size generated from the seed by
+// Ulichney's void-and-cluster method. The energies are integers so that the
+// ties, broken by the first pixel in row order, are the same everywhere.
+func voidAndCluster(size int, seed uint64) []int32 {
+	n := size * size
+	// The Gaussian filter, without the offsets where it rounds to 0, the
+	// mask wrapping around its edges.
+	type tap struct {
+		dx, dy int
+		weight int64
+	}
+	var filter []tap
+	for dy := -size / 2; dy < size/2; dy++ {
+		for dx := -size / 2; dx < size/2; dx++ {
+			d2 := float64(dx*dx + dy*dy)
+			if w := int64(math.Round(math.Exp(-d2/(2*blueNoiseSigma*blueNoiseSigma)) * (1 << 20))); w > 0 {
+				filter = append(filter, tap{dx, dy, w})
+			}
+		}
+	}
+	set := make([]bool, n)
+	energy := make([]int64, n)
+	toggle := func(p int) {
+		set[p] = !set[p]
+		sign := int64(1)
+		if !set[p] {
+			sign = -1
+		}
+		px, py := p%size, p/size
+		for _, t := range filter {
+			energy[mod(py+t.dy, size)*size+mod(px+t.dx, size)] += sign * t.weight
+		}
+	}
+	// tightest returns the set pixel of the highest energy, the center of
+	// the tightest cluster, and largest the unset one of the lowest, the
+	// center of the largest void.
+	tightest := func() int {
+		best := -1
+		for p, s := range set {
+			if s && (best < 0 || energy[p] > energy[best]) {
+				best = p
+			}
+		}
+		return best
+	}
+	largest := func() int {
+		best := -1
+		for p, s := range set {
+			if !s && (best < 0 || energy[p] < energy[best]) {
+				best = p
+			}
+		}
+		return best
+	}
+
+	// The initial pattern: a tenth of the pixels set at random, then moved
+	// from the tightest clusters to the largest voids until they are even.
+	state := seed
+	random := func() uint64 { // splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		return z ^ z>>31
+	}
+	initial := n / 10
+	for placed := 0; placed < initial; {
+		if p := int(random() % uint64(n)); !set[p] {
+			toggle(p)
+			placed++
+		}
+	}
+	for i := 0; i < n; i++ {
+		c := tightest()
+		toggle(c)
+		v := largest()
+		toggle(v)
+		if v == c {
+			break
+		}
+	}
+	prototype := append([]bool(nil), set...)
+	prototypeEnergy := append([]int64(nil), energy...)
+
+	ranks := make([]int32, n)
+	// The pixels of the pattern are ranked below it, removing its tightest
+	// clusters first, and the others above it, filling the largest voids
+	// first.
+	for ones := initial; ones > 0; ones-- {
+		c := tightest()
+		toggle(c)
+		ranks[c] = int32(ones - 1)
+	}
+	copy(set, prototype)
+	copy(energy, prototypeEnergy)
+	for ones := initial; ones < n; ones++ {
+		v := largest()
+		toggle(v)
+		ranks[v] = int32(ones)
+	}
+	return ranks
+}
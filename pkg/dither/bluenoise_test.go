@@ -0,0 +1,123 @@
+package dither
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"image"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// TestVoidAndCluster checks that the masks are permutations of the ranks,
+// the same for a seed on every platform, and that their first and last
+// ranks are spread apart.
+func TestVoidAndCluster(t *testing.T) {
+	want := []int32{
+		19, 29, 4, 17, 45, 22, 15, 48,
+		60, 33, 54, 10, 56, 31, 43, 2,
+		40, 12, 47, 26, 38, 5, 52, 25,
+		57, 18, 37, 1, 61, 20, 34, 9,
+		27, 6, 51, 23, 42, 13, 58, 46,
+		44, 32, 62, 11, 49, 30, 16, 0,
+		55, 14, 21, 36, 3, 53, 39, 24,
+		7, 50, 41, 59, 28, 8, 63, 35,
+	}
+	if got := voidAndCluster(8, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("mask of 8x8 pixels of the seed 0 %v, expected %v", got, want)
+	}
+
+	for _, tt := range []struct {
+		seed int64
+		hash uint64 // the FNV-1a hash of the ranks, in little-endian order
+	}{
+		{0, 0xffa05d264c227789},
+		{1, 0x5642704fad973c5d},
+		{7, 0xa4dab5655e7fd60d},
+	} {
+		m := blueNoiseMask(tt.seed).matrix
+		h := fnv.New64a()
+		binary.Write(h, binary.LittleEndian, m)
+		if h.Sum64() != tt.hash {
+			t.Errorf("seed %d: mask of hash %#x, expected %#x", tt.seed, h.Sum64(), tt.hash)
+		}
+		ranks := append([]int32(nil), m...)
+		sort.Slice(ranks, func(i, j int) bool { return ranks[i] < ranks[j] })
+		for i, r := range ranks {
+			if int(r) != i {
+				t.Fatalf("seed %d: mask missing rank %d", tt.seed, i)
+			}
+		}
+		// 64 pixels of 4096 are 8 pixels apart when evenly spread, those
+		// at random down to 1.
+		n := len(m)
+		for _, r := range []struct{ from, to, min2 int }{{0, 64, 25}, {n - 64, n, 25}, {0, 256, 4}} {
+			if d2 := minDistance2(m, blueNoiseSize, r.from, r.to); d2 < r.min2 {
+				t.Errorf("seed %d: ranks %d to %d %d² pixels apart", tt.seed, r.from, r.to, d2)
+			}
+		}
+	}
+}
+
+// minDistance2 returns the smallest squared distance between the pixels of
+// the mask m of the given size of the ranks from to to, the mask wrapping
+// around its edges.
+func minDistance2(m []int32, size, from, to int) int {
+	var points []image.Point
+	for p, r := range m {
+		if int(r) >= from && int(r) < to {
+			points = append(points, image.Pt(p%size, p/size))
+		}
+	}
+	wrap := func(d int) int {
+		if d = mod(d, size); d > size/2 {
+			d = size - d
+		}
+		return d
+	}
+	best := 2 * size * size
+	for i, p := range points {
+		for _, q := range points[i+1:] {
+			dx, dy := wrap(p.X-q.X), wrap(p.Y-q.Y)
+			if d := dx*dx + dy*dy; d < best {
+				best = d
+			}
+		}
+	}
+	return best
+}
+
+// TestBlueNoiseSeed checks that the results of a seed are the same, whether
+// its mask is generated again or not, those of other seeds different, and
+// that they tile with the size of the mask.
+func TestBlueNoiseSeed(t *testing.T) {
+	reduce := func(seed int64) *image.Paletted {
+		dst, err := Process(context.Background(), uniform(2*blueNoiseSize, blueNoiseSize, 0x60),
+			DefaultOptions(WithAlgorithm("blue-noise"), WithScreen(Screen{Threshold: 0.5, DotSize: 6, Seed: seed})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dst
+	}
+	first := reduce(42)
+	blueNoiseMasks.Lock()
+	blueNoiseMasks.m = make(map[int64]ordered)
+	blueNoiseMasks.Unlock()
+	if n, p := diffPixels(first, reduce(42)); n > 0 {
+		t.Errorf("%d pixels differ with the same seed, first %v", n, p)
+	}
+	if n, _ := diffPixels(first, reduce(43)); n < 500 {
+		t.Errorf("only %d pixels differ with another seed", n)
+	}
+	for y := 0; y < blueNoiseSize; y++ {
+		for x := 0; x < blueNoiseSize; x++ {
+			if first.ColorIndexAt(x, y) != first.ColorIndexAt(x+blueNoiseSize, y) {
+				t.Fatalf("pixel %d,%d different from the one a mask away", x, y)
+			}
+		}
+	}
+	if w := whiteShare(first); w < 0.37 || w > 0.38 {
+		t.Errorf("gray of 0x60 of %.4f white pixels, expected %.4f", w, 0x60/255.0)
+	}
+}
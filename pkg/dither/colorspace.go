@@ -0,0 +1,219 @@
+package dither
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// A conversion converts the colors of an RGB color space to sRGB, through
+// the linear components and the matrix between the colorants of the spaces.
+type conversion struct {
+	transfer func(v float64) float64 // from the encoded components to linear ones
+	linear   [256]float32            // transfer of the 8-bit components
+	matrix   [3][3]float32
+}
+
+// srgbEncode holds the 16-bit and 8-bit sRGB encodings of the linear
+// components quantized to 16 bits.
+var srgbEncode struct {
+	once sync.Once
+	e16  [1 << 16]uint16
+	e8   [1 << 16]uint8
+}
+
+func srgbTransfer(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func adobeRGBTransfer(v float64) float64 {
+	return math.Pow(v, 563./256)
+}
+
+var conversions struct {
+	sync.Mutex
+	m map[string]*conversion
+}
+
+// conversionFrom returns the conversion from the known color space to sRGB,
+// nil for sRGB itself or an unknown space.
+func conversionFrom(space string) *conversion {
+	transfer := srgbTransfer
+	switch space {
+	case SpaceAdobeRGB:
+		transfer = adobeRGBTransfer
+	case SpaceDisplayP3:
+	default:
+		return nil
+	}
+	conversions.Lock()
+	defer conversions.Unlock()
+	if c, ok := conversions.m[space]; ok {
+		return c
+	}
+
+	srgbEncode.once.Do(func() {
+		for i := range srgbEncode.e16 {
+			v := float64(i) / 0xffff
+			if v <= 0.0031308 {
+				v *= 12.92
+			} else {
+				v = 1.055*math.Pow(v, 1/2.4) - 0.055
+			}
+			srgbEncode.e16[i] = uint16(math.Round(v * 0xffff))
+			srgbEncode.e8[i] = uint8(math.Round(v * 0xff))
+		}
+	})
+	c := &conversion{transfer: transfer}
+	for i := range c.linear {
+		c.linear[i] = float32(transfer(float64(i) / 0xff))
+	}
+	// The matrix from the linear components of the space to the PCS, whose
+	// columns are the colorants, then back to those of sRGB.
+	m := mulMatrix(invMatrix(colorantMatrix(spaceColorants[SpaceSRGB])), colorantMatrix(spaceColorants[space]))
+	for i := range m {
+		for j := range m[i] {
+			c.matrix[i][j] = float32(m[i][j])
+		}
+	}
+	if conversions.m == nil {
+		conversions.m = make(map[string]*conversion)
+	}
+	conversions.m[space] = c
+	return c
+}
+
+func colorantMatrix(c colorants) (m [3][3]float64) {
+	for i := range c {
+		for j := range c[i] {
+			m[j][i] = c[i][j]
+		}
+	}
+	return m
+}
+
+func mulMatrix(a, b [3][3]float64) (m [3][3]float64) {
+	for i := range m {
+		for j := range m[i] {
+			for k := range a[i] {
+				m[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return m
+}
+
+func invMatrix(a [3][3]float64) (m [3][3]float64) {
+	det := a[0][0]*(a[1][1]*a[2][2]-a[1][2]*a[2][1]) -
+		a[0][1]*(a[1][0]*a[2][2]-a[1][2]*a[2][0]) +
+		a[0][2]*(a[1][0]*a[2][1]-a[1][1]*a[2][0])
+	for i := range m {
+		for j := range m[i] {
+			// The cofactor of a[j][i], from the rows and columns other
+			// than j and i, in cyclic order so that the sign is included.
+			r0, r1 := (j+1)%3, (j+2)%3
+			c0, c1 := (i+1)%3, (i+2)%3
+			m[i][j] = (a[r0][c0]*a[r1][c1] - a[r0][c1]*a[r1][c0]) / det
+		}
+	}
+	return m
+}
+
+// linearIndex returns the index in the tables of srgbEncode of the linear
+// component v, clamped to the sRGB gamut.
+func linearIndex(v float32) int {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 0xffff
+	}
+	return int(v*0xffff + 0.5)
+}
+
+// rgb8 converts the 8-bit components of a color.
+func (c *conversion) rgb8(r, g, b uint8) (uint8, uint8, uint8) {
+	lr, lg, lb := c.linear[r], c.linear[g], c.linear[b]
+	m := &c.matrix
+	return srgbEncode.e8[linearIndex(m[0][0]*lr+m[0][1]*lg+m[0][2]*lb)],
+		srgbEncode.e8[linearIndex(m[1][0]*lr+m[1][1]*lg+m[1][2]*lb)],
+		srgbEncode.e8[linearIndex(m[2][0]*lr+m[2][1]*lg+m[2][2]*lb)]
+}
+
+// convert returns img with its colors converted to sRGB. The images of the
+// types holding 8-bit RGB components are converted in place.
+func (c *conversion) convert(img image.Image) image.Image {
+	switch m := img.(type) {
+	case *Shrunk:
+		c.convertRGBA(m.RGBA)
+		return m
+	case *image.RGBA:
+		c.convertRGBA(m)
+		return m
+	case *image.NRGBA:
+		b := m.Rect
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)]
+			for i := 0; i < len(row); i += 4 {
+				p := row[i : i+4 : i+4]
+				p[0], p[1], p[2] = c.rgb8(p[0], p[1], p[2])
+			}
+		}
+		return m
+	case *image.YCbCr:
+		b := m.Rect
+		dst := &image.RGBA{Pix: getPix(4 * b.Dx() * b.Dy()), Stride: 4 * b.Dx(), Rect: b}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := dst.Pix[dst.PixOffset(b.Min.X, y):]
+			for x := b.Min.X; x < b.Max.X; x++ {
+				yi, ci := m.YOffset(x, y), m.COffset(x, y)
+				r, g, bl := color.YCbCrToRGB(m.Y[yi], m.Cb[ci], m.Cr[ci])
+				p := row[4*(x-b.Min.X) : 4*(x-b.Min.X)+4 : 4*(x-b.Min.X)+4]
+				p[0], p[1], p[2] = c.rgb8(r, g, bl)
+				p[3] = 0xff
+			}
+		}
+		return dst
+	}
+	b := img.Bounds()
+	dst := image.NewNRGBA64(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			n := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			lr := float32(c.transfer(float64(n.R) / 0xffff))
+			lg := float32(c.transfer(float64(n.G) / 0xffff))
+			lb := float32(c.transfer(float64(n.B) / 0xffff))
+			m := &c.matrix
+			dst.SetNRGBA64(x, y, color.NRGBA64{
+				R: srgbEncode.e16[linearIndex(m[0][0]*lr+m[0][1]*lg+m[0][2]*lb)],
+				G: srgbEncode.e16[linearIndex(m[1][0]*lr+m[1][1]*lg+m[1][2]*lb)],
+				B: srgbEncode.e16[linearIndex(m[2][0]*lr+m[2][1]*lg+m[2][2]*lb)],
+				A: n.A,
+			})
+		}
+	}
+	return dst
+}
+
+// convertRGBA converts the premultiplied colors of m in place.
+func (c *conversion) convertRGBA(m *image.RGBA) {
+	b := m.Rect
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			p := row[i : i+4 : i+4]
+			switch a := uint32(p[3]); a {
+			case 0:
+			case 0xff:
+				p[0], p[1], p[2] = c.rgb8(p[0], p[1], p[2])
+			default:
+				r, g, bl := c.rgb8(uint8(uint32(p[0])*0xff/a), uint8(uint32(p[1])*0xff/a), uint8(uint32(p[2])*0xff/a))
+				p[0], p[1], p[2] = uint8(uint32(r)*a/0xff), uint8(uint32(g)*a/0xff), uint8(uint32(bl)*a/0xff)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package dither
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"testing"
+)
+
+// generic hides the type of an image from the fast paths.
+type generic struct{ image.Image }
+
+// TestCompactMatchesGeneric checks that the Gray, Gray16 and Paletted images,
+// kept compact through the scaling and the dithering, give the results of
+// images of other types.
+func TestCompactMatchesGeneric(t *testing.T) {
+	ctx := context.Background()
+	images := testImages(t, 67, 45)
+	for _, name := range []string{"gray", "gray16", "paletted"} {
+		src := images[name]
+		for _, alg := range Algorithms() {
+			for _, opts := range []Options{
+				DefaultOptions(WithAlgorithm(alg)),
+				DefaultOptions(WithAlgorithm(alg), WithScale(0.5)),
+				DefaultOptions(WithAlgorithm(alg), WithScale(1.7), WithPalette(Grays(4))),
+				DefaultOptions(WithAlgorithm(alg), WithSize(50, 0), WithFilter("bilinear"), WithColors(8)),
+				DefaultOptions(WithAlgorithm(alg), WithScale(0.8), WithAdjustments(Adjustments{AutoContrast: true, Gamma: 1.3})),
+				DefaultOptions(WithAlgorithm(alg), WithGeometry(Geometry{Rotate: 90}), WithPalette(palettes["cga"])),
+			} {
+				want, err := Process(ctx, generic{src}, opts)
+				if err != nil {
+					t.Fatalf("%s %s: %v", name, alg, err)
+				}
+				got, err := Process(ctx, src, opts)
+				if err != nil {
+					t.Fatalf("%s %s: %v", name, alg, err)
+				}
+				if got.Rect != want.Rect {
+					t.Fatalf("%s %s with scale %v: bounds %v, expected %v", name, alg, opts.Scale, got.Rect, want.Rect)
+				}
+				if n, at := diffPixels(got, want); n > 0 {
+					t.Errorf("%s %s with scale %v, size %dx%d, filter %q and %+v: %d pixels differ from the generic path, the first at %v",
+						name, alg, opts.Scale, opts.Width, opts.Height, opts.Filter, opts.Adjust, n, at)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkGrayScan measures the halving and dithering of a 12 megapixels
+// grayscale scan, kept compact or going through the generic path, and the
+// size of the scaled image.
+func BenchmarkGrayScan(b *testing.B) {
+	ctx := context.Background()
+	src := testImages(b, 4000, 3000)["gray"]
+	opts := DefaultOptions(WithScale(0.5))
+	for _, img := range []image.Image{src, generic{src}} {
+		b.Run(fmt.Sprintf("%T", img), func(b *testing.B) {
+			// The scaled images are reused between the runs: their size
+			// is reported on its own.
+			scaled, err := prepare(ctx, img, opts)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var size int
+			switch m := scaled.(type) {
+			case *image.Gray:
+				size = len(m.Pix)
+			case *image.RGBA:
+				size = len(m.Pix)
+			}
+			Release(scaled)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m, err := Process(ctx, img, opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				Release(m)
+			}
+			b.ReportMetric(float64(size), "scaled-B")
+		})
+	}
+}
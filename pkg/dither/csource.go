@@ -0,0 +1,77 @@
+package dither
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+// IsCIdentifier reports whether name is a valid C identifier.
+func IsCIdentifier(name string) bool {
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// EncodeC writes to w a C header embedding img: the macros <NAME>_WIDTH,
+// <NAME>_HEIGHT and <NAME>_BITS, and the array <name>_pixels holding the
+// pixels packed as by Pack, <NAME> being name in upper case.
+func EncodeC(w io.Writer, img *image.Paletted, name string) error {
+	b := img.Bounds()
+	bits := PackedBits(img.Palette)
+	comment := fmt.Sprintf("the palette indices of the pixels of the %s image, row by row,\n"+
+		"packed %d bit(s) per pixel with the first pixel in the most significant bits\n"+
+		"of a byte. Each row starts on a new byte.", name, bits)
+	return writeC(w, name, "pixels", b.Dx(), b.Dy(), bits, comment, Pack(img))
+}
+
+// EncodeCFramebuffer writes to w a C header embedding the framebuffer of the
+// device d showing img, see Device.Framebuffer: the macros <NAME>_WIDTH,
+// <NAME>_HEIGHT and <NAME>_BITS of the framebuffer and the array
+// <name>_framebuffer holding its bytes.
+func EncodeCFramebuffer(w io.Writer, img *image.Paletted, name string, d Device) error {
+	data, err := d.Framebuffer(img)
+	if err != nil {
+		return &EncodeError{Err: err}
+	}
+	width, height := d.FramebufferSize()
+	comment := fmt.Sprintf("the framebuffer of the %s panel showing the %s image,\n"+
+		"in %d plane(s) of %d bit(s) per pixel.", d.Name, name, len(d.Planes), d.Bits)
+	return writeC(w, name, "framebuffer", width, height, d.Bits, comment, data)
+}
+
+// writeC writes the C header of the array <name>_<array> of data, with the
+// macros of the dimensions and the bits per pixel, and the comment of the
+// array.
+func writeC(w io.Writer, name, array string, width, height, bits int, comment string, data []byte) error {
+	if !IsCIdentifier(name) {
+		return &EncodeError{Err: fmt.Errorf("invalid C identifier %q", name)}
+	}
+	macro := strings.ToUpper(name)
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "// Code generated by fls; DO NOT EDIT.\n\n#ifndef %s_H\n#define %[1]s_H\n\n", macro)
+	fmt.Fprintf(bw, "#define %s_WIDTH %d\n#define %[1]s_HEIGHT %[3]d\n#define %[1]s_BITS %[4]d\n\n", macro, width, height, bits)
+	fmt.Fprintf(bw, "// %s_%s holds %s\n", name, array, strings.Replace(comment, "\n", "\n// ", -1))
+	fmt.Fprintf(bw, "static const unsigned char %s_%s[%d] = {", name, array, len(data))
+	for i, v := range data {
+		if i%12 == 0 {
+			bw.WriteString("\n\t")
+		} else {
+			bw.WriteString(" ")
+		}
+		fmt.Fprintf(bw, "0x%02x,", v)
+	}
+	bw.WriteString("\n};\n\n#endif\n")
+	if err := bw.Flush(); err != nil {
+		return &EncodeError{Err: err}
+	}
+	return nil
+}
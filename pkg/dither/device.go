@@ -0,0 +1,181 @@
+package dither
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+)
+
+// A Device describes an e-paper panel: the size and the palette of the
+// pictures it shows, and the layout of the framebuffer its driver expects.
+type Device struct {
+	Name        string
+	Description string
+	// Width and Height are the size in pixels of the pictures shown by the
+	// panel, the way it is usually mounted.
+	Width, Height int
+	// Rotation is the clockwise rotation in degrees, 0, 90, 180 or 270,
+	// turning the pictures to the orientation of the framebuffer.
+	Rotation int
+	// Palette holds the colors of the panel.
+	Palette color.Palette
+	// Bits is the number of bits per pixel of the planes of the
+	// framebuffer: 1, 2, 4 or 8.
+	Bits int
+	// Planes are the values of the colors of Palette in each plane of the
+	// framebuffer, which follow each other. Each plane holds the pixels row
+	// by row, the first pixel in the most significant bits of a byte, each
+	// row starting on a new byte.
+	Planes [][]uint8
+}
+
+// The palettes of the panels, the ink colors first.
+var (
+	bwPanel  = hexPalette("000000", "ffffff")
+	bwrPanel = hexPalette("000000", "ffffff", "ff0000")
+)
+
+// devices are the devices of LookupDevice.
+var devices = map[string]Device{}
+
+func init() {
+	for _, d := range []Device{
+		{
+			Name: "waveshare-1.54", Description: "Waveshare 1.54 inch, black and white",
+			Width: 200, Height: 200, Palette: bwPanel,
+			Bits: 1, Planes: [][]uint8{{0, 1}},
+		},
+		{
+			Name: "waveshare-2.13", Description: "Waveshare 2.13 inch V2 to V4, black and white",
+			Width: 250, Height: 122, Rotation: 90, Palette: bwPanel,
+			Bits: 1, Planes: [][]uint8{{0, 1}},
+		},
+		{
+			// The black plane, then the red one, where 0 is the ink.
+			Name: "waveshare-2.13b", Description: "Waveshare 2.13 inch B V4, black, white and red",
+			Width: 250, Height: 122, Rotation: 90, Palette: bwrPanel,
+			Bits: 1, Planes: [][]uint8{{0, 1, 1}, {1, 1, 0}},
+		},
+		{
+			Name: "waveshare-2.9", Description: "Waveshare 2.9 inch, black and white",
+			Width: 296, Height: 128, Rotation: 90, Palette: bwPanel,
+			Bits: 1, Planes: [][]uint8{{0, 1}},
+		},
+		{
+			Name: "waveshare-4.2", Description: "Waveshare 4.2 inch, black and white",
+			Width: 400, Height: 300, Palette: bwPanel,
+			Bits: 1, Planes: [][]uint8{{0, 1}},
+		},
+		{
+			Name: "waveshare-7.5", Description: "Waveshare 7.5 inch V2, black and white",
+			Width: 800, Height: 480, Palette: bwPanel,
+			Bits: 1, Planes: [][]uint8{{0, 1}},
+		},
+		{
+			// The palette indices of the colors, two pixels per byte.
+			Name: "waveshare-5.65f", Description: "Waveshare 5.65 inch F, 7 colors",
+			Width: 600, Height: 448, Palette: palettes["eink-7"],
+			Bits: 4, Planes: [][]uint8{{0, 1, 2, 3, 4, 5, 6}},
+		},
+		{
+			// The black plane, where 0 is the ink, then the red one, where
+			// 1 is.
+			Name: "inky-phat-red", Description: "Pimoroni Inky pHAT, black, white and red",
+			Width: 212, Height: 104, Rotation: 270, Palette: bwrPanel,
+			Bits: 1, Planes: [][]uint8{{0, 1, 1}, {0, 0, 1}},
+		},
+		{
+			Name: "inky-what-red", Description: "Pimoroni Inky wHAT, black, white and red",
+			Width: 400, Height: 300, Palette: bwrPanel,
+			Bits: 1, Planes: [][]uint8{{0, 1, 1}, {0, 0, 1}},
+		},
+	} {
+		devices[d.Name] = d
+	}
+}
+
+// LookupDevice returns the named device, see Devices.
+func LookupDevice(name string) (Device, bool) {
+	d, ok := devices[name]
+	return d, ok
+}
+
+// Devices returns the sorted names of the devices of LookupDevice.
+func Devices() []string {
+	names := make([]string, 0, len(devices))
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// orientation returns the EXIF orientation turning the pictures of d to the
+// orientation of its framebuffer, see Orient.
+func (d Device) orientation() int {
+	return rotation(d.Rotation)
+}
+
+// FramebufferSize returns the width and the height of the framebuffer of d,
+// in pixels.
+func (d Device) FramebufferSize() (width, height int) {
+	if d.Rotation == 90 || d.Rotation == 270 {
+		return d.Height, d.Width
+	}
+	return d.Width, d.Height
+}
+
+// Frame returns the picture of d showing img, of the palette of d: img
+// centered on the lightest color of the palette, and cropped to the size of
+// the picture if it is larger.
+func (d Device) Frame(img *image.Paletted) *image.Paletted {
+	r := image.Rect(0, 0, d.Width, d.Height)
+	if img.Rect == r {
+		return img
+	}
+	dst := image.NewPaletted(r, img.Palette)
+	if light := newTwoTones(img.Palette).light; light != 0 {
+		for i := range dst.Pix {
+			dst.Pix[i] = uint8(light)
+		}
+	}
+	b := img.Bounds()
+	offset := image.Pt((d.Width-b.Dx())/2, (d.Height-b.Dy())/2).Sub(b.Min)
+	shown := b.Add(offset).Intersect(r)
+	for y := shown.Min.Y; y < shown.Max.Y; y++ {
+		copy(dst.Pix[dst.PixOffset(shown.Min.X, y):dst.PixOffset(shown.Max.X, y)],
+			img.Pix[img.PixOffset(shown.Min.X-offset.X, y-offset.Y):])
+	}
+	return dst
+}
+
+// Framebuffer returns the framebuffer of d showing img, a picture of d like
+// the ones of Frame.
+func (d Device) Framebuffer(img *image.Paletted) ([]byte, error) {
+	b := img.Bounds()
+	if b.Dx() != d.Width || b.Dy() != d.Height {
+		return nil, fmt.Errorf("image of %dx%d pixels for the %dx%d pixels of %s", b.Dx(), b.Dy(), d.Width, d.Height, d.Name)
+	}
+	if len(img.Palette) > len(d.Palette) {
+		return nil, fmt.Errorf("palette of %d colors for the %d colors of %s", len(img.Palette), len(d.Palette), d.Name)
+	}
+	o := d.orientation()
+	w, h := d.FramebufferSize()
+	stride := (w*d.Bits + 7) / 8
+	data := make([]byte, 0, len(d.Planes)*stride*h)
+	for _, values := range d.Planes {
+		plane := make([]byte, stride*h)
+		for y := 0; y < h; y++ {
+			row := plane[y*stride:]
+			for x := 0; x < w; x++ {
+				sx, sy := orientedSource(o, x, y, b)
+				v := values[img.Pix[img.PixOffset(sx, sy)]]
+				i := x * d.Bits
+				row[i/8] |= v << uint(8-d.Bits-i%8)
+			}
+		}
+		data = append(data, plane...)
+	}
+	return data, nil
+}
@@ -0,0 +1,174 @@
+package dither
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+)
+
+// testPanel returns a device of 5x3 pixels of the black, white and red
+// palette, with the given rotation and planes of bits.
+func testPanel(rotation, bits int, planes ...[]uint8) Device {
+	return Device{
+		Name: "test-panel", Width: 5, Height: 3, Rotation: rotation, Palette: bwrPanel,
+		Bits: bits, Planes: planes,
+	}
+}
+
+// testPicture returns a picture of testPanel, of the pixels
+//
+//	K W R K W
+//	W W W R K
+//	R K W W W
+func testPicture() *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, 5, 3), bwrPanel)
+	copy(img.Pix, []uint8{0, 1, 2, 0, 1, 1, 1, 1, 2, 0, 2, 0, 1, 1, 1})
+	return img
+}
+
+// TestFramebuffer checks the framebuffers of testPicture against ones
+// packed by hand.
+func TestFramebuffer(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		d    Device
+		want []byte
+	}{
+		{
+			// The black plane, where 0 is black, then the red one.
+			"two planes", testPanel(0, 1, []uint8{0, 1, 1}, []uint8{0, 0, 1}),
+			[]byte{0x68, 0xf0, 0xb8, 0x20, 0x10, 0x80},
+		},
+		{
+			// Five rows of three pixels from the bottom left corner,
+			// RWK, KWW..., of two bytes each.
+			"rotated clockwise", testPanel(90, 4, []uint8{0, 1, 2}),
+			[]byte{0x21, 0x00, 0x01, 0x10, 0x11, 0x20, 0x12, 0x00, 0x10, 0x10},
+		},
+		{
+			// Five rows of three pixels from the top right corner, WKW,
+			// KRW..., of a byte each.
+			"rotated counterclockwise", testPanel(270, 2, []uint8{3, 0, 1}),
+			[]byte{0x30, 0xd0, 0x40, 0x0c, 0xc4},
+		},
+		{
+			"upside down", testPanel(180, 8, []uint8{0x10, 0x20, 0x30}),
+			[]byte{0x20, 0x20, 0x20, 0x10, 0x30, 0x10, 0x30, 0x20, 0x20, 0x20, 0x20, 0x10, 0x30, 0x20, 0x10},
+		},
+	} {
+		got, err := tt.d.Framebuffer(testPicture())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: framebuffer %#x, expected %#x", tt.name, got, tt.want)
+		}
+	}
+
+	d := testPanel(0, 1, []uint8{0, 1})
+	d.Palette = bwPanel
+	if _, err := d.Framebuffer(testPicture()); err == nil || err.Error() != "palette of 3 colors for the 2 colors of test-panel" {
+		t.Errorf("error %v with a palette too large", err)
+	}
+	if _, err := d.Framebuffer(image.NewPaletted(image.Rect(0, 0, 3, 5), bwPanel)); err == nil || err.Error() != "image of 3x5 pixels for the 5x3 pixels of test-panel" {
+		t.Errorf("error %v with an image of the size of the framebuffer", err)
+	}
+}
+
+// TestFrame checks that the images are centered on white in the pictures of
+// the devices, and cropped to them.
+func TestFrame(t *testing.T) {
+	d := testPanel(0, 1, []uint8{0, 1, 1})
+	small := image.NewPaletted(image.Rect(0, 0, 3, 1), bwrPanel)
+	copy(small.Pix, []uint8{0, 2, 0})
+	large := image.NewPaletted(image.Rect(0, 0, 7, 3), bwrPanel)
+	for i := range large.Pix {
+		large.Pix[i] = uint8(i % 7 % 3)
+	}
+	for _, tt := range []struct {
+		name string
+		img  *image.Paletted
+		want []uint8
+	}{
+		{"picture", testPicture(), testPicture().Pix},
+		{"smaller", small, []uint8{1, 1, 1, 1, 1, 1, 0, 2, 0, 1, 1, 1, 1, 1, 1}},
+		{"larger", large, []uint8{1, 2, 0, 1, 2, 1, 2, 0, 1, 2, 1, 2, 0, 1, 2}},
+	} {
+		got := d.Frame(tt.img)
+		if got.Rect != image.Rect(0, 0, 5, 3) || !bytes.Equal(got.Pix, tt.want) {
+			t.Errorf("%s: picture %v of pixels %v, expected %v", tt.name, got.Rect, got.Pix, tt.want)
+		}
+	}
+}
+
+// TestDevices checks that the planes of the devices have a value of their
+// bits for each color.
+func TestDevices(t *testing.T) {
+	for _, name := range Devices() {
+		d, _ := LookupDevice(name)
+		if d.Name != name || d.Width <= 0 || d.Height <= 0 || d.Rotation%90 != 0 || d.Rotation < 0 || d.Rotation >= 360 {
+			t.Errorf("%s: device %q of %dx%d pixels rotated by %d°", name, d.Name, d.Width, d.Height, d.Rotation)
+		}
+		switch d.Bits {
+		case 1, 2, 4, 8:
+		default:
+			t.Errorf("%s: %d bits per pixel", name, d.Bits)
+		}
+		for i, values := range d.Planes {
+			if len(values) != len(d.Palette) {
+				t.Errorf("%s: plane %d of %d values for %d colors", name, i+1, len(values), len(d.Palette))
+			}
+			for _, v := range values {
+				if int(v) >= 1<<uint(d.Bits) {
+					t.Errorf("%s: value %d in plane %d of %d bits", name, v, i+1, d.Bits)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeFramebuffer(t *testing.T) {
+	d := testPanel(0, 1, []uint8{0, 1, 1}, []uint8{0, 0, 1})
+	var c bytes.Buffer
+	if err := EncodeCFramebuffer(&c, testPicture(), "test", d); err != nil {
+		t.Fatal(err)
+	}
+	if want := `// Code generated by fls; DO NOT EDIT.
+
+#ifndef TEST_H
+#define TEST_H
+
+#define TEST_WIDTH 5
+#define TEST_HEIGHT 3
+#define TEST_BITS 1
+
+// test_framebuffer holds the framebuffer of the test-panel panel showing the test image,
+// in 2 plane(s) of 1 bit(s) per pixel.
+static const unsigned char test_framebuffer[6] = {
+	0x68, 0xf0, 0xb8, 0x20, 0x10, 0x80,
+};
+
+#endif
+`; c.String() != want {
+		t.Errorf("C header:\n%s\nexpected:\n%s", c.String(), want)
+	}
+
+	var g bytes.Buffer
+	if err := EncodeGoFramebuffer(&g, testPicture(), "panel", "Test", d); err != nil {
+		t.Fatal(err)
+	}
+	checkGoSource(t, g.Bytes(), "panel")
+	if want := "var TestFramebuffer = []byte{\n\t0x68, 0xf0, 0xb8, 0x20, 0x10, 0x80,\n}\n"; !strings.Contains(g.String(), want) {
+		t.Errorf("Go source without %q:\n%s", want, g.String())
+	}
+
+	// The raw format writes the framebuffer alone.
+	var raw bytes.Buffer
+	if err := Encode(&raw, testPicture(), "raw", EncodeOptions{Device: &d}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x68, 0xf0, 0xb8, 0x20, 0x10, 0x80}; !bytes.Equal(raw.Bytes(), want) {
+		t.Errorf("raw framebuffer %#x, expected %#x", raw.Bytes(), want)
+	}
+}
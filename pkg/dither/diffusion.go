@@ -0,0 +1,236 @@
+package dither
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// A BandDitherer is a Ditherer able to process an image as a sequence of
+// horizontal bands, from top to bottom, keeping between them only the state
+// it needs instead of the whole image.
+type BandDitherer interface {
+	Ditherer
+	// Bands returns a function dithering the successive bands of an image
+	// of the given width to the palette p. The result is the same as the one
+	// of Dither on the whole image.
+	Bands(width int, p color.Palette) DithererFunc
+}
+
+// Diffusion holds the settings of the ditherers diffusing the quantization
+// error of the pixels to their neighbors.
+type Diffusion struct {
+	// Strength is the fraction of the quantization error diffused, from 0,
+	// which maps each pixel to the nearest color, to 1. A weaker diffusion
+	// gives a softer dithering with less noise, and flatter tones.
+	Strength float64 `json:"strength"`
+	// Serpentine scans the rows alternately from left to right and from
+	// right to left, which breaks the directional worm artifacts.
+	Serpentine bool `json:"serpentine,omitempty"`
+}
+
+// defaultDiffusion is the Diffusion of the registered ditherers: the whole
+// error, diffused from left to right.
+var defaultDiffusion = Diffusion{Strength: 1}
+
+// A DiffusionDitherer is a Ditherer diffusing the quantization error whose
+// diffusion can be tuned.
+type DiffusionDitherer interface {
+	Ditherer
+	// Tuned returns the ditherer diffusing the error with the settings d.
+	Tuned(d Diffusion) Ditherer
+}
+
+// tuned returns d diffusing the error with the settings diff, or with the
+// threshold or halftone screen s, d itself for the default settings and for
+// the ditherers having none.
+func tuned(d Ditherer, diff Diffusion, s Screen) Ditherer {
+	if dd, ok := d.(DiffusionDitherer); ok && diff != defaultDiffusion && !parallel(d) {
+		return dd.Tuned(diff)
+	}
+	if sd, ok := d.(ScreenDitherer); ok && s != defaultScreen {
+		return sd.Screened(s)
+	}
+	return d
+}
+
+// errorDiffusion reduces images to a palette, like the image/draw package,
+// either by mapping each pixel to the nearest palette color or with the
+// Floyd-Steinberg error diffusion. It produces exactly the same results.
+type errorDiffusion bool
+
+func (e errorDiffusion) Dither(dst *image.Paletted, src image.Image) error {
+	return e.Bands(dst.Bounds().Dx(), dst.Palette)(dst, src)
+}
+
+// Parallel reports whether e maps each pixel to the nearest color without
+// diffusing the error.
+func (e errorDiffusion) Parallel() bool { return !bool(e) }
+
+// Tuned returns the Floyd-Steinberg kernel diffusion with the settings d,
+// and e itself without error diffusion.
+func (e errorDiffusion) Tuned(d Diffusion) Ditherer {
+	if !e {
+		return e
+	}
+	return kernelDiffusion{floydSteinberg, d}
+}
+
+func (e errorDiffusion) Bands(width int, p color.Palette) DithererFunc {
+	d := &diffusion{palette: paletteValues(p)}
+	d.match = newMatcher(d.palette)
+	if e {
+		// The errors of the current and next rows, with a pixel of margin on
+		// each side.
+		d.curr = make([][4]int32, width+2)
+		d.next = make([][4]int32, width+2)
+	}
+	return d.dither
+}
+
+// diffusion holds the state of an errorDiffusion between bands. The errors
+// are fixed-point sixteenths of 16-bit components, the Floyd-Steinberg
+// weights being integer numerators.
+type diffusion struct {
+	palette    [][4]int32
+	match      matcher
+	curr, next [][4]int32 // nil without error diffusion
+}
+
+// dither reduces to the palette the pixels of src in the bounds of dst, which
+// src must cover.
+func (d *diffusion) dither(dst *image.Paletted, src image.Image) error {
+	b := dst.Bounds()
+	if d.curr != nil && b.Dx()+2 != len(d.curr) {
+		return fmt.Errorf("dither: band of width %d for an image of width %d", b.Dx(), len(d.curr)-2)
+	}
+	if !b.In(src.Bounds()) {
+		return fmt.Errorf("dither: band %v out of the source bounds %v", b, src.Bounds())
+	}
+
+	pixel := pixelReader(src)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := dst.Pix[dst.PixOffset(b.Min.X, y):]
+		curr, next := d.curr, d.next
+		for i := 0; i < b.Dx(); i++ {
+			er, eg, eb, ea := pixel(b.Min.X+i, y)
+			if curr != nil {
+				e := &curr[i+1]
+				er = clamp(er + e[0]/16)
+				eg = clamp(eg + e[1]/16)
+				eb = clamp(eb + e[2]/16)
+				ea = clamp(ea + e[3]/16)
+			}
+
+			var best int
+			if len(d.palette) == 2 {
+				best = match2(&d.palette[0], &d.palette[1], er, eg, eb, ea)
+			} else {
+				best = d.match.index(er, eg, eb, ea)
+			}
+			row[i] = byte(best)
+
+			if curr == nil {
+				continue
+			}
+			p := &d.palette[best]
+			er -= p[0]
+			eg -= p[1]
+			eb -= p[2]
+			ea -= p[3]
+			// Floyd-Steinberg weights, in sixteenths.
+			n := next[i : i+3 : i+3]
+			n[0][0] += er * 3
+			n[0][1] += eg * 3
+			n[0][2] += eb * 3
+			n[0][3] += ea * 3
+			n[1][0] += er * 5
+			n[1][1] += eg * 5
+			n[1][2] += eb * 5
+			n[1][3] += ea * 5
+			n[2][0] += er * 1
+			n[2][1] += eg * 1
+			n[2][2] += eb * 1
+			n[2][3] += ea * 1
+			c := &curr[i+2]
+			c[0] += er * 7
+			c[1] += eg * 7
+			c[2] += eb * 7
+			c[3] += ea * 7
+		}
+		if curr != nil {
+			for i := range curr {
+				curr[i] = [4]int32{}
+			}
+			d.curr, d.next = next, curr
+		}
+	}
+	return nil
+}
+
+// pixelReader returns the function reading the 16-bit premultiplied
+// components of the pixels of src, with fast paths for the common image types,
+// like image/draw, and the grayscale planes.
+func pixelReader(src image.Image) func(x, y int) (r, g, b, a int32) {
+	switch s := src.(type) {
+	case *image.RGBA:
+		return func(x, y int) (int32, int32, int32, int32) {
+			p := s.Pix[s.PixOffset(x, y):]
+			return int32(p[0]) * 0x101, int32(p[1]) * 0x101, int32(p[2]) * 0x101, int32(p[3]) * 0x101
+		}
+	case *image.NRGBA:
+		return func(x, y int) (int32, int32, int32, int32) {
+			p := s.Pix[s.PixOffset(x, y):]
+			r, g, b, a := color.NRGBA{p[0], p[1], p[2], p[3]}.RGBA()
+			return int32(r), int32(g), int32(b), int32(a)
+		}
+	case *image.Gray:
+		return func(x, y int) (int32, int32, int32, int32) {
+			v := int32(s.Pix[s.PixOffset(x, y)]) * 0x101
+			return v, v, v, 0xffff
+		}
+	case *image.Gray16:
+		return func(x, y int) (int32, int32, int32, int32) {
+			p := s.Pix[s.PixOffset(x, y):]
+			v := int32(p[0])<<8 | int32(p[1])
+			return v, v, v, 0xffff
+		}
+	case *image.Paletted:
+		if len(s.Palette) > 0 {
+			p := paletteValues(s.Palette)
+			return func(x, y int) (int32, int32, int32, int32) {
+				c := &p[s.Pix[s.PixOffset(x, y)]]
+				return c[0], c[1], c[2], c[3]
+			}
+		}
+	case *image.YCbCr:
+		// The 16-bit conversion of color.YCbCr, which image/draw uses, is
+		// more precise than the 8-bit one of color.YCbCrToRGB.
+		return func(x, y int) (int32, int32, int32, int32) {
+			r, g, b, _ := s.YCbCrAt(x, y).RGBA()
+			return int32(r), int32(g), int32(b), 0xffff
+		}
+	}
+	return func(x, y int) (int32, int32, int32, int32) {
+		r, g, b, a := src.At(x, y).RGBA()
+		return int32(r), int32(g), int32(b), int32(a)
+	}
+}
+
+// clamp clamps i to the range of color components.
+func clamp(i int32) int32 {
+	if i < 0 {
+		return 0
+	}
+	if i > 0xffff {
+		return 0xffff
+	}
+	return i
+}
+
+// sqDiff returns the squared difference of two color components, halved
+// twice so that the sum of four of them fits in an uint32.
+func sqDiff(x, y int32) uint32 {
+	d := uint32(x - y)
+	return (d * d) >> 2
+}
@@ -0,0 +1,35 @@
+package dither
+
+import (
+	"image"
+	"image/draw"
+	"testing"
+)
+
+// TestErrorDiffusionMatchesDraw checks that floyd-steinberg and nearest give
+// the results of the image/draw package, on every source type with a fast
+// path.
+func TestErrorDiffusionMatchesDraw(t *testing.T) {
+	drawers := map[string]draw.Drawer{
+		"floyd-steinberg": draw.FloydSteinberg,
+		"nearest":         draw.Src,
+	}
+	for name, src := range testImages(t, 64, 48) {
+		for pname, p := range testPalettes {
+			for alg, drawer := range drawers {
+				b := src.Bounds()
+				want := image.NewPaletted(b, p)
+				drawer.Draw(want, b, src, b.Min)
+				d, _ := Lookup(alg)
+				got := image.NewPaletted(b, p)
+				if err := d.Dither(got, src); err != nil {
+					t.Fatalf("%s %s %s: %v", name, pname, alg, err)
+				}
+				if n, at := diffPixels(got, want); n > 0 {
+					t.Errorf("%s to %s with %s: %d of %d pixels differ from image/draw, the first at %v",
+						name, pname, alg, n, b.Dx()*b.Dy(), at)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Package dither produces paletted images from arbitrary ones. It scales the
+// source image and reduces it to a palette, with or without dithering, and
+// implements the decoding and encoding of the supported file formats.
+package dither
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+)
+
+// BlackAndWhite is the palette images are reduced to.
+var BlackAndWhite = color.Palette{color.White, color.Black}
+
+// Reduce reduces img to a paletted image of the same bounds and palette p with
+// the named registered ditherer, on GOMAXPROCS goroutines if it is parallel.
+// The ditherer itself is not interrupted when ctx is done.
+func Reduce(ctx context.Context, img image.Image, p color.Palette, algorithm string) (*image.Paletted, error) {
+	return reduce(ctx, img, p, algorithm, defaultDiffusion, defaultScreen, 0)
+}
+
+// ReduceDiffusing is Reduce with the diffusion settings diff for the
+// ditherers diffusing the quantization error, the other ones ignoring them.
+func ReduceDiffusing(ctx context.Context, img image.Image, p color.Palette, algorithm string, diff Diffusion) (*image.Paletted, error) {
+	return reduce(ctx, img, p, algorithm, diff, defaultScreen, 0)
+}
+
+// ReduceScreened is ReduceDiffusing with the threshold and halftone settings
+// s for the ditherers mapping the pixels to two tones, the other ones
+// ignoring them.
+func ReduceScreened(ctx context.Context, img image.Image, p color.Palette, algorithm string, diff Diffusion, s Screen) (*image.Paletted, error) {
+	return reduce(ctx, img, p, algorithm, diff, s, 0)
+}
+
+// reduce is ReduceScreened on up to threads goroutines.
+func reduce(ctx context.Context, img image.Image, p color.Palette, algorithm string, diff Diffusion, s Screen, threads int) (*image.Paletted, error) {
+	d, ok := Lookup(algorithm)
+	if !ok {
+		return nil, fmt.Errorf("unknown algorithm %q", algorithm)
+	}
+	d = tuned(d, diff, s)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	dst := &image.Paletted{Pix: getPix(b.Dx() * b.Dy()), Stride: b.Dx(), Rect: b, Palette: p}
+	if err := rowsDitherer(d, dst.Bounds().Dx(), p, threads)(dst, img); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// Process scales img and reduces it to the palette according to opts. It
+// returns ctx.Err() if ctx is done before the processing completes.
+func Process(ctx context.Context, img image.Image, opts Options) (*image.Paletted, error) {
+	p, err := NewPipeline(opts)
+	if err != nil {
+		return nil, err
+	}
+	// Nothing but the result is returned: the image reduced is released
+	// too, unless it is img.
+	last := len(p.Stages) - 1
+	reduce := p.Stages[last]
+	p.Stages[last] = NewStage(reduce.Name(), func(ctx context.Context, m image.Image) (image.Image, error) {
+		out, err := reduce.Apply(ctx, m)
+		if m != img {
+			Release(m)
+		}
+		return out, err
+	})
+	out, err := p.Run(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	return out.(*image.Paletted), nil
+}
+
+// Transform decodes the image read from r, processes it according to opts
+// and encodes the result to w in the format of opts, see ProcessReader.
+func Transform(ctx context.Context, w io.Writer, r io.Reader, opts Options) error {
+	dst, err := ProcessReader(ctx, r, opts)
+	if err != nil {
+		return err
+	}
+	return Encode(w, dst, opts.Format, opts.Encoding)
+}
+
+// ProcessReader decodes the image read from r and processes it according to
+// opts. The format of the input is detected from its first bytes and the
+// input is decoded as it is read, once its header shows it is within
+// opts.MaxPixels, shrunk right away for the heavy downscalings and converted
+// to sRGB and oriented, see DecodeWith. Reading stops with ctx.Err() once ctx is done.
+func ProcessReader(ctx context.Context, r io.Reader, opts Options) (*image.Paletted, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(contextReader{ctx, r}, SniffLen)
+	head, err := br.Peek(SniffLen)
+	if err != nil && err != io.EOF {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &DecodeError{Err: err}
+	}
+	format := SniffFormat(head)
+	if format == "" {
+		return nil, &DecodeError{Err: ErrUnsupportedFormat}
+	}
+	img, _, err := DecodeWith(br, format, DecodeOptions{
+		MaxPixels:         opts.MaxPixels,
+		Shrink:            ShrinkFactor(opts.Scale),
+		AssumeSRGB:        opts.AssumeSRGB,
+		IgnoreOrientation: opts.IgnoreOrientation,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return Process(ctx, img, opts)
+}
+
+// contextReader is a reader failing with ctx.Err() once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// ProcessFile processes the image at inPath according to opts and writes
+// the result at outPath. The format of the input is detected from its
+// content. Nothing is written if ctx is done before the processing completes.
+func ProcessFile(ctx context.Context, inPath, outPath string, opts Options) error {
+	in, err := os.Open(inPath)
+	if err != nil {
+		return &DecodeError{Path: inPath, Err: err}
+	}
+	defer in.Close()
+	var buf bytes.Buffer
+	if err := Transform(ctx, &buf, in, opts); err != nil {
+		return withPath(withPath(err, inPath), outPath)
+	}
+	return WriteFile(outPath, buf.Bytes())
+}
+
+// WriteFile writes data at path. The file is removed if it cannot be written
+// entirely so no truncated output is left behind. Errors are *EncodeError
+// values.
+func WriteFile(path string, data []byte) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return &EncodeError{Path: path, Err: err}
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil && cerr != nil {
+			err = &EncodeError{Path: path, Err: cerr}
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+	if _, err := file.Write(data); err != nil {
+		return &EncodeError{Path: path, Err: err}
+	}
+	return nil
+}
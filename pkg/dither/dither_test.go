@@ -0,0 +1,233 @@
+package dither
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// uniform returns a w x h gray image of the level v.
+func uniform(w, h int, v uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = v
+	}
+	return img
+}
+
+// whiteShare returns the share of the pixels of img of the color white.
+func whiteShare(img *image.Paletted) float64 {
+	n := 0
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			if color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y == 0xff {
+				n++
+			}
+		}
+	}
+	return float64(n) / float64(img.Rect.Dx()*img.Rect.Dy())
+}
+
+func TestProcessBounds(t *testing.T) {
+	src := testImages(t, 40, 20)["rgba"]
+	for _, tt := range []struct {
+		opts Options
+		want image.Rectangle
+	}{
+		{DefaultOptions(), image.Rect(0, 0, 40, 20)},
+		{DefaultOptions(WithScale(0.5)), image.Rect(0, 0, 20, 10)},
+		{DefaultOptions(WithScale(2), WithFilter("bilinear")), image.Rect(0, 0, 80, 40)},
+		{DefaultOptions(WithSize(10, 0)), image.Rect(0, 0, 10, 5)},
+		{DefaultOptions(WithSize(10, 7)), image.Rect(0, 0, 10, 7)},
+		{DefaultOptions(WithFit(10, 10)), image.Rect(0, 0, 10, 5)},
+	} {
+		dst, err := Process(context.Background(), src, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dst.Rect != tt.want {
+			t.Errorf("scale %v, size %dx%d, fit %v: bounds %v, expected %v", tt.opts.Scale, tt.opts.Width, tt.opts.Height, tt.opts.Fit, dst.Rect, tt.want)
+		}
+		if len(dst.Palette) != len(BlackAndWhite) || dst.Palette[0] != BlackAndWhite[0] || dst.Palette[1] != BlackAndWhite[1] {
+			t.Errorf("palette %v, expected %v", dst.Palette, BlackAndWhite)
+		}
+	}
+}
+
+// TestProcessTones checks that every algorithm turns black and white images
+// to their color, and keeps the tone of a mid gray.
+func TestProcessTones(t *testing.T) {
+	for _, alg := range Algorithms() {
+		opts := DefaultOptions(WithAlgorithm(alg))
+		for _, tt := range []struct {
+			level    uint8
+			min, max float64
+		}{
+			{0, 0, 0},
+			{0xff, 1, 1},
+			{0x80, 0.4, 0.6},
+		} {
+			if tt.level == 0x80 && (alg == "nearest" || alg == "threshold" || alg == "otsu") {
+				continue // not dithering
+			}
+			dst, err := Process(context.Background(), uniform(64, 64, tt.level), opts)
+			if err != nil {
+				t.Fatalf("%s: %v", alg, err)
+			}
+			if s := whiteShare(dst); s < tt.min || s > tt.max {
+				t.Errorf("%s: %.2f of the pixels of a gray of level %d are white, expected %v to %v", alg, s, tt.level, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestProcessCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Process(ctx, uniform(64, 64, 0x80), DefaultOptions()); err != context.Canceled {
+		t.Errorf("error %v, expected %v", err, context.Canceled)
+	}
+}
+
+func TestProcessInvalidOptions(t *testing.T) {
+	if _, err := Process(context.Background(), uniform(4, 4, 0), DefaultOptions(WithScale(0))); err == nil {
+		t.Error("processed with a scale of 0")
+	}
+}
+
+func TestProcessFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dither")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "in.png"), filepath.Join(dir, "out.png")
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, testImages(t, 30, 20)["nrgba"]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err := ProcessFile(context.Background(), in, out, DefaultOptions(WithScale(0.5))); err != nil {
+		t.Fatal(err)
+	}
+	f, err = os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := img.(*image.Paletted)
+	if !ok {
+		t.Fatalf("result of type %T, expected a paletted image", img)
+	}
+	if p.Rect != image.Rect(0, 0, 15, 10) {
+		t.Errorf("result of bounds %v, expected 15x10", p.Rect)
+	}
+	for _, c := range p.Palette {
+		if g := color.GrayModel.Convert(c).(color.Gray); g.Y != 0 && g.Y != 0xff {
+			t.Errorf("color %v in the palette of the result", c)
+		}
+	}
+}
+
+// TestTransform checks that Transform sniffs the format of the input and
+// encodes the result of Process in the format of the options.
+func TestTransform(t *testing.T) {
+	src := testImages(t, 24, 16)["rgba"]
+	encoders := map[string]func(*bytes.Buffer) error{
+		"png":  func(b *bytes.Buffer) error { return png.Encode(b, src) },
+		"jpeg": func(b *bytes.Buffer) error { return jpeg.Encode(b, src, nil) },
+		"gif":  func(b *bytes.Buffer) error { return gif.Encode(b, src, nil) },
+	}
+	for format, encode := range encoders {
+		var in bytes.Buffer
+		if err := encode(&in); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := Decode(bytes.NewReader(in.Bytes()), format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := DefaultOptions(WithAlgorithm("atkinson"), WithScale(0.5))
+		want, err := Process(context.Background(), decoded, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		if err := Transform(context.Background(), &out, &in, opts); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		img, err := png.Decode(&out)
+		if err != nil {
+			t.Fatalf("%s: decoding the result: %v", format, err)
+		}
+		got, ok := img.(*image.Paletted)
+		if !ok {
+			t.Fatalf("%s: result of type %T, expected a paletted image", format, img)
+		}
+		if got.Rect != want.Rect {
+			t.Fatalf("%s: result of bounds %v, expected %v", format, got.Rect, want.Rect)
+		}
+		if n, at := diffPixels(got, want); n > 0 {
+			t.Errorf("%s: %d pixels differ from Process, the first at %v", format, n, at)
+		}
+	}
+
+	var in, out bytes.Buffer
+	if err := png.Encode(&in, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := Transform(context.Background(), &out, &in, DefaultOptions(WithFormat("pbm"))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out.Bytes(), []byte("P4\n24 16\n")) {
+		t.Errorf("pbm result starting with %q", out.Bytes()[:10])
+	}
+}
+
+// TestTransformErrors checks that the inputs which cannot be decoded fail
+// with a *DecodeError, and that nothing is written for them.
+func TestTransformErrors(t *testing.T) {
+	var valid bytes.Buffer
+	if err := png.Encode(&valid, testImages(t, 24, 16)["rgba"]); err != nil {
+		t.Fatal(err)
+	}
+	for name, tt := range map[string]struct {
+		in     []byte
+		target error
+	}{
+		"empty":     {nil, ErrUnsupportedFormat},
+		"text":      {[]byte("not an image at all"), ErrUnsupportedFormat},
+		"truncated": {valid.Bytes()[:valid.Len()/2], nil},
+	} {
+		var out bytes.Buffer
+		err := Transform(context.Background(), &out, bytes.NewReader(tt.in), DefaultOptions())
+		var de *DecodeError
+		if !errors.As(err, &de) {
+			t.Errorf("%s: error %v, expected a *DecodeError", name, err)
+		}
+		if tt.target != nil && !errors.Is(err, tt.target) {
+			t.Errorf("%s: error %v, expected one wrapping %v", name, err, tt.target)
+		}
+		if out.Len() > 0 {
+			t.Errorf("%s: %d bytes written", name, out.Len())
+		}
+	}
+}
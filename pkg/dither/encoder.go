@@ -0,0 +1,292 @@
+package dither
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// EncodeOptions holds the settings of the encoders. Each encoder only uses
+// those relevant to its format.
+type EncodeOptions struct {
+	// Package and Name are the package name and identifier prefix of the
+	// Go source written by the go format, "img" and "Image" when empty.
+	// Name is also the identifier prefix of the C header written by the c
+	// format, "image" when empty.
+	Package string
+	Name    string
+	// Plain makes the pbm and pgm formats write their plain variant, with
+	// the pixels as ASCII numbers, instead of the binary one.
+	Plain bool
+	// RowAlign is the number of bytes the rows of the raw format are padded
+	// to a multiple of, see PackRows. They are not padded when it is zero.
+	RowAlign int
+	// Metadata is the metadata of the source kept by the png format, its
+	// resolution and ICC profile, written in pHYs and iCCP chunks.
+	Metadata Metadata
+	// Device, when set, makes the raw, c and go formats write the
+	// framebuffer of the device showing the image, one of its pictures,
+	// instead of the packed pixels of the image.
+	Device *Device
+}
+
+// An Encoder writes paletted images in a file format.
+type Encoder interface {
+	Encode(w io.Writer, img *image.Paletted, opts EncodeOptions) error
+}
+
+// EncoderFunc adapts an ordinary function to the Encoder interface.
+type EncoderFunc func(w io.Writer, img *image.Paletted, opts EncodeOptions) error
+
+// Encode calls f(w, img, opts).
+func (f EncoderFunc) Encode(w io.Writer, img *image.Paletted, opts EncodeOptions) error {
+	return f(w, img, opts)
+}
+
+// OutputFormat describes a registered output format.
+type OutputFormat struct {
+	Name string
+	// Extensions are the file extensions of the format, with their leading
+	// dot. The first one is used for the default output file names.
+	Extensions []string
+	MediaType  string
+	Encoder    Encoder
+}
+
+var encoders = struct {
+	sync.RWMutex
+	byName map[string]OutputFormat
+	byExt  map[string]OutputFormat
+}{byName: make(map[string]OutputFormat), byExt: make(map[string]OutputFormat)}
+
+// RegisterEncoder makes an output format available under its name and
+// extensions. It fails if the name or one of the extensions is already
+// taken.
+func RegisterEncoder(f OutputFormat) error {
+	if f.Name == "" {
+		return errors.New("dither: registering an encoder without name")
+	}
+	if f.Encoder == nil {
+		return fmt.Errorf("dither: registering a nil encoder as %q", f.Name)
+	}
+	if len(f.Extensions) == 0 {
+		return fmt.Errorf("dither: registering the encoder %q without extension", f.Name)
+	}
+	encoders.Lock()
+	defer encoders.Unlock()
+	if _, ok := encoders.byName[f.Name]; ok {
+		return fmt.Errorf("dither: an encoder is already registered as %q", f.Name)
+	}
+	for _, ext := range f.Extensions {
+		if other, ok := encoders.byExt[strings.ToLower(ext)]; ok {
+			return fmt.Errorf("dither: the extension %s of %q is already registered for %q", ext, f.Name, other.Name)
+		}
+	}
+	encoders.byName[f.Name] = f
+	for _, ext := range f.Extensions {
+		encoders.byExt[strings.ToLower(ext)] = f
+	}
+	return nil
+}
+
+// MustRegisterEncoder is like RegisterEncoder but panics on failure.
+func MustRegisterEncoder(f OutputFormat) {
+	if err := RegisterEncoder(f); err != nil {
+		panic(err)
+	}
+}
+
+// LookupEncoder returns the output format registered under the given name.
+func LookupEncoder(name string) (OutputFormat, bool) {
+	encoders.RLock()
+	defer encoders.RUnlock()
+	f, ok := encoders.byName[name]
+	return f, ok
+}
+
+// EncoderForExtension returns the output format registered for the file
+// extension ext, such as ".png".
+func EncoderForExtension(ext string) (OutputFormat, bool) {
+	encoders.RLock()
+	defer encoders.RUnlock()
+	f, ok := encoders.byExt[strings.ToLower(ext)]
+	return f, ok
+}
+
+// OutputFormats returns the registered output formats sorted by name.
+func OutputFormats() []OutputFormat {
+	encoders.RLock()
+	defer encoders.RUnlock()
+	formats := make([]OutputFormat, 0, len(encoders.byName))
+	for _, f := range encoders.byName {
+		formats = append(formats, f)
+	}
+	sort.Slice(formats, func(i, j int) bool { return formats[i].Name < formats[j].Name })
+	return formats
+}
+
+// OutputExtensions returns the sorted extensions of the registered output
+// formats.
+func OutputExtensions() []string {
+	encoders.RLock()
+	defer encoders.RUnlock()
+	exts := make([]string, 0, len(encoders.byExt))
+	for ext := range encoders.byExt {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+// Encode encodes img to w in the named registered format, PNG when format is
+// empty. Errors are *EncodeError values, wrapping ErrUnsupportedFormat for an
+// unknown format.
+func Encode(w io.Writer, img *image.Paletted, format string, opts EncodeOptions) error {
+	if format == "" {
+		format = "png"
+	}
+	f, ok := LookupEncoder(format)
+	if !ok {
+		return &EncodeError{Err: fmt.Errorf("%w %q", ErrUnsupportedFormat, format)}
+	}
+	if err := f.Encoder.Encode(w, img, opts); err != nil {
+		var ee *EncodeError
+		if errors.As(err, &ee) {
+			return err
+		}
+		return &EncodeError{Err: err}
+	}
+	return nil
+}
+
+func init() {
+	MustRegisterEncoder(OutputFormat{
+		Name:       "png",
+		Extensions: []string{".png"},
+		MediaType:  "image/png",
+		Encoder: EncoderFunc(func(w io.Writer, img *image.Paletted, opts EncodeOptions) error {
+			if !opts.Metadata.hasPNGChunks() {
+				return pngEncoder.Encode(w, img)
+			}
+			var buf bytes.Buffer
+			if err := pngEncoder.Encode(&buf, img); err != nil {
+				return err
+			}
+			return opts.Metadata.writePNG(w, buf.Bytes())
+		}),
+	})
+	MustRegisterEncoder(OutputFormat{
+		Name:       "gif",
+		Extensions: []string{".gif"},
+		MediaType:  "image/gif",
+		Encoder: EncoderFunc(func(w io.Writer, img *image.Paletted, opts EncodeOptions) error {
+			return gif.Encode(w, img, &gif.Options{NumColors: len(img.Palette)})
+		}),
+	})
+	MustRegisterEncoder(OutputFormat{
+		Name:       "bmp",
+		Extensions: []string{".bmp"},
+		MediaType:  "image/bmp",
+		Encoder: EncoderFunc(func(w io.Writer, img *image.Paletted, opts EncodeOptions) error {
+			return bmp.Encode(w, img)
+		}),
+	})
+	MustRegisterEncoder(OutputFormat{
+		Name:       "tiff",
+		Extensions: []string{".tif", ".tiff"},
+		MediaType:  "image/tiff",
+		Encoder: EncoderFunc(func(w io.Writer, img *image.Paletted, opts EncodeOptions) error {
+			return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+		}),
+	})
+	MustRegisterEncoder(OutputFormat{
+		Name:       "raw",
+		Extensions: []string{".raw", ".bin"},
+		MediaType:  "application/octet-stream",
+		Encoder: EncoderFunc(func(w io.Writer, img *image.Paletted, opts EncodeOptions) error {
+			data := PackRows(img, opts.RowAlign)
+			if opts.Device != nil {
+				var err error
+				if data, err = opts.Device.Framebuffer(img); err != nil {
+					return err
+				}
+			}
+			_, err := w.Write(data)
+			return err
+		}),
+	})
+	MustRegisterEncoder(OutputFormat{
+		Name:       "pbm",
+		Extensions: []string{".pbm"},
+		MediaType:  "image/x-portable-bitmap",
+		Encoder: EncoderFunc(func(w io.Writer, img *image.Paletted, opts EncodeOptions) error {
+			return EncodePBM(w, img, opts.Plain)
+		}),
+	})
+	MustRegisterEncoder(OutputFormat{
+		Name:       "pgm",
+		Extensions: []string{".pgm"},
+		MediaType:  "image/x-portable-graymap",
+		Encoder: EncoderFunc(func(w io.Writer, img *image.Paletted, opts EncodeOptions) error {
+			return EncodePGM(w, img, opts.Plain)
+		}),
+	})
+	MustRegisterEncoder(OutputFormat{
+		Name:       "ansi",
+		Extensions: []string{".ans"},
+		MediaType:  "text/x-ansi; charset=utf-8",
+		Encoder: EncoderFunc(func(w io.Writer, img *image.Paletted, opts EncodeOptions) error {
+			return EncodeANSI(w, img)
+		}),
+	})
+	MustRegisterEncoder(OutputFormat{
+		Name:       "ascii",
+		Extensions: []string{".txt"},
+		MediaType:  "text/plain; charset=utf-8",
+		Encoder: EncoderFunc(func(w io.Writer, img *image.Paletted, opts EncodeOptions) error {
+			return EncodeBraille(w, img)
+		}),
+	})
+	MustRegisterEncoder(OutputFormat{
+		Name:       "go",
+		Extensions: []string{".go"},
+		MediaType:  "text/x-go; charset=utf-8",
+		Encoder: EncoderFunc(func(w io.Writer, img *image.Paletted, opts EncodeOptions) error {
+			pkg, name := opts.Package, opts.Name
+			if pkg == "" {
+				pkg = "img"
+			}
+			if name == "" {
+				name = "Image"
+			}
+			if opts.Device != nil {
+				return EncodeGoFramebuffer(w, img, pkg, name, *opts.Device)
+			}
+			return EncodeGo(w, img, pkg, name)
+		}),
+	})
+	MustRegisterEncoder(OutputFormat{
+		Name:       "c",
+		Extensions: []string{".h"},
+		MediaType:  "text/x-c; charset=utf-8",
+		Encoder: EncoderFunc(func(w io.Writer, img *image.Paletted, opts EncodeOptions) error {
+			name := opts.Name
+			if name == "" {
+				name = "image"
+			}
+			if opts.Device != nil {
+				return EncodeCFramebuffer(w, img, name, *opts.Device)
+			}
+			return EncodeC(w, img, name)
+		}),
+	})
+}
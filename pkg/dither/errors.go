@@ -0,0 +1,75 @@
+package dither
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnsupportedFormat is returned, possibly wrapped, for an image format that
+// cannot be decoded or encoded.
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// ErrTooLarge is returned, wrapped in a *DecodeError, for an image with more
+// pixels than allowed by the MaxPixels option.
+var ErrTooLarge = errors.New("image too large")
+
+// DecodeError reports the failure to read or decode an image.
+type DecodeError struct {
+	Path   string // empty when the image is not read from a file
+	Format string // empty when the format is unknown
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	var b strings.Builder
+	b.WriteString("decoding ")
+	if e.Format != "" {
+		b.WriteString(e.Format + " ")
+	}
+	b.WriteString("image")
+	if e.Path != "" {
+		fmt.Fprintf(&b, " %q", e.Path)
+	}
+	return b.String() + ": " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// EncodeError reports the failure to encode or write a result.
+type EncodeError struct {
+	Path string // empty when the result is not written to a file
+	Err  error
+}
+
+func (e *EncodeError) Error() string {
+	if e.Path == "" {
+		return "encoding image: " + e.Err.Error()
+	}
+	return fmt.Sprintf("writing image %q: %v", e.Path, e.Err)
+}
+
+func (e *EncodeError) Unwrap() error { return e.Err }
+
+// ValidationError lists all the invalid settings of an Options.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid options: " + strings.Join(e.Problems, "; ")
+}
+
+// withPath sets the path of the DecodeError or EncodeError err, if it is one
+// and has none yet.
+func withPath(err error, path string) error {
+	var de *DecodeError
+	var ee *EncodeError
+	switch {
+	case errors.As(err, &de) && de.Path == "":
+		de.Path = path
+	case errors.As(err, &ee) && ee.Path == "":
+		ee.Path = path
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package dither
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestProcessFileErrors checks the errors of ProcessFile, which name the
+// file failing and wrap the error of the decoder or of the file system.
+func TestProcessFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dither")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImages(t, 32, 32)["rgba"]); err != nil {
+		t.Fatal(err)
+	}
+	valid := filepath.Join(dir, "valid.png")
+	truncated := filepath.Join(dir, "truncated.png")
+	text := filepath.Join(dir, "notes.txt")
+	missing := filepath.Join(dir, "missing.png")
+	for path, data := range map[string][]byte{
+		valid:     buf.Bytes(),
+		truncated: buf.Bytes()[:buf.Len()/2],
+		text:      []byte("not an image"),
+	} {
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := filepath.Join(dir, "out.png")
+
+	for _, tt := range []struct {
+		in, out string
+		format  string // of the *DecodeError, none for an *EncodeError
+		target  error
+		message string
+	}{
+		{truncated, out, "png", png.FormatError("not enough pixel data"), `decoding png image "` + truncated + `": png: invalid format: not enough pixel data`},
+		{text, out, "", ErrUnsupportedFormat, `decoding image "` + text + `": unsupported image format`},
+		{missing, out, "", os.ErrNotExist, ""},
+		{valid, filepath.Join(dir, "missing", "out.png"), "", os.ErrNotExist, ""},
+	} {
+		err := ProcessFile(context.Background(), tt.in, tt.out, DefaultOptions())
+		if !errors.Is(err, tt.target) {
+			t.Errorf("%s to %s: error %v, expected one wrapping %v", tt.in, tt.out, err, tt.target)
+		}
+		var de *DecodeError
+		var ee *EncodeError
+		switch {
+		case tt.out != out:
+			if !errors.As(err, &ee) || ee.Path != tt.out {
+				t.Errorf("%s to %s: error %#v, expected an *EncodeError of the output", tt.in, tt.out, err)
+			}
+		case !errors.As(err, &de) || de.Path != tt.in || de.Format != tt.format:
+			t.Errorf("%s: error %#v, expected a *DecodeError of the %q input", tt.in, err, tt.format)
+		}
+		if tt.message != "" && err.Error() != tt.message {
+			t.Errorf("%s: message %q, expected %q", tt.in, err, tt.message)
+		}
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Errorf("%s: output left: %v", tt.in, err)
+		}
+	}
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	err := DefaultOptions(WithScale(0), WithThreads(-1)).Validate()
+	if want := "invalid options: invalid scale 0, must be positive; invalid thread count -1, must not be negative"; err == nil || err.Error() != want {
+		t.Errorf("message %q, expected %q", err, want)
+	}
+}
+
+func TestEncodeErrorMessage(t *testing.T) {
+	for _, tt := range []struct {
+		err  *EncodeError
+		want string
+	}{
+		{&EncodeError{Err: errors.New("disk full")}, "encoding image: disk full"},
+		{&EncodeError{Path: "out.png", Err: errors.New("disk full")}, `writing image "out.png": disk full`},
+	} {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("message %q, expected %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package dither
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// Extensions lists the file extensions of the supported input formats.
+var Extensions = []string{".png", ".jpg", ".jpeg", ".gif", ".tif", ".tiff", ".webp", ".heic", ".heif", ".avif"}
+
+// FormatOf returns the format of the image at path from its extension, or an
+// empty string if it is not supported.
+func FormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		return "png"
+	case ".jpg", ".jpeg":
+		return "jpeg"
+	case ".gif":
+		return "gif"
+	case ".tif", ".tiff":
+		return "tiff"
+	case ".webp":
+		return "webp"
+	case ".heic", ".heif":
+		return "heic"
+	case ".avif":
+		return "avif"
+	}
+	return ""
+}
+
+// SniffLen is the number of leading bytes SniffFormat needs.
+const SniffLen = 12
+
+// SniffFormat returns the format of the image encoded in data from its magic
+// bytes, or an empty string if it is not supported. Only the first few bytes
+// of the image are needed.
+func SniffFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("\xff\xd8")):
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return "tiff"
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp"
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		return heifBrands[string(data[8:12])]
+	}
+	return ""
+}
+
+// Decode decodes an image of the given format from r, the first frame of an
+// animated GIF, see DecodeAnimation for all of them. Errors are
+// *DecodeError values, wrapping ErrUnsupportedFormat for an unknown format.
+func Decode(r io.Reader, format string) (image.Image, error) {
+	var (
+		img image.Image
+		err error
+	)
+	switch format {
+	case "png":
+		img, err = png.Decode(r)
+	case "jpeg":
+		img, err = jpeg.Decode(r)
+	case "gif":
+		img, err = gif.Decode(r)
+	case "tiff":
+		img, err = tiff.Decode(r)
+	case "webp":
+		img, err = webp.Decode(r)
+	case "heic", "avif":
+		var d optionalDecoder
+		if d, err = lookupOptional(format); err == nil {
+			img, _, err = d.decode(r)
+		}
+	default:
+		return nil, &DecodeError{Err: fmt.Errorf("%w %q", ErrUnsupportedFormat, format)}
+	}
+	if err != nil {
+		return nil, &DecodeError{Format: format, Err: err}
+	}
+	return img, nil
+}
+
+// DecodeLimit is like Decode, but first reads the dimensions of the image
+// from its header and fails with an error wrapping ErrTooLarge, without
+// decoding the pixels, if it has more than maxPixels pixels. There is no limit
+// if maxPixels is not positive.
+func DecodeLimit(r io.Reader, format string, maxPixels int64) (image.Image, error) {
+	return decodeLimit(r, format, maxPixels, func(r io.Reader) (image.Image, error) {
+		return Decode(r, format)
+	})
+}
+
+// decodeLimit is DecodeLimit decoding the image with decode.
+func decodeLimit(r io.Reader, format string, maxPixels int64, decode func(r io.Reader) (image.Image, error)) (image.Image, error) {
+	if maxPixels <= 0 {
+		return decode(r)
+	}
+	var (
+		head bytes.Buffer
+		cfg  image.Config
+		err  error
+	)
+	tee := io.TeeReader(r, &head)
+	switch format {
+	case "png":
+		cfg, err = png.DecodeConfig(tee)
+	case "jpeg":
+		cfg, err = jpeg.DecodeConfig(tee)
+	case "gif":
+		cfg, err = gif.DecodeConfig(tee)
+	case "tiff":
+		cfg, err = tiff.DecodeConfig(tee)
+	case "webp":
+		cfg, err = webp.DecodeConfig(tee)
+	case "heic", "avif":
+		var d optionalDecoder
+		if d, err = lookupOptional(format); err == nil {
+			cfg, err = d.decodeConfig(tee)
+		}
+	default:
+		return nil, &DecodeError{Err: fmt.Errorf("%w %q", ErrUnsupportedFormat, format)}
+	}
+	if err != nil {
+		return nil, &DecodeError{Format: format, Err: err}
+	}
+	if n := int64(cfg.Width) * int64(cfg.Height); n > maxPixels {
+		return nil, &DecodeError{Format: format, Err: fmt.Errorf("%w: %dx%d is %d pixels, over the limit of %d",
+			ErrTooLarge, cfg.Width, cfg.Height, n, maxPixels)}
+	}
+	return decode(io.MultiReader(&head, r))
+}
+
+// DecodeOptions are the settings of DecodeWith.
+type DecodeOptions struct {
+	// MaxPixels is the largest number of pixels of the images decoded, see
+	// DecodeLimit.
+	MaxPixels int64
+	// Shrink is the factor by which the images are shrunk as they are
+	// decoded when their format supports it, see ShrinkFactor. They are not
+	// shrunk when it is below 2.
+	Shrink int
+	// AssumeSRGB makes the colors of the images be taken as sRGB ones
+	// without looking for an embedded color profile.
+	AssumeSRGB bool
+	// Page is the page decoded, from 0, of the images of a format with
+	// several pages, see Pages.
+	Page int
+	// IgnoreOrientation leaves the JPEG and PNG images as they are encoded
+	// instead of turning them according to their EXIF orientation, see
+	// Orient.
+	IgnoreOrientation bool
+}
+
+// DecodeWith decodes an image of the given format from r like DecodeLimit,
+// shrinking it as it is decoded if its format supports it, in which case the
+// image is Shrunk. Unless opts.AssumeSRGB, the colors of an image with an
+// embedded ICC profile of a known color space other than sRGB are then
+// converted to sRGB. It returns the profile, nil when the image has none or
+// with opts.AssumeSRGB; the colors of an image of an unknown color space are
+// left as they are. Unless opts.IgnoreOrientation, the image is then turned
+// according to its EXIF orientation. The pages other than the first are read
+// in memory before they are decoded.
+func DecodeWith(r io.Reader, format string, opts DecodeOptions) (image.Image, *Profile, error) {
+	img, md, err := DecodeWithMetadata(r, format, opts)
+	return img, md.Profile, err
+}
+
+// DecodeWithMetadata is like DecodeWith, but returns all the metadata of the
+// image read from the header of a JPEG or PNG one.
+func DecodeWithMetadata(r io.Reader, format string, opts DecodeOptions) (image.Image, Metadata, error) {
+	if opts.Page > 0 {
+		p, err := pageReader(r, format, opts.Page)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		r = p
+	}
+	decode := func(r io.Reader) (image.Image, error) {
+		return Decode(r, format)
+	}
+	// The decoders of the formats whose profile isn't recorded from the
+	// encoded image return it themselves.
+	var decoded *Profile
+	if d, ok := optionalDecoders[format]; ok {
+		decode = func(r io.Reader) (image.Image, error) {
+			img, p, err := d.decode(r)
+			decoded = p
+			if err != nil {
+				return nil, &DecodeError{Format: format, Err: err}
+			}
+			return img, nil
+		}
+	}
+	if shrink, ok := shrinkDecoders[format]; ok && opts.Shrink >= 2 {
+		decode = func(r io.Reader) (image.Image, error) {
+			return shrink(r, opts.Shrink)
+		}
+	}
+
+	rec := &headerRecorder{format: format}
+	img, err := decodeLimit(io.TeeReader(r, rec), format, opts.MaxPixels, decode)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	var md Metadata
+	if format == "jpeg" || format == "png" {
+		info := ImageInfo{Format: format}
+		if info.readHeader(rec) == nil {
+			md.DPIX, md.DPIY, md.Orientation = info.DPIX, info.DPIY, info.Orientation
+		}
+	}
+	if !opts.AssumeSRGB {
+		if c := md.readProfile(rec, decoded); c != nil {
+			img = c.convert(img)
+		}
+	}
+	if !opts.IgnoreOrientation {
+		if o := Orient(img, md.Orientation); o != img {
+			Release(img)
+			img = o
+		}
+	}
+	return img, md, nil
+}
+
+// readProfile sets the profile of md from the one recorded by rec, or else
+// decoded, and returns the conversion of the colors of the image to sRGB from
+// a profile of another known color space, nil otherwise.
+func (md *Metadata) readProfile(rec *headerRecorder, decoded *Profile) *conversion {
+	var p Profile
+	switch data, srgb := rec.embedded(); {
+	case data != nil:
+		p = parseProfile(data)
+		if rgbProfile(data) && conversionFrom(p.Space) == nil {
+			md.ICC = data
+		}
+	case decoded != nil:
+		p = *decoded
+	case srgb:
+		md.Profile = &Profile{Space: SpaceSRGB}
+		return nil
+	default:
+		return nil
+	}
+	md.Profile = &p
+	return conversionFrom(p.Space)
+}
+
+// DecodeBytes decodes an image of the given format from data.
+func DecodeBytes(data []byte, format string) (image.Image, error) {
+	return Decode(bytes.NewReader(data), format)
+}
+
+// EncodePNG encodes img, which needs not be paletted, as PNG.
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := pngEncoder.Encode(&buf, img); err != nil {
+		return nil, &EncodeError{Err: err}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,35 @@
+package dither
+
+import "testing"
+
+func TestSniffFormat(t *testing.T) {
+	for _, tt := range []struct {
+		data, want string
+	}{
+		{"\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "png"},
+		{"\xff\xd8\xff\xe0\x00\x10JFIF", "jpeg"},
+		{"GIF87a\x08\x00", "gif"},
+		{"GIF89a\x08\x00", "gif"},
+		{"II*\x00\x08\x00\x00\x00", "tiff"},
+		{"MM\x00*\x00\x00\x00\x08", "tiff"},
+		{"RIFF\x24\x00\x00\x00WEBPVP8 ", "webp"},
+		{"\x00\x00\x00\x18ftypheic\x00\x00\x00\x00", "heic"},
+		{"\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00", "heic"},
+		{"\x00\x00\x00\x1cftypavif\x00\x00\x00\x00", "avif"},
+		// An MP4 video and a WAV sound, of the same structure as the images.
+		{"\x00\x00\x00\x18ftypisom\x00\x00\x02\x00", ""},
+		{"RIFF\x24\x00\x00\x00WAVEfmt ", ""},
+		// Truncated magic bytes.
+		{"\x89PNG\r\n", ""},
+		{"GIF8", ""},
+		{"RIFF\x24\x00\x00\x00WEB", ""},
+		{"\xff", ""},
+		{"", ""},
+		{"P6\n8 4\n255\n", ""},
+		{"<svg xmlns=", ""},
+	} {
+		if got := SniffFormat([]byte(tt.data)); got != tt.want {
+			t.Errorf("%q: format %q, expected %q", tt.data, got, tt.want)
+		}
+	}
+}
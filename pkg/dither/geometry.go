@@ -0,0 +1,130 @@
+package dither
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Geometry holds the geometric transforms of the images: the crop, the
+// rotation and the flips of the source before it is scaled, and the padding
+// of the scaled and adjusted image before it is reduced to the palette.
+type Geometry struct {
+	// Crop, when not empty, is the part of the source kept, in the pixels
+	// of the source turned according to its EXIF orientation.
+	Crop image.Rectangle
+	// Rotate is the clockwise rotation in degrees of the cropped source, 0,
+	// 90, 180 or 270.
+	Rotate int
+	// FlipH and FlipV mirror the rotated source horizontally and
+	// vertically.
+	FlipH, FlipV bool
+	// PadWidth and PadHeight, when set, are the dimensions of the result:
+	// the image is centered on PadColor, white when nil, and cropped to
+	// them if it is larger.
+	PadWidth, PadHeight int
+	PadColor            color.Color
+}
+
+// none reports whether g leaves the images as they are.
+func (g Geometry) none() bool {
+	return g.Crop.Empty() && !g.reorients() && !g.pads()
+}
+
+// reorients reports whether g rotates or flips the images.
+func (g Geometry) reorients() bool {
+	return g.Rotate%360 != 0 || g.FlipH || g.FlipV
+}
+
+// pads reports whether g pads the images.
+func (g Geometry) pads() bool {
+	return g.PadWidth > 0 || g.PadHeight > 0
+}
+
+// problems returns the descriptions of the invalid settings of g.
+func (g Geometry) problems() []string {
+	var problems []string
+	if c := g.Crop; !c.Empty() && (c.Min.X < 0 || c.Min.Y < 0) {
+		problems = append(problems, fmt.Sprintf("invalid crop at %d,%d, must not be negative", c.Min.X, c.Min.Y))
+	}
+	switch g.Rotate {
+	case 0, 90, 180, 270:
+	default:
+		problems = append(problems, fmt.Sprintf("invalid rotation %d, must be 0, 90, 180 or 270", g.Rotate))
+	}
+	if g.pads() && (g.PadWidth < 1 || g.PadHeight < 1) {
+		problems = append(problems, fmt.Sprintf("invalid padding to %dx%d, needs both a width and a height", g.PadWidth, g.PadHeight))
+	}
+	return problems
+}
+
+// orientation returns the EXIF orientation turning the images like the
+// rotation and then the flips of g, see Orient.
+func (g Geometry) orientation() int {
+	rotate := g.Rotate
+	if g.FlipH && g.FlipV {
+		// Flipping both ways is rotating by 180°.
+		rotate += 180
+	}
+	rotate %= 360
+	if g.FlipH == g.FlipV {
+		return rotation(rotate)
+	}
+	// The orientations flipping horizontally, then vertically, after each
+	// rotation.
+	flips := map[int][2]int{0: {2, 4}, 90: {5, 7}, 180: {4, 2}, 270: {7, 5}}[rotate]
+	if g.FlipV {
+		return flips[1]
+	}
+	return flips[0]
+}
+
+// bounds returns the bounds of the source of bounds r once cropped,
+// rotated and flipped according to g.
+func (g Geometry) bounds(r image.Rectangle) image.Rectangle {
+	if !g.Crop.Empty() {
+		r = g.Crop
+	}
+	if g.Rotate == 90 || g.Rotate == 270 {
+		return image.Rect(0, 0, r.Dy(), r.Dx())
+	}
+	return r.Sub(r.Min)
+}
+
+// reframe returns img cropped, rotated and flipped according to g, img
+// itself when g does neither.
+func reframe(img image.Image, g Geometry) (image.Image, error) {
+	b := SourceBounds(img)
+	r := b
+	if !g.Crop.Empty() {
+		if !g.Crop.In(b) {
+			return nil, fmt.Errorf("dither: crop of %dx%d pixels at %d,%d out of the image of %dx%d pixels",
+				g.Crop.Dx(), g.Crop.Dy(), g.Crop.Min.X, g.Crop.Min.Y, b.Dx(), b.Dy())
+		}
+		r = g.Crop
+	}
+	if r == b && !g.reorients() {
+		return img, nil
+	}
+	return orientRect(img, r, g.orientation()), nil
+}
+
+// pad returns img centered on the padding color of g, and cropped, to the
+// padding size of g, img itself when it has that size.
+func pad(img image.Image, g Geometry) image.Image {
+	r := image.Rect(0, 0, g.PadWidth, g.PadHeight)
+	b := img.Bounds()
+	if b.Size() == r.Size() {
+		return img
+	}
+	c := g.PadColor
+	if c == nil {
+		c = color.White
+	}
+	dst := &image.RGBA{Pix: getPix(4 * r.Dx() * r.Dy()), Stride: 4 * r.Dx(), Rect: r}
+	draw.Draw(dst, r, image.NewUniform(c), image.Point{}, draw.Src)
+	offset := image.Pt((r.Dx()-b.Dx())/2, (r.Dy()-b.Dy())/2)
+	draw.Draw(dst, b.Sub(b.Min).Add(offset), img, b.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,75 @@
+package dither
+
+import (
+	"fmt"
+	"image"
+	"testing"
+)
+
+// TestGeometryOrientation checks that the single orientation of each
+// rotation and flips turns the images like the rotation followed by the
+// horizontal and then the vertical flip.
+func TestGeometryOrientation(t *testing.T) {
+	for _, rotate := range []int{0, 90, 180, 270} {
+		for _, flips := range [][2]bool{{false, false}, {true, false}, {false, true}, {true, true}} {
+			g := Geometry{Rotate: rotate, FlipH: flips[0], FlipV: flips[1]}
+			src := markedCorners(image.Pt(2, 3), false)
+			want := Orient(src, rotation(rotate))
+			if g.FlipH {
+				want = Orient(want, 2)
+			}
+			if g.FlipV {
+				want = Orient(want, 4)
+			}
+			got, err := reframe(src, g)
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := fmt.Sprintf("rotation %d, flips %v", rotate, flips)
+			if got.Bounds().Size() != want.Bounds().Size() {
+				t.Errorf("%s: size %v, expected %v", name, got.Bounds().Size(), want.Bounds().Size())
+				continue
+			}
+			gb, wb := got.Bounds(), want.Bounds()
+			for y := 0; y < gb.Dy(); y++ {
+				for x := 0; x < gb.Dx(); x++ {
+					if g, w := got.At(gb.Min.X+x, gb.Min.Y+y), want.At(wb.Min.X+x, wb.Min.Y+y); g != w {
+						t.Errorf("%s: pixel %d,%d of %v, expected %v", name, x, y, g, w)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestReframes checks that the pipelines crop, rotate or flip the images
+// exactly when Reframes, and pad them exactly when Pads.
+func TestReframes(t *testing.T) {
+	for _, tt := range []struct {
+		g              Geometry
+		reframes, pads bool
+	}{
+		{Geometry{}, false, false},
+		{Geometry{Crop: image.Rect(1, 1, 1, 5)}, false, false},
+		{Geometry{Crop: image.Rect(0, 0, 2, 2)}, true, false},
+		{Geometry{Rotate: 90}, true, false},
+		{Geometry{FlipV: true}, true, false},
+		{Geometry{Rotate: 180, FlipH: true, FlipV: true}, true, false},
+		{Geometry{PadWidth: 4, PadHeight: 3}, false, true},
+		{Geometry{Rotate: 270, PadWidth: 4, PadHeight: 3}, true, true},
+	} {
+		opts := DefaultOptions(WithGeometry(tt.g))
+		p, err := NewPipeline(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		staged := map[string]bool{}
+		for _, s := range p.Stages {
+			staged[s.Name()] = true
+		}
+		if opts.Reframes() != tt.reframes || opts.Pads() != tt.pads || staged["geometry"] != tt.reframes || staged["pad"] != tt.pads {
+			t.Errorf("%+v: Reframes %v and Pads %v, stages %v, expected %v and %v",
+				tt.g, opts.Reframes(), opts.Pads(), p.Stages, tt.reframes, tt.pads)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package dither
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"image"
+	"image/color"
+	"io"
+)
+
+// PackedBits returns the number of bits per pixel used to pack the indices of
+// an image of palette p: 1, 2, 4 or 8.
+func PackedBits(p color.Palette) int {
+	bits := 1
+	for 1<<uint(bits) < len(p) {
+		bits *= 2
+	}
+	return bits
+}
+
+// Pack packs the palette indices of the pixels of img row by row, PackedBits
+// per pixel, the first pixel in the most significant bits of a byte. Each row
+// starts on a new byte.
+func Pack(img *image.Paletted) []byte {
+	return PackRows(img, 1)
+}
+
+// PackRows packs img like Pack, padding each row with zeros to a multiple of
+// align bytes, as expected by some display controllers and printers.
+func PackRows(img *image.Paletted, align int) []byte {
+	if align < 1 {
+		align = 1
+	}
+	b := img.Bounds()
+	bits := PackedBits(img.Palette)
+	stride := (b.Dx()*bits + 7) / 8
+	stride = (stride + align - 1) / align * align
+	data := make([]byte, stride*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := data[(y-b.Min.Y)*stride:]
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := (x - b.Min.X) * bits
+			row[i/8] |= img.ColorIndexAt(x, y) << uint(8-bits-i%8)
+		}
+	}
+	return data
+}
+
+// EncodeGo writes to w a gofmt-formatted Go source file of package pkg
+// embedding img: the constants <name>Width and <name>Height, the variables
+// <name>Palette and <name>Pixels, holding the pixels packed as by Pack, and
+// the function <name>Image reconstructing img. The generated code only
+// depends on the image and image/color packages.
+func EncodeGo(w io.Writer, img *image.Paletted, pkg, name string) error {
+	for _, id := range []string{pkg, name} {
+		if !token.IsIdentifier(id) {
+			return &EncodeError{Err: fmt.Errorf("invalid Go identifier %q", id)}
+		}
+	}
+	if len(img.Palette) > 256 {
+		return &EncodeError{Err: fmt.Errorf("palette of %d colors is too large", len(img.Palette))}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by fls; DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	b.WriteString("import (\n\"image\"\n\"image/color\"\n)\n\n")
+	fmt.Fprintf(&b, "// Dimensions of the %s image.\nconst (\n%[1]sWidth = %[2]d\n%[1]sHeight = %[3]d\n)\n\n",
+		name, img.Bounds().Dx(), img.Bounds().Dy())
+
+	fmt.Fprintf(&b, "// %sPalette is the palette of the %[1]s image.\nvar %[1]sPalette = color.Palette{\n", name)
+	for _, c := range img.Palette {
+		rgba := color.RGBAModel.Convert(c).(color.RGBA)
+		fmt.Fprintf(&b, "color.RGBA{%#02x, %#02x, %#02x, %#02x},\n", rgba.R, rgba.G, rgba.B, rgba.A)
+	}
+	b.WriteString("}\n\n")
+
+	bits := PackedBits(img.Palette)
+	fmt.Fprintf(&b, "// %sPixels holds the palette indices of the pixels of the %[1]s image, row by\n", name)
+	fmt.Fprintf(&b, "// row, packed %d bit(s) per pixel with the first pixel in the most\n", bits)
+	b.WriteString("// significant bits of a byte. Each row starts on a new byte.\n")
+	fmt.Fprintf(&b, "var %sPixels = []byte{", name)
+	for i, v := range Pack(img) {
+		if i%16 == 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%#02x, ", v)
+	}
+	b.WriteString("\n}\n\n")
+
+	fmt.Fprintf(&b, `// %[1]sImage returns a new paletted image holding the %[1]s image.
+func %[1]sImage() *image.Paletted {
+	const bits = %[2]d
+	img := image.NewPaletted(image.Rect(0, 0, %[1]sWidth, %[1]sHeight), %[1]sPalette)
+	stride := (%[1]sWidth*bits + 7) / 8
+	for y := 0; y < %[1]sHeight; y++ {
+		row := %[1]sPixels[y*stride:]
+		for x := 0; x < %[1]sWidth; x++ {
+			i := x * bits
+			img.Pix[y*img.Stride+x] = row[i/8] >> uint(8-bits-i%%8) & (1<<bits - 1)
+		}
+	}
+	return img
+}
+`, name, bits)
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return &EncodeError{Err: fmt.Errorf("formatting Go source: %w", err)}
+	}
+	if _, err := w.Write(src); err != nil {
+		return &EncodeError{Err: err}
+	}
+	return nil
+}
+
+// EncodeGoFramebuffer writes to w a gofmt-formatted Go source file of package
+// pkg embedding the framebuffer of the device d showing img, see
+// Device.Framebuffer: the constants <name>Width and <name>Height of the
+// framebuffer and the variable <name>Framebuffer holding its bytes. The
+// generated code has no dependencies.
+func EncodeGoFramebuffer(w io.Writer, img *image.Paletted, pkg, name string, d Device) error {
+	for _, id := range []string{pkg, name} {
+		if !token.IsIdentifier(id) {
+			return &EncodeError{Err: fmt.Errorf("invalid Go identifier %q", id)}
+		}
+	}
+	data, err := d.Framebuffer(img)
+	if err != nil {
+		return &EncodeError{Err: err}
+	}
+
+	var b bytes.Buffer
+	width, height := d.FramebufferSize()
+	fmt.Fprintf(&b, "// Code generated by fls; DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	fmt.Fprintf(&b, "// Dimensions of the framebuffer of the %s image.\nconst (\n%[1]sWidth = %[2]d\n%[1]sHeight = %[3]d\n)\n\n",
+		name, width, height)
+	fmt.Fprintf(&b, "// %sFramebuffer is the framebuffer of the %s panel showing the %[1]s image,\n", name, d.Name)
+	fmt.Fprintf(&b, "// in %d plane(s) of %d bit(s) per pixel.\n", len(d.Planes), d.Bits)
+	fmt.Fprintf(&b, "var %sFramebuffer = []byte{", name)
+	for i, v := range data {
+		if i%16 == 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%#02x, ", v)
+	}
+	b.WriteString("\n}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return &EncodeError{Err: fmt.Errorf("formatting Go source: %w", err)}
+	}
+	if _, err := w.Write(src); err != nil {
+		return &EncodeError{Err: err}
+	}
+	return nil
+}
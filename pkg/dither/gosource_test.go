@@ -0,0 +1,147 @@
+package dither
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"image"
+	"image/color/palette"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPack(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, 3, 2), BlackAndWhite)
+	copy(img.Pix, []uint8{1, 0, 1, 0, 1, 1})
+	if got, want := Pack(img), []byte{0xa0, 0x60}; !bytes.Equal(got, want) {
+		t.Errorf("Pack = %#x, expected %#x", got, want)
+	}
+	if got, want := PackRows(img, 4), []byte{0xa0, 0, 0, 0, 0x60, 0, 0, 0}; !bytes.Equal(got, want) {
+		t.Errorf("PackRows = %#x, expected %#x", got, want)
+	}
+	img.Palette = Grays(16)
+	copy(img.Pix, []uint8{15, 0, 9, 1, 2, 3})
+	if got, want := Pack(img), []byte{0xf0, 0x90, 0x12, 0x30}; !bytes.Equal(got, want) {
+		t.Errorf("Pack of 4 bits = %#x, expected %#x", got, want)
+	}
+}
+
+// testPaletted returns a w x h image of the palette of n colors whose
+// pixels take all its indices.
+func testPaletted(w, h, n int) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, w, h), palette.Plan9[:n])
+	for i := range img.Pix {
+		img.Pix[i] = uint8((i*7 + i/w) % n)
+	}
+	return img
+}
+
+// checkGoSource checks that src is a gofmt-formatted Go source file of
+// package pkg which type-checks, and returns its package.
+func checkGoSource(t *testing.T, src []byte, pkg string) *types.Package {
+	t.Helper()
+	if formatted, err := format.Source(src); err != nil || !bytes.Equal(formatted, src) {
+		t.Fatalf("source not formatted by gofmt (%v):\n%s", err, src)
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "image.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	p, err := conf.Check(pkg, fset, []*ast.File{f}, nil)
+	if err != nil {
+		t.Fatalf("type-checking:\n%s\n%v", src, err)
+	}
+	return p
+}
+
+// TestEncodeGo checks that the Go source files of images of every packing
+// type-check and declare the documented identifiers.
+func TestEncodeGo(t *testing.T) {
+	for _, n := range []int{2, 4, 16, 200} {
+		var b bytes.Buffer
+		if err := EncodeGo(&b, testPaletted(13, 5, n), "sprites", "Ship"); err != nil {
+			t.Fatal(err)
+		}
+		p := checkGoSource(t, b.Bytes(), "sprites")
+		for _, id := range []string{"ShipWidth", "ShipHeight", "ShipPalette", "ShipPixels", "ShipImage"} {
+			if p.Scope().Lookup(id) == nil {
+				t.Errorf("%d colors: %s not declared", n, id)
+			}
+		}
+	}
+	d, _ := LookupDevice("waveshare-2.13b")
+	var b bytes.Buffer
+	if err := EncodeGoFramebuffer(&b, d.Frame(testPaletted(30, 20, 3)), "display", "logo", d); err != nil {
+		t.Fatal(err)
+	}
+	p := checkGoSource(t, b.Bytes(), "display")
+	for _, id := range []string{"logoWidth", "logoHeight", "logoFramebuffer"} {
+		if p.Scope().Lookup(id) == nil {
+			t.Errorf("%s not declared", id)
+		}
+	}
+
+	for _, id := range [][2]string{{"main-pkg", "img"}, {"sprites", "2d"}} {
+		if err := EncodeGo(&b, testPaletted(2, 2, 2), id[0], id[1]); err == nil {
+			t.Errorf("package %q and name %q accepted", id[0], id[1])
+		}
+	}
+}
+
+// TestEncodeGoImage builds and runs a program printing the pixels of the
+// images reconstructed by the generated code, which must be those encoded.
+func TestEncodeGoImage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a program")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go command")
+	}
+	dir, err := ioutil.TempDir("", "gosource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{"go.mod": "module sprites\n\ngo 1.16\n"}
+	main := "package main\n\nimport \"fmt\"\n\nfunc main() {\n"
+	var want strings.Builder
+	for _, n := range []int{2, 4, 16, 200} {
+		img := testPaletted(13, 5, n)
+		var b bytes.Buffer
+		name := fmt.Sprintf("Image%d", n)
+		if err := EncodeGo(&b, img, "main", name); err != nil {
+			t.Fatal(err)
+		}
+		files[strings.ToLower(name)+".go"] = b.String()
+		main += fmt.Sprintf("\timg%d := %sImage()\n\tfmt.Println(img%[1]d.Rect, img%[1]d.Pix, len(img%[1]d.Palette))\n", n, name)
+		fmt.Fprintln(&want, img.Rect, img.Pix, len(img.Palette))
+	}
+	files["main.go"] = main + "}\n"
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(goTool, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GO111MODULE=on")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run: %v\n%s", err, out)
+	}
+	if string(out) != want.String() {
+		t.Errorf("pixels of the generated images:\n%s\nexpected:\n%s", out, want.String())
+	}
+}
@@ -0,0 +1,70 @@
+package dither
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"sort"
+	"strings"
+)
+
+// heifBrands maps the major brands of the ftyp box starting the HEIF
+// containers to the format of their images.
+var heifBrands = map[string]string{
+	"heic": "heic", "heix": "heic", "heim": "heic", "heis": "heic",
+	"hevc": "heic", "hevx": "heic", "mif1": "heic", "msf1": "heic",
+	"avif": "avif", "avis": "avif",
+}
+
+// An optionalDecoder decodes the images of a format with a decoder compiled
+// in with a build tag. It returns the color profile of the image, or nil
+// when it has none.
+type optionalDecoder struct {
+	decode       func(r io.Reader) (image.Image, *Profile, error)
+	decodeConfig func(r io.Reader) (image.Config, error)
+	library      string // the library decoding the images and its version
+}
+
+// optionalDecoders are the decoders of the HEIC and AVIF images, registered
+// by the files of the build tags in optionalTags.
+var optionalDecoders = map[string]optionalDecoder{}
+
+// optionalTags are the build tags compiling in the decoder of the optional
+// formats.
+var optionalTags = map[string]string{
+	"heic": "libheif",
+	"avif": "libheif",
+}
+
+// lookupOptional returns the decoder of the optional format, failing with an
+// error wrapping ErrUnsupportedFormat when it isn't compiled in.
+func lookupOptional(format string) (optionalDecoder, error) {
+	d, ok := optionalDecoders[format]
+	if !ok {
+		return d, fmt.Errorf("%w: built without %s support, see the %s build tag",
+			ErrUnsupportedFormat, strings.ToUpper(format), optionalTags[format])
+	}
+	return d, nil
+}
+
+// An OptionalDecoder describes the decoder of an input format compiled in
+// only with a build tag.
+type OptionalDecoder struct {
+	Format string
+	Tag    string // the build tag compiling the decoder in
+	// Library is the library decoding the images and its version, empty
+	// when the decoder isn't compiled in.
+	Library string
+}
+
+// OptionalDecoders returns the decoders of the input formats compiled in only
+// with a build tag, sorted by format, with the library of the ones the binary
+// has.
+func OptionalDecoders() []OptionalDecoder {
+	var ds []OptionalDecoder
+	for format, tag := range optionalTags {
+		ds = append(ds, OptionalDecoder{Format: format, Tag: tag, Library: optionalDecoders[format].library})
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i].Format < ds[j].Format })
+	return ds
+}
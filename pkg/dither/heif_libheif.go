@@ -0,0 +1,170 @@
+//go:build libheif
+// +build libheif
+
+package dither
+
+/*
+#cgo pkg-config: libheif
+#include <libheif/heif.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"unsafe"
+)
+
+func init() {
+	C.heif_init(nil)
+	d := optionalDecoder{
+		decode:       decodeHEIF,
+		decodeConfig: decodeHEIFConfig,
+		library:      "libheif " + C.GoString(C.heif_get_version()),
+	}
+	// libheif reads both, with the AV1 decoder it is built with for AVIF.
+	optionalDecoders["heic"] = d
+	optionalDecoders["avif"] = d
+}
+
+func heifError(err C.struct_heif_error) error {
+	if err.code == C.heif_error_Ok {
+		return nil
+	}
+	return errors.New(C.GoString(err.message))
+}
+
+// heifImage holds the HEIF container read by libheif and its primary image,
+// to be freed by close.
+type heifImage struct {
+	ctx    *C.struct_heif_context
+	handle *C.struct_heif_image_handle
+}
+
+func openHEIF(r io.Reader) (*heifImage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	h := &heifImage{ctx: C.heif_context_alloc()}
+	// The data is copied by libheif.
+	if err := heifError(C.heif_context_read_from_memory(h.ctx, unsafe.Pointer(&data[0]), C.size_t(len(data)), nil)); err != nil {
+		h.close()
+		return nil, err
+	}
+	if err := heifError(C.heif_context_get_primary_image_handle(h.ctx, &h.handle)); err != nil {
+		h.close()
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *heifImage) close() {
+	if h.handle != nil {
+		C.heif_image_handle_release(h.handle)
+	}
+	C.heif_context_free(h.ctx)
+}
+
+// The nclx color primaries and transfer characteristics of sRGB and Display
+// P3, from ITU-T H.273.
+const (
+	nclxPrimariesBT709 = 1
+	nclxPrimariesP3D65 = 12
+	nclxTransferBT709  = 1
+	nclxTransferUnset  = 2
+	nclxTransferSRGB   = 13
+)
+
+// profile returns the color profile of the primary image: its ICC profile, or
+// the color space of its nclx profile, nil if it has none.
+func (h *heifImage) profile() *Profile {
+	switch C.heif_image_handle_get_color_profile_type(h.handle) {
+	case C.heif_color_profile_type_prof, C.heif_color_profile_type_rICC:
+		n := C.heif_image_handle_get_raw_color_profile_size(h.handle)
+		if n == 0 || n > maxProfileSize {
+			return nil
+		}
+		data := make([]byte, n)
+		if heifError(C.heif_image_handle_get_raw_color_profile(h.handle, unsafe.Pointer(&data[0]))) != nil {
+			return nil
+		}
+		p := parseProfile(data)
+		return &p
+	case C.heif_color_profile_type_nclx:
+		var nclx *C.struct_heif_color_profile_nclx
+		if heifError(C.heif_image_handle_get_nclx_color_profile(h.handle, &nclx)) != nil {
+			return nil
+		}
+		defer C.heif_nclx_color_profile_free(nclx)
+		primaries, transfer := int(nclx.color_primaries), int(nclx.transfer_characteristics)
+		p := &Profile{Description: fmt.Sprintf("nclx primaries %d, transfer %d", primaries, transfer)}
+		switch transfer {
+		case nclxTransferSRGB, nclxTransferBT709, nclxTransferUnset:
+			// The BT.709 transfer is close enough to the sRGB one for
+			// the images of phones.
+			switch primaries {
+			case nclxPrimariesBT709:
+				p.Space = SpaceSRGB
+			case nclxPrimariesP3D65:
+				p.Space = SpaceDisplayP3
+			}
+		}
+		return p
+	}
+	return nil
+}
+
+// decodeHEIF decodes the primary image of a HEIF container to an NRGBA image.
+// libheif applies the rotation and mirroring of the container, which the
+// EXIF orientation of HEIF images only duplicates, so that the image is
+// upright.
+func decodeHEIF(r io.Reader) (image.Image, *Profile, error) {
+	h, err := openHEIF(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer h.close()
+
+	var img *C.struct_heif_image
+	if err := heifError(C.heif_decode_image(h.handle, &img, C.heif_colorspace_RGB, C.heif_chroma_interleaved_RGBA, nil)); err != nil {
+		return nil, nil, err
+	}
+	defer C.heif_image_release(img)
+	w := int(C.heif_image_get_width(img, C.heif_channel_interleaved))
+	ht := int(C.heif_image_get_height(img, C.heif_channel_interleaved))
+	var stride C.int
+	plane := C.heif_image_get_plane_readonly(img, C.heif_channel_interleaved, &stride)
+	if plane == nil || w <= 0 || ht <= 0 {
+		return nil, nil, errors.New("no interleaved RGBA plane")
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, ht))
+	n := int(stride) * ht
+	src := (*[1 << 30]byte)(unsafe.Pointer(plane))[:n:n]
+	for y := 0; y < ht; y++ {
+		copy(dst.Pix[y*dst.Stride:(y+1)*dst.Stride], src[y*int(stride):])
+	}
+	return dst, h.profile(), nil
+}
+
+// decodeHEIFConfig reads the dimensions of the primary image of a HEIF
+// container, once rotated, without decoding it.
+func decodeHEIFConfig(r io.Reader) (image.Config, error) {
+	h, err := openHEIF(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer h.close()
+	return image.Config{
+		ColorModel: color.NRGBAModel,
+		Width:      int(C.heif_image_handle_get_width(h.handle)),
+		Height:     int(C.heif_image_handle_get_height(h.handle)),
+	}, nil
+}
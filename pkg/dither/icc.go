@@ -0,0 +1,298 @@
+package dither
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf16"
+)
+
+// A Profile describes the ICC color profile embedded in an image.
+type Profile struct {
+	// Description is the description of the profile, empty if it has none.
+	Description string
+	// Space is the color space identified from the description or the
+	// colorants of the profile, empty when it is unknown.
+	Space string
+}
+
+// The color spaces of the profiles recognized by DecodeWith.
+const (
+	SpaceSRGB      = "sRGB"
+	SpaceAdobeRGB  = "Adobe RGB (1998)"
+	SpaceDisplayP3 = "Display P3"
+)
+
+// maxProfileHead is the size of the beginning of an image, before its pixels,
+// searched for an embedded profile. maxProfileSize is the largest profile
+// read.
+const (
+	maxProfileHead = 16 << 20
+	maxProfileSize = 4 << 20
+)
+
+// headerRecorder records the beginning of an encoded image written to it,
+// until the start of the pixels, to extract the ICC profile embedded in its
+// metadata, the APP2 segments of a JPEG image or the iCCP chunk of a PNG one,
+// and the header segments or chunks read by Inspect.
+type headerRecorder struct {
+	format string
+	head   []byte
+	pos    int  // the start of the next segment or chunk in head
+	done   bool // the pixels are reached, or the head is too large
+	srgb   bool // a PNG sRGB chunk is found
+
+	chunks  map[int][]byte // the JPEG ICC_PROFILE chunks, by sequence number
+	count   int            // the number of JPEG chunks
+	profile []byte         // the profile of a PNG image
+
+	frame   []byte // the JPEG SOF segment or the PNG IHDR chunk
+	density []byte // the JPEG JFIF segment or the PNG pHYs chunk
+	exif    []byte // the TIFF structure of the EXIF metadata
+	palette int    // the number of colors of the PNG PLTE chunk
+}
+
+func (r *headerRecorder) Write(p []byte) (int, error) {
+	if !r.done {
+		r.head = append(r.head, p...)
+		switch r.format {
+		case "jpeg":
+			r.scanJPEG()
+		case "png":
+			r.scanPNG()
+		default:
+			r.done = true
+		}
+		if len(r.head) > maxProfileHead {
+			r.done = true
+		}
+		if r.done {
+			r.head = nil
+		}
+	}
+	return len(p), nil
+}
+
+// scanJPEG reads the complete segments recorded since the last call.
+func (r *headerRecorder) scanJPEG() {
+	if r.pos == 0 {
+		if len(r.head) < 2 {
+			return
+		}
+		r.pos = 2 // SOI
+	}
+	for !r.done {
+		h := r.head[r.pos:]
+		if len(h) >= 2 && h[0] == 0xff && h[1] == 0xff {
+			r.pos++ // fill byte
+			continue
+		}
+		if len(h) < 4 {
+			return
+		}
+		if h[0] != 0xff || h[1] == 0xda { // not a marker, or the start of scan
+			r.done = true
+			return
+		}
+		n := 2 + int(binary.BigEndian.Uint16(h[2:4]))
+		if len(h) < n {
+			return
+		}
+		const sig = "ICC_PROFILE\x00"
+		switch data := h[4:n]; {
+		case h[1] == 0xe2 && len(data) > len(sig)+2 && string(data[:len(sig)]) == sig:
+			if r.chunks == nil {
+				r.chunks = make(map[int][]byte)
+			}
+			r.chunks[int(data[len(sig)])] = append([]byte(nil), data[len(sig)+2:]...)
+			r.count = int(data[len(sig)+1])
+		case h[1] == 0xe0 && bytes.HasPrefix(data, []byte("JFIF\x00")):
+			r.density = append([]byte(nil), data[5:]...)
+		case h[1] == 0xe1 && bytes.HasPrefix(data, []byte("Exif\x00\x00")):
+			r.exif = append([]byte(nil), data[6:]...)
+		case h[1] >= 0xc0 && h[1] <= 0xcf && h[1] != 0xc4 && h[1] != 0xc8 && h[1] != 0xcc: // SOFn
+			r.frame = append([]byte(nil), data...)
+		}
+		r.pos += n
+	}
+}
+
+// scanPNG reads the complete chunks recorded since the last call.
+func (r *headerRecorder) scanPNG() {
+	if r.pos == 0 {
+		if len(r.head) < 8 {
+			return
+		}
+		r.pos = 8 // signature
+	}
+	for !r.done {
+		h := r.head[r.pos:]
+		if len(h) < 8 {
+			return
+		}
+		n := int64(binary.BigEndian.Uint32(h[:4]))
+		switch string(h[4:8]) {
+		case "IDAT", "IEND":
+			r.done = true
+			return
+		}
+		if n > maxProfileHead {
+			r.done = true
+			return
+		}
+		if int64(len(h)) < 12+n {
+			return
+		}
+		data := h[8 : 8+n]
+		switch string(h[4:8]) {
+		case "sRGB":
+			r.srgb = true
+		case "IHDR":
+			r.frame = append([]byte(nil), data...)
+		case "PLTE":
+			r.palette = len(data) / 3
+		case "pHYs":
+			r.density = append([]byte(nil), data...)
+		case "eXIf":
+			r.exif = append([]byte(nil), data...)
+		case "iCCP":
+			// The profile name, a compression method byte, then the
+			// compressed profile.
+			if i := bytes.IndexByte(data, 0); i >= 0 && i+2 <= len(data) {
+				if zr, err := zlib.NewReader(bytes.NewReader(data[i+2:])); err == nil {
+					r.profile, _ = io.ReadAll(io.LimitReader(zr, maxProfileSize))
+				}
+			}
+		}
+		r.pos += 12 + int(n)
+	}
+}
+
+// embedded returns the ICC profile recorded, nil if there is none or it is
+// incomplete, and whether the image declares to be sRGB without one.
+func (r *headerRecorder) embedded() (profile []byte, srgb bool) {
+	if r.format == "png" {
+		return r.profile, r.srgb && r.profile == nil
+	}
+	if r.count == 0 || len(r.chunks) != r.count {
+		return nil, false
+	}
+	for i := 1; i <= r.count; i++ {
+		c, ok := r.chunks[i]
+		if !ok || len(profile)+len(c) > maxProfileSize {
+			return nil, false
+		}
+		profile = append(profile, c...)
+	}
+	return profile, false
+}
+
+// colorants holds the XYZ coordinates, adapted to D50, of the red, green and
+// blue primaries of a color space, the columns of its matrix to the PCS.
+type colorants [3][3]float64
+
+// spaceColorants are the colorants of the known color spaces, as written in
+// their common profiles.
+var spaceColorants = map[string]colorants{
+	SpaceSRGB:      {{0.4361, 0.2225, 0.0139}, {0.3851, 0.7169, 0.0971}, {0.1431, 0.0606, 0.7141}},
+	SpaceAdobeRGB:  {{0.6097, 0.3111, 0.0195}, {0.2053, 0.6257, 0.0609}, {0.1492, 0.0632, 0.7446}},
+	SpaceDisplayP3: {{0.5151, 0.2412, -0.0011}, {0.2920, 0.6922, 0.0419}, {0.1571, 0.0666, 0.7841}},
+}
+
+// colorantTolerance is the largest difference of the coordinates of the
+// colorants of a profile with the ones of a known space.
+const colorantTolerance = 0.003
+
+// parseProfile returns the description of an ICC profile and the known color
+// space identified from it. An RGB profile is identified by its description,
+// or failing that by its colorants.
+func parseProfile(data []byte) Profile {
+	var p Profile
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return p
+	}
+	tags := make(map[string][]byte)
+	n := int(binary.BigEndian.Uint32(data[128:132]))
+	for i := 0; i < n && 132+12*(i+1) <= len(data); i++ {
+		t := data[132+12*i:]
+		off, size := binary.BigEndian.Uint32(t[4:8]), binary.BigEndian.Uint32(t[8:12])
+		if uint64(off)+uint64(size) <= uint64(len(data)) {
+			tags[string(t[:4])] = data[off : off+size]
+		}
+	}
+	p.Description = profileText(tags["desc"])
+	if string(data[16:20]) != "RGB " {
+		return p
+	}
+
+	desc := strings.ToLower(p.Description)
+	switch {
+	case strings.Contains(desc, "srgb"):
+		p.Space = SpaceSRGB
+	case strings.Contains(desc, "adobe rgb"), strings.Contains(desc, "adobergb"):
+		p.Space = SpaceAdobeRGB
+	case strings.Contains(desc, "display p3"):
+		p.Space = SpaceDisplayP3
+	}
+	if p.Space != "" {
+		return p
+	}
+	var c colorants
+	for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		t := tags[sig]
+		if len(t) < 20 || string(t[:4]) != "XYZ " {
+			return p
+		}
+		for j := range c[i] {
+			c[i][j] = float64(int32(binary.BigEndian.Uint32(t[8+4*j:]))) / 65536
+		}
+	}
+search:
+	for space, known := range spaceColorants {
+		for i := range known {
+			for j := range known[i] {
+				if math.Abs(c[i][j]-known[i][j]) > colorantTolerance {
+					continue search
+				}
+			}
+		}
+		p.Space = space
+		break
+	}
+	return p
+}
+
+// profileText returns the text of a textDescriptionType tag of a version 2
+// profile, or the first one of a multiLocalizedUnicodeType tag of a version 4
+// profile.
+func profileText(t []byte) string {
+	if len(t) < 12 {
+		return ""
+	}
+	switch string(t[:4]) {
+	case "desc":
+		n := binary.BigEndian.Uint32(t[8:12])
+		if uint64(n) > uint64(len(t)-12) {
+			return ""
+		}
+		return strings.TrimRight(string(t[12:12+n]), "\x00")
+	case "mluc":
+		if len(t) < 28 || binary.BigEndian.Uint32(t[8:12]) == 0 {
+			return ""
+		}
+		n, off := binary.BigEndian.Uint32(t[20:24]), binary.BigEndian.Uint32(t[24:28])
+		if uint64(off)+uint64(n) > uint64(len(t)) {
+			return ""
+		}
+		s := t[off : off+n]
+		u := make([]uint16, len(s)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(s[2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+	}
+	return ""
+}
@@ -0,0 +1,231 @@
+package dither
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"testing"
+	"unicode/utf16"
+)
+
+// testProfile returns an ICC profile of the given color space, "RGB " or
+// "GRAY", with the description desc, written in a version 4 mluc tag if
+// mluc, and the colorants c when the space is RGB.
+func testProfile(space, desc string, mluc bool, c colorants) []byte {
+	type tag struct {
+		sig  string
+		data []byte
+	}
+	var text []byte
+	if mluc {
+		u := utf16.Encode([]rune(desc))
+		text = make([]byte, 28+2*len(u))
+		copy(text, "mluc")
+		binary.BigEndian.PutUint32(text[8:], 1)
+		binary.BigEndian.PutUint32(text[12:], 12)
+		copy(text[16:], "enUS")
+		binary.BigEndian.PutUint32(text[20:], uint32(2*len(u)))
+		binary.BigEndian.PutUint32(text[24:], 28)
+		for i, v := range u {
+			binary.BigEndian.PutUint16(text[28+2*i:], v)
+		}
+	} else {
+		text = make([]byte, 12+len(desc)+1)
+		copy(text, "desc")
+		binary.BigEndian.PutUint32(text[8:], uint32(len(desc)+1))
+		copy(text[12:], desc)
+	}
+	tags := []tag{{"desc", text}}
+	if space == "RGB " {
+		for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+			xyz := make([]byte, 20)
+			copy(xyz, "XYZ ")
+			for j, v := range c[i] {
+				binary.BigEndian.PutUint32(xyz[8+4*j:], uint32(int32(math.Round(v*65536))))
+			}
+			tags = append(tags, tag{sig, xyz})
+		}
+	}
+
+	data := make([]byte, 132+12*len(tags))
+	copy(data[12:], "mntr")
+	copy(data[16:], space)
+	copy(data[20:], "XYZ ")
+	copy(data[36:], "acsp")
+	binary.BigEndian.PutUint32(data[128:], uint32(len(tags)))
+	for i, t := range tags {
+		e := data[132+12*i:]
+		copy(e, t.sig)
+		binary.BigEndian.PutUint32(e[4:], uint32(len(data)))
+		binary.BigEndian.PutUint32(e[8:], uint32(len(t.data)))
+		data = append(data, t.data...)
+	}
+	binary.BigEndian.PutUint32(data, uint32(len(data)))
+	return data
+}
+
+func TestParseProfile(t *testing.T) {
+	odd := colorants{{0.5, 0.3, 0.1}, {0.3, 0.6, 0.1}, {0.1, 0.1, 0.6}}
+	for _, tt := range []struct {
+		name    string
+		profile []byte
+		want    Profile
+	}{
+		{"srgb", testProfile("RGB ", "sRGB IEC61966-2.1", false, spaceColorants[SpaceSRGB]), Profile{"sRGB IEC61966-2.1", SpaceSRGB}},
+		{"adobe", testProfile("RGB ", "Adobe RGB (1998)", false, odd), Profile{"Adobe RGB (1998)", SpaceAdobeRGB}},
+		{"p3 v4", testProfile("RGB ", "Display P3", true, odd), Profile{"Display P3", SpaceDisplayP3}},
+		{"adobe colorants", testProfile("RGB ", "Camera RGB", false, spaceColorants[SpaceAdobeRGB]), Profile{"Camera RGB", SpaceAdobeRGB}},
+		{"p3 colorants", testProfile("RGB ", "", true, spaceColorants[SpaceDisplayP3]), Profile{"", SpaceDisplayP3}},
+		{"unknown", testProfile("RGB ", "ProPhoto", false, odd), Profile{"ProPhoto", ""}},
+		{"gray", testProfile("GRAY", "Gray Gamma 2.2 sRGB", false, colorants{}), Profile{"Gray Gamma 2.2 sRGB", ""}},
+		{"not a profile", make([]byte, 200), Profile{}},
+		{"truncated", testProfile("RGB ", "sRGB", false, odd)[:100], Profile{}},
+	} {
+		if got := parseProfile(tt.profile); got != tt.want {
+			t.Errorf("%s: %+v, expected %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// testProfiled returns a w x h image of the color c encoded in format with the
+// ICC profile icc embedded, split in two APP2 segments in a JPEG image.
+func testProfiled(t *testing.T, format string, c color.RGBA, icc []byte) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	var buf bytes.Buffer
+	if format == "png" {
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := (Metadata{ICC: icc}).writePNG(&out, buf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	out := append([]byte(nil), data[:2]...) // SOI
+	half := len(icc) / 2
+	for i, chunk := range [][]byte{icc[:half], icc[half:]} {
+		seg := append([]byte("ICC_PROFILE\x00"), byte(i+1), 2)
+		seg = append(seg, chunk...)
+		out = append(out, 0xff, 0xe2, byte((len(seg)+2)>>8), byte(len(seg)+2))
+		out = append(out, seg...)
+	}
+	return append(out, data[2:]...)
+}
+
+// toSRGB returns the sRGB color of the 8-bit components c of the color space
+// with the transfer function transfer and the matrix m of its linear
+// components to the ones of sRGB.
+func toSRGB(c color.RGBA, transfer func(float64) float64, m [3][3]float64) color.RGBA {
+	l := [3]float64{transfer(float64(c.R) / 255), transfer(float64(c.G) / 255), transfer(float64(c.B) / 255)}
+	var s [3]uint8
+	for i := range s {
+		v := m[i][0]*l[0] + m[i][1]*l[1] + m[i][2]*l[2]
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		s[i] = uint8(math.Round(255 * math.Max(0, math.Min(1, v))))
+	}
+	return color.RGBA{s[0], s[1], s[2], c.A}
+}
+
+// The matrices of the linear components of Adobe RGB and Display P3 to the
+// ones of sRGB.
+var (
+	adobeToSRGB = [3][3]float64{{1.3983557, -0.3983557, 0}, {0, 1, 0}, {0, -0.0429283, 1.0429283}}
+	p3ToSRGB    = [3][3]float64{{1.2249401, -0.2249404, 0}, {-0.0420569, 1.0420571, 0}, {-0.0196376, -0.0786361, 1.0982735}}
+)
+
+// TestDecodeProfile checks that the colors of the JPEG and PNG images of a
+// known color space are converted to sRGB as they are decoded, and that the
+// profiles still describing their colors, sRGB and unknown ones, are kept.
+func TestDecodeProfile(t *testing.T) {
+	red := color.RGBA{200, 30, 30, 255}
+	for _, tt := range []struct {
+		name  string
+		icc   []byte
+		space string
+		want  color.RGBA
+		kept  bool
+	}{
+		{"adobe", testProfile("RGB ", "Adobe RGB (1998)", false, spaceColorants[SpaceAdobeRGB]), SpaceAdobeRGB, toSRGB(red, adobeRGBTransfer, adobeToSRGB), false},
+		{"p3", testProfile("RGB ", "Camera", true, spaceColorants[SpaceDisplayP3]), SpaceDisplayP3, toSRGB(red, srgbTransfer, p3ToSRGB), false},
+		{"srgb", testProfile("RGB ", "sRGB IEC61966-2.1", false, spaceColorants[SpaceSRGB]), SpaceSRGB, red, true},
+		{"unknown", testProfile("RGB ", "ProPhoto", false, colorants{{0.8, 0.3, 0}, {0.1, 0.7, 0}, {0.03, 0, 0.8}}), "", red, true},
+	} {
+		for _, format := range []string{"png", "jpeg"} {
+			// The JPEG images are not lossless.
+			tolerance := 2
+			if format == "jpeg" {
+				tolerance = 4
+			}
+			data := testProfiled(t, format, red, tt.icc)
+			img, md, err := DecodeWithMetadata(bytes.NewReader(data), format, DecodeOptions{})
+			if err != nil {
+				t.Fatalf("%s %s: %v", tt.name, format, err)
+			}
+			if md.Profile == nil || md.Profile.Space != tt.space {
+				t.Errorf("%s %s: profile %+v, expected the space %q", tt.name, format, md.Profile, tt.space)
+			}
+			if kept := md.ICC != nil; kept != tt.kept {
+				t.Errorf("%s %s: profile kept %v, expected %v", tt.name, format, kept, tt.kept)
+			}
+			if got := color.RGBAModel.Convert(img.At(8, 8)).(color.RGBA); !near(got, tt.want, tolerance) {
+				t.Errorf("%s %s: decoded %v, expected %v", tt.name, format, got, tt.want)
+			}
+
+			img, p, err := DecodeWith(bytes.NewReader(data), format, DecodeOptions{AssumeSRGB: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p != nil {
+				t.Errorf("%s %s: profile %+v with AssumeSRGB", tt.name, format, p)
+			}
+			if got := color.RGBAModel.Convert(img.At(8, 8)).(color.RGBA); !near(got, red, tolerance) {
+				t.Errorf("%s %s: decoded %v with AssumeSRGB, expected %v", tt.name, format, got, red)
+			}
+		}
+	}
+}
+
+// TestAssumeSRGBGrayscale checks that the saturated red of an Adobe RGB image,
+// converted to a lighter sRGB red, is reduced to a lighter gray than when it
+// is taken as an sRGB color.
+func TestAssumeSRGBGrayscale(t *testing.T) {
+	ctx := context.Background()
+	red := color.RGBA{200, 30, 30, 255}
+	data := testProfiled(t, "png", red, testProfile("RGB ", "Adobe RGB (1998)", false, spaceColorants[SpaceAdobeRGB]))
+	grays := Grays(256)
+	converted := Luminance(grays.Convert(toSRGB(red, adobeRGBTransfer, adobeToSRGB)))
+	assumed := Luminance(grays.Convert(red))
+	if converted-assumed < 0.015 {
+		t.Fatalf("gray of luminance %.3f converted and %.3f as sRGB", converted, assumed)
+	}
+	for _, assume := range []bool{false, true} {
+		m, err := ProcessReader(ctx, bytes.NewReader(data), DefaultOptions(WithPalette(grays), WithAlgorithm("nearest"), WithAssumeSRGB(assume)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := converted
+		if assume {
+			want = assumed
+		}
+		if got := Luminance(m.At(8, 8)); math.Abs(got-want) > 0.005 {
+			t.Errorf("AssumeSRGB %v: gray of luminance %.3f, expected %.3f", assume, got, want)
+		}
+	}
+}
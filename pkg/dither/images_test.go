@@ -0,0 +1,103 @@
+package dither
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/jpeg"
+	"testing"
+)
+
+// testImages returns synthetic images of the given size of every type with a
+// fast path, covering the whole range of their components with gradients
+// and a pattern of their own.
+func testImages(t testing.TB, w, h int) map[string]image.Image {
+	r := image.Rect(0, 0, w, h)
+	rgba := image.NewRGBA(r)
+	nrgba := image.NewNRGBA(r)
+	gray := image.NewGray(r)
+	gray16 := image.NewGray16(r)
+	paletted := image.NewPaletted(r, palette.Plan9)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := testColor(x, y, w, h)
+			rgba.SetRGBA(x, y, c)
+			nrgba.SetNRGBA(x, y, color.NRGBA{c.R, c.G, c.B, uint8(255 - (x*y)%97)})
+			gray.SetGray(x, y, color.Gray{uint8((x*255/max(w-1, 1) + y*3) % 256)})
+			gray16.SetGray16(x, y, color.Gray16{uint16((x*65535/max(w-1, 1) + y*771) % 65536)})
+			paletted.SetColorIndex(x, y, uint8((x+y*w)%len(palette.Plan9)))
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, rgba, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	ycbcr, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]image.Image{
+		"rgba":     rgba,
+		"nrgba":    nrgba,
+		"gray":     gray,
+		"gray16":   gray16,
+		"paletted": paletted,
+		"ycbcr":    ycbcr,
+	}
+}
+
+// testRGBA returns a w x h image of the colors of testColor, cheaper than
+// testImages for the large images of the benchmarks.
+func testRGBA(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, testColor(x, y, w, h))
+		}
+	}
+	return img
+}
+
+// testColor returns the color at x, y of a w x h image made of a horizontal
+// gradient of red, a vertical one of green and a checkerboard of blue.
+func testColor(x, y, w, h int) color.RGBA {
+	b := uint8(0x40)
+	if (x/4+y/4)%2 == 0 {
+		b = 0xc0
+	}
+	return color.RGBA{uint8(x * 255 / max(w-1, 1)), uint8(y * 255 / max(h-1, 1)), b, 0xff}
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// testPalettes are the palettes the ditherers are tested with, from two
+// colors to the ones matched with the candidate cube.
+var testPalettes = map[string]color.Palette{
+	"bw":       BlackAndWhite,
+	"grays-4":  Grays(4),
+	"cga":      palettes["cga"],
+	"web-safe": palette.WebSafe,
+}
+
+// diffPixels returns the number of pixels of the paletted images a and b of
+// the same bounds whose indices differ, and the first of them.
+func diffPixels(a, b *image.Paletted) (n int, first image.Point) {
+	first = image.Pt(-1, -1)
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		for x := a.Rect.Min.X; x < a.Rect.Max.X; x++ {
+			if a.ColorIndexAt(x, y) != b.ColorIndexAt(x, y) {
+				if n == 0 {
+					first = image.Pt(x, y)
+				}
+				n++
+			}
+		}
+	}
+	return n, first
+}
@@ -0,0 +1,365 @@
+package dither
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+
+	"golang.org/x/image/webp"
+)
+
+// ImageInfo holds the properties of an encoded image read by Inspect. The
+// fields the format or the image doesn't give are zero.
+type ImageInfo struct {
+	Format     string `json:"format"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	ColorModel string `json:"color_model,omitempty"`
+	// BitDepth is the number of bits of a sample, a component of a color or
+	// the index of a paletted image.
+	BitDepth    int     `json:"bit_depth,omitempty"`
+	PaletteSize int     `json:"palette_size,omitempty"`
+	DPIX        float64 `json:"dpi_x,omitempty"`
+	DPIY        float64 `json:"dpi_y,omitempty"`
+	// Orientation is the EXIF orientation, from 1 to 8.
+	Orientation int `json:"orientation,omitempty"`
+	// Profile is the description of the embedded ICC profile, or sRGB for
+	// a PNG image with an sRGB chunk.
+	Profile string `json:"icc_profile,omitempty"`
+	// ColorSpace is the known color space identified from the profile, see
+	// Profile.
+	ColorSpace string `json:"color_space,omitempty"`
+	// Frames is the number of frames of a GIF image, of pages of a TIFF one,
+	// 1 for the others.
+	Frames int `json:"frames"`
+}
+
+// The EXIF and TIFF tags read by Inspect.
+const (
+	tagWidth          = 256
+	tagHeight         = 257
+	tagBitsPerSample  = 258
+	tagPhotometric    = 262
+	tagOrientation    = 274
+	tagXResolution    = 282
+	tagYResolution    = 283
+	tagResolutionUnit = 296
+	tagColorMap       = 320
+	tagExtraSamples   = 338
+	tagICCProfile     = 34675
+)
+
+// Inspect reads the properties of the image encoded in r from its header,
+// sniffing its format like SniffFormat, without decoding its pixels. The HEIC
+// and AVIF images are read by their decoder, which needs the whole container.
+func Inspect(r io.ReaderAt) (ImageInfo, error) {
+	head := make([]byte, SniffLen)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return ImageInfo{}, &DecodeError{Err: err}
+	}
+	info := ImageInfo{Format: SniffFormat(head[:n]), Frames: 1}
+	stream := io.NewSectionReader(r, 0, math.MaxInt64)
+	switch info.Format {
+	case "png", "jpeg":
+		err = info.inspectHeader(stream)
+	case "tiff":
+		err = info.inspectTIFF(r)
+	case "gif":
+		err = info.inspectGIF(stream)
+	case "webp":
+		var cfg image.Config
+		cfg, err = webp.DecodeConfig(stream)
+		info.Width, info.Height = cfg.Width, cfg.Height
+	case "heic", "avif":
+		var d optionalDecoder
+		if d, err = lookupOptional(info.Format); err == nil {
+			cfg, cerr := d.decodeConfig(stream)
+			info.Width, info.Height, err = cfg.Width, cfg.Height, cerr
+		}
+	default:
+		return info, &DecodeError{Err: ErrUnsupportedFormat}
+	}
+	if err != nil {
+		return info, &DecodeError{Format: info.Format, Err: err}
+	}
+	return info, nil
+}
+
+// inspectHeader reads the properties of a PNG or JPEG image from the chunks or
+// segments before its pixels.
+func (info *ImageInfo) inspectHeader(r io.Reader) error {
+	rec := &headerRecorder{format: info.Format}
+	buf := make([]byte, 32<<10)
+	for !rec.done {
+		n, err := r.Read(buf)
+		rec.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return info.readHeader(rec)
+}
+
+// readHeader reads the properties of a PNG or JPEG image from its chunks or
+// segments recorded by rec.
+func (info *ImageInfo) readHeader(rec *headerRecorder) error {
+	if profile, srgb := rec.embedded(); profile != nil {
+		p := parseProfile(profile)
+		info.Profile, info.ColorSpace = p.Description, p.Space
+	} else if srgb {
+		info.Profile, info.ColorSpace = SpaceSRGB, SpaceSRGB
+	}
+	if rec.exif != nil {
+		info.inspectEXIF(rec.exif)
+	}
+
+	if info.Format == "png" {
+		h := rec.frame
+		if len(h) < 13 {
+			return errors.New("missing IHDR chunk")
+		}
+		info.Width, info.Height = int(binary.BigEndian.Uint32(h)), int(binary.BigEndian.Uint32(h[4:]))
+		info.BitDepth = int(h[8])
+		info.ColorModel = map[byte]string{0: "gray", 2: "RGB", 3: "paletted", 4: "gray with alpha", 6: "RGBA"}[h[9]]
+		if h[9] == 3 {
+			info.PaletteSize = rec.palette
+		}
+		// The pixels per unit, the unit 1 being the meter.
+		if d := rec.density; len(d) >= 9 && d[8] == 1 {
+			info.DPIX = roundDPI(float64(binary.BigEndian.Uint32(d)) * 0.0254)
+			info.DPIY = roundDPI(float64(binary.BigEndian.Uint32(d[4:])) * 0.0254)
+		}
+		return nil
+	}
+
+	f := rec.frame
+	if len(f) < 6 {
+		return errors.New("missing SOF segment")
+	}
+	info.BitDepth = int(f[0])
+	info.Height, info.Width = int(binary.BigEndian.Uint16(f[1:])), int(binary.BigEndian.Uint16(f[3:]))
+	switch components := int(f[5]); {
+	case components == 1:
+		info.ColorModel = "gray"
+	case components == 3 && len(f) >= 6+3*3:
+		// The ratio of the sampling factors of the luma to the ones of the
+		// chroma.
+		h, v := f[7]>>4/max1(f[10]>>4), f[7]&15/max1(f[10]&15)
+		ratio := map[[2]byte]string{{1, 1}: "4:4:4", {2, 1}: "4:2:2", {2, 2}: "4:2:0", {1, 2}: "4:4:0", {4, 1}: "4:1:1", {4, 2}: "4:1:0"}[[2]byte{h, v}]
+		info.ColorModel = "YCbCr " + ratio
+	case components == 4:
+		info.ColorModel = "CMYK"
+	}
+	// The JFIF density, in dots per inch for the unit 1 and per centimeter
+	// for the unit 2, takes precedence over the EXIF resolution.
+	if d := rec.density; len(d) >= 7 && (d[2] == 1 || d[2] == 2) {
+		scale := 1.
+		if d[2] == 2 {
+			scale = 2.54
+		}
+		info.DPIX = roundDPI(float64(binary.BigEndian.Uint16(d[3:])) * scale)
+		info.DPIY = roundDPI(float64(binary.BigEndian.Uint16(d[5:])) * scale)
+	}
+	return nil
+}
+
+func max1(v byte) byte {
+	if v == 0 {
+		return 1
+	}
+	return v
+}
+
+// roundDPI rounds a resolution converted from metric units to hundredths.
+func roundDPI(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// inspectEXIF reads the orientation and, when not given by the image header,
+// the resolution from the TIFF structure of EXIF metadata.
+func (info *ImageInfo) inspectEXIF(exif []byte) {
+	r := bytes.NewReader(exif)
+	order, off, err := tiffHeader(r)
+	if err != nil {
+		return
+	}
+	tags, err := readIFD(r, order, off)
+	if err == nil {
+		info.inspectTags(r, order, tags)
+	}
+}
+
+// inspectTags reads the orientation and resolution from the tags of a TIFF
+// IFD.
+func (info *ImageInfo) inspectTags(r io.ReaderAt, order binary.ByteOrder, tags map[uint16]tiffEntry) {
+	if v, ok := tags[tagOrientation].uint(r, order); ok && v >= 1 && v <= 8 {
+		info.Orientation = int(v)
+	}
+	if info.DPIX != 0 {
+		return
+	}
+	// The resolution unit is the inch unless it is 3, the centimeter; 1 is
+	// no absolute unit.
+	scale := 1.
+	switch unit, ok := tags[tagResolutionUnit].uint(r, order); {
+	case ok && unit == 1:
+		return
+	case ok && unit == 3:
+		scale = 2.54
+	}
+	x, okX := tags[tagXResolution].rational(r, order)
+	y, okY := tags[tagYResolution].rational(r, order)
+	if okX && okY {
+		info.DPIX, info.DPIY = roundDPI(x*scale), roundDPI(y*scale)
+	}
+}
+
+// inspectTIFF reads the properties of a TIFF image from the tags of its first
+// IFD, and counts its pages.
+func (info *ImageInfo) inspectTIFF(r io.ReaderAt) error {
+	order, off, err := tiffHeader(r)
+	if err != nil {
+		return err
+	}
+	tags, err := readIFD(r, order, off)
+	if err != nil {
+		return err
+	}
+	get := func(tag uint16) int {
+		v, _ := tags[tag].uint(r, order)
+		return int(v)
+	}
+	info.Width, info.Height = get(tagWidth), get(tagHeight)
+	info.BitDepth = get(tagBitsPerSample)
+	if info.BitDepth == 0 {
+		info.BitDepth = 1 // the default of bilevel images
+	}
+	_, alpha := tags[tagExtraSamples]
+	switch get(tagPhotometric) {
+	case 0, 1:
+		info.ColorModel = "gray"
+		if alpha {
+			info.ColorModel = "gray with alpha"
+		}
+	case 2:
+		info.ColorModel = "RGB"
+		if alpha {
+			info.ColorModel = "RGBA"
+		}
+	case 3:
+		info.ColorModel = "paletted"
+		info.PaletteSize = int(tags[tagColorMap].count / 3)
+	case 5:
+		info.ColorModel = "CMYK"
+	case 6:
+		info.ColorModel = "YCbCr"
+	}
+	if e, ok := tags[tagICCProfile]; ok {
+		if data, ok := e.values(r, order, maxProfileSize); ok {
+			p := parseProfile(data)
+			info.Profile, info.ColorSpace = p.Description, p.Space
+		}
+	}
+	info.inspectTags(r, order, tags)
+
+	offsets, err := tiffIFDs(r, maxPages)
+	if err != nil {
+		return err
+	}
+	info.Frames = len(offsets)
+	return nil
+}
+
+// inspectGIF reads the properties of a GIF image from its logical screen
+// descriptor, and counts its frames by skipping their data.
+func (info *ImageInfo) inspectGIF(r io.Reader) error {
+	br := &byteReader{r: r}
+	screen := br.next(13)
+	if br.err != nil {
+		return br.err
+	}
+	info.Width, info.Height = int(binary.LittleEndian.Uint16(screen[6:])), int(binary.LittleEndian.Uint16(screen[8:]))
+	info.ColorModel = "paletted"
+	info.Frames = 0
+	table := func(flags byte) int {
+		if flags&0x80 == 0 {
+			return 0
+		}
+		return 1 << (flags&7 + 1)
+	}
+	if n := table(screen[10]); n > 0 {
+		info.PaletteSize, info.BitDepth = n, int(screen[10]&7+1)
+		br.skip(3 * n)
+	}
+	for br.err == nil {
+		switch b := br.next(1); {
+		case br.err != nil:
+		case b[0] == 0x2c: // image descriptor
+			d := br.next(9)
+			if br.err != nil {
+				break
+			}
+			if info.Frames == 0 && info.PaletteSize == 0 {
+				info.PaletteSize, info.BitDepth = table(d[8]), int(d[8]&7+1)
+			}
+			br.skip(3 * table(d[8]))
+			br.next(1) // the LZW minimum code size
+			br.skipBlocks()
+			info.Frames++
+		case b[0] == 0x21: // extension
+			br.next(1)
+			br.skipBlocks()
+		case b[0] == 0x3b: // trailer
+			return nil
+		default:
+			return fmt.Errorf("unknown GIF block 0x%02x", b[0])
+		}
+	}
+	if br.err == io.EOF || br.err == io.ErrUnexpectedEOF {
+		// A truncated image still has the frames read.
+		return nil
+	}
+	return br.err
+}
+
+// byteReader reads a stream of GIF blocks, keeping the first error.
+type byteReader struct {
+	r   io.Reader
+	buf [256]byte
+	err error
+}
+
+// next returns the next n bytes, at most 256, valid until the next call.
+func (br *byteReader) next(n int) []byte {
+	if br.err != nil {
+		return nil
+	}
+	_, br.err = io.ReadFull(br.r, br.buf[:n])
+	return br.buf[:n]
+}
+
+// skip skips the next n bytes.
+func (br *byteReader) skip(n int) {
+	if br.err == nil && n > 0 {
+		_, br.err = io.CopyN(io.Discard, br.r, int64(n))
+	}
+}
+
+// skipBlocks skips the data sub-blocks up to their terminator.
+func (br *byteReader) skipBlocks() {
+	for br.err == nil {
+		n := br.next(1)
+		if br.err != nil || n[0] == 0 {
+			return
+		}
+		br.next(int(n[0]))
+	}
+}
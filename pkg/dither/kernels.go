@@ -0,0 +1,292 @@
+package dither
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// A kernel is an error diffusion matrix: the weights, over divisor, of the
+// quantization error of a pixel given to its neighbors on the right of its
+// row and on the rows below.
+type kernel struct {
+	divisor int32
+	weights []weight
+}
+
+// A weight is the numerator of the error given to the pixel dx columns right
+// of and dy rows below the quantized one.
+type weight struct {
+	dx, dy int
+	w      int32
+}
+
+// The error diffusion kernels besides Floyd-Steinberg, by algorithm name.
+var kernels = map[string]kernel{
+	// Atkinson diffuses only three quarters of the error, which keeps the
+	// contrast of the original Macintosh images but loses the shadow and
+	// highlight details.
+	"atkinson": {8, []weight{
+		{1, 0, 1}, {2, 0, 1},
+		{-1, 1, 1}, {0, 1, 1}, {1, 1, 1},
+		{0, 2, 1},
+	}},
+	"jarvis-judice-ninke": {48, []weight{
+		{1, 0, 7}, {2, 0, 5},
+		{-2, 1, 3}, {-1, 1, 5}, {0, 1, 7}, {1, 1, 5}, {2, 1, 3},
+		{-2, 2, 1}, {-1, 2, 3}, {0, 2, 5}, {1, 2, 3}, {2, 2, 1},
+	}},
+	"stucki": {42, []weight{
+		{1, 0, 8}, {2, 0, 4},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 8}, {1, 1, 4}, {2, 1, 2},
+		{-2, 2, 1}, {-1, 2, 2}, {0, 2, 4}, {1, 2, 2}, {2, 2, 1},
+	}},
+	"burkes": {32, []weight{
+		{1, 0, 8}, {2, 0, 4},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 8}, {1, 1, 4}, {2, 1, 2},
+	}},
+	"sierra": {32, []weight{
+		{1, 0, 5}, {2, 0, 3},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 5}, {1, 1, 4}, {2, 1, 2},
+		{-1, 2, 2}, {0, 2, 3}, {1, 2, 2},
+	}},
+	"sierra-two-row": {16, []weight{
+		{1, 0, 4}, {2, 0, 3},
+		{-2, 1, 1}, {-1, 1, 2}, {0, 1, 3}, {1, 1, 2}, {2, 1, 1},
+	}},
+	"sierra-lite": {4, []weight{
+		{1, 0, 2},
+		{-1, 1, 1}, {0, 1, 1},
+	}},
+}
+
+// floydSteinberg is the kernel of errorDiffusion, which implements it with
+// the results of the image/draw package, used once its diffusion is tuned.
+var floydSteinberg = kernel{16, []weight{
+	{1, 0, 7},
+	{-1, 1, 3}, {0, 1, 5}, {1, 1, 1},
+}}
+
+// kernelMargin is the number of columns of margin on each side of the rows of
+// errors, the largest dx of the kernels.
+const kernelMargin = 2
+
+// kernelDiffusion reduces images to a palette by diffusing the quantization
+// error of each pixel with its kernel, scanning the rows from left to right
+// unless its diffusion is serpentine.
+type kernelDiffusion struct {
+	kernel
+	diffusion Diffusion
+}
+
+func (k kernelDiffusion) Dither(dst *image.Paletted, src image.Image) error {
+	return k.Bands(dst.Bounds().Dx(), dst.Palette)(dst, src)
+}
+
+func (k kernelDiffusion) Tuned(d Diffusion) Ditherer {
+	return kernelDiffusion{k.kernel, d}
+}
+
+func (k kernelDiffusion) Bands(width int, p color.Palette) DithererFunc {
+	return k.state(width, p).dither
+}
+
+// state returns the state of the diffusion of the bands of an image of the
+// given width to the palette p.
+func (k kernelDiffusion) state(width int, p color.Palette) *kernelState {
+	d := &kernelState{
+		kernel:     k.kernel,
+		palette:    paletteValues(p),
+		strength:   int32(math.Round(k.diffusion.Strength * fullStrength)),
+		serpentine: k.diffusion.Serpentine,
+		gray:       true,
+	}
+	d.match = newMatcher(d.palette)
+	for _, c := range d.palette {
+		if c[0] != c[1] || c[0] != c[2] || c[3] != 0xffff {
+			d.gray = false
+		}
+	}
+	rows := 1
+	for _, w := range k.weights {
+		if w.dy+1 > rows {
+			rows = w.dy + 1
+		}
+	}
+	d.errs = make([][][4]int32, rows)
+	for i := range d.errs {
+		d.errs[i] = make([][4]int32, width+2*kernelMargin)
+	}
+	return d
+}
+
+// fullStrength is the fixed-point strength of the diffusion of the whole
+// quantization error.
+const fullStrength = 256
+
+// kernelState holds the state of a kernelDiffusion between bands: the
+// errors, in units of 1/divisor of 16-bit components, of the current row and
+// of the rows below it reached by the kernel, and the number of rows dithered
+// for the direction of the serpentine scanning.
+//
+// The 8-bit gray planes reduced to a palette of opaque grays, whose four
+// components have the same errors, are dithered with the single errors of
+// grays instead, allocated by their first band.
+type kernelState struct {
+	kernel
+	palette    [][4]int32
+	match      matcher
+	errs       [][][4]int32
+	grays      [][]int32
+	gray       bool  // whether the palette holds only opaque grays
+	strength   int32 // in 1/fullStrength of the error
+	serpentine bool
+	rows       int
+
+	// trace, if set, is called with the components of each pixel with its
+	// diffused error, before it is matched, for the tests.
+	trace func(x, y int, v [4]int32)
+}
+
+// dither reduces to the palette the pixels of src in the bounds of dst, which
+// src must cover.
+func (d *kernelState) dither(dst *image.Paletted, src image.Image) error {
+	b := dst.Bounds()
+	if width := len(d.errs[0]) - 2*kernelMargin; b.Dx() != width {
+		return fmt.Errorf("dither: band of width %d for an image of width %d", b.Dx(), width)
+	}
+	if !b.In(src.Bounds()) {
+		return fmt.Errorf("dither: band %v out of the source bounds %v", b, src.Bounds())
+	}
+
+	if g, ok := src.(*image.Gray); ok && d.gray && (d.rows == 0 || d.grays != nil) {
+		if d.grays == nil {
+			d.grays = make([][]int32, len(d.errs))
+			for i := range d.grays {
+				d.grays[i] = make([]int32, len(d.errs[i]))
+			}
+		}
+		d.ditherGray(dst, g, b)
+		return nil
+	}
+	if d.grays != nil {
+		// A band of another type follows gray ones: their errors go on
+		// with the four components.
+		for k, row := range d.grays {
+			for i, e := range row {
+				d.errs[k][i] = [4]int32{e, e, e, 0}
+			}
+		}
+		d.grays = nil
+	}
+
+	// The RGBA pixels are read from their plane, the other ones through
+	// pixelReader.
+	rgba, _ := src.(*image.RGBA)
+	var pixel func(x, y int) (r, g, b, a int32)
+	if rgba == nil {
+		pixel = pixelReader(src)
+	}
+	div := d.divisor
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := dst.Pix[dst.PixOffset(b.Min.X, y):]
+		var pix []uint8
+		if rgba != nil {
+			pix = rgba.Pix[rgba.PixOffset(b.Min.X, y):]
+		}
+		curr := d.errs[0]
+		// The odd rows of a serpentine scanning are scanned from right to
+		// left, with the kernel mirrored.
+		start, end, dir := 0, b.Dx(), 1
+		if d.serpentine && d.rows%2 == 1 {
+			start, end, dir = b.Dx()-1, -1, -1
+		}
+		d.rows++
+		for i := start; i != end; i += dir {
+			e := &curr[i+kernelMargin]
+			var er, eg, eb, ea int32
+			if pix != nil {
+				p := pix[4*i : 4*i+4 : 4*i+4]
+				er, eg, eb, ea = int32(p[0])*0x101, int32(p[1])*0x101, int32(p[2])*0x101, int32(p[3])*0x101
+			} else {
+				er, eg, eb, ea = pixel(b.Min.X+i, y)
+			}
+			er = clamp(er + e[0]/div)
+			eg = clamp(eg + e[1]/div)
+			eb = clamp(eb + e[2]/div)
+			ea = clamp(ea + e[3]/div)
+			if d.trace != nil {
+				d.trace(b.Min.X+i, y, [4]int32{er, eg, eb, ea})
+			}
+
+			best := d.match.index(er, eg, eb, ea)
+			row[i] = byte(best)
+
+			p := &d.palette[best]
+			er -= p[0]
+			eg -= p[1]
+			eb -= p[2]
+			ea -= p[3]
+			if d.strength != fullStrength {
+				er = er * d.strength / fullStrength
+				eg = eg * d.strength / fullStrength
+				eb = eb * d.strength / fullStrength
+				ea = ea * d.strength / fullStrength
+			}
+			for _, w := range d.weights {
+				n := &d.errs[w.dy][i+kernelMargin+dir*w.dx]
+				n[0] += er * w.w
+				n[1] += eg * w.w
+				n[2] += eb * w.w
+				n[3] += ea * w.w
+			}
+		}
+		// The row below becomes the current one, and the current one, cleared,
+		// the last one.
+		for i := range curr {
+			curr[i] = [4]int32{}
+		}
+		copy(d.errs, d.errs[1:])
+		d.errs[len(d.errs)-1] = curr
+	}
+	return nil
+}
+
+// ditherGray dithers the band b of the gray plane src with the single errors
+// of grays, with the results of the four components.
+func (d *kernelState) ditherGray(dst *image.Paletted, src *image.Gray, b image.Rectangle) {
+	div := d.divisor
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := dst.Pix[dst.PixOffset(b.Min.X, y):]
+		pix := src.Pix[src.PixOffset(b.Min.X, y):]
+		curr := d.grays[0]
+		start, end, dir := 0, b.Dx(), 1
+		if d.serpentine && d.rows%2 == 1 {
+			start, end, dir = b.Dx()-1, -1, -1
+		}
+		d.rows++
+		for i := start; i != end; i += dir {
+			v := clamp(int32(pix[i])*0x101 + curr[i+kernelMargin]/div)
+			if d.trace != nil {
+				d.trace(b.Min.X+i, y, [4]int32{v, v, v, 0xffff})
+			}
+
+			best := d.match.index(v, v, v, 0xffff)
+			row[i] = byte(best)
+
+			e := v - d.palette[best][0]
+			if d.strength != fullStrength {
+				e = e * d.strength / fullStrength
+			}
+			for _, w := range d.weights {
+				d.grays[w.dy][i+kernelMargin+dir*w.dx] += e * w.w
+			}
+		}
+		for i := range curr {
+			curr[i] = 0
+		}
+		copy(d.grays, d.grays[1:])
+		d.grays[len(d.grays)-1] = curr
+	}
+}
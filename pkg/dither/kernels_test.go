@@ -0,0 +1,345 @@
+package dither
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+// levels16 returns the 16-bit levels of the colors of the gray palette p.
+func levels16(p color.Palette) []float64 {
+	levels := make([]float64, len(p))
+	for i, c := range p {
+		y, _, _, _ := c.RGBA()
+		levels[i] = float64(y)
+	}
+	return levels
+}
+
+// floatDiffusion is the floating-point reference of kernelDiffusion for the
+// gray images and palettes. It returns the indices of the levels chosen for
+// the pixels of src, in rows, and their 16-bit values with the diffused
+// error. The levels are chosen by choose, or are the nearest ones if it is
+// nil.
+func floatDiffusion(src *image.Gray, levels []float64, k kernel, d Diffusion, choose func(x, y int) int) ([]int, []float64) {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	stride := w + 2*kernelMargin
+	errs := make([]float64, stride*(h+3))
+	chosen := make([]int, w*h)
+	values := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		start, end, dir := 0, w, 1
+		if d.Serpentine && y%2 == 1 {
+			start, end, dir = w-1, -1, -1
+		}
+		for x := start; x != end; x += dir {
+			v := math.Max(0, math.Min(0xffff, float64(src.Pix[src.PixOffset(b.Min.X+x, b.Min.Y+y)])*0x101+errs[y*stride+x+kernelMargin]))
+			best := 0
+			if choose != nil {
+				best = choose(b.Min.X+x, b.Min.Y+y)
+			} else {
+				for i, l := range levels {
+					if math.Abs(v-l) < math.Abs(v-levels[best]) {
+						best = i
+					}
+				}
+			}
+			chosen[y*w+x], values[y*w+x] = best, v
+			e := (v - levels[best]) * d.Strength
+			for _, wt := range k.weights {
+				errs[(y+wt.dy)*stride+x+kernelMargin+dir*wt.dx] += e * float64(wt.w) / float64(k.divisor)
+			}
+		}
+	}
+	return chosen, values
+}
+
+// traceDiffusion dithers src to p with the fixed-point diffusion of k and d,
+// returning the result and the 16-bit gray values of its pixels, in rows,
+// with the diffused error.
+func traceDiffusion(t *testing.T, src image.Image, p color.Palette, k kernel, d Diffusion) (*image.Paletted, []float64) {
+	b := src.Bounds()
+	dst := image.NewPaletted(b, p)
+	values := make([]float64, b.Dx()*b.Dy())
+	state := kernelDiffusion{k, d}.state(b.Dx(), p)
+	state.trace = func(x, y int, v [4]int32) {
+		values[(y-b.Min.Y)*b.Dx()+x-b.Min.X] = float64(v[0])
+	}
+	if err := state.dither(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	return dst, values
+}
+
+// allKernels returns the kernels by algorithm name, Floyd-Steinberg too.
+func allKernels() map[string]kernel {
+	all := map[string]kernel{"floyd-steinberg": floydSteinberg}
+	for name, k := range kernels {
+		all[name] = k
+	}
+	return all
+}
+
+// TestKernelDiffusionMatchesFloat checks that the values with the diffused
+// error of the fixed-point diffusion of every kernel, strength and direction
+// stay within 1 level of the floating-point reference making the same
+// choices, on every pixel of a gray plane and of its RGBA copy.
+func TestKernelDiffusionMatchesFloat(t *testing.T) {
+	gray := testImages(t, 97, 61)["gray"].(*image.Gray)
+	rgba := image.NewRGBA(gray.Bounds())
+	draw.Draw(rgba, rgba.Rect, gray, gray.Rect.Min, draw.Src)
+	for name, k := range allKernels() {
+		for _, p := range []color.Palette{BlackAndWhite, Grays(4), Grays(16)} {
+			for _, d := range []Diffusion{{Strength: 1}, {Strength: 0.6}, {Strength: 1, Serpentine: true}} {
+				var results []*image.Paletted
+				for _, src := range []image.Image{gray, rgba} {
+					dst, values := traceDiffusion(t, src, p, k, d)
+					results = append(results, dst)
+					_, want := floatDiffusion(gray, levels16(p), k, d, func(x, y int) int {
+						return int(dst.ColorIndexAt(x, y))
+					})
+					for i, v := range values {
+						if math.Abs(v-want[i]) > 0x101 {
+							t.Errorf("%s of %T to %d levels with %+v: pixel %d,%d of value %.0f, %.0f in floating point",
+								name, src, len(p), d, i%gray.Rect.Dx(), i/gray.Rect.Dx(), v, want[i])
+							break
+						}
+					}
+				}
+				if n, first := diffPixels(results[0], results[1]); n > 0 {
+					t.Errorf("%s to %d levels with %+v: %d pixels of the gray and RGBA results differ, the first at %v", name, len(p), d, n, first)
+				}
+			}
+		}
+	}
+}
+
+// TestKernelDiffusionFloatLevels checks that the fixed-point diffusion of
+// every kernel to the 256 levels of gray chooses, on every pixel, a level
+// within 1 of the one of the floating-point reference.
+func TestKernelDiffusionFloatLevels(t *testing.T) {
+	src := testImages(t, 97, 61)["gray"].(*image.Gray)
+	p := Grays(256)
+	for name, k := range allKernels() {
+		for _, d := range []Diffusion{{Strength: 1}, {Strength: 0.6}, {Strength: 1, Serpentine: true}} {
+			dst, _ := traceDiffusion(t, src, p, k, d)
+			want, _ := floatDiffusion(src, levels16(p), k, d, nil)
+			for i, l := range want {
+				x, y := i%src.Rect.Dx(), i/src.Rect.Dx()
+				if got := int(dst.ColorIndexAt(x, y)); got-l > 1 || l-got > 1 {
+					t.Errorf("%s with %+v: pixel %d,%d of level %d, %d in floating point", name, d, x, y, 255-got, 255-l)
+					break
+				}
+			}
+		}
+	}
+}
+
+// TestKernelDiffusionBandTypes checks that the errors of gray bands go on
+// with the RGBA bands that follow them.
+func TestKernelDiffusionBandTypes(t *testing.T) {
+	gray := testImages(t, 40, 30)["gray"].(*image.Gray)
+	rgba := image.NewRGBA(gray.Rect)
+	draw.Draw(rgba, rgba.Rect, gray, gray.Rect.Min, draw.Src)
+	k := kernelDiffusion{kernels["jarvis-judice-ninke"], Diffusion{Strength: 0.8, Serpentine: true}}
+	want := image.NewPaletted(gray.Rect, Grays(4))
+	if err := k.Dither(want, gray); err != nil {
+		t.Fatal(err)
+	}
+	got := image.NewPaletted(gray.Rect, Grays(4))
+	f := k.Bands(gray.Rect.Dx(), got.Palette)
+	for i, src := range []image.Image{gray, rgba, gray} {
+		band := got.SubImage(image.Rect(0, 10*i, 40, 10*i+10)).(*image.Paletted)
+		if err := f(band, src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, first := diffPixels(got, want); n > 0 {
+		t.Errorf("%d pixels differ, the first at %v", n, first)
+	}
+}
+
+// kernelGolden are the black and white results of the kernels for the
+// gradient of TestKernelDiffusionGolden, in the rows of pixelsString, checked
+// against a floating-point implementation when recorded.
+var kernelGolden = map[string]string{
+	"atkinson": "#######..#......\n" +
+		"#####.##..#.....\n" +
+		"#####..##...#...\n" +
+		"#######..#......\n" +
+		"####.##..#......\n" +
+		"####.#.##..#....\n",
+	"burkes": "######.#..#.....\n" +
+		"####.##.##......\n" +
+		"#####.#...#.#...\n" +
+		"###.##.##..#....\n" +
+		"#####.#.#.......\n" +
+		"###.##.#.#.#....\n",
+	"floyd-steinberg": "######.#.#......\n" +
+		"####.##.#.#.#...\n" +
+		"###.#.#.#.......\n" +
+		"######.#.#.#.#..\n" +
+		"###.#.#.#.#.....\n" +
+		"######.#.#......\n",
+	"jarvis-judice-ninke": "#######.#.......\n" +
+		"#####.#.##......\n" +
+		"####.#.#..##....\n" +
+		"######.#....#...\n" +
+		"###.##.##.#.....\n" +
+		"###.#.#..#......\n",
+	"sierra": "#######.#.......\n" +
+		"####.#.#.#.#....\n" +
+		"#####.##..#.....\n" +
+		"#####.##..#..#..\n" +
+		"###.##..#.......\n" +
+		"###.##.##.##....\n",
+	"sierra-lite": "#####.#.#.#.....\n" +
+		"###.###.#..#....\n" +
+		"#####.#.#.#..#..\n" +
+		"###.##.#.#......\n" +
+		"####.##.#..#....\n" +
+		"#####.#.#.#..#..\n",
+	"sierra-two-row": "######.#..#.....\n" +
+		"####.##.#..#....\n" +
+		"#####.#.#..#....\n" +
+		"###.##.#.#......\n" +
+		"#####.#.#..#..#.\n" +
+		"###.##.#.#......\n",
+	"stucki": "#######.#.......\n" +
+		"####.#.#.#.#....\n" +
+		"#####.#.#.#.....\n" +
+		"######.#....#...\n" +
+		"###.#.##.#.#....\n" +
+		"####.#.#..#.....\n",
+}
+
+// TestKernelDiffusionGolden checks the black and white results of the
+// kernels on a small gradient.
+func TestKernelDiffusionGolden(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 16, 6))
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 16; x++ {
+			src.SetGray(x, y, color.Gray{uint8(x*16 + y*2 + 1)})
+		}
+	}
+	for name, want := range kernelGolden {
+		d, _ := Lookup(name)
+		dst := image.NewPaletted(src.Bounds(), BlackAndWhite)
+		if err := d.Dither(dst, src); err != nil {
+			t.Fatal(err)
+		}
+		if got := pixelsString(dst); got != want {
+			t.Errorf("%s: result\n%s\nexpected\n%s", name, got, want)
+		}
+	}
+}
+
+// pixelsString returns the pixels of the black and white img as rows of #
+// and . for the black and white ones.
+func pixelsString(img *image.Paletted) string {
+	var s string
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			if y, _, _, _ := img.At(x, y).RGBA(); y == 0 {
+				s += "#"
+			} else {
+				s += "."
+			}
+		}
+		s += "\n"
+	}
+	return s
+}
+
+func TestBayerMatrix(t *testing.T) {
+	want := []int32{
+		0, 8, 2, 10,
+		12, 4, 14, 6,
+		3, 11, 1, 9,
+		15, 7, 13, 5,
+	}
+	if got := bayer(4).matrix; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("matrix %v, expected %v", got, want)
+	}
+	seen := make(map[int32]bool)
+	for _, v := range bayer(8).matrix {
+		seen[v] = true
+	}
+	if len(seen) != 64 {
+		t.Errorf("8x8 matrix of %d distinct thresholds", len(seen))
+	}
+}
+
+// TestBayerLevels checks that a uniform gray of k sixteenths is dithered by
+// bayer-4x4 to the k pixels of each tile of the highest thresholds.
+func TestBayerLevels(t *testing.T) {
+	m := bayer(4).matrix
+	for k := 0; k <= 16; k++ {
+		src := image.NewGray16(image.Rect(-3, -2, 13, 10))
+		for i := 0; i < len(src.Pix); i += 2 {
+			v := uint16(k * 0xffff / 16)
+			src.Pix[i], src.Pix[i+1] = uint8(v>>8), uint8(v)
+		}
+		dst, err := Reduce(context.Background(), src, BlackAndWhite, "bayer-4x4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+			for x := src.Rect.Min.X; x < src.Rect.Max.X; x++ {
+				white := m[mod(y, 4)*4+mod(x, 4)] >= int32(16-k)
+				if l, _, _, _ := dst.At(x, y).RGBA(); (l == 0xffff) != white {
+					t.Fatalf("%d/16 of gray: pixel %d,%d of level %#04x, expected white %v:\n%s", k, x, y, l, white, pixelsString(dst))
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkKernelDiffusion measures the fixed-point error diffusion of a
+// 12-megapixel image to black and white, to the 16 colors of pico-8 and to 64
+// levels of gray, matched with the candidate cube. Floyd-Steinberg is the
+// kernel of its tuned diffusion. The gray benchmarks compare the diffusion of
+// the gray plane of the image to black and white in floating point and in
+// fixed point.
+func BenchmarkKernelDiffusion(b *testing.B) {
+	src := testRGBA(4000, 3000)
+	for _, name := range []string{"floyd-steinberg", "atkinson", "jarvis-judice-ninke"} {
+		for _, p := range []color.Palette{BlackAndWhite, palettes["pico-8"], Grays(64)} {
+			d, _ := Lookup(name)
+			if name == "floyd-steinberg" {
+				d = kernelDiffusion{floydSteinberg, defaultDiffusion}
+			}
+			b.Run(fmt.Sprintf("%s/%d", name, len(p)), func(b *testing.B) {
+				dst := image.NewPaletted(src.Rect, p)
+				for i := 0; i < b.N; i++ {
+					if err := d.Dither(dst, src); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+
+	gray := image.NewGray(src.Rect)
+	draw.Draw(gray, gray.Rect, src, src.Rect.Min, draw.Src)
+	k := kernelDiffusion{floydSteinberg, defaultDiffusion}
+	b.Run("gray/float", func(b *testing.B) {
+		levels := levels16(BlackAndWhite)
+		for i := 0; i < b.N; i++ {
+			floatDiffusion(gray, levels, k.kernel, k.diffusion, nil)
+		}
+	})
+	b.Run("gray/int", func(b *testing.B) {
+		dst := image.NewPaletted(gray.Rect, BlackAndWhite)
+		for i := 0; i < b.N; i++ {
+			if err := k.Dither(dst, gray); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
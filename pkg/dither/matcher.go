@@ -0,0 +1,254 @@
+package dither
+
+import "image/color"
+
+// A matcher finds the index of the palette color nearest to the color of
+// 16-bit premultiplied components r, g, b and a. Like image/draw, the nearest
+// color is the first minimizing the sum of the sqDiff of the components.
+type matcher struct {
+	palette [][4]int32
+	small   *small // nil for the large palettes
+	cube    *cube  // nil for the small palettes
+}
+
+// cubeMinColors is the palette size from which newMatcher precomputes the
+// candidates of the cells of the opaque color cube instead of comparing a
+// color to every palette entry.
+const cubeMinColors = 17
+
+// smallColors is the largest size of the palettes matched by a small.
+const smallColors = cubeMinColors - 1
+
+// cubeBits is the number of bits of each component selecting a cell of the
+// cube, which has 1<<cubeBits cells per side.
+const cubeBits = 5
+
+// paletteValues returns the 16-bit premultiplied components of the colors of
+// p.
+func paletteValues(p color.Palette) [][4]int32 {
+	values := make([][4]int32, len(p))
+	for i, c := range p {
+		r, g, b, a := c.RGBA()
+		values[i] = [4]int32{int32(r), int32(g), int32(b), int32(a)}
+	}
+	return values
+}
+
+// newMatcher returns the matcher of the palette of the given components,
+// precomputing the candidates of the cube cells for the large palettes.
+func newMatcher(p [][4]int32) matcher {
+	m := matcher{palette: p}
+	switch {
+	case len(p) >= cubeMinColors:
+		m.cube = newCube(p)
+	case len(p) > 0:
+		m.small = newSmall(p)
+	}
+	return m
+}
+
+// index returns the index of the nearest color. The palettes of two colors,
+// the most common, are best matched inline with match2.
+func (m matcher) index(r, g, b, a int32) int {
+	switch {
+	case m.small != nil:
+		return m.small.match(r, g, b, a)
+	case m.cube != nil && a == 0xffff:
+		return m.cube.match(r, g, b)
+	}
+	return nearest(m.palette, nil, r, g, b, a)
+}
+
+// A small holds a palette of at most smallColors colors component by
+// component, padded with copies of its first color to a multiple of 4
+// colors, so that they are compared 4 at a time without bounds checks. The
+// copies are never strictly nearer than the first color, and the ties are
+// broken as by nearest.
+type small struct {
+	n          int // the number of colors, padding included
+	r, g, b, a [smallColors]int32
+	// opaque reports whether all the colors are opaque, their alpha then
+	// being left out for the opaque colors.
+	opaque bool
+}
+
+func newSmall(p [][4]int32) *small {
+	s := &small{n: (len(p) + 3) &^ 3, opaque: true}
+	for i := 0; i < s.n; i++ {
+		c := p[0]
+		if i < len(p) {
+			c = p[i]
+		}
+		s.r[i], s.g[i], s.b[i], s.a[i] = c[0], c[1], c[2], c[3]
+		s.opaque = s.opaque && c[3] == 0xffff
+	}
+	return s
+}
+
+// match returns the index of the color nearest to the given one.
+func (s *small) match(r, g, b, a int32) int {
+	best, bestSum := 0, uint32(1<<32-1)
+	if s.opaque && a == 0xffff {
+		for i := 0; i+3 < s.n && i+3 < smallColors; i += 4 {
+			s0 := sqDiff(r, s.r[i]) + sqDiff(g, s.g[i]) + sqDiff(b, s.b[i])
+			s1 := sqDiff(r, s.r[i+1]) + sqDiff(g, s.g[i+1]) + sqDiff(b, s.b[i+1])
+			s2 := sqDiff(r, s.r[i+2]) + sqDiff(g, s.g[i+2]) + sqDiff(b, s.b[i+2])
+			s3 := sqDiff(r, s.r[i+3]) + sqDiff(g, s.g[i+3]) + sqDiff(b, s.b[i+3])
+			best, bestSum = nearest4(best, bestSum, i, s0, s1, s2, s3)
+		}
+		return best
+	}
+	for i := 0; i+3 < s.n && i+3 < smallColors; i += 4 {
+		s0 := sqDiff(r, s.r[i]) + sqDiff(g, s.g[i]) + sqDiff(b, s.b[i]) + sqDiff(a, s.a[i])
+		s1 := sqDiff(r, s.r[i+1]) + sqDiff(g, s.g[i+1]) + sqDiff(b, s.b[i+1]) + sqDiff(a, s.a[i+1])
+		s2 := sqDiff(r, s.r[i+2]) + sqDiff(g, s.g[i+2]) + sqDiff(b, s.b[i+2]) + sqDiff(a, s.a[i+2])
+		s3 := sqDiff(r, s.r[i+3]) + sqDiff(g, s.g[i+3]) + sqDiff(b, s.b[i+3]) + sqDiff(a, s.a[i+3])
+		best, bestSum = nearest4(best, bestSum, i, s0, s1, s2, s3)
+	}
+	return best
+}
+
+// nearest4 returns the index and the sum of the nearest color among the
+// current best one and the 4 colors of the sums s0 to s3 from the index i,
+// the earliest on ties.
+func nearest4(best int, bestSum uint32, i int, s0, s1, s2, s3 uint32) (int, uint32) {
+	if s0 < bestSum {
+		best, bestSum = i, s0
+	}
+	if s1 < bestSum {
+		best, bestSum = i+1, s1
+	}
+	if s2 < bestSum {
+		best, bestSum = i+2, s2
+	}
+	if s3 < bestSum {
+		best, bestSum = i+3, s3
+	}
+	return best, bestSum
+}
+
+// nearest returns the index of the color of p nearest to the given one among
+// those of the given indices, or all when indices is nil.
+func nearest(p [][4]int32, indices []uint8, r, g, b, a int32) int {
+	best, bestSum := 0, uint32(1<<32-1)
+	if indices == nil {
+		for i, c := range p {
+			sum := sqDiff(r, c[0]) + sqDiff(g, c[1]) + sqDiff(b, c[2]) + sqDiff(a, c[3])
+			if sum < bestSum {
+				best, bestSum = i, sum
+				if sum == 0 {
+					break
+				}
+			}
+		}
+		return best
+	}
+	for _, i := range indices {
+		c := p[i]
+		sum := sqDiff(r, c[0]) + sqDiff(g, c[1]) + sqDiff(b, c[2]) + sqDiff(a, c[3])
+		if sum < bestSum {
+			best, bestSum = int(i), sum
+			if sum == 0 {
+				break
+			}
+		}
+	}
+	return best
+}
+
+// match2 returns the index of the color of c0 and c1 nearest to the given
+// one: the second is chosen only when strictly nearer.
+func match2(c0, c1 *[4]int32, r, g, b, a int32) int {
+	s0 := sqDiff(r, c0[0]) + sqDiff(g, c0[1]) + sqDiff(b, c0[2]) + sqDiff(a, c0[3])
+	s1 := sqDiff(r, c1[0]) + sqDiff(g, c1[1]) + sqDiff(b, c1[2]) + sqDiff(a, c1[3])
+	if s1 < s0 {
+		return 1
+	}
+	return 0
+}
+
+// A cube holds, for each cell of the cube of the opaque colors, the indices
+// of the palette colors that may be the nearest to a color of the cell, in
+// increasing order so that the ties are broken as by a full search.
+type cube struct {
+	palette [][4]int32
+	cells   [][2]uint32 // the candidates of a cell are indices[cell[0]:cell[1]]
+	indices []uint8
+}
+
+func newCube(p [][4]int32) *cube {
+	c := &cube{palette: p, cells: make([][2]uint32, 1<<(3*cubeBits))}
+	all := make([]uint8, len(p))
+	for i := range all {
+		all[i] = uint8(i)
+	}
+	c.split([3]int{}, cubeBits, all, make([]uint32, len(p)))
+	return c
+}
+
+// split computes the candidates of the cells of the sub-cube of 1<<bits cells
+// per side starting at the cell first, with the given candidates of an
+// enclosing cube. Those of a cell are among those of any cube enclosing it,
+// so that dividing the cube in eight recursively prunes most of the palette
+// early. sums is a scratch slice of the palette size.
+func (c *cube) split(first [3]int, bits int, candidates []uint8, sums []uint32) {
+	var lo, hi [3]int32
+	for i, v := range first {
+		lo[i] = int32(v << (16 - cubeBits))
+		hi[i] = int32((v+1<<uint(bits))<<(16-cubeBits)) - 1
+	}
+	if bits == 0 {
+		cell := (first[0]<<cubeBits|first[1])<<cubeBits | first[2]
+		c.cells[cell][0] = uint32(len(c.indices))
+		c.indices = appendCandidates(c.indices, c.palette, candidates, lo, hi, sums)
+		c.cells[cell][1] = uint32(len(c.indices))
+		return
+	}
+	candidates = appendCandidates(nil, c.palette, candidates, lo, hi, sums)
+	half := 1 << uint(bits-1)
+	for i := 0; i < 8; i++ {
+		c.split([3]int{first[0] + i>>2&1*half, first[1] + i>>1&1*half, first[2] + i&1*half}, bits-1, candidates, sums)
+	}
+}
+
+// appendCandidates appends to indices those of the given candidates colors of
+// p that may be the nearest to an opaque color of components between lo and
+// hi: the colors whose smallest sum over the box doesn't exceed the smallest
+// of the largest sums. sums is a scratch slice of the length of p.
+func appendCandidates(indices []uint8, p [][4]int32, candidates []uint8, lo, hi [3]int32, sums []uint32) []uint8 {
+	bound := uint32(1<<32 - 1)
+	for _, i := range candidates {
+		c := p[i]
+		min, max := sqDiff(0xffff, c[3]), sqDiff(0xffff, c[3])
+		for j := 0; j < 3; j++ {
+			near, far := c[j], lo[j]
+			if near < lo[j] {
+				near = lo[j]
+			} else if near > hi[j] {
+				near = hi[j]
+			}
+			if c[j]-lo[j] < hi[j]-c[j] {
+				far = hi[j]
+			}
+			min += sqDiff(c[j], near)
+			max += sqDiff(c[j], far)
+		}
+		sums[i] = min
+		if max < bound {
+			bound = max
+		}
+	}
+	for _, i := range candidates {
+		if sums[i] <= bound {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// match returns the index of the palette color nearest to the opaque color
+// of components r, g and b.
+func (c *cube) match(r, g, b int32) int {
+	cell := c.cells[(r>>(16-cubeBits)<<cubeBits|g>>(16-cubeBits))<<cubeBits|b>>(16-cubeBits)]
+	return nearest(c.palette, c.indices[cell[0]:cell[1]], r, g, b, 0xffff)
+}
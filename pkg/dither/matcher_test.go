@@ -0,0 +1,90 @@
+package dither
+
+import (
+	"fmt"
+	"image/color"
+	"image/color/palette"
+	"testing"
+)
+
+// matcherPalettes are the palettes the matcher is checked with, of both
+// the small and the cube kinds, one with translucent colors and one with
+// repeated colors whose ties must be broken as by nearest.
+var matcherPalettes = map[string]color.Palette{
+	"bw":       BlackAndWhite,
+	"grays-3":  Grays(3),
+	"cga":      palettes["cga"],
+	"gameboy":  palettes["gameboy"],
+	"pico-8":   palettes["pico-8"],
+	"grays-17": Grays(17),
+	"web-safe": palette.WebSafe,
+	"plan9":    palette.Plan9,
+	"translucent": {
+		color.NRGBA{0xff, 0, 0, 0x80}, color.NRGBA{0, 0xff, 0, 0xff},
+		color.NRGBA{0, 0, 0xff, 0x40}, color.Transparent, color.White,
+	},
+	"ties": {
+		color.Black, color.White, color.Black, color.Gray{0x80},
+		color.White, color.Gray{0x80}, color.RGBA{0xff, 0, 0, 0xff},
+	},
+}
+
+// TestMatcherMatchesNearest checks that the matcher chooses the index of a
+// search through the whole palette for every color of the 15-bit cube, and
+// for some translucent colors.
+func TestMatcherMatchesNearest(t *testing.T) {
+	for name, p := range matcherPalettes {
+		values := paletteValues(p)
+		m := newMatcher(values)
+		for r := int32(0); r < 32; r++ {
+			for g := int32(0); g < 32; g++ {
+				for b := int32(0); b < 32; b++ {
+					// The 5-bit components widened to 16 bits, as by
+					// repeating their bits.
+					r16, g16, b16 := r<<11|r<<6|r<<1|r>>4, g<<11|g<<6|g<<1|g>>4, b<<11|b<<6|b<<1|b>>4
+					if got, want := m.index(r16, g16, b16, 0xffff), nearest(values, nil, r16, g16, b16, 0xffff); got != want {
+						t.Fatalf("%s: index %d for %#04x %#04x %#04x, expected %d", name, got, r16, g16, b16, want)
+					}
+				}
+			}
+		}
+		for _, a := range []int32{0, 0x4000, 0x8080, 0xfffe} {
+			for v := int32(0); v <= a; v += 0x101 {
+				if got, want := m.index(v, a-v, v/2, a), nearest(values, nil, v, a-v, v/2, a); got != want {
+					t.Fatalf("%s: index %d for %#04x %#04x %#04x %#04x, expected %d", name, got, v, a-v, v/2, a, want)
+				}
+			}
+		}
+	}
+}
+
+// benchmarkColors returns n opaque colors spread over the cube.
+func benchmarkColors(n int) [][3]int32 {
+	c := make([][3]int32, n)
+	for i := range c {
+		c[i] = [3]int32{int32(i*7919) & 0xffff, int32(i*104729) & 0xffff, int32(i*1299709) & 0xffff}
+	}
+	return c
+}
+
+// BenchmarkMatcher compares the matcher with a search through the whole
+// palette, for the palettes of the small matcher and of the cube.
+func BenchmarkMatcher(b *testing.B) {
+	colors := benchmarkColors(4096)
+	for _, n := range []int{4, 8, 16, 216} {
+		values := paletteValues(palette.WebSafe[:n])
+		m := newMatcher(values)
+		b.Run(fmt.Sprintf("nearest/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c := colors[i%len(colors)]
+				nearest(values, nil, c[0], c[1], c[2], 0xffff)
+			}
+		})
+		b.Run(fmt.Sprintf("matcher/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c := colors[i%len(colors)]
+				m.index(c[0], c[1], c[2], 0xffff)
+			}
+		})
+	}
+}
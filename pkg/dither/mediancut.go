@@ -0,0 +1,141 @@
+package dither
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// A colorCount is a distinct color of an image, of 8-bit premultiplied
+// components, and its number of pixels.
+type colorCount struct {
+	c [4]uint8
+	n int
+}
+
+// A colorBox is a set of colors of a median cut, split along the component
+// of the widest range.
+type colorBox struct {
+	colors []colorCount
+	pixels int
+	axis   int   // the component of the widest range
+	width  uint8 // the range of that component
+}
+
+func newColorBox(colors []colorCount) colorBox {
+	b := colorBox{colors: colors}
+	lo, hi := [4]uint8{255, 255, 255, 255}, [4]uint8{}
+	for _, c := range colors {
+		b.pixels += c.n
+		for i, v := range c.c {
+			if v < lo[i] {
+				lo[i] = v
+			}
+			if v > hi[i] {
+				hi[i] = v
+			}
+		}
+	}
+	for i := range lo {
+		if w := hi[i] - lo[i]; w > b.width {
+			b.axis, b.width = i, w
+		}
+	}
+	return b
+}
+
+// MedianCut returns a palette of at most n colors representing img, built by
+// splitting the box of its colors at the median of the pixels along its widest
+// component until there are n boxes, each giving its mean color. The palette
+// holds the exact colors of an image of at most n distinct colors.
+func MedianCut(img image.Image, n int) color.Palette {
+	c := make(colorCounts)
+	c.add(img, img.Bounds(), false)
+	return c.medianCut(n)
+}
+
+// colorCounts counts the pixels of images by color, of 8-bit premultiplied
+// components.
+type colorCounts map[[4]uint8]int
+
+// add counts the pixels of img in r, only the opaque ones if opaque is set.
+func (c colorCounts) add(img image.Image, r image.Rectangle, opaque bool) {
+	pixel := pixelReader(img)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			r, g, b, a := pixel(x, y)
+			if opaque && a != 0xffff {
+				continue
+			}
+			c[[4]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}]++
+		}
+	}
+}
+
+// medianCut returns the MedianCut palette of at most n colors of the pixels
+// counted by c.
+func (c colorCounts) medianCut(n int) color.Palette {
+	colors := make([]colorCount, 0, len(c))
+	for col, k := range c {
+		colors = append(colors, colorCount{col, k})
+	}
+	// The map order is random: sort the colors for a deterministic palette.
+	sort.Slice(colors, func(i, j int) bool {
+		a, b := colors[i].c, colors[j].c
+		return uint32(a[0])<<24|uint32(a[1])<<16|uint32(a[2])<<8|uint32(a[3]) <
+			uint32(b[0])<<24|uint32(b[1])<<16|uint32(b[2])<<8|uint32(b[3])
+	})
+
+	boxes := []colorBox{newColorBox(colors)}
+	for len(boxes) < n {
+		// Split the box of the widest range, the most populated one among
+		// the boxes of the same range.
+		best := -1
+		for i, bx := range boxes {
+			if len(bx.colors) > 1 && (best < 0 || bx.width > boxes[best].width ||
+				bx.width == boxes[best].width && bx.pixels > boxes[best].pixels) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+		bx := boxes[best]
+		axis := bx.axis
+		sort.SliceStable(bx.colors, func(i, j int) bool { return bx.colors[i].c[axis] < bx.colors[j].c[axis] })
+		half, split := 0, 1
+		for i, c := range bx.colors[:len(bx.colors)-1] {
+			if half += c.n; 2*half >= bx.pixels {
+				split = i + 1
+				break
+			}
+		}
+		boxes[best] = newColorBox(bx.colors[:split])
+		boxes = append(boxes, newColorBox(bx.colors[split:]))
+	}
+
+	p := make(color.Palette, 0, len(boxes))
+	for _, bx := range boxes {
+		if bx.pixels == 0 {
+			continue
+		}
+		var sums [4]int
+		for _, c := range bx.colors {
+			for i, v := range c.c {
+				sums[i] += c.n * int(v)
+			}
+		}
+		var m [4]uint8
+		for i, s := range sums {
+			m[i] = uint8((s + bx.pixels/2) / bx.pixels)
+		}
+		// The mean of premultiplied colors may exceed its alpha by rounding.
+		for i := 0; i < 3; i++ {
+			if m[i] > m[3] {
+				m[i] = m[3]
+			}
+		}
+		p = append(p, color.RGBA{m[0], m[1], m[2], m[3]})
+	}
+	return p
+}
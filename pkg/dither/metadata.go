@@ -0,0 +1,83 @@
+package dither
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// Metadata holds the metadata of a decoded image its results may keep, see
+// DecodeWithMetadata and EncodeOptions.Metadata.
+type Metadata struct {
+	// Profile is the color profile of the image, like the one returned by
+	// DecodeWith.
+	Profile *Profile
+	// ICC is the embedded RGB ICC profile still describing the colors of
+	// the decoded image: nil when the image has none, its colors were
+	// converted to sRGB or taken as sRGB ones.
+	ICC []byte
+	// DPIX and DPIY are the resolution in dots per inch, zero when unknown.
+	DPIX, DPIY float64
+	// Orientation is the EXIF orientation of the encoded image, from 1 to
+	// 8, or zero when it has none, see Orient.
+	Orientation int
+}
+
+// pngHeaderLen is the length of the signature and the IHDR chunk starting
+// a PNG image.
+const pngHeaderLen = 8 + 12 + 13
+
+// iccProfileName is the name of the profiles written in the iCCP chunks.
+const iccProfileName = "ICC profile"
+
+// hasPNGChunks reports whether md has a resolution or a profile to write in
+// a PNG image.
+func (md Metadata) hasPNGChunks() bool {
+	return md.ICC != nil || md.DPIX > 0 && md.DPIY > 0
+}
+
+// writePNGChunks writes the pHYs and iCCP chunks of the resolution and
+// profile of md, when it has them, with e.
+func (md Metadata) writePNGChunks(e *PNGWriter) {
+	if md.ICC != nil {
+		var buf bytes.Buffer
+		buf.WriteString(iccProfileName + "\x00\x00") // the name, then the zlib method
+		zw := zlib.NewWriter(&buf)
+		zw.Write(md.ICC)
+		zw.Close()
+		e.chunk("iCCP", buf.Bytes())
+	}
+	if md.DPIX > 0 && md.DPIY > 0 {
+		// The pixels per meter, the unit 1.
+		var phys [9]byte
+		binary.BigEndian.PutUint32(phys[:4], uint32(math.Round(md.DPIX/0.0254)))
+		binary.BigEndian.PutUint32(phys[4:8], uint32(math.Round(md.DPIY/0.0254)))
+		phys[8] = 1
+		e.chunk("pHYs", phys[:])
+	}
+}
+
+// writePNG writes to w the PNG image data with the chunks of md inserted
+// after its header.
+func (md Metadata) writePNG(w io.Writer, data []byte) error {
+	if len(data) < pngHeaderLen {
+		return errors.New("truncated PNG image")
+	}
+	e := &PNGWriter{w: w}
+	_, e.err = w.Write(data[:pngHeaderLen])
+	md.writePNGChunks(e)
+	if e.err != nil {
+		return e.err
+	}
+	_, err := w.Write(data[pngHeaderLen:])
+	return err
+}
+
+// rgbProfile reports whether the ICC profile data is the one of an RGB color
+// space, the only ones a PNG image of colors may embed.
+func rgbProfile(data []byte) bool {
+	return len(data) >= 20 && string(data[16:20]) == "RGB "
+}
@@ -0,0 +1,151 @@
+package dither
+
+import (
+	"image"
+	"math"
+)
+
+// Metrics measures how well a halftone reproduces its source once
+// low-pass filtered, approximating the blur of the human visual system.
+type Metrics struct {
+	MSE   float64 `json:"mse"`
+	PSNR  float64 `json:"psnr_db"`
+	SSIM  float64 `json:"ssim"`
+	Sigma float64 `json:"sigma"`
+}
+
+// maxPSNR caps the PSNR of identical images, which would be infinite.
+const maxPSNR = 100
+
+// plane holds the luminance of an image, between 0 and 1.
+type plane struct {
+	w, h int
+	pix  []float64
+}
+
+func grayPlane(img image.Image) plane {
+	b := img.Bounds()
+	p := plane{w: b.Dx(), h: b.Dy(), pix: make([]float64, b.Dx()*b.Dy())}
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			p.pix[y*p.w+x] = Luminance(img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return p
+}
+
+// gaussianKernel returns the normalized 1D Gaussian kernel of standard
+// deviation sigma, truncated at three sigmas.
+func gaussianKernel(sigma float64) []float64 {
+	radius := int(math.Ceil(3 * sigma))
+	k := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range k {
+		d := float64(i - radius)
+		k[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += k[i]
+	}
+	for i := range k {
+		k[i] /= sum
+	}
+	return k
+}
+
+// blur convolves p with a Gaussian of standard deviation sigma, extending the
+// edges.
+func blur(p plane, sigma float64) plane {
+	if sigma <= 0 {
+		return p
+	}
+	k := gaussianKernel(sigma)
+	r := len(k) / 2
+	clamp := func(v, max int) int {
+		if v < 0 {
+			return 0
+		}
+		if v >= max {
+			return max - 1
+		}
+		return v
+	}
+
+	tmp := make([]float64, len(p.pix))
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			var v float64
+			for i, w := range k {
+				v += w * p.pix[y*p.w+clamp(x+i-r, p.w)]
+			}
+			tmp[y*p.w+x] = v
+		}
+	}
+	out := plane{w: p.w, h: p.h, pix: make([]float64, len(p.pix))}
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			var v float64
+			for i, w := range k {
+				v += w * tmp[clamp(y+i-r, p.h)*p.w+x]
+			}
+			out.pix[y*p.w+x] = v
+		}
+	}
+	return out
+}
+
+// mse returns the mean squared error between a and b.
+func mse(a, b plane) float64 {
+	var sum float64
+	for i := range a.pix {
+		d := a.pix[i] - b.pix[i]
+		sum += d * d
+	}
+	return sum / float64(len(a.pix))
+}
+
+// psnr returns the peak signal-to-noise ratio of the mean squared error mse
+// of planes from 0 to 1.
+func psnr(mse float64) float64 {
+	if mse == 0 {
+		return maxPSNR
+	}
+	return math.Min(maxPSNR, -10*math.Log10(mse))
+}
+
+// ssim returns the mean structural similarity index of a and b, computed
+// with the usual 1.5 standard deviation Gaussian window.
+func ssim(a, b plane) float64 {
+	const (
+		window = 1.5
+		c1     = 0.01 * 0.01
+		c2     = 0.03 * 0.03
+	)
+	product := func(x, y plane) plane {
+		p := plane{w: x.w, h: x.h, pix: make([]float64, len(x.pix))}
+		for i := range p.pix {
+			p.pix[i] = x.pix[i] * y.pix[i]
+		}
+		return p
+	}
+	muA, muB := blur(a, window), blur(b, window)
+	sAA, sBB, sAB := blur(product(a, a), window), blur(product(b, b), window), blur(product(a, b), window)
+
+	var sum float64
+	for i := range a.pix {
+		ma, mb := muA.pix[i], muB.pix[i]
+		va, vb, cov := sAA.pix[i]-ma*ma, sBB.pix[i]-mb*mb, sAB.pix[i]-ma*mb
+		sum += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+	}
+	return sum / float64(len(a.pix))
+}
+
+// ComputeMetrics compares the grayscale source src with the halftone dst,
+// low-pass filtered by a Gaussian of standard deviation sigma. Both images
+// must have the same bounds.
+func ComputeMetrics(src, dst image.Image, sigma float64) Metrics {
+	a, b := grayPlane(src), blur(grayPlane(dst), sigma)
+	if len(a.pix) == 0 {
+		return Metrics{Sigma: sigma}
+	}
+	e := mse(a, b)
+	return Metrics{MSE: e, PSNR: psnr(e), SSIM: ssim(a, b), Sigma: sigma}
+}
@@ -0,0 +1,115 @@
+package dither
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+)
+
+// plainLineLength is the longest line of the plain netpbm formats.
+const plainLineLength = 70
+
+// grayLevels returns the 8-bit luma of the colors of p, the transparent ones
+// being taken over white.
+func grayLevels(p color.Palette) []uint8 {
+	levels := make([]uint8, len(p))
+	for i, c := range p {
+		r, g, b, a := c.RGBA()
+		// The luma of color.GrayModel of the premultiplied components, plus
+		// the white showing through.
+		y := (19595*r+38470*g+7471*b+1<<15)>>16 + 0xffff - a
+		levels[i] = uint8(y >> 8)
+	}
+	return levels
+}
+
+// EncodePBM writes img to w as a PBM bitmap, the pixels of the colors darker
+// than the mid-gray being black, in the plain format with ASCII digits if
+// plain is set and in the binary one otherwise.
+func EncodePBM(w io.Writer, img *image.Paletted, plain bool) error {
+	levels := grayLevels(img.Palette)
+	black := make([]bool, len(levels))
+	for i, l := range levels {
+		black[i] = l < 0x80
+	}
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	if !plain {
+		fmt.Fprintf(bw, "P4\n%d %d\n", b.Dx(), b.Dy())
+		row := make([]byte, (b.Dx()+7)/8)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for i := range row {
+				row[i] = 0
+			}
+			for i, v := range img.Pix[img.PixOffset(b.Min.X, y):][:b.Dx()] {
+				if int(v) < len(black) && black[v] {
+					row[i/8] |= 0x80 >> uint(i%8)
+				}
+			}
+			bw.Write(row)
+		}
+		return bw.Flush()
+	}
+	fmt.Fprintf(bw, "P1\n%d %d\n", b.Dx(), b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for i, v := range img.Pix[img.PixOffset(b.Min.X, y):][:b.Dx()] {
+			if i > 0 && i%plainLineLength == 0 {
+				bw.WriteByte('\n')
+			}
+			if int(v) < len(black) && black[v] {
+				bw.WriteByte('1')
+			} else {
+				bw.WriteByte('0')
+			}
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// EncodePGM writes img to w as a PGM graymap of 8-bit levels, the luma of
+// the colors of the pixels, in the plain format with ASCII numbers if plain is
+// set and in the binary one otherwise.
+func EncodePGM(w io.Writer, img *image.Paletted, plain bool) error {
+	levels := grayLevels(img.Palette)
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	magic := "P5"
+	if plain {
+		magic = "P2"
+	}
+	fmt.Fprintf(bw, "%s\n%d %d\n255\n", magic, b.Dx(), b.Dy())
+	row := make([]byte, b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for i, v := range img.Pix[img.PixOffset(b.Min.X, y):][:b.Dx()] {
+			if int(v) < len(levels) {
+				row[i] = levels[v]
+			} else {
+				row[i] = 0
+			}
+		}
+		if !plain {
+			bw.Write(row)
+			continue
+		}
+		n := 0
+		for _, l := range row {
+			s := strconv.Itoa(int(l))
+			if n > 0 && n+1+len(s) > plainLineLength {
+				bw.WriteByte('\n')
+				n = 0
+			}
+			if n > 0 {
+				bw.WriteByte(' ')
+				n++
+			}
+			bw.WriteString(s)
+			n += len(s)
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
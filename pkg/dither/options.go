@@ -0,0 +1,291 @@
+package dither
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Options holds the settings of the processing of an image. It is a plain
+// value: functions taking Options never modify it, so the same Options may
+// be shared by concurrent calls.
+type Options struct {
+	// Scale is the coefficient applied to the dimensions of the source
+	// image before it is reduced to the palette.
+	Scale float32
+	// Width and Height are the dimensions of the result, replacing Scale
+	// when one of them is set, see SizedBounds: the other one follows the
+	// aspect ratio of the source when it is zero.
+	Width, Height int
+	// Fit makes Width and Height the bounds of the box the result is fitted
+	// in, keeping the aspect ratio of the source.
+	Fit bool
+	// Filter is the name of the interpolation scaling the source, see
+	// Filters. It is nearest-neighbor when empty.
+	Filter string
+	// Geometry holds the crop, rotation and flips of the source before it
+	// is scaled, and the padding of the scaled image.
+	Geometry Geometry
+	// Adjust holds the tone adjustments of the scaled image.
+	Adjust Adjustments
+	// Algorithm is the name of the registered ditherer reducing the scaled
+	// image to the palette.
+	Algorithm string
+	// Palette is the palette the image is reduced to.
+	Palette color.Palette
+	// Colors, when not zero, replaces Palette by the MedianCut palette of at
+	// most that many colors of the scaled and adjusted image.
+	Colors int
+	// Diffusion tunes the ditherers diffusing the quantization error. It
+	// must be the one of DefaultOptions for the other ones.
+	Diffusion Diffusion
+	// Screen sets the threshold and the halftone screen of the ditherers
+	// mapping the pixels to two tones, and the mask of the blue-noise one.
+	// It must be the one of DefaultOptions for the other ones.
+	Screen Screen
+	// Format is the name of the registered output format of the encoded
+	// result, PNG when empty.
+	Format string
+	// Encoding holds the settings of the encoder of Format.
+	Encoding EncodeOptions
+	// Threads is the number of goroutines sharing the stages processing
+	// each pixel independently, the scaling and the parallel ditherers. It
+	// is GOMAXPROCS when zero.
+	Threads int
+	// MaxPixels is the largest number of pixels of the images decoded by
+	// Transform, to refuse an image before allocating it. There is no limit
+	// when it is zero.
+	MaxPixels int64
+	// AssumeSRGB makes Transform take the colors of the images as sRGB
+	// ones instead of converting them to sRGB according to their embedded
+	// color profile, see DecodeWith.
+	AssumeSRGB bool
+	// IgnoreOrientation makes Transform leave the images as they are
+	// encoded instead of turning them according to their EXIF orientation.
+	IgnoreOrientation bool
+}
+
+// DefaultMaxPixels is the MaxPixels of DefaultOptions, 100 megapixels.
+const DefaultMaxPixels = 100 * 1000 * 1000
+
+// An Option modifies the Options it is applied to.
+type Option func(*Options)
+
+// DefaultOptions returns the options of a black and white Floyd-Steinberg
+// dithering, diffusing the whole error from left to right, at the original
+// size of images of at most DefaultMaxPixels, modified by opts in order.
+func DefaultOptions(opts ...Option) Options {
+	o := Options{
+		Scale:     1,
+		Algorithm: "floyd-steinberg",
+		Palette:   BlackAndWhite,
+		Diffusion: defaultDiffusion,
+		Screen:    defaultScreen,
+		Format:    "png",
+		MaxPixels: DefaultMaxPixels,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithScale sets the scaling coefficient.
+func WithScale(s float32) Option {
+	return func(o *Options) { o.Scale = s }
+}
+
+// WithSize sets the dimensions of the result, see Options.Width.
+func WithSize(width, height int) Option {
+	return func(o *Options) { o.Width, o.Height = width, height }
+}
+
+// WithFit sets the box the result is fitted in.
+func WithFit(width, height int) Option {
+	return func(o *Options) { o.Width, o.Height, o.Fit = width, height, true }
+}
+
+// WithFilter sets the name of the scaling filter.
+func WithFilter(name string) Option {
+	return func(o *Options) { o.Filter = name }
+}
+
+// WithGeometry sets the crop, rotation, flips and padding.
+func WithGeometry(g Geometry) Option {
+	return func(o *Options) { o.Geometry = g }
+}
+
+// WithAdjustments sets the tone adjustments.
+func WithAdjustments(a Adjustments) Option {
+	return func(o *Options) { o.Adjust = a }
+}
+
+// WithAlgorithm sets the name of the ditherer.
+func WithAlgorithm(name string) Option {
+	return func(o *Options) { o.Algorithm = name }
+}
+
+// WithPalette sets the palette.
+func WithPalette(p color.Palette) Option {
+	return func(o *Options) { o.Palette = p }
+}
+
+// WithColors sets the number of colors of the palette extracted from the
+// images, see Options.Colors.
+func WithColors(n int) Option {
+	return func(o *Options) { o.Colors = n }
+}
+
+// WithDiffusion sets the settings of the error diffusion.
+func WithDiffusion(d Diffusion) Option {
+	return func(o *Options) { o.Diffusion = d }
+}
+
+// WithScreen sets the threshold, halftone and blue-noise settings.
+func WithScreen(s Screen) Option {
+	return func(o *Options) { o.Screen = s }
+}
+
+// WithFormat sets the format of the encoded result.
+func WithFormat(format string) Option {
+	return func(o *Options) { o.Format = format }
+}
+
+// WithThreads sets the number of goroutines processing an image.
+func WithThreads(n int) Option {
+	return func(o *Options) { o.Threads = n }
+}
+
+// WithMaxPixels sets the largest number of pixels of the decoded images.
+func WithMaxPixels(n int64) Option {
+	return func(o *Options) { o.MaxPixels = n }
+}
+
+// WithAssumeSRGB sets whether the colors of the decoded images are taken as
+// sRGB ones whatever their color profile.
+func WithAssumeSRGB(b bool) Option {
+	return func(o *Options) { o.AssumeSRGB = b }
+}
+
+// WithIgnoreOrientation sets whether the decoded images are left as they are
+// encoded regardless of their EXIF orientation.
+func WithIgnoreOrientation(b bool) Option {
+	return func(o *Options) { o.IgnoreOrientation = b }
+}
+
+// Validate returns a *ValidationError listing all the invalid settings of o,
+// or nil if there is none.
+func (o Options) Validate() error {
+	var problems []string
+	if !(o.Scale > 0) {
+		problems = append(problems, fmt.Sprintf("invalid scale %v, must be positive", o.Scale))
+	}
+	switch {
+	case o.Width < 0 || o.Height < 0:
+		problems = append(problems, fmt.Sprintf("invalid size %dx%d, must not be negative", o.Width, o.Height))
+	case o.Fit && (o.Width == 0 || o.Height == 0):
+		problems = append(problems, fmt.Sprintf("invalid box %dx%d to fit in, needs both a width and a height", o.Width, o.Height))
+	case o.sized() && o.Scale != 1:
+		problems = append(problems, fmt.Sprintf("scale %v set with a size, only one of them can be", o.Scale))
+	}
+	if _, ok := filter(o.Filter); !ok {
+		problems = append(problems, fmt.Sprintf("unknown filter %q, expected one of %v", o.Filter, Filters()))
+	}
+	problems = append(problems, o.Geometry.problems()...)
+	problems = append(problems, o.Adjust.problems()...)
+	d, ok := Lookup(o.Algorithm)
+	if !ok {
+		problems = append(problems, fmt.Sprintf("unknown algorithm %q, expected one of %v", o.Algorithm, Algorithms()))
+	}
+	if s := o.Diffusion.Strength; !(s >= 0 && s <= 1) {
+		problems = append(problems, fmt.Sprintf("invalid diffusion strength %v, must be from 0 to 1", s))
+	}
+	if _, diffuses := d.(DiffusionDitherer); ok && (!diffuses || parallel(d)) && o.Diffusion != defaultDiffusion {
+		problems = append(problems, fmt.Sprintf("algorithm %q does not diffuse the quantization error, its diffusion cannot be tuned", o.Algorithm))
+	}
+	problems = append(problems, o.Screen.problems()...)
+	if _, screens := d.(ScreenDitherer); ok && !screens && o.Screen != defaultScreen {
+		problems = append(problems, fmt.Sprintf("algorithm %q has no threshold, halftone screen or mask to set", o.Algorithm))
+	}
+	if o.Threads < 0 {
+		problems = append(problems, fmt.Sprintf("invalid thread count %d, must not be negative", o.Threads))
+	}
+	if o.MaxPixels < 0 {
+		problems = append(problems, fmt.Sprintf("invalid pixel limit %d, must not be negative", o.MaxPixels))
+	}
+	if n := len(o.Palette); n < 2 || n > 256 {
+		problems = append(problems, fmt.Sprintf("invalid palette of %d colors, must have 2 to 256", n))
+	}
+	if n := o.Colors; n != 0 && (n < 2 || n > 256) {
+		problems = append(problems, fmt.Sprintf("invalid color count %d, must be from 2 to 256", n))
+	}
+	if o.Encoding.RowAlign < 0 {
+		problems = append(problems, fmt.Sprintf("invalid row alignment %d, must not be negative", o.Encoding.RowAlign))
+	}
+	if _, ok := LookupEncoder(o.Format); o.Format != "" && !ok {
+		problems = append(problems, fmt.Sprintf("unknown output format %q", o.Format))
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// sized reports whether o sets the dimensions of the result.
+func (o Options) sized() bool {
+	return o.Width > 0 || o.Height > 0
+}
+
+// Scales reports whether the images are scaled according to o: whether its
+// scale isn't 1 or it sets the dimensions of the result.
+func (o Options) Scales() bool {
+	return o.Scale != 1 || o.sized()
+}
+
+// Adjusts reports whether the tones of the images are adjusted according to
+// o.
+func (o Options) Adjusts() bool {
+	return !o.Adjust.none()
+}
+
+// Reframes reports whether the sources are cropped, rotated or flipped
+// according to o, by the first stage of its pipeline.
+func (o Options) Reframes() bool {
+	return !o.Geometry.Crop.Empty() || o.Geometry.reorients()
+}
+
+// Pads reports whether the images are padded according to o.
+func (o Options) Pads() bool {
+	return o.Geometry.pads()
+}
+
+// palette returns the palette the scaled and adjusted image img is reduced to
+// according to o.
+func (o Options) palette(img image.Image) color.Palette {
+	if o.Colors == 0 {
+		return o.Palette
+	}
+	return MedianCut(img, o.Colors)
+}
+
+// ResultBounds returns the bounds of the result of the processing with o of
+// an image of bounds r: cropped, rotated, scaled and padded.
+func (o Options) ResultBounds(r image.Rectangle) image.Rectangle {
+	r = o.Geometry.bounds(r)
+	if o.Scales() {
+		r = o.ScaledBounds(r)
+	}
+	if o.Geometry.pads() {
+		r = image.Rect(0, 0, o.Geometry.PadWidth, o.Geometry.PadHeight)
+	}
+	return r
+}
+
+// ScaledBounds returns the bounds of the scaling with o of an image of
+// bounds r.
+func (o Options) ScaledBounds(r image.Rectangle) image.Rectangle {
+	if o.sized() {
+		return SizedBounds(r, o.Width, o.Height, o.Fit)
+	}
+	return ScaledBounds(r, o.Scale)
+}
@@ -0,0 +1,110 @@
+package dither
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDefaultOptionsValid(t *testing.T) {
+	o := DefaultOptions()
+	if err := o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if o.Scales() || o.Adjusts() {
+		t.Errorf("default options scale (%v) or adjust (%v) the images", o.Scales(), o.Adjusts())
+	}
+	if o.Algorithm != "floyd-steinberg" || len(o.Palette) != 2 || o.Format != "png" || o.MaxPixels != DefaultMaxPixels {
+		t.Errorf("default options %+v", o)
+	}
+}
+
+func TestDefaultOptionsOrder(t *testing.T) {
+	o := DefaultOptions(WithScale(2), WithAlgorithm("atkinson"), WithScale(3), WithFit(4, 5))
+	if o.Scale != 3 || o.Algorithm != "atkinson" || o.Width != 4 || o.Height != 5 || !o.Fit {
+		t.Errorf("options %+v, expected the last scale, atkinson and a box of 4x5", o)
+	}
+	// The options of a call are not shared with another one.
+	p := DefaultOptions(WithPalette(Grays(4)))
+	if len(DefaultOptions().Palette) != 2 || len(p.Palette) != 4 {
+		t.Errorf("palettes of %d and %d colors, expected 2 and 4", len(DefaultOptions().Palette), len(p.Palette))
+	}
+}
+
+// TestValidate checks that Validate reports all the invalid settings at
+// once.
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		opts Options
+		want []string // the beginnings of the problems
+	}{
+		{DefaultOptions(WithScale(0)), []string{"invalid scale 0"}},
+		{DefaultOptions(WithSize(-1, 2)), []string{"invalid size -1x2"}},
+		{DefaultOptions(WithFit(10, 0)), []string{"invalid box 10x0"}},
+		{DefaultOptions(WithSize(10, 0), WithScale(2)), []string{"scale 2 set with a size"}},
+		{DefaultOptions(WithFilter("sinc")), []string{`unknown filter "sinc"`}},
+		{DefaultOptions(WithAlgorithm("sepia")), []string{`unknown algorithm "sepia"`}},
+		{DefaultOptions(WithDiffusion(Diffusion{Strength: 2})), []string{"invalid diffusion strength 2"}},
+		{DefaultOptions(WithAlgorithm("bayer-4x4"), WithDiffusion(Diffusion{Strength: 0.5})), []string{`algorithm "bayer-4x4" does not diffuse`}},
+		{DefaultOptions(WithAlgorithm("atkinson"), WithScreen(Screen{Threshold: 0.2, DotSize: 6, Angle: 45})), []string{`algorithm "atkinson" has no threshold`}},
+		{DefaultOptions(WithThreads(-1)), []string{"invalid thread count -1"}},
+		{DefaultOptions(WithMaxPixels(-1)), []string{"invalid pixel limit -1"}},
+		{DefaultOptions(WithPalette(Grays(2)[:1])), []string{"invalid palette of 1 colors"}},
+		{DefaultOptions(WithColors(1)), []string{"invalid color count 1"}},
+		{DefaultOptions(WithFormat("jpeg")), []string{`unknown output format "jpeg"`}},
+		{DefaultOptions(WithAdjustments(Adjustments{Brightness: 2, Gamma: -1})), []string{"invalid brightness 2", "invalid gamma -1"}},
+		{DefaultOptions(WithGeometry(Geometry{Rotate: 45})), []string{"invalid rotation 45"}},
+		{
+			DefaultOptions(WithScale(-1), WithAlgorithm("sepia"), WithPalette(nil), WithThreads(-2)),
+			[]string{"invalid scale -1", `unknown algorithm "sepia"`, "invalid thread count -2", "invalid palette of 0 colors"},
+		},
+	} {
+		err := tt.opts.Validate()
+		var ve *ValidationError
+		if !errors.As(err, &ve) {
+			t.Errorf("%v: error %v, expected a *ValidationError", tt.want, err)
+			continue
+		}
+		if len(ve.Problems) != len(tt.want) {
+			t.Errorf("problems %q, expected %d", ve.Problems, len(tt.want))
+			continue
+		}
+		for i, p := range ve.Problems {
+			if !strings.HasPrefix(p, tt.want[i]) {
+				t.Errorf("problem %q, expected %q", p, tt.want[i])
+			}
+		}
+	}
+}
+
+// TestOptionsConcurrentUse checks, run with the race detector, that the
+// same options may be shared by concurrent calls.
+func TestOptionsConcurrentUse(t *testing.T) {
+	src := testImages(t, 48, 32)["nrgba"]
+	var wg sync.WaitGroup
+	for _, alg := range []string{"floyd-steinberg", "bayer-8x8", "blue-noise", "atkinson"} {
+		opts := DefaultOptions(WithAlgorithm(alg), WithScale(0.75), WithFilter("bilinear"), WithPalette(Grays(4)),
+			WithAdjustments(Adjustments{Contrast: 0.2, Gamma: 1.1}))
+		want, err := Process(context.Background(), src, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(alg string) {
+				defer wg.Done()
+				got, err := Process(context.Background(), src, opts)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if n, at := diffPixels(got, want); n > 0 {
+					t.Errorf("%s: %d pixels differ from the first call, the first at %v", alg, n, at)
+				}
+			}(alg)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,111 @@
+package dither
+
+import (
+	"image"
+	"image/color"
+)
+
+// ordered reduces images to a palette by adding to each pixel the threshold
+// of its position in a Bayer matrix before mapping it to the nearest color,
+// which produces the regular crosshatch patterns of the old LCD screens. The
+// pixels being independent, the rows are dithered concurrently.
+type ordered struct {
+	matrix []int32 // the thresholds, from 0 to size²-1, by row
+	size   int
+}
+
+// bayer returns the Bayer matrix of the given size, a power of two.
+func bayer(size int) ordered {
+	m := []int32{0}
+	for n := 1; n < size; n *= 2 {
+		// Each threshold t of the matrix of size n gives 4t, 4t+2, 4t+3 and
+		// 4t+1 in the four quadrants of the matrix of size 2n.
+		next := make([]int32, 4*n*n)
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				t := 4 * m[y*n+x]
+				next[y*2*n+x] = t
+				next[y*2*n+x+n] = t + 2
+				next[(y+n)*2*n+x] = t + 3
+				next[(y+n)*2*n+x+n] = t + 1
+			}
+		}
+		m = next
+	}
+	return ordered{matrix: m, size: size}
+}
+
+func (o ordered) Dither(dst *image.Paletted, src image.Image) error {
+	return o.Bands(dst.Bounds().Dx(), dst.Palette)(dst, src)
+}
+
+// Parallel reports that the pixels are dithered independently.
+func (o ordered) Parallel() bool { return true }
+
+func (o ordered) Bands(width int, p color.Palette) DithererFunc {
+	palette := paletteValues(p)
+	match := newMatcher(palette)
+	// The thresholds are centered on 0 and spread over the spacing of the
+	// palette colors.
+	spread := paletteSpacing(palette)
+	n := int64(len(o.matrix))
+	offsets := make([]int32, n)
+	for i, t := range o.matrix {
+		offsets[i] = int32((2*int64(t) + 1 - n) * spread / (2 * n))
+	}
+	return func(dst *image.Paletted, src image.Image) error {
+		b := dst.Bounds()
+		pixel := pixelReader(src)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := dst.Pix[dst.PixOffset(b.Min.X, y):]
+			// The matrix is anchored at the origin, so that the bands and
+			// the rows of the goroutines continue the pattern.
+			line := offsets[mod(y, o.size)*o.size:][:o.size]
+			for i := 0; i < b.Dx(); i++ {
+				x := b.Min.X + i
+				r, g, bl, a := pixel(x, y)
+				off := line[mod(x, o.size)]
+				row[i] = byte(match.index(clamp(r+off), clamp(g+off), clamp(bl+off), a))
+			}
+		}
+		return nil
+	}
+}
+
+func mod(i, n int) int {
+	if i %= n; i < 0 {
+		i += n
+	}
+	return i
+}
+
+// paletteSpacing returns the mean, over the colors of p, of the largest
+// component difference to the nearest other color: 0xffff for black and
+// white, 0x5555 for four levels of gray.
+func paletteSpacing(p [][4]int32) int64 {
+	if len(p) < 2 {
+		return 0
+	}
+	var sum int64
+	for i, c := range p {
+		nearest := int64(-1)
+		for j, o := range p {
+			if i == j {
+				continue
+			}
+			var d int64
+			for k := 0; k < 3; k++ {
+				if v := int64(c[k] - o[k]); v > d {
+					d = v
+				} else if -v > d {
+					d = -v
+				}
+			}
+			if nearest < 0 || d < nearest {
+				nearest = d
+			}
+		}
+		sum += nearest
+	}
+	return sum / int64(len(p))
+}
@@ -0,0 +1,101 @@
+package dither
+
+import (
+	"image"
+)
+
+// Orient returns img turned the way the EXIF orientation o says it is
+// displayed, from 1, as it is, to 8. It returns img itself for 1 and the
+// unknown orientations. Gray images stay Gray ones, Shrunk images Shrunk
+// ones standing for their oriented source, and the others become RGBA
+// ones.
+func Orient(img image.Image, o int) image.Image {
+	if o < 2 || o > 8 {
+		return img
+	}
+	return orientRect(img, SourceBounds(img), o)
+}
+
+// orientRect returns the part r of img, in the bounds of the source of a
+// Shrunk image, turned by the EXIF orientation o like Orient, copied even
+// for the orientation 1.
+func orientRect(img image.Image, r image.Rectangle, o int) image.Image {
+	if s, ok := img.(*Shrunk); ok {
+		// The blocks of the shrunk image covering r.
+		f, min := s.Factor, r.Min.Sub(s.Source.Min)
+		max := r.Max.Sub(s.Source.Min).Add(image.Pt(f-1, f-1))
+		sr := image.Rect(min.X/f, min.Y/f, max.X/f, max.Y/f).Add(s.RGBA.Rect.Min).Intersect(s.RGBA.Rect)
+		size := r.Size()
+		if o >= 5 {
+			size.X, size.Y = size.Y, size.X
+		}
+		src := image.Rectangle{Min: s.Source.Min, Max: s.Source.Min.Add(size)}
+		return &Shrunk{RGBA: orientRect(s.RGBA, sr, o).(*image.RGBA), Source: src, Factor: s.Factor}
+	}
+
+	b := r
+	w, h := b.Dx(), b.Dy()
+	if o >= 5 {
+		w, h = h, w
+	}
+	r = image.Rect(0, 0, w, h)
+	if g, ok := img.(*image.Gray); ok {
+		dst := &image.Gray{Pix: getPix(w * h), Stride: w, Rect: r}
+		for y := 0; y < h; y++ {
+			row := dst.Pix[y*w : (y+1)*w]
+			for x := range row {
+				sx, sy := orientedSource(o, x, y, b)
+				row[x] = g.Pix[g.PixOffset(sx, sy)]
+			}
+		}
+		return dst
+	}
+	dst := &image.RGBA{Pix: getPix(4 * w * h), Stride: 4 * w, Rect: r}
+	pixel := pixelReader(img)
+	for y := 0; y < h; y++ {
+		row := dst.Pix[y*dst.Stride : (y+1)*dst.Stride]
+		for x := 0; x < w; x++ {
+			cr, cg, cb, ca := pixel(orientedSource(o, x, y, b))
+			p := row[4*x : 4*x+4 : 4*x+4]
+			p[0], p[1], p[2], p[3] = uint8(cr>>8), uint8(cg>>8), uint8(cb>>8), uint8(ca>>8)
+		}
+	}
+	return dst
+}
+
+// rotation returns the EXIF orientation turning images clockwise by the
+// given degrees, 0, 90, 180 or 270.
+func rotation(degrees int) int {
+	switch degrees {
+	case 90:
+		return 6
+	case 180:
+		return 3
+	case 270:
+		return 8
+	}
+	return 1
+}
+
+// orientedSource returns the pixel of an image of bounds b shown at x, y,
+// from 0, 0, once the image is turned by the EXIF orientation o.
+func orientedSource(o, x, y int, b image.Rectangle) (int, int) {
+	last := b.Max.Sub(image.Pt(1, 1))
+	switch o {
+	case 2: // mirrored horizontally
+		return last.X - x, b.Min.Y + y
+	case 3: // rotated by 180°
+		return last.X - x, last.Y - y
+	case 4: // mirrored vertically
+		return b.Min.X + x, last.Y - y
+	case 5: // transposed
+		return b.Min.X + y, b.Min.Y + x
+	case 6: // rotated 90° clockwise to be shown
+		return b.Min.X + y, last.Y - x
+	case 7: // transversed
+		return last.X - y, last.Y - x
+	case 8: // rotated 90° counterclockwise to be shown
+		return last.X - y, b.Min.Y + x
+	}
+	return b.Min.X + x, b.Min.Y + y
+}
@@ -0,0 +1,187 @@
+package dither
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// orientations are the EXIF orientations with where the top left and top
+// right pixels of a stored image of 3x2 pixels are shown.
+var orientations = []struct {
+	o                 int
+	topLeft, topRight image.Point
+}{
+	{1, image.Pt(0, 0), image.Pt(2, 0)},
+	{2, image.Pt(2, 0), image.Pt(0, 0)},
+	{3, image.Pt(2, 1), image.Pt(0, 1)},
+	{4, image.Pt(0, 1), image.Pt(2, 1)},
+	{5, image.Pt(0, 0), image.Pt(0, 2)},
+	{6, image.Pt(1, 0), image.Pt(1, 2)},
+	{7, image.Pt(1, 2), image.Pt(1, 0)},
+	{8, image.Pt(0, 2), image.Pt(0, 0)},
+}
+
+// markedCorners returns a white image of 3x2 pixels at min, of the given
+// type, whose top left pixel is black and top right one gray.
+func markedCorners(min image.Point, gray bool) image.Image {
+	r := image.Rectangle{Min: min, Max: min.Add(image.Pt(3, 2))}
+	var img interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	if gray {
+		img = image.NewGray(r)
+	} else {
+		img = image.NewRGBA(r)
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	img.Set(r.Min.X, r.Min.Y, color.Black)
+	img.Set(r.Max.X-1, r.Min.Y, color.Gray{Y: 0x80})
+	return img
+}
+
+// checkCorners checks that img is shown with the corners of markedCorners at
+// the given points of its bounds.
+func checkCorners(t *testing.T, name string, img image.Image, topLeft, topRight image.Point) {
+	t.Helper()
+	b := img.Bounds()
+	size := image.Pt(3, 2)
+	if topLeft.X == topRight.X {
+		size = image.Pt(2, 3)
+	}
+	if b.Size() != size {
+		t.Errorf("%s: size %v, expected %v", name, b.Size(), size)
+		return
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			want := uint32(0xffff)
+			switch image.Pt(x, y) {
+			case topLeft:
+				want = 0
+			case topRight:
+				want = 0x8080
+			}
+			if l, _, _, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA(); l != want {
+				t.Errorf("%s: pixel %d,%d of level %#04x, expected %#04x", name, x, y, l, want)
+			}
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	for _, tt := range orientations {
+		for _, gray := range []bool{true, false} {
+			src := markedCorners(image.Pt(5, -7), gray)
+			img := Orient(src, tt.o)
+			name := fmt.Sprintf("orientation %d of %T", tt.o, src)
+			checkCorners(t, name, img, tt.topLeft, tt.topRight)
+			if _, ok := img.(*image.Gray); ok != gray {
+				t.Errorf("%s: %T", name, img)
+			}
+			if tt.o == 1 && img != src {
+				t.Errorf("orientation 1 copied the image")
+			}
+		}
+	}
+	for _, o := range []int{0, 9, -1} {
+		src := markedCorners(image.Point{}, true)
+		if img := Orient(src, o); img != src {
+			t.Errorf("unknown orientation %d turned the image", o)
+		}
+	}
+}
+
+// exifOrientation returns the TIFF structure, in the given byte order, of
+// EXIF metadata of the orientation o.
+func exifOrientation(order binary.ByteOrder, o int) []byte {
+	b := make([]byte, 8+2+12+4)
+	copy(b, "MM\x00*")
+	if order == binary.LittleEndian {
+		copy(b, "II*\x00")
+	}
+	order.PutUint32(b[4:], 8)
+	order.PutUint16(b[8:], 1)
+	order.PutUint16(b[10:], tagOrientation)
+	order.PutUint16(b[12:], 3) // SHORT
+	order.PutUint32(b[14:], 1)
+	order.PutUint16(b[18:], uint16(o))
+	return b
+}
+
+// pngWithEXIF returns the PNG image img with the EXIF metadata exif in an
+// eXIf chunk after its header.
+func pngWithEXIF(t *testing.T, img image.Image, exif []byte) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	var chunk bytes.Buffer
+	binary.Write(&chunk, binary.BigEndian, uint32(len(exif)))
+	chunk.WriteString("eXIf")
+	chunk.Write(exif)
+	binary.Write(&chunk, binary.BigEndian, crc32.ChecksumIEEE(chunk.Bytes()[4:]))
+	data := buf.Bytes()
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	return append(append(data[:ihdrEnd:ihdrEnd], chunk.Bytes()...), data[ihdrEnd:]...)
+}
+
+// jpegWithEXIF returns the JPEG image img with the EXIF metadata exif in an
+// APP1 segment after its start.
+func jpegWithEXIF(t *testing.T, img image.Image, exif []byte) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	segment := []byte{0xff, 0xe1, 0, 0}
+	segment = append(append(segment, "Exif\x00\x00"...), exif...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+	data := buf.Bytes()
+	return append(append(data[:2:2], segment...), data[2:]...)
+}
+
+// TestEXIFOrientation checks that the orientation of the EXIF metadata of
+// both byte orders is read from PNG and JPEG images, and that the decoded
+// images are turned by it unless IgnoreOrientation.
+func TestEXIFOrientation(t *testing.T) {
+	src := markedCorners(image.Point{}, true)
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, tt := range orientations {
+			exif := exifOrientation(order, tt.o)
+			for format, data := range map[string][]byte{"png": pngWithEXIF(t, src, exif), "jpeg": jpegWithEXIF(t, src, exif)} {
+				name := fmt.Sprintf("%s of orientation %d in %v", format, tt.o, order)
+				info, err := Inspect(bytes.NewReader(data))
+				if err != nil || info.Orientation != tt.o {
+					t.Errorf("%s: orientation %d, error %v", name, info.Orientation, err)
+				}
+				if format != "png" {
+					continue
+				}
+				img, md, err := DecodeWithMetadata(bytes.NewReader(data), format, DecodeOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if md.Orientation != tt.o {
+					t.Errorf("%s: decoded orientation %d", name, md.Orientation)
+				}
+				checkCorners(t, name, img, tt.topLeft, tt.topRight)
+				img, _, err = DecodeWithMetadata(bytes.NewReader(data), format, DecodeOptions{IgnoreOrientation: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkCorners(t, name+" ignored", img, image.Pt(0, 0), image.Pt(2, 0))
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package dither
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/color/palette"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// palettes are the named palettes of LookupPalette.
+var palettes = map[string]color.Palette{
+	"bw": BlackAndWhite,
+	// The shades of green of the original Game Boy screen, from the darkest.
+	"gameboy": hexPalette("0f380f", "306230", "8bac0f", "9bbc0f"),
+	// The 16 colors of the CGA text mode.
+	"cga": hexPalette(
+		"000000", "0000aa", "00aa00", "00aaaa", "aa0000", "aa00aa", "aa5500", "aaaaaa",
+		"555555", "5555ff", "55ff55", "55ffff", "ff5555", "ff55ff", "ffff55", "ffffff",
+	),
+	// The CGA palette 1 of the 320x200 graphics mode, in high intensity.
+	"cga-mode4": hexPalette("000000", "55ffff", "ff55ff", "ffffff"),
+	"pico-8": hexPalette(
+		"000000", "1d2b53", "7e2553", "008751", "ab5236", "5f574f", "c2c3c7", "fff1e8",
+		"ff004d", "ffa300", "ffec27", "00e436", "29adff", "83769c", "ff77a8", "ffccaa",
+	),
+	// The colors of the e-ink displays of 7 colors.
+	"eink-7":   hexPalette("000000", "ffffff", "00ff00", "0000ff", "ff0000", "ffff00", "ff8000"),
+	"web-safe": palette.WebSafe,
+	"plan9":    palette.Plan9,
+}
+
+// hexPalette returns the palette of the given hex colors, which must be
+// valid.
+func hexPalette(colors ...string) color.Palette {
+	p := make(color.Palette, len(colors))
+	for i, s := range colors {
+		c, err := ParseHexColor(s)
+		if err != nil {
+			panic(err)
+		}
+		p[i] = c
+	}
+	return p
+}
+
+// LookupPalette returns the named palette, see Palettes.
+func LookupPalette(name string) (color.Palette, bool) {
+	p, ok := palettes[name]
+	return p, ok
+}
+
+// Palettes returns the sorted names of the palettes of LookupPalette.
+func Palettes() []string {
+	names := make([]string, 0, len(palettes))
+	for name := range palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Grays returns the palette of n evenly spaced levels of gray, from white to
+// black like BlackAndWhite, which is Grays(2). n must be at least 2.
+func Grays(n int) color.Palette {
+	p := make(color.Palette, n)
+	for i := range p {
+		p[i] = color.Gray{Y: uint8(255 - (255*i+(n-1)/2)/(n-1))}
+	}
+	return p
+}
+
+// ParseHexColor parses an opaque color written with 3 or 6 hex digits, such
+// as #fff or #ff8000, the # being optional.
+func ParseHexColor(s string) (color.RGBA, error) {
+	h := strings.TrimPrefix(s, "#")
+	if len(h) == 3 {
+		h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+	}
+	v, err := strconv.ParseUint(h, 16, 32)
+	if len(h) != 6 || err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q, expected hex digits like #ff8000", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// ParsePalette parses a list of 1 to 256 hex colors, see ParseHexColor,
+// separated by commas or white space.
+func ParsePalette(s string) (color.Palette, error) {
+	var p color.Palette
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		c, err := ParseHexColor(f)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, c)
+	}
+	if len(p) == 0 {
+		return nil, fmt.Errorf("no color in the palette %q", s)
+	}
+	if len(p) > 256 {
+		return nil, fmt.Errorf("palette of %d colors, at most 256 are supported", len(p))
+	}
+	return p, nil
+}
+
+// ReadPalette reads a palette file: either a GIMP palette, whose color lines
+// are the decimal red, green and blue components followed by a name, or hex
+// colors, one or more per line, for 1 to 256 colors. Empty lines and the lines
+// starting with ; are skipped, and so are the comments starting with # of GIMP
+// palettes.
+func ReadPalette(r io.Reader) (color.Palette, error) {
+	var p color.Palette
+	s := bufio.NewScanner(r)
+	gimp := false
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case n == 1 && line == "GIMP Palette":
+			gimp = true
+			continue
+		case line == "" || line[0] == ';', gimp && line[0] == '#':
+			continue
+		case gimp && (strings.HasPrefix(line, "Name:") || strings.HasPrefix(line, "Columns:")):
+			continue
+		}
+		if !gimp {
+			colors, err := ParsePalette(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			p = append(p, colors...)
+			continue
+		}
+		var c [3]uint8
+		fields := strings.Fields(line)
+		for i := range c {
+			var v uint64
+			var err error
+			if i < len(fields) {
+				v, err = strconv.ParseUint(fields[i], 10, 8)
+			}
+			if i >= len(fields) || err != nil {
+				return nil, fmt.Errorf("line %d: invalid color %q, expected red, green and blue from 0 to 255", n, line)
+			}
+			c[i] = uint8(v)
+		}
+		p = append(p, color.RGBA{R: c[0], G: c[1], B: c[2], A: 0xff})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		return nil, errors.New("no color in the palette")
+	}
+	if len(p) > 256 {
+		return nil, fmt.Errorf("palette of %d colors, at most 256 are supported", len(p))
+	}
+	return p, nil
+}
@@ -0,0 +1,117 @@
+package dither
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// hexColors returns n distinct hex colors separated by sep.
+func hexColors(n int, sep string) string {
+	colors := make([]string, n)
+	for i := range colors {
+		colors[i] = fmt.Sprintf("#%02x%02x%02x", i%256, i/256, 0x80)
+	}
+	return strings.Join(colors, sep)
+}
+
+// samePalette returns whether the colors of a and b are the same.
+func samePalette(a, b color.Palette) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParsePalette(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want color.Palette
+		err  string // the beginning of the error, if any
+	}{
+		{s: "#fff", want: color.Palette{color.RGBA{0xff, 0xff, 0xff, 0xff}}},
+		{s: "f80", want: color.Palette{color.RGBA{0xff, 0x88, 0x00, 0xff}}},
+		{s: "#ff8000", want: color.Palette{color.RGBA{0xff, 0x80, 0x00, 0xff}}},
+		{s: "0F380F", want: color.Palette{color.RGBA{0x0f, 0x38, 0x0f, 0xff}}},
+		{s: "#000,fff", want: color.Palette{color.RGBA{0, 0, 0, 0xff}, color.RGBA{0xff, 0xff, 0xff, 0xff}}},
+		{s: " #102030 \t405060,\n708090, ", want: color.Palette{
+			color.RGBA{0x10, 0x20, 0x30, 0xff}, color.RGBA{0x40, 0x50, 0x60, 0xff}, color.RGBA{0x70, 0x80, 0x90, 0xff},
+		}},
+		{s: hexColors(256, ","), want: mustParse(t, hexColors(256, " "))},
+		{s: "", err: `no color in the palette ""`},
+		{s: " , ,", err: "no color in the palette"},
+		{s: "#ff80", err: `invalid color "#ff80"`},
+		{s: "#ff80000", err: `invalid color "#ff80000"`},
+		{s: "##fff", err: `invalid color "##fff"`},
+		{s: "#ggg", err: `invalid color "#ggg"`},
+		{s: "#fff;#000", err: `invalid color "#fff;#000"`},
+		{s: "#fff,+00", err: `invalid color "+00"`},
+		{s: hexColors(257, ","), err: "palette of 257 colors, at most 256"},
+	} {
+		p, err := ParsePalette(tt.s)
+		name := tt.s
+		if len(name) > 20 {
+			name = name[:20] + "..."
+		}
+		if tt.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("%q: error %v, expected %q", name, err, tt.err)
+			}
+		} else if err != nil || !samePalette(p, tt.want) {
+			t.Errorf("%q: palette %v, error %v, expected %v", name, p, err, tt.want)
+		}
+	}
+}
+
+// mustParse returns the palette of the hex colors s.
+func mustParse(t *testing.T, s string) color.Palette {
+	p, err := ParsePalette(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestReadPalette(t *testing.T) {
+	black, white := color.RGBA{0, 0, 0, 0xff}, color.RGBA{0xff, 0xff, 0xff, 0xff}
+	orange := color.RGBA{0xff, 0x80, 0x00, 0xff}
+	for _, tt := range []struct {
+		name, file string
+		want       color.Palette
+		err        string
+	}{
+		{"hex", "#000000\nfff\n#ff8000\n", color.Palette{black, white, orange}, ""},
+		{"hex lines of several colors", "#000 #fff\n\n#ff8000", color.Palette{black, white, orange}, ""},
+		{"comments and blank lines", "; e-ink\n\n  ; black first\n#000\n   \n\t#fff ,\n", color.Palette{black, white}, ""},
+		{"crlf", "#000\r\n#fff\r\n", color.Palette{black, white}, ""},
+		{"gimp", "GIMP Palette\nName: duo\nColumns: 2\n# black and white\n  0   0   0\tBlack\n255 255 255 White\n\n255 128 0\n",
+			color.Palette{black, white, orange}, ""},
+		{"256 colors", hexColors(256, "\n"), mustParse(t, hexColors(256, " ")), ""},
+		{"empty", "", nil, "no color in the palette"},
+		{"only comments", "; nothing\n\n", nil, "no color in the palette"},
+		{"gimp without colors", "GIMP Palette\nName: none\n# nothing\n", nil, "no color in the palette"},
+		{"invalid hex", "#000\n\n#fff\n#12345\n", nil, `line 4: invalid color "#12345"`},
+		{"hex comment", "#000\n# black\n", nil, `line 2: invalid color "#"`},
+		{"gimp component out of range", "GIMP Palette\n0 0 0\n256 0 0 Red\n", nil, `line 3: invalid color "256 0 0 Red"`},
+		{"gimp missing component", "GIMP Palette\n# two\n0 0\n", nil, `line 3: invalid color "0 0"`},
+		{"gimp name without components", "GIMP Palette\n# black\nBlack\n", nil, `line 3: invalid color "Black"`},
+		{"gimp header not first", "\nGIMP Palette\n0 0 0\n", nil, `line 2: invalid color "GIMP"`},
+		{"257 colors", hexColors(257, "\n"), nil, "palette of 257 colors, at most 256"},
+		{"257 colors on a line", "#000\n" + hexColors(257, " "), nil, "line 2: palette of 257 colors"},
+	} {
+		p, err := ReadPalette(strings.NewReader(tt.file))
+		if tt.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, expected %q", tt.name, err, tt.err)
+			}
+		} else if err != nil || !samePalette(p, tt.want) {
+			t.Errorf("%s: palette %v, error %v, expected %v", tt.name, p, err, tt.want)
+		}
+	}
+}
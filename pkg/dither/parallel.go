@@ -0,0 +1,85 @@
+package dither
+
+import (
+	"image"
+	"image/color"
+	"runtime"
+	"sync"
+)
+
+// A ParallelDitherer is a Ditherer able to tell whether it reduces each pixel
+// independently of the others, in which case the rows of an image are split
+// between goroutines, each dithering a sub-image of the destination from the
+// same source. The function returned by Bands of a parallel BandDitherer
+// is called concurrently as well, sharing its setup between the goroutines.
+type ParallelDitherer interface {
+	Ditherer
+	Parallel() bool
+}
+
+// parallel reports whether d may dither the rows of an image concurrently.
+func parallel(d Ditherer) bool {
+	p, ok := d.(ParallelDitherer)
+	return ok && p.Parallel()
+}
+
+// threadCount returns the number of goroutines processing the given number of
+// rows for the Threads option threads.
+func threadCount(threads, rows int) int {
+	if threads <= 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+	if threads > rows {
+		threads = rows
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	return threads
+}
+
+// parallelRows calls f concurrently with up to threads ranges of whole rows
+// splitting r, so that the goroutines don't write to the same cache lines of
+// a destination, and returns the first error.
+func parallelRows(r image.Rectangle, threads int, f func(rows image.Rectangle) error) error {
+	n := threadCount(threads, r.Dy())
+	if n == 1 {
+		return f(r)
+	}
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		rows := image.Rect(r.Min.X, r.Min.Y+i*r.Dy()/n, r.Max.X, r.Min.Y+(i+1)*r.Dy()/n)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = f(rows)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowsDitherer returns the function dithering with d the images, or the
+// successive bands of an image if d is a BandDitherer, of the given width to
+// the palette p, splitting their rows between up to threads goroutines if d is
+// parallel.
+func rowsDitherer(d Ditherer, width int, p color.Palette, threads int) DithererFunc {
+	f := DithererFunc(d.Dither)
+	if bd, ok := d.(BandDitherer); ok {
+		f = bd.Bands(width, p)
+	}
+	if !parallel(d) {
+		return f
+	}
+	return func(dst *image.Paletted, src image.Image) error {
+		return parallelRows(dst.Bounds(), threads, func(rows image.Rectangle) error {
+			return f(dst.SubImage(rows).(*image.Paletted), src)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package dither
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// TestParallelMatchesSerial checks that the results of every algorithm do not
+// depend on the number of goroutines sharing the processing, including
+// more goroutines than rows.
+func TestParallelMatchesSerial(t *testing.T) {
+	ctx := context.Background()
+	src := testImages(t, 53, 37)["nrgba"]
+	for _, alg := range Algorithms() {
+		for _, opts := range []Options{
+			DefaultOptions(WithAlgorithm(alg), WithPalette(Grays(4))),
+			DefaultOptions(WithAlgorithm(alg), WithSize(70, 0), WithFilter("catmull-rom"),
+				WithAdjustments(Adjustments{AutoContrast: true, Contrast: 0.3, Gamma: 0.8})),
+		} {
+			opts.Threads = 1
+			want, err := Process(ctx, src, opts)
+			if err != nil {
+				t.Fatalf("%s: %v", alg, err)
+			}
+			for _, threads := range []int{2, 3, 8, 100} {
+				opts.Threads = threads
+				got, err := Process(ctx, src, opts)
+				if err != nil {
+					t.Fatalf("%s: %v", alg, err)
+				}
+				if n, at := diffPixels(got, want); n > 0 {
+					t.Errorf("%s on %d goroutines with %+v: %d pixels differ from a single one, the first at %v", alg, threads, opts.Adjust, n, at)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkParallel measures the adjustments and the ordered ditherers of a
+// 4K image on 1 to 8 goroutines, the speedup being bound by GOMAXPROCS.
+func BenchmarkParallel(b *testing.B) {
+	src := testRGBA(3840, 2160)
+	for _, alg := range []string{"bayer-8x8", "blue-noise", "halftone"} {
+		for _, threads := range []int{1, 2, 4, 8} {
+			opts := DefaultOptions(WithAlgorithm(alg), WithThreads(threads), WithAdjustments(Adjustments{Contrast: 0.2, Gamma: 1.2}))
+			b.Run(fmt.Sprintf("%s/threads=%d", alg, threads), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					dst, err := Process(context.Background(), src, opts)
+					if err != nil {
+						b.Fatal(err)
+					}
+					Release(dst)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package dither
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"time"
+)
+
+// A Stage is one step of a Pipeline, transforming an image into another.
+type Stage interface {
+	// Name identifies the stage in logs and timings.
+	Name() string
+	Apply(ctx context.Context, img image.Image) (image.Image, error)
+}
+
+type stageFunc struct {
+	name string
+	f    func(context.Context, image.Image) (image.Image, error)
+	// owned is set for the stages of the standard pipelines, returning
+	// either their input or an image of pixels of their own, from getPix.
+	owned bool
+}
+
+// ownedStage returns the stage of a standard pipeline of the given name
+// applying f, see stageFunc.owned.
+func ownedStage(name string, f func(ctx context.Context, img image.Image) (image.Image, error)) Stage {
+	return stageFunc{name: name, f: f, owned: true}
+}
+
+// owns reports whether the image returned by s, when not its input, is owned
+// by the pipeline and may be released.
+func owns(s Stage) bool {
+	f, ok := s.(stageFunc)
+	return ok && f.owned
+}
+
+func (s stageFunc) Name() string { return s.name }
+
+func (s stageFunc) Apply(ctx context.Context, img image.Image) (image.Image, error) {
+	return s.f(ctx, img)
+}
+
+// NewStage returns the Stage of the given name applying f.
+func NewStage(name string, f func(ctx context.Context, img image.Image) (image.Image, error)) Stage {
+	return stageFunc{name: name, f: f}
+}
+
+// ScaleStage returns the "scale" stage rescaling images by s with Scale.
+func ScaleStage(s float32) Stage {
+	return NewStage("scale", func(ctx context.Context, img image.Image) (image.Image, error) {
+		return Scale(ctx, img, s)
+	})
+}
+
+// AdjustStage returns the "adjust" stage applying the tone adjustments a to
+// images with Adjust.
+func AdjustStage(a Adjustments) Stage {
+	return NewStage("adjust", func(ctx context.Context, img image.Image) (image.Image, error) {
+		return Adjust(ctx, img, a)
+	})
+}
+
+// ReduceStage returns the "dither" stage reducing images to the palette p
+// with the named ditherer with Reduce.
+func ReduceStage(p color.Palette, algorithm string) Stage {
+	return NewStage("dither", func(ctx context.Context, img image.Image) (image.Image, error) {
+		return Reduce(ctx, img, p, algorithm)
+	})
+}
+
+// Hooks are called by a Pipeline around each of its stages. Nil hooks are
+// ignored.
+type Hooks struct {
+	// Start is called before the named stage is applied.
+	Start func(stage string)
+	// Done is called once the named stage completed, after d, with the
+	// error it failed with if any.
+	Done func(stage string, d time.Duration, err error)
+}
+
+// A Pipeline applies its stages in order, each to the result of the previous
+// one.
+type Pipeline struct {
+	Stages []Stage
+	Hooks  Hooks
+}
+
+// NewPipeline returns the standard pipeline for opts: the crop, rotation and
+// flips of opts.Geometry, when it has some, scaling, unless the scale is 1
+// and no size is set, tone adjustment, when opts has some, padding, when
+// opts.Geometry sets it, then reduction to the palette, extracted from the
+// image with opts.Colors, as the last stage, like ScaleStage, AdjustStage
+// and ReduceStage but with the filter of opts and on opts.Threads
+// goroutines.
+func NewPipeline(opts Options) (*Pipeline, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return newPipeline(opts), nil
+}
+
+// newPipeline is NewPipeline without the validation of opts.
+func newPipeline(opts Options) *Pipeline {
+	p := &Pipeline{}
+	if opts.Reframes() {
+		p.Stages = append(p.Stages, ownedStage("geometry", func(ctx context.Context, img image.Image) (image.Image, error) {
+			return reframe(img, opts.Geometry)
+		}))
+	}
+	if opts.Scales() {
+		p.Stages = append(p.Stages, ownedStage("scale", func(ctx context.Context, img image.Image) (image.Image, error) {
+			return scale(ctx, img, opts)
+		}))
+	}
+	if opts.Adjusts() {
+		p.Stages = append(p.Stages, ownedStage("adjust", func(ctx context.Context, img image.Image) (image.Image, error) {
+			return adjust(ctx, img, opts.Adjust, opts.Threads)
+		}))
+	}
+	if opts.Pads() {
+		p.Stages = append(p.Stages, ownedStage("pad", func(ctx context.Context, img image.Image) (image.Image, error) {
+			return pad(img, opts.Geometry), nil
+		}))
+	}
+	p.Stages = append(p.Stages, ownedStage("dither", func(ctx context.Context, img image.Image) (image.Image, error) {
+		return reduce(ctx, img, opts.palette(img), opts.Algorithm, opts.Diffusion, opts.Screen, opts.Threads)
+	}))
+	return p
+}
+
+// prepare returns img scaled and adjusted according to opts, the image the
+// standard pipeline reduces to the palette.
+func prepare(ctx context.Context, img image.Image, opts Options) (image.Image, error) {
+	p := newPipeline(opts)
+	p.Stages = p.Stages[:len(p.Stages)-1]
+	return p.Run(ctx, img)
+}
+
+// Run applies the stages of p to img. It returns ctx.Err() without starting
+// the next stage once ctx is done. The images of the stages of the standard
+// pipelines, when passed from one of them to the next, are released for
+// reuse once the next one is done with them, but the image the last stage is
+// applied to, which stays valid. The images returned by the other stages and
+// the ones passed to them are left to their owners.
+func (p *Pipeline) Run(ctx context.Context, img image.Image) (image.Image, error) {
+	in := img
+	owned := false // img was returned by a stage of a standard pipeline
+	for i, s := range p.Stages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if p.Hooks.Start != nil {
+			p.Hooks.Start(s.Name())
+		}
+		start := time.Now()
+		out, err := s.Apply(ctx, img)
+		if p.Hooks.Done != nil {
+			p.Hooks.Done(s.Name(), time.Since(start), err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if owned && owns(s) && img != in && out != img && i < len(p.Stages)-1 {
+			Release(img)
+		}
+		// The images passed to or returned by the other stages may be
+		// kept by them and are not released.
+		owned = owns(s) && (owned || out != img)
+		img = out
+	}
+	return img, nil
+}
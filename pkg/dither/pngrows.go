@@ -0,0 +1,478 @@
+package dither
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+)
+
+// The PNG color types.
+const (
+	pngGray      = 0
+	pngRGB       = 2
+	pngPaletted  = 3
+	pngGrayAlpha = 4
+	pngRGBA      = 6
+)
+
+// pngRows decodes the pixels of a non-interlaced PNG image row by row, to
+// rows of the image types of png.Decode.
+type pngRows struct {
+	r     *bufio.Reader
+	crc   hash.Hash32
+	rec   *headerRecorder // the chunks up to the pixels
+	width int
+	// height is the number of rows, y the next one decoded.
+	height, y int
+
+	depth, colorType int
+	// trns is the transparent sample of a gray or RGB image, nil when it
+	// has none.
+	trns    []uint16
+	palette color.Palette
+
+	idat int // the bytes left in the current IDAT chunk
+	zr   io.ReadCloser
+	bpp  int // the bytes per pixel of the filters, at least 1
+	// curr and prev are the filter type and the samples of the current and
+	// previous rows, zeroes before the first one.
+	curr, prev []byte
+	readErr    error // the error ending the IDAT chunks
+}
+
+// readPNGRows reads the header of the PNG image read from r, up to its
+// pixels, and returns the decoder of its rows. The chunks are recorded by
+// rec. It fails with an error wrapping ErrNotStreamable for an interlaced
+// image.
+func readPNGRows(r io.Reader, rec *headerRecorder) (*pngRows, error) {
+	p := &pngRows{r: bufio.NewReader(r), crc: crc32.NewIEEE(), rec: rec}
+	var sig [8]byte
+	if _, err := io.ReadFull(p.r, sig[:]); err != nil {
+		return nil, noEOF(err)
+	}
+	if string(sig[:]) != "\x89PNG\r\n\x1a\n" {
+		return nil, errors.New("not a PNG file")
+	}
+	rec.Write(sig[:])
+	for {
+		n, name, err := p.chunkHeader()
+		if err != nil {
+			return nil, err
+		}
+		if name == "IDAT" {
+			if p.width == 0 {
+				return nil, errors.New("missing IHDR chunk")
+			}
+			if p.colorType == pngPaletted && p.palette == nil {
+				return nil, errors.New("missing PLTE chunk")
+			}
+			p.idat = n
+			return p, p.start()
+		}
+		if n > maxProfileHead {
+			return nil, fmt.Errorf("%s chunk of %d bytes is too large", name, n)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(p.r, data); err != nil {
+			return nil, noEOF(err)
+		}
+		p.crc.Write(data)
+		if err := p.chunkFooter(); err != nil {
+			return nil, err
+		}
+		rec.Write(data)
+		var crc [4]byte
+		binary.BigEndian.PutUint32(crc[:], p.crc.Sum32())
+		rec.Write(crc[:])
+		switch name {
+		case "IHDR":
+			err = p.parseIHDR(data)
+		case "PLTE":
+			err = p.parsePLTE(data)
+		case "tRNS":
+			err = p.parseTRNS(data)
+		case "IEND":
+			err = errors.New("no image data")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// noEOF returns err, io.ErrUnexpectedEOF for io.EOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// chunkHeader reads the length and the name of the next chunk, which starts
+// its checksum, and records them.
+func (p *pngRows) chunkHeader() (int, string, error) {
+	var h [8]byte
+	if _, err := io.ReadFull(p.r, h[:]); err != nil {
+		return 0, "", noEOF(err)
+	}
+	n := binary.BigEndian.Uint32(h[:4])
+	if n > 1<<31-1 {
+		return 0, "", fmt.Errorf("invalid chunk length %d", n)
+	}
+	p.crc.Reset()
+	p.crc.Write(h[4:])
+	p.rec.Write(h[:])
+	return int(n), string(h[4:]), nil
+}
+
+// chunkFooter reads and checks the checksum of the chunk read.
+func (p *pngRows) chunkFooter() error {
+	var crc [4]byte
+	if _, err := io.ReadFull(p.r, crc[:]); err != nil {
+		return noEOF(err)
+	}
+	if binary.BigEndian.Uint32(crc[:]) != p.crc.Sum32() {
+		return errors.New("invalid checksum")
+	}
+	return nil
+}
+
+func (p *pngRows) parseIHDR(data []byte) error {
+	if len(data) != 13 {
+		return errors.New("invalid IHDR chunk")
+	}
+	w, h := binary.BigEndian.Uint32(data), binary.BigEndian.Uint32(data[4:])
+	if w == 0 || h == 0 || w > 1<<31-1 || h > 1<<31-1 {
+		return fmt.Errorf("invalid image size %dx%d", w, h)
+	}
+	p.width, p.height = int(w), int(h)
+	p.depth, p.colorType = int(data[8]), int(data[9])
+	depths := map[int][]int{
+		pngGray:      {1, 2, 4, 8, 16},
+		pngRGB:       {8, 16},
+		pngPaletted:  {1, 2, 4, 8},
+		pngGrayAlpha: {8, 16},
+		pngRGBA:      {8, 16},
+	}[p.colorType]
+	valid := false
+	for _, d := range depths {
+		valid = valid || d == p.depth
+	}
+	if !valid {
+		return fmt.Errorf("unsupported bit depth %d of color type %d", p.depth, p.colorType)
+	}
+	if data[10] != 0 || data[11] != 0 {
+		return errors.New("unsupported compression or filter method")
+	}
+	if data[12] != 0 {
+		return fmt.Errorf("%w: interlaced PNG image", ErrNotStreamable)
+	}
+	return nil
+}
+
+func (p *pngRows) parsePLTE(data []byte) error {
+	if p.colorType != pngPaletted {
+		return nil // a suggested palette
+	}
+	n := len(data) / 3
+	if len(data)%3 != 0 || n == 0 || n > 1<<uint(p.depth) {
+		return errors.New("invalid PLTE chunk")
+	}
+	// Like png.Decode, the indexes out of the palette are opaque black.
+	p.palette = make(color.Palette, 256)
+	for i := range p.palette {
+		p.palette[i] = color.RGBA{0, 0, 0, 0xff}
+		if i < n {
+			p.palette[i] = color.RGBA{data[3*i], data[3*i+1], data[3*i+2], 0xff}
+		}
+	}
+	return nil
+}
+
+func (p *pngRows) parseTRNS(data []byte) error {
+	switch p.colorType {
+	case pngPaletted:
+		if p.palette == nil || len(data) > 256 {
+			return errors.New("invalid tRNS chunk")
+		}
+		for i, a := range data {
+			c := p.palette[i].(color.RGBA)
+			p.palette[i] = color.NRGBA{c.R, c.G, c.B, a}
+		}
+	case pngGray, pngRGB:
+		if len(data) != 2 && len(data) != 6 || len(data) == 6 != (p.colorType == pngRGB) {
+			return errors.New("invalid tRNS chunk")
+		}
+		for i := 0; i < len(data); i += 2 {
+			p.trns = append(p.trns, binary.BigEndian.Uint16(data[i:]))
+		}
+	}
+	return nil
+}
+
+// samples returns the number of samples of a pixel.
+func (p *pngRows) samples() int {
+	return map[int]int{pngGray: 1, pngRGB: 3, pngPaletted: 1, pngGrayAlpha: 2, pngRGBA: 4}[p.colorType]
+}
+
+// start sets up the decompression of the pixels. The rows are allocated by
+// the first readRow, once the caller has checked the size of the image.
+func (p *pngRows) start() error {
+	p.bpp = (p.depth*p.samples() + 7) / 8
+	zr, err := zlib.NewReader(idatReader{p})
+	if err != nil {
+		return noEOF(err)
+	}
+	p.zr = zr
+	return nil
+}
+
+// idatReader reads the data of the successive IDAT chunks of a pngRows.
+type idatReader struct {
+	p *pngRows
+}
+
+func (r idatReader) Read(b []byte) (int, error) {
+	p := r.p
+	for p.idat == 0 {
+		if p.readErr != nil {
+			return 0, p.readErr
+		}
+		if err := p.chunkFooter(); err != nil {
+			p.readErr = err
+			return 0, err
+		}
+		n, name, err := p.chunkHeader()
+		if err == nil && name != "IDAT" {
+			err = io.EOF // the end of the pixels
+		}
+		if err != nil {
+			p.readErr = err
+			return 0, err
+		}
+		p.idat = n
+	}
+	if len(b) > p.idat {
+		b = b[:p.idat]
+	}
+	n, err := p.r.Read(b)
+	p.crc.Write(b[:n])
+	p.idat -= n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// bytesPerPixel returns the number of bytes of a pixel of the images the rows
+// are decoded to.
+func (p *pngRows) bytesPerPixel() int {
+	switch {
+	case p.colorType == pngPaletted || p.colorType == pngGray && p.trns == nil && p.depth <= 8:
+		return 1
+	case p.colorType == pngGray && p.trns == nil:
+		return 2
+	case p.depth == 16:
+		return 8
+	}
+	return 4
+}
+
+// image returns the image with the pixels pix and bounds r of the type the
+// rows are decoded to, the one of png.Decode.
+func (p *pngRows) image(pix []byte, r image.Rectangle) image.Image {
+	stride := p.bytesPerPixel() * r.Dx()
+	pix = pix[:stride*r.Dy()]
+	opaque := p.trns == nil
+	switch {
+	case p.colorType == pngPaletted:
+		return &image.Paletted{Pix: pix, Stride: stride, Rect: r, Palette: p.palette}
+	case p.colorType == pngGray && opaque && p.depth <= 8:
+		return &image.Gray{Pix: pix, Stride: stride, Rect: r}
+	case p.colorType == pngGray && opaque:
+		return &image.Gray16{Pix: pix, Stride: stride, Rect: r}
+	case p.colorType == pngRGB && opaque && p.depth == 8:
+		return &image.RGBA{Pix: pix, Stride: stride, Rect: r}
+	case p.colorType == pngRGB && opaque:
+		return &image.RGBA64{Pix: pix, Stride: stride, Rect: r}
+	case p.depth == 16:
+		return &image.NRGBA64{Pix: pix, Stride: stride, Rect: r}
+	}
+	return &image.NRGBA{Pix: pix, Stride: stride, Rect: r}
+}
+
+// readRow decodes the next row to row, the pixels of a row of the image
+// type of the rows.
+func (p *pngRows) readRow(row []byte) error {
+	if p.y >= p.height {
+		return io.EOF
+	}
+	if p.curr == nil {
+		n := (p.width*p.depth*p.samples() + 7) / 8
+		p.prev = make([]byte, 1+n)
+		p.curr = make([]byte, 1+n)
+	}
+	if _, err := io.ReadFull(p.zr, p.curr); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errors.New("not enough pixel data")
+		}
+		return err
+	}
+	p.y++
+	if err := unfilter(p.curr[0], p.curr[1:], p.prev[1:], p.bpp); err != nil {
+		return err
+	}
+	p.convert(row, p.curr[1:])
+	p.prev, p.curr = p.curr, p.prev
+	return nil
+}
+
+// unfilter reverses the filter of the given type of the row cur, whose
+// previous row is prev, for pixels of bpp bytes.
+func unfilter(filter byte, cur, prev []byte, bpp int) error {
+	switch filter {
+	case 0: // none
+	case 1: // sub
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += cur[i-bpp]
+		}
+	case 2: // up
+		for i, p := range prev {
+			cur[i] += p
+		}
+	case 3: // average
+		for i := 0; i < bpp; i++ {
+			cur[i] += prev[i] / 2
+		}
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += uint8((int(cur[i-bpp]) + int(prev[i])) / 2)
+		}
+	case 4: // Paeth
+		for i := range cur {
+			var a, c int
+			if i >= bpp {
+				a, c = int(cur[i-bpp]), int(prev[i-bpp])
+			}
+			cur[i] += paeth(a, int(prev[i]), c)
+		}
+	default:
+		return fmt.Errorf("invalid filter type %d", filter)
+	}
+	return nil
+}
+
+// paeth returns whichever of a, b and c is the nearest to a+b-c.
+func paeth(a, b, c int) uint8 {
+	pa, pb, pc := abs(b-c), abs(a-c), abs(a+b-2*c)
+	switch {
+	case pa <= pb && pa <= pc:
+		return uint8(a)
+	case pb <= pc:
+		return uint8(b)
+	}
+	return uint8(c)
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// convert converts the unfiltered samples cur of a row to the pixels row of
+// the image type of the rows.
+func (p *pngRows) convert(row, cur []byte) {
+	w := p.width
+	switch {
+	case p.depth < 8:
+		// The gray levels are scaled to 8 bits, the indexes kept.
+		scale := byte(0xff / (1<<uint(p.depth) - 1))
+		if p.colorType == pngPaletted {
+			scale = 1
+		}
+		perByte := 8 / p.depth
+		mask := byte(1<<uint(p.depth) - 1)
+		for x := 0; x < w; x++ {
+			shift := uint(8 - p.depth*(x%perByte+1))
+			v := cur[x/perByte] >> shift & mask
+			if p.bytesPerPixel() == 1 {
+				row[x] = v * scale
+				continue
+			}
+			a := byte(0xff)
+			if v == uint8(p.trns[0]) {
+				a = 0
+			}
+			px := row[4*x : 4*x+4 : 4*x+4]
+			px[0], px[1], px[2], px[3] = v*scale, v*scale, v*scale, a
+		}
+	case p.colorType == pngPaletted || p.colorType == pngGray && p.trns == nil:
+		copy(row, cur)
+	case p.colorType == pngGray && p.depth == 8:
+		for x, v := range cur[:w] {
+			a := byte(0xff)
+			if v == uint8(p.trns[0]) {
+				a = 0
+			}
+			px := row[4*x : 4*x+4 : 4*x+4]
+			px[0], px[1], px[2], px[3] = v, v, v, a
+		}
+	case p.colorType == pngGray:
+		for x := 0; x < w; x++ {
+			v := cur[2*x : 2*x+2]
+			a := byte(0xff)
+			if binary.BigEndian.Uint16(v) == p.trns[0] {
+				a = 0
+			}
+			px := row[8*x : 8*x+8 : 8*x+8]
+			px[0], px[1], px[2], px[3], px[4], px[5], px[6], px[7] = v[0], v[1], v[0], v[1], v[0], v[1], a, a
+		}
+	case p.colorType == pngRGB && p.depth == 8:
+		for x := 0; x < w; x++ {
+			v := cur[3*x : 3*x+3 : 3*x+3]
+			a := byte(0xff)
+			if p.trns != nil && v[0] == uint8(p.trns[0]) && v[1] == uint8(p.trns[1]) && v[2] == uint8(p.trns[2]) {
+				a = 0
+			}
+			px := row[4*x : 4*x+4 : 4*x+4]
+			px[0], px[1], px[2], px[3] = v[0], v[1], v[2], a
+		}
+	case p.colorType == pngRGB:
+		for x := 0; x < w; x++ {
+			v := cur[6*x : 6*x+6 : 6*x+6]
+			a := byte(0xff)
+			if p.trns != nil && binary.BigEndian.Uint16(v) == p.trns[0] && binary.BigEndian.Uint16(v[2:]) == p.trns[1] && binary.BigEndian.Uint16(v[4:]) == p.trns[2] {
+				a = 0
+			}
+			px := row[8*x : 8*x+8 : 8*x+8]
+			copy(px, v)
+			px[6], px[7] = a, a
+		}
+	case p.colorType == pngGrayAlpha && p.depth == 8:
+		for x := 0; x < w; x++ {
+			px := row[4*x : 4*x+4 : 4*x+4]
+			px[0], px[1], px[2], px[3] = cur[2*x], cur[2*x], cur[2*x], cur[2*x+1]
+		}
+	case p.colorType == pngGrayAlpha:
+		for x := 0; x < w; x++ {
+			v := cur[4*x : 4*x+4 : 4*x+4]
+			px := row[8*x : 8*x+8 : 8*x+8]
+			px[0], px[1], px[2], px[3], px[4], px[5], px[6], px[7] = v[0], v[1], v[0], v[1], v[0], v[1], v[2], v[3]
+		}
+	default: // RGBA
+		copy(row, cur)
+	}
+}
+
+// close releases the decompressor.
+func (p *pngRows) close() {
+	p.zr.Close()
+}
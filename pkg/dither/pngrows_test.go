@@ -0,0 +1,391 @@
+package dither
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// A testPNG describes a PNG file written by encode, of random samples.
+type testPNG struct {
+	colorType, depth int
+	interlaced       bool
+	// trns adds a tRNS chunk: the sample of the first pixel for the gray
+	// and RGB images, alphas for the paletted ones.
+	trns bool
+}
+
+// pngDepths are the bit depths of each color type.
+var pngDepths = map[int][]int{
+	pngGray:      {1, 2, 4, 8, 16},
+	pngRGB:       {8, 16},
+	pngPaletted:  {1, 2, 4, 8},
+	pngGrayAlpha: {8, 16},
+	pngRGBA:      {8, 16},
+}
+
+// testPNGs returns the descriptions of the PNG files of every color type,
+// bit depth and interlacing, with and without a tRNS chunk when allowed.
+func testPNGs() []testPNG {
+	var ps []testPNG
+	for _, ct := range []int{pngGray, pngRGB, pngPaletted, pngGrayAlpha, pngRGBA} {
+		for _, d := range pngDepths[ct] {
+			for _, interlaced := range []bool{false, true} {
+				ps = append(ps, testPNG{ct, d, interlaced, false})
+				if ct != pngGrayAlpha && ct != pngRGBA {
+					ps = append(ps, testPNG{ct, d, interlaced, true})
+				}
+			}
+		}
+	}
+	return ps
+}
+
+func (tp testPNG) String() string {
+	s := fmt.Sprintf("color type %d, depth %d", tp.colorType, tp.depth)
+	if tp.interlaced {
+		s += ", interlaced"
+	}
+	if tp.trns {
+		s += ", tRNS"
+	}
+	return s
+}
+
+// encode returns a PNG file of the w x h image of samples drawn from rng,
+// whose rows are filtered with the 5 filter types in turn.
+func (tp testPNG) encode(w, h int, rng *rand.Rand) []byte {
+	samples := map[int]int{pngGray: 1, pngRGB: 3, pngPaletted: 1, pngGrayAlpha: 2, pngRGBA: 4}[tp.colorType]
+	bits := tp.depth * samples
+	bpp := (bits + 7) / 8
+	pix := make([][]uint16, h)
+	for y := range pix {
+		pix[y] = make([]uint16, w*samples)
+		for i := range pix[y] {
+			pix[y][i] = uint16(rng.Intn(1 << uint(tp.depth)))
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr, uint32(w))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(h))
+	ihdr[8], ihdr[9] = byte(tp.depth), byte(tp.colorType)
+	if tp.interlaced {
+		ihdr[12] = 1
+	}
+	writeChunk(&buf, "IHDR", ihdr)
+	if tp.colorType == pngPaletted {
+		// Fewer colors than indexes, those out of the palette being
+		// opaque black.
+		n := 1<<uint(tp.depth) - 1
+		plte := make([]byte, 3*n)
+		rng.Read(plte)
+		writeChunk(&buf, "PLTE", plte)
+	}
+	if tp.trns {
+		var trns []byte
+		switch tp.colorType {
+		case pngPaletted:
+			trns = make([]byte, 1<<uint(tp.depth)/2)
+			rng.Read(trns)
+		default:
+			for _, v := range pix[0][:samples] {
+				trns = append(trns, byte(v>>8), byte(v))
+			}
+		}
+		writeChunk(&buf, "tRNS", trns)
+	}
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	passes := []struct{ x, y, dx, dy int }{{0, 0, 1, 1}}
+	if tp.interlaced {
+		passes = []struct{ x, y, dx, dy int }{
+			{0, 0, 8, 8}, {4, 0, 8, 8}, {0, 4, 4, 8}, {2, 0, 4, 4}, {0, 2, 2, 4}, {1, 0, 2, 2}, {0, 1, 1, 2},
+		}
+	}
+	n := 0
+	for _, pass := range passes {
+		var prev []byte
+		for y := pass.y; y < h; y += pass.dy {
+			var samples []uint16
+			for x := pass.x; x < w; x += pass.dx {
+				samples = append(samples, pix[y][x*len(pix[y])/w:(x+1)*len(pix[y])/w]...)
+			}
+			if len(samples) == 0 {
+				break
+			}
+			row := packSamples(samples, tp.depth)
+			if prev == nil {
+				prev = make([]byte, len(row))
+			}
+			filter := byte(n % 5)
+			n++
+			zw.Write(append([]byte{filter}, filterRow(filter, row, prev, bpp)...))
+			prev = row
+		}
+	}
+	zw.Close()
+	// The pixels are split in two IDAT chunks.
+	data := z.Bytes()
+	writeChunk(&buf, "IDAT", data[:len(data)/2])
+	writeChunk(&buf, "IDAT", data[len(data)/2:])
+	writeChunk(&buf, "IEND", nil)
+	return buf.Bytes()
+}
+
+// packSamples returns the bytes of the samples of depth bits, big-endian.
+func packSamples(samples []uint16, depth int) []byte {
+	var b []byte
+	switch depth {
+	case 16:
+		for _, s := range samples {
+			b = append(b, byte(s>>8), byte(s))
+		}
+	case 8:
+		for _, s := range samples {
+			b = append(b, byte(s))
+		}
+	default:
+		b = make([]byte, (len(samples)*depth+7)/8)
+		for i, s := range samples {
+			b[i*depth/8] |= byte(s) << uint(8-depth-i*depth%8)
+		}
+	}
+	return b
+}
+
+// filterRow returns the bytes of row filtered with the given filter type,
+// prev being the previous row.
+func filterRow(filter byte, row, prev []byte, bpp int) []byte {
+	out := make([]byte, len(row))
+	for i := range row {
+		var a, c int
+		if i >= bpp {
+			a, c = int(row[i-bpp]), int(prev[i-bpp])
+		}
+		b := int(prev[i])
+		switch filter {
+		case 0:
+		case 1:
+			out[i] = row[i] - byte(a)
+			continue
+		case 2:
+			out[i] = row[i] - byte(b)
+			continue
+		case 3:
+			out[i] = row[i] - byte((a+b)/2)
+			continue
+		case 4:
+			out[i] = row[i] - paeth(a, b, c)
+			continue
+		}
+		out[i] = row[i]
+	}
+	return out
+}
+
+func writeChunk(buf *bytes.Buffer, name string, data []byte) {
+	var h [8]byte
+	binary.BigEndian.PutUint32(h[:], uint32(len(data)))
+	copy(h[4:], name)
+	buf.Write(h[:])
+	buf.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write(h[4:])
+	crc.Write(data)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
+
+// decodeRows decodes the PNG file data row by row, refusing the images of
+// more than 1<<20 pixels like DecodeStream with MaxPixels.
+func decodeRows(data []byte) (image.Image, error) {
+	p, err := readPNGRows(bytes.NewReader(data), &headerRecorder{format: "png"})
+	if err != nil {
+		return nil, err
+	}
+	defer p.close()
+	if int64(p.width)*int64(p.height) > 1<<20 {
+		return nil, ErrTooLarge
+	}
+	n := p.bytesPerPixel() * p.width
+	pix := make([]byte, n*p.height)
+	for y := 0; y < p.height; y++ {
+		if err := p.readRow(pix[y*n : (y+1)*n]); err != nil {
+			return nil, err
+		}
+	}
+	return p.image(pix, image.Rect(0, 0, p.width, p.height)), nil
+}
+
+// samePixels returns an error telling how got differs from want, of the
+// image type and the colors of every pixel.
+func samePixels(got, want image.Image) error {
+	if fmt.Sprintf("%T", got) != fmt.Sprintf("%T", want) {
+		return fmt.Errorf("image of type %T, expected %T", got, want)
+	}
+	if got.Bounds() != want.Bounds() {
+		return fmt.Errorf("bounds %v, expected %v", got.Bounds(), want.Bounds())
+	}
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if g, w := got.At(x, y), want.At(x, y); g != w {
+				return fmt.Errorf("color %v at %d,%d, expected %v", g, x, y, w)
+			}
+		}
+	}
+	return nil
+}
+
+// TestPNGRowsMatchesDecode checks that the rows of the PNG files of every
+// color type and bit depth are decoded to the image of png.Decode, and that
+// the interlaced files are refused with ErrNotStreamable.
+func TestPNGRowsMatchesDecode(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, tp := range testPNGs() {
+		// Widths that fill the bytes of the rows or not.
+		for _, w := range []int{1, 7, 33} {
+			data := tp.encode(w, 11, rng)
+			want, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("%v, width %d: png.Decode: %v", tp, w, err)
+			}
+			got, err := decodeRows(data)
+			if tp.interlaced {
+				if !errors.Is(err, ErrNotStreamable) {
+					t.Errorf("%v, width %d: error %v, expected ErrNotStreamable", tp, w, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%v, width %d: %v", tp, w, err)
+			}
+			if err := samePixels(got, want); err != nil {
+				t.Errorf("%v, width %d: %v", tp, w, err)
+			}
+		}
+	}
+}
+
+// TestPNGRowsRefusesInvalidFiles checks that the invalid PNG files fail
+// with an error.
+func TestPNGRowsRefusesInvalidFiles(t *testing.T) {
+	valid := testPNG{colorType: pngRGB, depth: 8}.encode(8, 8, rand.New(rand.NewSource(2)))
+	ihdr := func(w, h uint32, depth, colorType, compression byte) []byte {
+		data := make([]byte, 13)
+		binary.BigEndian.PutUint32(data, w)
+		binary.BigEndian.PutUint32(data[4:], h)
+		data[8], data[9], data[10] = depth, colorType, compression
+		return data
+	}
+	// file returns a PNG file of the given chunks.
+	file := func(chunks ...interface{}) []byte {
+		var buf bytes.Buffer
+		buf.WriteString("\x89PNG\r\n\x1a\n")
+		for i := 0; i < len(chunks); i += 2 {
+			data, _ := chunks[i+1].([]byte)
+			writeChunk(&buf, chunks[i].(string), data)
+		}
+		return buf.Bytes()
+	}
+	// pixels returns the compressed rows of n bytes of the given filter.
+	pixels := func(rows, n int, filter byte) []byte {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		for y := 0; y < rows; y++ {
+			zw.Write(append([]byte{filter}, make([]byte, n)...))
+		}
+		zw.Close()
+		return z.Bytes()
+	}
+	badCRC := append([]byte(nil), valid...)
+	badCRC[8+8+13]++
+	for name, data := range map[string][]byte{
+		"empty":               nil,
+		"signature":           append([]byte("\x89PNG\r\n\x1a\r"), valid[8:]...),
+		"checksum":            badCRC,
+		"no IHDR":             file("IDAT", pixels(1, 3, 0)),
+		"short IHDR":          file("IHDR", make([]byte, 12)),
+		"zero width":          file("IHDR", ihdr(0, 1, 8, pngGray, 0)),
+		"bit depth":           file("IHDR", ihdr(1, 1, 4, pngRGB, 0)),
+		"color type":          file("IHDR", ihdr(1, 1, 8, 5, 0)),
+		"compression":         file("IHDR", ihdr(1, 1, 8, pngGray, 1)),
+		"no PLTE":             file("IHDR", ihdr(1, 1, 8, pngPaletted, 0), "IDAT", pixels(1, 1, 0)),
+		"large PLTE":          file("IHDR", ihdr(1, 1, 1, pngPaletted, 0), "PLTE", make([]byte, 9)),
+		"short gray tRNS":     file("IHDR", ihdr(1, 1, 8, pngGray, 0), "tRNS", make([]byte, 1)),
+		"no image data":       file("IHDR", ihdr(1, 1, 8, pngGray, 0), "IEND", nil),
+		"filter type":         file("IHDR", ihdr(4, 2, 8, pngGray, 0), "IDAT", pixels(2, 4, 5)),
+		"missing rows":        file("IHDR", ihdr(4, 3, 8, pngGray, 0), "IDAT", pixels(2, 4, 0), "IEND", nil),
+		"compressed data":     file("IHDR", ihdr(4, 2, 8, pngGray, 0), "IDAT", []byte("not zlib")),
+		"chunk after IHDR":    valid[:8+8+13+4+2],
+		"truncated IDAT data": valid[:len(valid)-len("IEND")-20],
+	} {
+		if _, err := decodeRows(data); err == nil {
+			t.Errorf("%s: decoded an invalid file", name)
+		}
+	}
+}
+
+// TestPNGRowsCorruptFiles checks that truncated and corrupted PNG files of
+// every kind are decoded without panicking, either with an error or to the
+// image of png.Decode. The corrupted chunks have a valid checksum, so that
+// the decoding goes past it.
+func TestPNGRowsCorruptFiles(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for _, tp := range testPNGs() {
+		if tp.interlaced {
+			continue
+		}
+		data := tp.encode(9, 5, rng)
+		want, _ := png.Decode(bytes.NewReader(data))
+		for n := 0; n < len(data); n++ {
+			if got, err := decodeRows(data[:n]); err == nil {
+				if err := samePixels(got, want); err != nil {
+					t.Errorf("%v, truncated to %d bytes: %v", tp, n, err)
+				}
+			}
+		}
+		for i := 0; i < 200; i++ {
+			corrupt := corruptChunk(data, rng)
+			got, err := decodeRows(corrupt)
+			if err != nil {
+				continue
+			}
+			if want, err := png.Decode(bytes.NewReader(corrupt)); err == nil {
+				if err := samePixels(got, want); err != nil {
+					t.Errorf("%v, corrupted: %v", tp, err)
+				}
+			}
+		}
+	}
+}
+
+// corruptChunk returns a copy of the PNG file data with random bytes of the
+// data of one of its chunks changed, and its checksum updated.
+func corruptChunk(data []byte, rng *rand.Rand) []byte {
+	data = append([]byte(nil), data...)
+	var chunks []int // the offsets of the chunks
+	for off := 8; off+12 <= len(data); off += 12 + int(binary.BigEndian.Uint32(data[off:])) {
+		chunks = append(chunks, off)
+	}
+	off := chunks[rng.Intn(len(chunks))]
+	n := int(binary.BigEndian.Uint32(data[off:]))
+	if n == 0 {
+		return data
+	}
+	for i := 0; i < 1+rng.Intn(3); i++ {
+		data[off+8+rng.Intn(n)] = byte(rng.Intn(256))
+	}
+	binary.BigEndian.PutUint32(data[off+8+n:], crc32.ChecksumIEEE(data[off+4:off+8+n]))
+	return data
+}
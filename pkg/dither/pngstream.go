@@ -0,0 +1,160 @@
+package dither
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+)
+
+// A PNGWriter encodes a paletted image as PNG band by band, without holding
+// the whole image. It writes the same bytes as png.Encode.
+type PNGWriter struct {
+	w      io.Writer
+	bounds image.Rectangle
+	bits   int
+	y      int // next row to write
+	zw     *zlib.Writer
+	bw     *bufio.Writer
+	row    []byte
+	err    error
+}
+
+// NewPNGWriter writes to w the header of a PNG image of the given bounds and
+// palette and returns the writer of its pixels. The rows are given with
+// WriteBand, from top to bottom, and the image is completed by Close.
+func NewPNGWriter(w io.Writer, bounds image.Rectangle, p color.Palette) (*PNGWriter, error) {
+	return newPNGWriter(w, bounds, p, Metadata{})
+}
+
+// newPNGWriter is NewPNGWriter writing the chunks of md in the header.
+func newPNGWriter(w io.Writer, bounds image.Rectangle, p color.Palette, md Metadata) (*PNGWriter, error) {
+	if bounds.Empty() {
+		return nil, &EncodeError{Err: fmt.Errorf("invalid image size %dx%d", bounds.Dx(), bounds.Dy())}
+	}
+	if len(p) < 1 || len(p) > 256 {
+		return nil, &EncodeError{Err: fmt.Errorf("bad palette length %d", len(p))}
+	}
+	e := &PNGWriter{w: w, bounds: bounds, bits: PackedBits(p), y: bounds.Min.Y}
+	e.row = make([]byte, 1+(bounds.Dx()*e.bits+7)/8) // filter type, then pixels
+
+	if _, err := io.WriteString(w, "\x89PNG\r\n\x1a\n"); err != nil {
+		return nil, &EncodeError{Err: err}
+	}
+	var ihdr [13]byte
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(bounds.Dy()))
+	ihdr[8] = byte(e.bits)
+	ihdr[9] = 3 // paletted color type
+	e.chunk("IHDR", ihdr[:])
+	md.writePNGChunks(e)
+
+	plte := make([]byte, 3*len(p))
+	trns := make([]byte, len(p))
+	last := -1
+	for i, c := range p {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		plte[3*i], plte[3*i+1], plte[3*i+2] = n.R, n.G, n.B
+		if n.A != 0xff {
+			last = i
+		}
+		trns[i] = n.A
+	}
+	e.chunk("PLTE", plte)
+	if last != -1 {
+		e.chunk("tRNS", trns[:last+1])
+	}
+	if e.err != nil {
+		return nil, &EncodeError{Err: e.err}
+	}
+
+	// Like png.Encode, buffer the compressed data in IDAT chunks of 32 KiB.
+	e.bw = bufio.NewWriterSize(idatWriter{e}, 1<<15)
+	e.zw = zlib.NewWriter(e.bw)
+	return e, nil
+}
+
+// WriteBand writes the rows of band, which must follow the last written rows
+// and have the width of the image.
+func (e *PNGWriter) WriteBand(band *image.Paletted) error {
+	b := band.Bounds()
+	if b.Min.X != e.bounds.Min.X || b.Max.X != e.bounds.Max.X || b.Min.Y != e.y || b.Max.Y > e.bounds.Max.Y {
+		return &EncodeError{Err: fmt.Errorf("band %v does not follow row %d of the image %v", b, e.y, e.bounds)}
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		px := e.row[1:]
+		for i := range px {
+			px[i] = 0
+		}
+		pix := band.Pix[band.PixOffset(b.Min.X, y):]
+		for i := 0; i < b.Dx(); i++ {
+			j := i * e.bits
+			px[j/8] |= pix[i] << uint(8-e.bits-j%8)
+		}
+		if _, err := e.zw.Write(e.row); err != nil {
+			return &EncodeError{Err: err}
+		}
+	}
+	e.y = b.Max.Y
+	return nil
+}
+
+// Close completes the image. It fails if not all the rows were written.
+func (e *PNGWriter) Close() error {
+	if e.y != e.bounds.Max.Y {
+		return &EncodeError{Err: fmt.Errorf("missing rows %d to %d of the image %v", e.y, e.bounds.Max.Y-1, e.bounds)}
+	}
+	if err := e.zw.Close(); err != nil {
+		return &EncodeError{Err: err}
+	}
+	if err := e.bw.Flush(); err != nil {
+		return &EncodeError{Err: err}
+	}
+	e.chunk("IEND", nil)
+	if e.err != nil {
+		return &EncodeError{Err: e.err}
+	}
+	return nil
+}
+
+// chunk writes a PNG chunk, keeping the first error.
+func (e *PNGWriter) chunk(name string, data []byte) {
+	if e.err != nil {
+		return
+	}
+	if uint64(len(data)) > 1<<31-1 {
+		e.err = errors.New(name + " chunk is too large")
+		return
+	}
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], name)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	var footer [4]byte
+	binary.BigEndian.PutUint32(footer[:], crc.Sum32())
+	for _, b := range [][]byte{header[:], data, footer[:]} {
+		if _, e.err = e.w.Write(b); e.err != nil {
+			return
+		}
+	}
+}
+
+// idatWriter writes each slice of compressed data as an IDAT chunk.
+type idatWriter struct {
+	e *PNGWriter
+}
+
+func (w idatWriter) Write(b []byte) (int, error) {
+	w.e.chunk("IDAT", b)
+	if w.e.err != nil {
+		return 0, w.e.err
+	}
+	return len(b), nil
+}
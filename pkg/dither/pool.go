@@ -0,0 +1,74 @@
+package dither
+
+import (
+	"image"
+	"image/png"
+	"math/bits"
+	"sync"
+)
+
+// pixPools hold the pixel buffers released for reuse, by size class: the
+// class of a buffer is the bit length of its capacity, so that the images of
+// similar sizes share their buffers.
+var pixPools [bits.UintSize + 1]sync.Pool
+
+// getPix returns a zeroed pixel buffer of length n, reusing a released buffer
+// of the size class of n if one is large enough.
+func getPix(n int) []uint8 {
+	pool := &pixPools[bits.Len(uint(n))]
+	if v, ok := pool.Get().(*[]uint8); ok {
+		if b := *v; cap(b) >= n {
+			b = b[:n]
+			// No pixel of the previous image may show through.
+			for i := range b {
+				b[i] = 0
+			}
+			return b
+		}
+		pool.Put(v)
+	}
+	return make([]uint8, n)
+}
+
+// putPix releases the pixel buffer b for reuse by getPix.
+func putPix(b []uint8) {
+	if cap(b) == 0 {
+		return
+	}
+	pixPools[bits.Len(uint(cap(b)))].Put(&b)
+}
+
+// Release hands the pixels of img back for reuse by the processing of the
+// next images, which then allocates less when they have a similar size. It is
+// meant for the images returned by Scale, Reduce, Process and the pipelines
+// once they are encoded, and ignores the images other than *image.RGBA,
+// *image.Gray, *image.Paletted and Shrunk ones. Neither img nor its
+// sub-images may be used afterwards.
+func Release(img image.Image) {
+	switch m := img.(type) {
+	case *image.RGBA:
+		putPix(m.Pix)
+	case *image.Gray:
+		putPix(m.Pix)
+	case *image.Paletted:
+		putPix(m.Pix)
+	case *Shrunk:
+		putPix(m.Pix)
+	}
+}
+
+// pngBuffers reuses the compression buffers of the PNG encoder between
+// images.
+type pngBuffers struct {
+	pool sync.Pool
+}
+
+func (p *pngBuffers) Get() *png.EncoderBuffer {
+	b, _ := p.pool.Get().(*png.EncoderBuffer)
+	return b
+}
+
+func (p *pngBuffers) Put(b *png.EncoderBuffer) { p.pool.Put(b) }
+
+// pngEncoder is the PNG encoder of the package, safe for concurrent use.
+var pngEncoder = png.Encoder{BufferPool: &pngBuffers{}}
@@ -0,0 +1,193 @@
+package dither
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"testing"
+)
+
+// TestGetPixZeroed checks that the buffers reused by getPix hold no byte of
+// their previous image.
+func TestGetPixZeroed(t *testing.T) {
+	for _, n := range []int{1, 100, 4096, 5000} {
+		for i := 0; i < 10; i++ {
+			dirty := make([]uint8, n+i)
+			for j := range dirty {
+				dirty[j] = 0xff
+			}
+			putPix(dirty)
+			b := getPix(n)
+			if len(b) != n {
+				t.Fatalf("getPix(%d) returned %d bytes", n, len(b))
+			}
+			if j := bytes.IndexByte(b, 0xff); j >= 0 {
+				t.Fatalf("getPix(%d) returned a buffer with a previous byte at %d", n, j)
+			}
+		}
+	}
+}
+
+// TestReleaseNoLeak checks that processing an image after releasing the
+// results of a very different one gives the results of processing it alone,
+// through every stage allocating from the pools.
+func TestReleaseNoLeak(t *testing.T) {
+	ctx := context.Background()
+	images := testImages(t, 90, 70)
+	white := uniform(90, 70, 0xff)
+	for _, opts := range []Options{
+		DefaultOptions(),
+		DefaultOptions(WithAlgorithm("bayer-8x8"), WithColors(8)),
+		DefaultOptions(WithScale(0.5), WithFilter("bilinear")),
+		DefaultOptions(WithSize(120, 0), WithAdjustments(Adjustments{AutoContrast: true, Gamma: 0.8})),
+		DefaultOptions(WithGeometry(Geometry{Rotate: 90}), WithPalette(Grays(4))),
+	} {
+		for _, name := range []string{"rgba", "gray", "paletted"} {
+			src := images[name]
+			want, err := Process(ctx, src, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want = clonePaletted(want)
+			// Fill the pools with the intermediate images of white and
+			// with buffers of the size classes of 0xff bytes.
+			for i := 0; i < 4; i++ {
+				m, err := Process(ctx, white, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				Release(m)
+				for _, n := range []int{90 * 70, 4 * 90 * 70, 4 * 120 * 93} {
+					dirty := make([]uint8, n)
+					for j := range dirty {
+						dirty[j] = 0xff
+					}
+					putPix(dirty)
+				}
+			}
+			got, err := Process(ctx, src, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, at := diffPixels(got, want); n > 0 {
+				t.Errorf("%s with %+v after a white image: %d pixels differ, the first at %v", name, opts, n, at)
+			}
+			Release(got)
+		}
+	}
+}
+
+// TestPipelineKeepsReduced checks that the standard pipelines release the
+// images of their stages but the one reduced by the last stage, which the
+// callers may still read, like the statistics of fls.
+func TestPipelineKeepsReduced(t *testing.T) {
+	ctx := context.Background()
+	opts := DefaultOptions(WithScale(0.5), WithAdjustments(Adjustments{Gamma: 1.5}))
+	p, err := NewPipeline(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reduced *image.RGBA
+	last := len(p.Stages) - 1
+	reduce := p.Stages[last]
+	p.Stages[last] = NewStage(reduce.Name(), func(ctx context.Context, img image.Image) (image.Image, error) {
+		reduced = img.(*image.RGBA)
+		return reduce.Apply(ctx, img)
+	})
+	if _, err := p.Run(ctx, testRGBA(200, 100)); err != nil {
+		t.Fatal(err)
+	}
+	want := append([]uint8(nil), reduced.Pix...)
+	for i := 0; i < 4; i++ {
+		m, err := Process(ctx, testRGBA(100, 200), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		Release(m)
+	}
+	if !bytes.Equal(reduced.Pix, want) {
+		t.Error("the image reduced by the last stage was overwritten by the next images")
+	}
+}
+
+// TestPipelineKeepsForeign checks that the images passed to or returned by
+// stages spliced into a standard pipeline are not released, whether the
+// stages keep them, return a sub-image of their input or their input itself.
+func TestPipelineKeepsForeign(t *testing.T) {
+	ctx := context.Background()
+	opts := DefaultOptions(WithScale(0.5), WithAdjustments(Adjustments{Gamma: 1.5}))
+	for _, name := range []string{"keep", "sub-image", "input"} {
+		p, err := NewPipeline(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var kept *image.RGBA
+		var want []uint8
+		custom := NewStage(name, func(ctx context.Context, img image.Image) (image.Image, error) {
+			kept = img.(*image.RGBA)
+			want = append([]uint8(nil), kept.Pix...)
+			switch name {
+			case "keep":
+				return image.NewRGBA(img.Bounds()), nil
+			case "sub-image":
+				return kept.SubImage(kept.Rect), nil
+			}
+			return img, nil
+		})
+		// After the scaling, before the adjustment and the dithering.
+		p.Stages = append(p.Stages[:1], append([]Stage{custom}, p.Stages[1:]...)...)
+		if _, err := p.Run(ctx, testRGBA(200, 100)); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(kept.Pix, want) || reused(kept.Pix) {
+			t.Errorf("%s: the image of the custom stage was released", name)
+		}
+	}
+}
+
+// reused reports whether the pixel buffer pix was released: whether getPix
+// returns it, as it does with the ones released the latest.
+func reused(pix []uint8) bool {
+	for i := 0; i < 8; i++ {
+		if b := getPix(len(pix)); &b[0] == &pix[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// clonePaletted returns a copy of m not sharing its pixels.
+func clonePaletted(m *image.Paletted) *image.Paletted {
+	c := *m
+	c.Pix = append([]uint8(nil), m.Pix...)
+	return &c
+}
+
+// BenchmarkRelease measures the allocations of processing and encoding an
+// image of about 1 megapixel, halved and adjusted, with and without releasing
+// the results.
+func BenchmarkRelease(b *testing.B) {
+	ctx := context.Background()
+	src := testRGBA(1152, 864)
+	opts := DefaultOptions(WithScale(0.5), WithAdjustments(Adjustments{Gamma: 1.2}))
+	for _, release := range []bool{false, true} {
+		b.Run(fmt.Sprintf("release=%v", release), func(b *testing.B) {
+			b.ReportAllocs()
+			var buf bytes.Buffer
+			for i := 0; i < b.N; i++ {
+				m, err := Process(ctx, src, opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				buf.Reset()
+				if err := Encode(&buf, m, "png", EncodeOptions{}); err != nil {
+					b.Fatal(err)
+				}
+				if release {
+					Release(m)
+				}
+			}
+		})
+	}
+}
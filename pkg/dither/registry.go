@@ -0,0 +1,88 @@
+package dither
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+	"sync"
+)
+
+// A Ditherer reduces the colors of src to the palette of dst, which has the
+// same bounds.
+type Ditherer interface {
+	Dither(dst *image.Paletted, src image.Image) error
+}
+
+// DithererFunc adapts an ordinary function to the Ditherer interface.
+type DithererFunc func(dst *image.Paletted, src image.Image) error
+
+// Dither calls f(dst, src).
+func (f DithererFunc) Dither(dst *image.Paletted, src image.Image) error {
+	return f(dst, src)
+}
+
+var registry = struct {
+	sync.RWMutex
+	m map[string]Ditherer
+}{m: make(map[string]Ditherer)}
+
+// Register makes a ditherer available under the given name, for Lookup and
+// the Algorithm option. It fails if the name is empty or already taken.
+func Register(name string, d Ditherer) error {
+	if name == "" {
+		return errors.New("dither: registering a ditherer without name")
+	}
+	if d == nil {
+		return fmt.Errorf("dither: registering a nil ditherer as %q", name)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.m[name]; ok {
+		return fmt.Errorf("dither: a ditherer is already registered as %q", name)
+	}
+	registry.m[name] = d
+	return nil
+}
+
+// MustRegister is like Register but panics on failure. It is meant for the
+// registrations made in init functions.
+func MustRegister(name string, d Ditherer) {
+	if err := Register(name, d); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the ditherer registered under the given name.
+func Lookup(name string) (Ditherer, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	d, ok := registry.m[name]
+	return d, ok
+}
+
+// Algorithms returns the sorted names of the registered ditherers.
+func Algorithms() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	names := make([]string, 0, len(registry.m))
+	for name := range registry.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	MustRegister("floyd-steinberg", errorDiffusion(true))
+	MustRegister("nearest", errorDiffusion(false))
+	for name, k := range kernels {
+		MustRegister(name, kernelDiffusion{k, defaultDiffusion})
+	}
+	MustRegister("bayer-4x4", bayer(4))
+	MustRegister("bayer-8x8", bayer(8))
+	MustRegister("blue-noise", blueNoise{defaultScreen.Seed})
+	MustRegister("threshold", threshold{defaultScreen.Threshold})
+	MustRegister("otsu", otsu{})
+	MustRegister("halftone", halftone{defaultScreen.DotSize, defaultScreen.Angle})
+}
@@ -0,0 +1,120 @@
+package dither
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// unregister removes the ditherer registered as name by a test.
+func unregister(name string) {
+	registry.Lock()
+	defer registry.Unlock()
+	delete(registry.m, name)
+}
+
+func TestAlgorithms(t *testing.T) {
+	want := []string{
+		"atkinson", "bayer-4x4", "bayer-8x8", "blue-noise", "burkes", "floyd-steinberg", "halftone",
+		"jarvis-judice-ninke", "nearest", "otsu", "sierra", "sierra-lite", "sierra-two-row", "stucki", "threshold",
+	}
+	if got := Algorithms(); !reflect.DeepEqual(got, want) {
+		t.Errorf("algorithms %q, expected %q", got, want)
+	}
+	for _, name := range want {
+		if d, ok := Lookup(name); !ok || d == nil {
+			t.Errorf("%s: ditherer %v, found %v", name, d, ok)
+		}
+	}
+	for _, name := range []string{"", "Atkinson", "floyd_steinberg", " atkinson", "bayer"} {
+		if d, ok := Lookup(name); ok {
+			t.Errorf("%q: found %v", name, d)
+		}
+	}
+	if d, _ := Lookup("atkinson"); !reflect.DeepEqual(d, kernelDiffusion{kernels["atkinson"], defaultDiffusion}) {
+		t.Errorf("atkinson of %v", d)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	var calls int
+	d := DithererFunc(func(dst *image.Paletted, src image.Image) error {
+		calls++
+		return nil
+	})
+	defer unregister("test-register")
+	if err := Register("test-register", d); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Reduce(context.Background(), uniform(4, 4, 0x80), BlackAndWhite, "test-register"); err != nil || calls != 1 {
+		t.Errorf("reduction with the registered ditherer of error %v, %d calls", err, calls)
+	}
+	found := false
+	for _, name := range Algorithms() {
+		found = found || name == "test-register"
+	}
+	if !found {
+		t.Errorf("test-register not among the algorithms %q", Algorithms())
+	}
+
+	for _, tt := range []struct {
+		name string
+		d    Ditherer
+		err  string
+	}{
+		{"", d, "dither: registering a ditherer without name"},
+		{"test-nil", nil, `dither: registering a nil ditherer as "test-nil"`},
+		{"test-register", d, `dither: a ditherer is already registered as "test-register"`},
+		{"atkinson", d, `dither: a ditherer is already registered as "atkinson"`},
+	} {
+		if err := Register(tt.name, tt.d); err == nil || err.Error() != tt.err {
+			t.Errorf("%q: error %v, expected %q", tt.name, err, tt.err)
+		}
+	}
+	if _, ok := Lookup("test-nil"); ok {
+		t.Error("nil ditherer registered")
+	}
+	if d, _ := Lookup("atkinson"); !reflect.DeepEqual(d, kernelDiffusion{kernels["atkinson"], defaultDiffusion}) {
+		t.Errorf("atkinson replaced by %v", d)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("MustRegister of a taken name did not panic")
+		}
+	}()
+	MustRegister("atkinson", d)
+}
+
+// TestRegisterConcurrently checks, run with the race detector, that the
+// ditherers may be registered while others are looked up.
+func TestRegisterConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("test-concurrent-%d", i)
+		defer unregister(name)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := Register(name, errorDiffusion(false)); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, ok := Lookup("floyd-steinberg"); !ok {
+				t.Error("floyd-steinberg not found")
+			}
+			Algorithms()
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 8; i++ {
+		if _, ok := Lookup(fmt.Sprintf("test-concurrent-%d", i)); !ok {
+			t.Errorf("test-concurrent-%d not registered", i)
+		}
+	}
+}
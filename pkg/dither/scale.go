@@ -0,0 +1,220 @@
+package dither
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// ScaledBounds returns the bounds of an image of bounds r once scaled by s.
+func ScaledBounds(r image.Rectangle, s float32) image.Rectangle {
+	return image.Rect(0, 0, int(float32(r.Dx())*s), int(float32(r.Dy())*s))
+}
+
+// SizedBounds returns the bounds of an image of bounds r once scaled to the
+// given width and height. When one of them is zero, it follows the aspect
+// ratio of r. With fit, the result is instead the largest one of the aspect
+// ratio of r within width x height. A side is at least one pixel.
+func SizedBounds(r image.Rectangle, width, height int, fit bool) image.Rectangle {
+	w, h := int64(r.Dx()), int64(r.Dy())
+	if w <= 0 || h <= 0 {
+		return image.Rectangle{}
+	}
+	if fit {
+		// The side limiting the scaling is kept, the other one is derived.
+		if int64(width)*h <= int64(height)*w {
+			height = 0
+		} else {
+			width = 0
+		}
+	}
+	switch {
+	case width == 0:
+		width = int((int64(height)*w + h/2) / h)
+	case height == 0:
+		height = int((int64(width)*h + w/2) / w)
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	return image.Rect(0, 0, width, height)
+}
+
+// filters are the interpolations scaling the images, by name.
+var filters = map[string]draw.Interpolator{
+	"nearest":         draw.NearestNeighbor,
+	"approx-bilinear": draw.ApproxBiLinear,
+	"bilinear":        draw.BiLinear,
+	"catmull-rom":     draw.CatmullRom,
+}
+
+// Filters returns the names of the scaling filters, sorted.
+func Filters() []string {
+	names := make([]string, 0, len(filters))
+	for name := range filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// filter returns the interpolation of the named filter, nearest-neighbor for
+// an empty name, and whether it exists.
+func filter(name string) (draw.Interpolator, bool) {
+	if name == "" {
+		return draw.NearestNeighbor, true
+	}
+	f, ok := filters[name]
+	return f, ok
+}
+
+// scaleBandRows is the number of rows Scale produces between two checks of
+// its context.
+const scaleBandRows = 256
+
+// Scale rescales img by s with the nearest-neighbor algorithm, on GOMAXPROCS
+// goroutines. It returns img itself when s is 1, and ctx.Err() if ctx is done
+// before the scaling completes.
+func Scale(ctx context.Context, img image.Image, s float32) (image.Image, error) {
+	return scale(ctx, img, Options{Scale: s})
+}
+
+// scale rescales img to the bounds of the result of opts, with its filter and
+// on up to opts.Threads goroutines.
+func scale(ctx context.Context, img image.Image, opts Options) (image.Image, error) {
+	rect, ok := scaling(img, opts)
+	if !ok {
+		return img, ctx.Err()
+	}
+	f, _ := filter(opts.Filter)
+	dst := newScaled(img, f, getPix(scaledBytes(img, f)*rect.Dx()*rect.Dy()), rect, scaledPalette(img, f))
+	if err := scaleRows(ctx, dst, rect, img, f, opts.Threads); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// compact reports whether img is scaled with f to an image of one byte per
+// pixel: the Gray and Gray16 images are scaled by nearest-neighbor to Gray
+// ones and the Paletted ones to Paletted ones, instead of RGBA ones, so that
+// they are kept compact through the dithering. The other filters interpolate
+// colors out of their palette.
+func compact(img image.Image, f draw.Interpolator) bool {
+	switch img.(type) {
+	case *image.Gray, *image.Gray16, *image.Paletted:
+		return f == draw.NearestNeighbor
+	}
+	return false
+}
+
+// scaledBytes returns the number of bytes per pixel of the image img is
+// scaled to with f.
+func scaledBytes(img image.Image, f draw.Interpolator) int {
+	if compact(img, f) {
+		return 1
+	}
+	return 4
+}
+
+// scaledPalette returns the palette of the image the Paletted img is scaled
+// to with f, nil for the other images. Its colors are truncated to 8-bit
+// premultiplied components like the ones of an image scaled to RGBA.
+func scaledPalette(img image.Image, f draw.Interpolator) color.Palette {
+	m, ok := img.(*image.Paletted)
+	if !ok || !compact(img, f) {
+		return nil
+	}
+	p := make(color.Palette, len(m.Palette))
+	for i, c := range m.Palette {
+		r, g, b, a := c.RGBA()
+		p[i] = color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+	}
+	return p
+}
+
+// newScaled returns the image of bounds r with the zeroed pixels pix that img
+// is scaled to with f, of palette p if it is Paletted.
+func newScaled(img image.Image, f draw.Interpolator, pix []uint8, r image.Rectangle, p color.Palette) draw.Image {
+	if !compact(img, f) {
+		return &image.RGBA{Pix: pix, Stride: 4 * r.Dx(), Rect: r}
+	}
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		return &image.Gray{Pix: pix, Stride: r.Dx(), Rect: r}
+	case *image.Paletted:
+		return &image.Paletted{Pix: pix, Stride: r.Dx(), Rect: r, Palette: p}
+	}
+	return &image.RGBA{Pix: pix, Stride: 4 * r.Dx(), Rect: r}
+}
+
+// scaleRows scales img with f to the rectangle r of which dst, from
+// newScaled, holds some rows, over the current content of dst, on up to
+// threads goroutines.
+func scaleRows(ctx context.Context, dst draw.Image, r image.Rectangle, img image.Image, f draw.Interpolator, threads int) error {
+	if s, ok := img.(*Shrunk); ok {
+		img = s.RGBA // for the fast path of draw
+	}
+	// Each band is scaled with the mapping of the whole image so that the
+	// result doesn't depend on the banding.
+	return parallelRows(dst.Bounds(), threads, func(rows image.Rectangle) error {
+		for y := rows.Min.Y; y < rows.Max.Y; y += scaleBandRows {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			band := image.Rect(rows.Min.X, y, rows.Max.X, y+scaleBandRows).Intersect(rows)
+			if rgba, ok := dst.(*image.RGBA); ok {
+				f.Scale(rgba.SubImage(band).(*image.RGBA), r, img, img.Bounds(), draw.Over, nil)
+			} else {
+				scaleCompact(dst, band, r, img)
+			}
+		}
+		return nil
+	})
+}
+
+// scaleCompact scales the Gray, Gray16 or Paletted img to the rows band of
+// the Gray or Paletted dst of the rectangle r, copying the pixels directly.
+// Like draw.NearestNeighbor, a pixel is the one of img under its center.
+func scaleCompact(dst draw.Image, band, r image.Rectangle, img image.Image) {
+	sr := img.Bounds()
+	sw, sh := uint64(sr.Dx()), uint64(sr.Dy())
+	dw2, dh2 := 2*uint64(r.Dx()), 2*uint64(r.Dy())
+	cols := make([]int, band.Dx())
+	for i := range cols {
+		cols[i] = int((2*uint64(band.Min.X-r.Min.X+i) + 1) * sw / dw2)
+	}
+	for y := band.Min.Y; y < band.Max.Y; y++ {
+		sy := sr.Min.Y + int((2*uint64(y-r.Min.Y)+1)*sh/dh2)
+		var row []uint8
+		switch d := dst.(type) {
+		case *image.Gray:
+			row = d.Pix[d.PixOffset(band.Min.X, y):][:len(cols)]
+		case *image.Paletted:
+			row = d.Pix[d.PixOffset(band.Min.X, y):][:len(cols)]
+		}
+		switch s := img.(type) {
+		case *image.Gray:
+			src := s.Pix[s.PixOffset(sr.Min.X, sy):]
+			for i, x := range cols {
+				row[i] = src[x]
+			}
+		case *image.Gray16:
+			// The high byte, as the 16-bit gray is truncated to 8 bits.
+			src := s.Pix[s.PixOffset(sr.Min.X, sy):]
+			for i, x := range cols {
+				row[i] = src[2*x]
+			}
+		case *image.Paletted:
+			src := s.Pix[s.PixOffset(sr.Min.X, sy):]
+			for i, x := range cols {
+				row[i] = src[x]
+			}
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package dither
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// Screen holds the settings of the threshold and halftone ditherers, which
+// map each pixel to either the darkest or the lightest color of the palette,
+// and the seed of the mask of the blue-noise ditherer.
+type Screen struct {
+	// Threshold is the luma, from 0 to 1, from which the threshold ditherer
+	// maps the pixels to the lightest color rather than the darkest.
+	Threshold float64 `json:"threshold"`
+	// DotSize is the distance in pixels between the centers of the dots of
+	// the halftone ditherer, at least 2.
+	DotSize float64 `json:"dot_size"`
+	// Angle is the angle in degrees of the rows of dots of the halftone
+	// ditherer, clockwise from the horizontal.
+	Angle float64 `json:"angle"`
+	// Seed is the seed of the generation of the blue-noise mask: the same
+	// seed always gives the same mask, other seeds other masks.
+	Seed int64 `json:"seed"`
+}
+
+// defaultScreen is the Screen of the registered ditherers: a threshold at
+// mid-gray, and the dots of 6 pixels at 45° of a newspaper halftone.
+var defaultScreen = Screen{Threshold: 0.5, DotSize: 6, Angle: 45}
+
+// problems returns the descriptions of the invalid settings of s.
+func (s Screen) problems() []string {
+	var problems []string
+	if t := s.Threshold; !(t >= 0 && t <= 1) {
+		problems = append(problems, fmt.Sprintf("invalid threshold %v, must be from 0 to 1", t))
+	}
+	if d := s.DotSize; !(d >= 2) || math.IsInf(d, 1) {
+		problems = append(problems, fmt.Sprintf("invalid dot size %v, must be at least 2 pixels", d))
+	}
+	if a := s.Angle; math.IsNaN(a) || math.IsInf(a, 0) {
+		problems = append(problems, fmt.Sprintf("invalid screen angle %v", a))
+	}
+	return problems
+}
+
+// A ScreenDitherer is a Ditherer whose threshold, halftone screen or
+// blue-noise mask can be set.
+type ScreenDitherer interface {
+	Ditherer
+	// Screened returns the ditherer with the settings s.
+	Screened(s Screen) Ditherer
+}
+
+// twoTones holds the colors of a palette the threshold and halftone
+// ditherers map the pixels to: the darkest and the lightest opaque ones, and
+// the most transparent one if it is mostly transparent, -1 otherwise.
+type twoTones struct {
+	dark, light, clear int
+}
+
+func newTwoTones(p color.Palette) twoTones {
+	t := twoTones{clear: -1}
+	values := paletteValues(p)
+	opaque := false
+	for _, c := range values {
+		opaque = opaque || c[3] == 0xffff
+	}
+	var low, high int32 = math.MaxInt32, -1
+	for i, c := range values {
+		if opaque && c[3] != 0xffff {
+			continue
+		}
+		l := luma(c[0], c[1], c[2])
+		if l < low {
+			t.dark, low = i, l
+		}
+		if l > high {
+			t.light, high = i, l
+		}
+	}
+	alpha := int32(0x8000)
+	for i, c := range values {
+		if c[3] < alpha {
+			t.clear, alpha = i, c[3]
+		}
+	}
+	return t
+}
+
+// luma returns the luma of color.GrayModel of the 16-bit components r, g
+// and b.
+func luma(r, g, b int32) int32 {
+	return int32((19595*int64(r) + 38470*int64(g) + 7471*int64(b) + 1<<15) >> 16)
+}
+
+// dither maps the pixels of src in the bounds of dst to the dark tone below
+// the 16-bit luma level(x, y), to the light one from it. The premultiplied
+// luma is compared, like the other ditherers composing the pixels over
+// black, but the mostly transparent pixels are mapped to the clear tone if
+// the palette has one.
+func (t twoTones) dither(dst *image.Paletted, src image.Image, level func(x, y int) int32) {
+	b := dst.Bounds()
+	pixel := pixelReader(src)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := dst.Pix[dst.PixOffset(b.Min.X, y):]
+		for i := 0; i < b.Dx(); i++ {
+			x := b.Min.X + i
+			r, g, bl, a := pixel(x, y)
+			switch {
+			case t.clear >= 0 && a < 0x8000:
+				row[i] = byte(t.clear)
+			case luma(r, g, bl) < level(x, y):
+				row[i] = byte(t.dark)
+			default:
+				row[i] = byte(t.light)
+			}
+		}
+	}
+}
+
+// threshold reduces images to the darkest and lightest colors of a palette,
+// mapping the pixels below the luma level to the darkest one and the others
+// to the lightest one, without any dithering. The pixels being independent,
+// the rows are processed concurrently.
+type threshold struct {
+	level float64
+}
+
+func (t threshold) Dither(dst *image.Paletted, src image.Image) error {
+	return t.Bands(dst.Bounds().Dx(), dst.Palette)(dst, src)
+}
+
+// Parallel reports that the pixels are mapped independently.
+func (t threshold) Parallel() bool { return true }
+
+// Screened returns the threshold at the luma s.Threshold.
+func (t threshold) Screened(s Screen) Ditherer {
+	return threshold{s.Threshold}
+}
+
+func (t threshold) Bands(width int, p color.Palette) DithererFunc {
+	tones := newTwoTones(p)
+	level := int32(math.Round(t.level * 0xffff))
+	return func(dst *image.Paletted, src image.Image) error {
+		tones.dither(dst, src, func(x, y int) int32 { return level })
+		return nil
+	}
+}
+
+// otsu is the threshold ditherer at the luma found by Otsu's method, which
+// best separates the pixels of each image into a dark and a light class. The
+// luma histogram is the one of the whole image, so that otsu neither runs
+// concurrently nor in bands.
+type otsu struct{}
+
+func (otsu) Dither(dst *image.Paletted, src image.Image) error {
+	var h histogram
+	h.add(src, dst.Bounds())
+	level := int32(h.otsu()+1) << 8
+	newTwoTones(dst.Palette).dither(dst, src, func(x, y int) int32 { return level })
+	return nil
+}
+
+// otsu returns the luma of h maximizing the variance between the pixels of
+// lumas up to it and the lighter ones, 127 when all pixels have the same.
+func (h *histogram) otsu() int {
+	var n, sum float64
+	for l, c := range h {
+		n += float64(c)
+		sum += float64(l) * float64(c)
+	}
+	best, max := 127, -1.
+	var dark, darkSum float64
+	for l, c := range h {
+		dark += float64(c)
+		darkSum += float64(l) * float64(c)
+		light := n - dark
+		if dark == 0 || light == 0 {
+			continue
+		}
+		// The variance between the classes, without the constant factor
+		// 1/n².
+		d := darkSum/dark - (sum-darkSum)/light
+		if v := dark * light * d * d; v > max {
+			best, max = l, v
+		}
+	}
+	return best
+}
+
+// halftone reduces images to the darkest and lightest colors of a palette
+// with the clustered dots of a newspaper screen: dark round dots on the
+// light color, growing with the darkness of the image until they merge into
+// a checkerboard, then leaving light holes. The screen is a grid of dots of
+// the given size rotated by the given angle in degrees. The pixels being
+// independent, the rows are processed concurrently.
+type halftone struct {
+	size, angle float64
+}
+
+func (h halftone) Dither(dst *image.Paletted, src image.Image) error {
+	return h.Bands(dst.Bounds().Dx(), dst.Palette)(dst, src)
+}
+
+// Parallel reports that the pixels are mapped independently.
+func (h halftone) Parallel() bool { return true }
+
+// Screened returns the halftone of the dots of s.
+func (h halftone) Screened(s Screen) Ditherer {
+	return halftone{s.DotSize, s.Angle}
+}
+
+func (h halftone) Bands(width int, p color.Palette) DithererFunc {
+	tones := newTwoTones(p)
+	ranks := spotRanks()
+	sin, cos := math.Sincos(h.angle * math.Pi / 180)
+	sin, cos = sin/h.size, cos/h.size
+	// level returns the rank of the spot function at the center of the
+	// pixel, in the cells of the grid anchored at the origin so that the
+	// bands and the rows of the goroutines continue the pattern.
+	level := func(x, y int) int32 {
+		cx, cy := float64(x)+0.5, float64(y)+0.5
+		u, v := cx*cos+cy*sin, cy*cos-cx*sin
+		return ranks[int(math.Round(spot(u, v)*(spotBins-1)))]
+	}
+	return func(dst *image.Paletted, src image.Image) error {
+		tones.dither(dst, src, level)
+		return nil
+	}
+}
+
+// spot is the spot function of the halftone at u, v in dot periods: 1 at the
+// centers of the dots, on the integer coordinates, and 0 halfway between
+// them.
+func spot(u, v float64) float64 {
+	return (math.Cos(2*math.Pi*u)+math.Cos(2*math.Pi*v))/4 + 0.5
+}
+
+// spotBins is the number of values of the spot function ranked by spotRanks.
+const spotBins = 1024
+
+var (
+	spotOnce  sync.Once
+	spotTable []int32
+)
+
+// spotRanks returns the 16-bit fractions of a dot cell whose spot function
+// is lower than the ones of spotBins evenly spaced values, from 0 to 1.
+// Comparing the luma of a pixel to the fraction of its spot value, rather
+// than to the value itself, makes the area of the dots follow the darkness
+// of the image.
+func spotRanks() []int32 {
+	spotOnce.Do(func() {
+		const n = 256
+		var counts [spotBins]int64
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				s := spot((float64(i)+0.5)/n, (float64(j)+0.5)/n)
+				counts[int(math.Round(s*(spotBins-1)))]++
+			}
+		}
+		spotTable = make([]int32, spotBins)
+		var below int64
+		for i, c := range counts {
+			// The bin counts half, for the ranks to be centered.
+			spotTable[i] = int32((2*below + c) * 0xffff / (2 * n * n))
+			below += c
+		}
+	})
+	return spotTable
+}
@@ -0,0 +1,123 @@
+package dither
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// bimodal returns a gray image of w x h pixels of lumas spread around dark in
+// the share of its columns on the left, and around light in the others.
+func bimodal(w, h int, dark, light uint8, share float64) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	rnd := rand.New(rand.NewSource(1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := light
+			if float64(x) < float64(w)*share {
+				v = dark
+			}
+			img.SetGray(x, y, grayOf(int(v)+rnd.Intn(21)-10))
+		}
+	}
+	return img
+}
+
+// TestOtsu checks that otsu maps the dark and light pixels of bimodal images
+// apart, wherever their modes are.
+func TestOtsu(t *testing.T) {
+	for _, tt := range []struct {
+		dark, light uint8
+		share       float64
+	}{
+		{40, 200, 0.5},
+		{60, 180, 0.8},
+		{60, 180, 0.2},
+		// All above the fixed mid-gray threshold.
+		{150, 230, 0.5},
+		{20, 100, 0.3},
+	} {
+		src := bimodal(64, 16, tt.dark, tt.light, tt.share)
+		var h histogram
+		h.add(src, src.Rect)
+		if l := h.otsu(); l < int(tt.dark)+10 || l >= int(tt.light)-10 {
+			t.Errorf("modes %d and %d: Otsu threshold %d between them", tt.dark, tt.light, l)
+		}
+		dst, err := Reduce(context.Background(), src, BlackAndWhite, "otsu")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 64; x++ {
+				dark := float64(x) < 64*tt.share
+				if black := dst.ColorIndexAt(x, y) == 1; black != dark {
+					t.Fatalf("modes %d and %d: pixel %d,%d of luma %d black %v", tt.dark, tt.light, x, y, src.GrayAt(x, y).Y, black)
+				}
+			}
+		}
+	}
+
+	var h histogram
+	h.add(uniform(8, 8, 90), image.Rect(0, 0, 8, 8))
+	if l := h.otsu(); l != 127 {
+		t.Errorf("Otsu threshold %d of a uniform image, expected 127", l)
+	}
+}
+
+// grayOf returns the gray of luma v clamped to 0 to 255.
+func grayOf(v int) color.Gray {
+	if v < 0 {
+		v = 0
+	}
+	if v > 255 {
+		v = 255
+	}
+	return color.Gray{Y: uint8(v)}
+}
+
+// TestHalftonePeriod checks that the dots of the halftone repeat with the
+// dot size along the axes of the screen angle: 4,3 and -3,4 for dots of 5
+// pixels at atan(3/4), and 8,0 and 0,8 for dots of 8 pixels at 0°, but not
+// along other vectors.
+func TestHalftonePeriod(t *testing.T) {
+	for _, tt := range []struct {
+		size, angle float64
+		periods     []image.Point
+		other       image.Point
+	}{
+		{5, 36.86989764584402, []image.Point{{4, 3}, {-3, 4}}, image.Pt(5, 0)},
+		{8, 0, []image.Point{{8, 0}, {0, 8}}, image.Pt(4, 4)},
+		{8, 90, []image.Point{{8, 0}, {0, 8}}, image.Pt(0, 4)},
+	} {
+		for _, gray := range []uint8{64, 128, 200} {
+			src := uniform(64, 64, gray)
+			dst := image.NewPaletted(src.Rect, BlackAndWhite)
+			if err := (halftone{tt.size, tt.angle}).Dither(dst, src); err != nil {
+				t.Fatal(err)
+			}
+			mismatches := func(p image.Point) int {
+				n := 0
+				for y := 0; y < 64-8; y++ {
+					for x := 8; x < 64-8; x++ {
+						if dst.ColorIndexAt(x, y) != dst.ColorIndexAt(x+p.X, y+p.Y) {
+							n++
+						}
+					}
+				}
+				return n
+			}
+			for _, p := range tt.periods {
+				// The pixels on the edges of the dots may round either
+				// way.
+				if n := mismatches(p); n > 20 {
+					t.Errorf("dots of %v at %v° on gray %d: %d pixels differ from the ones %v away", tt.size, tt.angle, gray, n, p)
+				}
+			}
+			if n := mismatches(tt.other); n < 200 {
+				t.Errorf("dots of %v at %v° on gray %d: only %d pixels differ from the ones %v away", tt.size, tt.angle, gray, n, tt.other)
+			}
+		}
+	}
+}
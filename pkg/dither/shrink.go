@@ -0,0 +1,209 @@
+package dither
+
+import (
+	"image"
+	"image/color"
+	"io"
+)
+
+// A Shrunk image is the reduction of an image by an integer factor, each of
+// its pixels being the average of a block of Factor x Factor pixels of the
+// source. Scaled, it is scaled as the source it stands for: to
+// ScaledBounds(Source, s) rather than to its own bounds.
+type Shrunk struct {
+	*image.RGBA
+	Source image.Rectangle // the bounds of the source
+	Factor int
+}
+
+// SourceBounds returns the bounds of the source img stands for: Source if
+// img is Shrunk, its own bounds otherwise.
+func SourceBounds(img image.Image) image.Rectangle {
+	if s, ok := img.(*Shrunk); ok {
+		return s.Source
+	}
+	return img.Bounds()
+}
+
+// scaling returns the bounds of img once scaled according to opts, and
+// whether it needs scaling.
+func scaling(img image.Image, opts Options) (image.Rectangle, bool) {
+	if !opts.Scales() {
+		return img.Bounds(), false
+	}
+	return opts.ScaledBounds(SourceBounds(img)), true
+}
+
+// ShrinkFactor returns the factor by which an image to be scaled by s can be
+// shrunk ahead of the scaling while keeping at least twice the resolution of
+// the result, or 1 when s is not a heavy enough downscaling.
+func ShrinkFactor(s float32) int {
+	if s <= 0 || s > 0.25 {
+		return 1
+	}
+	return int(1 / (2 * s))
+}
+
+// Shrink reduces img by factor, averaging each block of factor x factor
+// pixels. The blocks of the last columns and rows are partial when the size
+// of img is not a multiple of factor.
+func Shrink(img image.Image, factor int) *Shrunk {
+	b := img.Bounds()
+	w, h := (b.Dx()+factor-1)/factor, (b.Dy()+factor-1)/factor
+	dst := &image.RGBA{Pix: getPix(4 * w * h), Stride: 4 * w, Rect: image.Rect(0, 0, w, h)}
+	switch s := img.(type) {
+	case *image.YCbCr:
+		shrinkYCbCr(dst, s, factor)
+	case *image.Gray:
+		// The luma of a Gray image is averaged like the one of a YCbCr
+		// image without chroma.
+		shrinkYCbCr(dst, &image.YCbCr{Y: s.Pix, YStride: s.Stride, Rect: s.Rect}, factor)
+	default:
+		shrinkImage(dst, img, factor)
+	}
+	return &Shrunk{RGBA: dst, Source: b, Factor: factor}
+}
+
+// blockSize returns the number of rows or columns of the block i of the
+// given factor along a side of the given length.
+func blockSize(i, factor, length int) int {
+	if n := length - i*factor; n < factor {
+		return n
+	}
+	return factor
+}
+
+// shrinkImage shrinks img to dst by factor, from the 16-bit premultiplied
+// components of its pixels.
+func shrinkImage(dst *image.RGBA, img image.Image, factor int) {
+	b := img.Bounds()
+	pixel := pixelReader(img)
+	sums := make([][4]uint64, dst.Rect.Dx())
+	for y0 := 0; y0 < dst.Rect.Dy(); y0++ {
+		rows := blockSize(y0, factor, b.Dy())
+		for y := b.Min.Y + y0*factor; y < b.Min.Y+y0*factor+rows; y++ {
+			for x0 := range sums {
+				s := &sums[x0]
+				x := b.Min.X + x0*factor
+				for x1 := x + blockSize(x0, factor, b.Dx()); x < x1; x++ {
+					pr, pg, pb, pa := pixel(x, y)
+					s[0] += uint64(pr)
+					s[1] += uint64(pg)
+					s[2] += uint64(pb)
+					s[3] += uint64(pa)
+				}
+			}
+		}
+		row := dst.Pix[y0*dst.Stride:]
+		for x0, s := range sums {
+			n := uint64(rows*blockSize(x0, factor, b.Dx())) * 0x101
+			for i, v := range s {
+				row[4*x0+i] = uint8((v + n/2) / n)
+			}
+			sums[x0] = [4]uint64{}
+		}
+	}
+}
+
+// shrinkYCbCr shrinks img to dst by factor, averaging the luma and chroma
+// samples of the blocks, then converting the averages to RGB. Without chroma
+// planes the result is gray.
+func shrinkYCbCr(dst *image.RGBA, img *image.YCbCr, factor int) {
+	b := img.Rect
+	chroma := len(img.Cb) > 0
+	// A subsampled chroma sample is shared by neighbor pixels. It is added
+	// once per block and row of chroma samples, weighted by the number of
+	// pixels of the block sharing it: starts[x0] is the index in samples of
+	// those of the columns of the block x0.
+	type sample struct{ offset, weight uint32 }
+	var samples []sample
+	starts := make([]int, dst.Rect.Dx()+1)
+	if chroma {
+		base := img.COffset(b.Min.X, b.Min.Y)
+		for x0 := 0; x0 < dst.Rect.Dx(); x0++ {
+			starts[x0] = len(samples)
+			x := b.Min.X + x0*factor
+			for x1 := x + blockSize(x0, factor, b.Dx()); x < x1; x++ {
+				c := uint32(img.COffset(x, b.Min.Y) - base)
+				if n := len(samples); n > starts[x0] && samples[n-1].offset == c {
+					samples[n-1].weight++
+				} else {
+					samples = append(samples, sample{c, 1})
+				}
+			}
+		}
+		starts[dst.Rect.Dx()] = len(samples)
+	}
+
+	sums := make([][3]uint32, dst.Rect.Dx())
+	columns := make([]uint32, b.Dx()) // the luma sums of the columns of a row of blocks
+	for y0 := 0; y0 < dst.Rect.Dy(); y0++ {
+		rows := blockSize(y0, factor, b.Dy())
+		y1 := b.Min.Y + y0*factor + rows
+		for y := b.Min.Y + y0*factor; y < y1; y++ {
+			luma := img.Y[img.YOffset(b.Min.X, y):]
+			luma = luma[:len(columns)]
+			for i, v := range luma {
+				columns[i] += uint32(v)
+			}
+		}
+		c := columns
+		for x0 := range sums {
+			n := factor
+			if n > len(c) {
+				n = len(c)
+			}
+			for i, v := range c[:n] {
+				sums[x0][0] += v
+				c[i] = 0
+			}
+			c = c[n:]
+		}
+		for y := b.Min.Y + y0*factor; chroma && y < y1; {
+			// The rows sharing the chroma samples of the row y.
+			ci := img.COffset(b.Min.X, y)
+			n := uint32(1)
+			for y++; y < y1 && img.COffset(b.Min.X, y) == ci; y++ {
+				n++
+			}
+			cb, cr := img.Cb[ci:], img.Cr[ci:]
+			for x0 := range sums {
+				var sb, sr uint32
+				for _, s := range samples[starts[x0]:starts[x0+1]] {
+					sb += s.weight * uint32(cb[s.offset])
+					sr += s.weight * uint32(cr[s.offset])
+				}
+				sums[x0][1] += n * sb
+				sums[x0][2] += n * sr
+			}
+		}
+
+		row := dst.Pix[y0*dst.Stride:]
+		for x0, s := range sums {
+			n := uint32(rows * blockSize(x0, factor, b.Dx()))
+			yy := uint8((s[0] + n/2) / n)
+			p := row[4*x0 : 4*x0+4 : 4*x0+4]
+			if chroma {
+				p[0], p[1], p[2] = color.YCbCrToRGB(yy, uint8((s[1]+n/2)/n), uint8((s[2]+n/2)/n))
+			} else {
+				p[0], p[1], p[2] = yy, yy, yy
+			}
+			p[3] = 0xff
+			sums[x0] = [3]uint32{}
+		}
+	}
+}
+
+// shrinkDecoders decode the images of a format shrunk by a factor. The ones
+// here decode the whole image, then shrink it right away so that the decoded
+// image can be collected before the processing; a decoder scaling down the
+// DCT blocks of JPEG images would avoid decoding it in the first place.
+var shrinkDecoders = map[string]func(r io.Reader, factor int) (image.Image, error){
+	"jpeg": func(r io.Reader, factor int) (image.Image, error) {
+		img, err := Decode(r, "jpeg")
+		if err != nil {
+			return nil, err
+		}
+		return Shrink(img, factor), nil
+	},
+}
@@ -0,0 +1,160 @@
+package dither
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+func TestShrinkFactor(t *testing.T) {
+	for _, tt := range []struct {
+		s    float32
+		want int
+	}{
+		{1, 1}, {0.5, 1}, {0.3, 1}, {0.25, 2}, {0.2, 2}, {0.1, 5}, {0.01, 50}, {0, 1}, {-1, 1},
+	} {
+		if got := ShrinkFactor(tt.s); got != tt.want {
+			t.Errorf("ShrinkFactor(%v) = %d, expected %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+// TestShrinkAverages checks that the pixels of the shrunk images are the
+// averages of their blocks, partial ones included, for every source type.
+func TestShrinkAverages(t *testing.T) {
+	for name, src := range testImages(t, 23, 17) {
+		// The YCbCr images are averaged before their conversion to RGB,
+		// which differs where it clamps the components.
+		tolerance := 1
+		if name == "ycbcr" {
+			tolerance = 4
+		}
+		for _, factor := range []int{2, 3, 5} {
+			got := Shrink(src, factor)
+			b := src.Bounds()
+			if got.Source != b || got.Factor != factor {
+				t.Fatalf("%s by %d: source %v and factor %d", name, factor, got.Source, got.Factor)
+			}
+			if want := image.Rect(0, 0, (b.Dx()+factor-1)/factor, (b.Dy()+factor-1)/factor); got.Rect != want {
+				t.Fatalf("%s by %d: bounds %v, expected %v", name, factor, got.Rect, want)
+			}
+			for y := 0; y < got.Rect.Dy(); y++ {
+				for x := 0; x < got.Rect.Dx(); x++ {
+					want := blockAverage(src, image.Rect(x*factor, y*factor, (x+1)*factor, (y+1)*factor).Add(b.Min).Intersect(b))
+					if c := got.RGBAAt(x, y); !near(c, want, tolerance) {
+						t.Fatalf("%s by %d: pixel %d,%d is %v, expected %v", name, factor, x, y, c, want)
+					}
+				}
+			}
+			Release(got)
+		}
+	}
+}
+
+// blockAverage returns the average of the 8-bit premultiplied components of
+// the pixels of img in r.
+func blockAverage(img image.Image, r image.Rectangle) color.RGBA {
+	var sum [4]uint32
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			sum[0] += uint32(c.R)
+			sum[1] += uint32(c.G)
+			sum[2] += uint32(c.B)
+			sum[3] += uint32(c.A)
+		}
+	}
+	n := uint32(r.Dx() * r.Dy())
+	return color.RGBA{uint8((sum[0] + n/2) / n), uint8((sum[1] + n/2) / n), uint8((sum[2] + n/2) / n), uint8((sum[3] + n/2) / n)}
+}
+
+// near reports whether the components of a and b differ by at most d.
+func near(a, b color.RGBA, d int) bool {
+	diff := func(x, y uint8) bool { return int(x)-int(y) <= d && int(y)-int(x) <= d }
+	return diff(a.R, b.R) && diff(a.G, b.G) && diff(a.B, b.B) && diff(a.A, b.A)
+}
+
+// testPhoto returns a photo-like w x h JPEG image: smooth gradients, a
+// texture of waves and noise.
+func testPhoto(t testing.TB, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	seed := uint32(1)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			seed = seed*1664525 + 1013904223
+			wave := 40 * math.Sin(float64(x)/23) * math.Cos(float64(y)/31)
+			v := func(base int) uint8 {
+				c := float64(base) + wave + float64(int(seed>>28)-8)
+				return uint8(math.Max(0, math.Min(255, c)))
+			}
+			img.SetRGBA(x, y, color.RGBA{v(200 * x / w), v(200 * y / h), v(100 + 50*(x+y)/(w+h)), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestShrinkDecodeQuality checks that scaling the JPEG images shrunk as they
+// are decoded gives the result of scaling them in full to the same bounds,
+// within a PSNR of 35 dB, and that the dithered results are as close.
+func TestShrinkDecodeQuality(t *testing.T) {
+	ctx := context.Background()
+	data := testPhoto(t, 1600, 1200)
+	full, _, err := DecodeWith(bytes.NewReader(data), "jpeg", DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []float32{0.25, 0.1, 0.03} {
+		shrunk, _, err := DecodeWith(bytes.NewReader(data), "jpeg", DecodeOptions{Shrink: ShrinkFactor(s)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := shrunk.(*Shrunk); !ok {
+			t.Fatalf("scale %v: decoded a %T, expected a Shrunk image", s, shrunk)
+		}
+		for _, f := range []string{"bilinear", "catmull-rom"} {
+			name := fmt.Sprintf("scale %v with %s", s, f)
+			opts := DefaultOptions(WithScale(s), WithFilter(f))
+			want, err := scale(ctx, full, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := scale(ctx, shrunk, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Bounds() != want.Bounds() {
+				t.Fatalf("%s: bounds %v, expected %v", name, got.Bounds(), want.Bounds())
+			}
+			if m := ComputeMetrics(want, got, 0); m.PSNR < 35 {
+				t.Errorf("%s: PSNR of %.1f dB from the full resolution", name, m.PSNR)
+			}
+
+			dwant, err := Process(ctx, full, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dgot, err := Process(ctx, shrunk, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mwant, mgot := ComputeMetrics(want, dwant, 1.5), ComputeMetrics(want, dgot, 1.5)
+			if math.Abs(mwant.PSNR-mgot.PSNR) > 1 {
+				t.Errorf("%s: dithered with a PSNR of %.1f dB, expected %.1f", name, mgot.PSNR, mwant.PSNR)
+			}
+			Release(want)
+			Release(got)
+			Release(dwant)
+			Release(dgot)
+		}
+		Release(shrunk)
+	}
+}
@@ -0,0 +1,130 @@
+package dither
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// HistogramBuckets is the number of buckets of the luminance histogram of
+// Stats.
+const HistogramBuckets = 16
+
+// Stats summarizes the tonal content of a source image and of the
+// paletted image it was reduced to.
+type Stats struct {
+	Palette    []PaletteShare            `json:"palette"`
+	Histogram  [HistogramBuckets]float64 `json:"luminance_histogram"`
+	MeanBefore float64                   `json:"mean_luminance_before"`
+	MeanAfter  float64                   `json:"mean_luminance_after"`
+	Runs       *RunStats                 `json:"runs,omitempty"`
+}
+
+// PaletteShare is the fraction of the pixels mapped to a palette entry.
+type PaletteShare struct {
+	Color    string  `json:"color"`
+	Fraction float64 `json:"fraction"`
+}
+
+// RunStats gives the longest runs of adjacent black and white pixels on the
+// rows of a 1-bit image, which matters to printers that cannot fire too many
+// adjacent dots.
+type RunStats struct {
+	MaxBlack int       `json:"max_black"`
+	MaxWhite int       `json:"max_white"`
+	Rows     []RowRuns `json:"rows"`
+}
+
+// RowRuns gives the longest runs of adjacent black and white pixels of a row.
+type RowRuns struct {
+	Black int `json:"black"`
+	White int `json:"white"`
+}
+
+// Luminance returns the luma of c between 0 and 1.
+func Luminance(c color.Color) float64 {
+	return float64(color.Gray16Model.Convert(c).(color.Gray16).Y) / 0xffff
+}
+
+// HexColor formats the RGB components of c in hexadecimal.
+func HexColor(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+}
+
+// ComputeStats computes the statistics of dst, the paletted image obtained
+// from src, which must have the same bounds.
+func ComputeStats(src image.Image, dst *image.Paletted) Stats {
+	b := dst.Bounds()
+	n := float64(b.Dx() * b.Dy())
+	var s Stats
+	if n == 0 {
+		return s
+	}
+
+	counts := make([]int, len(dst.Palette))
+	lum := make([]float64, len(dst.Palette))
+	for i, c := range dst.Palette {
+		lum[i] = Luminance(c)
+	}
+
+	bilevel := len(dst.Palette) == 2
+	var black uint8
+	if bilevel {
+		s.Runs = &RunStats{Rows: make([]RowRuns, b.Dy())}
+		if lum[1] < lum[0] {
+			black = 1
+		}
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var run int
+		var row RowRuns
+		for x := b.Min.X; x < b.Max.X; x++ {
+			l := Luminance(src.At(x, y))
+			s.MeanBefore += l
+			bucket := int(l * HistogramBuckets)
+			if bucket == HistogramBuckets {
+				bucket--
+			}
+			s.Histogram[bucket]++
+
+			idx := dst.ColorIndexAt(x, y)
+			counts[idx]++
+			s.MeanAfter += lum[idx]
+
+			if !bilevel {
+				continue
+			}
+			if x > b.Min.X && dst.ColorIndexAt(x-1, y) == idx {
+				run++
+			} else {
+				run = 1
+			}
+			if idx == black && run > row.Black {
+				row.Black = run
+			} else if idx != black && run > row.White {
+				row.White = run
+			}
+		}
+		if bilevel {
+			s.Runs.Rows[y-b.Min.Y] = row
+			if row.Black > s.Runs.MaxBlack {
+				s.Runs.MaxBlack = row.Black
+			}
+			if row.White > s.Runs.MaxWhite {
+				s.Runs.MaxWhite = row.White
+			}
+		}
+	}
+
+	s.MeanBefore /= n
+	s.MeanAfter /= n
+	for i := range s.Histogram {
+		s.Histogram[i] /= n
+	}
+	for i, c := range dst.Palette {
+		s.Palette = append(s.Palette, PaletteShare{Color: HexColor(c), Fraction: float64(counts[i]) / n})
+	}
+	return s
+}
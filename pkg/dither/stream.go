@@ -0,0 +1,196 @@
+package dither
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// ErrNotStreamable is returned, possibly wrapped, for an image or options
+// that TransformStream cannot process row by row.
+var ErrNotStreamable = errors.New("cannot process the image as a stream")
+
+// A Stream is a PNG image whose rows are decoded as they are processed by
+// TransformStream, so that the image is never held in memory.
+type Stream struct {
+	rows *pngRows
+	conv *conversion // nil when the colors are kept
+}
+
+// DecodeStream reads the header of the PNG image read from r, up to its
+// pixels, and returns the stream of its rows with the metadata of the image.
+// The rows are decoded like DecodeWith decodes the image, but for the
+// Shrink and Page options. It fails with an error wrapping
+// ErrNotStreamable for an interlaced image, and for an image with an EXIF
+// orientation other than 1 unless opts.IgnoreOrientation.
+func DecodeStream(r io.Reader, opts DecodeOptions) (*Stream, Metadata, error) {
+	rec := &headerRecorder{format: "png"}
+	rows, err := readPNGRows(r, rec)
+	if err != nil {
+		return nil, Metadata{}, &DecodeError{Format: "png", Err: err}
+	}
+	if n := int64(rows.width) * int64(rows.height); opts.MaxPixels > 0 && n > opts.MaxPixels {
+		rows.close()
+		return nil, Metadata{}, &DecodeError{Format: "png", Err: fmt.Errorf("%w: %dx%d is %d pixels, over the limit of %d",
+			ErrTooLarge, rows.width, rows.height, n, opts.MaxPixels)}
+	}
+	var md Metadata
+	info := ImageInfo{Format: "png"}
+	if info.readHeader(rec) == nil {
+		md.DPIX, md.DPIY, md.Orientation = info.DPIX, info.DPIY, info.Orientation
+	}
+	if md.Orientation > 1 && !opts.IgnoreOrientation {
+		rows.close()
+		return nil, Metadata{}, &DecodeError{Format: "png", Err: fmt.Errorf("%w: the image is turned by its EXIF orientation %d",
+			ErrNotStreamable, md.Orientation)}
+	}
+	s := &Stream{rows: rows}
+	if !opts.AssumeSRGB {
+		s.conv = md.readProfile(rec, nil)
+	}
+	return s, md, nil
+}
+
+// Bounds returns the bounds of the image of s.
+func (s *Stream) Bounds() image.Rectangle {
+	return image.Rect(0, 0, s.rows.width, s.rows.height)
+}
+
+// Bytes returns the number of bytes of the pixels of the image of s decoded
+// in memory, which the stream saves.
+func (s *Stream) Bytes() int64 {
+	return int64(s.rows.bytesPerPixel()) * int64(s.rows.width) * int64(s.rows.height)
+}
+
+// Close releases the resources of the decoding of s. It does not close the
+// reader of the image.
+func (s *Stream) Close() error {
+	s.rows.close()
+	return nil
+}
+
+// read decodes the next rows of s to the image of bounds r, whose pixels are
+// pix, and returns it with its colors converted.
+func (s *Stream) read(pix []byte, r image.Rectangle) (image.Image, error) {
+	n := s.rows.bytesPerPixel() * r.Dx()
+	for y := 0; y < r.Dy(); y++ {
+		if err := s.rows.readRow(pix[y*n : (y+1)*n]); err != nil {
+			return nil, &DecodeError{Format: "png", Err: err}
+		}
+	}
+	img := s.rows.image(pix, r)
+	if s.conv != nil {
+		img = s.conv.convert(img)
+	}
+	return img, nil
+}
+
+// Streamable returns nil if TransformStream can process images with opts,
+// and otherwise an error wrapping ErrNotStreamable telling why not: the
+// stream is only read once, from top to bottom, which neither the
+// histogram of the auto-contrast nor the palette extraction of Colors
+// allow, the scaling filters other than nearest-neighbor would need
+// several rows of the image at once, and the geometric transforms the whole
+// image.
+func Streamable(opts Options) error {
+	var reason string
+	d, _ := Lookup(opts.Algorithm)
+	switch {
+	case opts.Format != "" && opts.Format != "png":
+		reason = fmt.Sprintf("%s output has no banded encoding", opts.Format)
+	case !opts.Geometry.none():
+		reason = "the crop, rotation, flips and padding need the whole image"
+	case opts.Scales() && opts.Filter != "" && opts.Filter != "nearest":
+		reason = fmt.Sprintf("the %s filter needs the whole image", opts.Filter)
+	case opts.Adjust.AutoContrast:
+		reason = "the auto-contrast needs the histogram of the whole image"
+	case opts.Colors != 0:
+		reason = "the palette extraction needs the colors of the whole image"
+	case d == nil || !Bandable(tuned(d, opts.Diffusion, opts.Screen)):
+		reason = fmt.Sprintf("algorithm %q cannot process images in bands", opts.Algorithm)
+	default:
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrNotStreamable, reason)
+}
+
+// TransformStream processes the image of s like TransformBands does a
+// decoded image, decoding the bands of at most rows rows of the image as
+// they are processed, so that the memory needed is proportional to the
+// width of the image rather than to its size. The options must be
+// Streamable. It returns ctx.Err() if ctx is done before the result is
+// encoded.
+func TransformStream(ctx context.Context, w io.Writer, s *Stream, opts Options, rows int) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if err := Streamable(opts); err != nil {
+		return err
+	}
+	d, _ := Lookup(opts.Algorithm)
+	d = tuned(d, opts.Diffusion, opts.Screen)
+	if rows < 1 {
+		return fmt.Errorf("dither: invalid band height %d", rows)
+	}
+
+	src := s.Bounds()
+	r := src
+	if opts.Scales() {
+		r = opts.ScaledBounds(src)
+	}
+	bpp := s.rows.bytesPerPixel()
+	var scaledBand func(band image.Rectangle) (image.Image, error)
+	if r == src {
+		pix := getPix(bpp * src.Dx() * rows)
+		defer putPix(pix)
+		scaledBand = func(band image.Rectangle) (image.Image, error) {
+			return s.read(pix, band)
+		}
+	} else {
+		// Each row of the result is the nearest-neighbor scaling of the
+		// source row under its center, with the mapping of the whole image.
+		rowPix := getPix(bpp * src.Dx())
+		defer putPix(rowPix)
+		scaled := getPix(4 * r.Dx() * rows)
+		defer putPix(scaled)
+		var (
+			row  image.Image
+			next = src.Min.Y // the next source row to read
+		)
+		sh, dh2 := uint64(src.Dy()), 2*uint64(r.Dy())
+		scaledBand = func(band image.Rectangle) (image.Image, error) {
+			var dst draw.Image
+			for y := band.Min.Y; y < band.Max.Y; y++ {
+				sy := src.Min.Y + int((2*uint64(y-r.Min.Y)+1)*sh/dh2)
+				for ; next <= sy; next++ {
+					var err error
+					if row, err = s.read(rowPix, image.Rect(src.Min.X, next, src.Max.X, next+1)); err != nil {
+						return nil, err
+					}
+				}
+				if dst == nil {
+					pix := scaled[:scaledBytes(row, draw.NearestNeighbor)*band.Dx()*band.Dy()]
+					for i := range pix {
+						pix[i] = 0
+					}
+					dst = newScaled(row, draw.NearestNeighbor, pix, band, scaledPalette(row, draw.NearestNeighbor))
+				}
+				rows := image.Rect(band.Min.X, y, band.Max.X, y+1)
+				whole := image.Rect(r.Min.X, y, r.Max.X, y+1)
+				if rgba, ok := dst.(*image.RGBA); ok {
+					draw.NearestNeighbor.Scale(rgba.SubImage(rows).(*image.RGBA), whole, row, row.Bounds(), draw.Over, nil)
+				} else {
+					scaleCompact(dst, rows, whole, row)
+				}
+			}
+			return dst, nil
+		}
+	}
+	return encodeBands(w, r, opts, func(emit func(band *image.Paletted) error) error {
+		return processBands(ctx, r, scaledBand, opts, d, rows, emit)
+	})
+}
@@ -0,0 +1,113 @@
+package dither
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// EncodeANSI writes img to w as text for a terminal: each character is the
+// upper half block of two pixels stacked vertically, the upper one in the
+// foreground color and the lower one in the background color, set by the
+// 24-bit ANSI escape sequences. The mostly transparent pixels are left in the
+// default colors of the terminal. Each line ends by resetting the colors.
+func EncodeANSI(w io.Writer, img *image.Paletted) error {
+	// The parameters of the sequences setting the palette colors.
+	fg := make([]string, len(img.Palette))
+	bg := make([]string, len(img.Palette))
+	opaque := make([]bool, len(img.Palette))
+	for i, c := range img.Palette {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		fg[i] = fmt.Sprintf("38;2;%d;%d;%d", n.R, n.G, n.B)
+		bg[i] = fmt.Sprintf("48;2;%d;%d;%d", n.R, n.G, n.B)
+		opaque[i] = n.A >= 0x80
+	}
+	// index returns the palette index of the pixel at x, y, -1 when it
+	// shows the default colors.
+	index := func(x, y int) int {
+		if y >= img.Rect.Max.Y {
+			return -1
+		}
+		v := int(img.Pix[img.PixOffset(x, y)])
+		if v >= len(opaque) || !opaque[v] {
+			return -1
+		}
+		return v
+	}
+
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		curFG, curBG := "39", "49"
+		for x := b.Min.X; x < b.Max.X; x++ {
+			top, bottom := index(x, y), index(x, y+1)
+			block, wantFG, wantBG := "▀", "39", "49"
+			switch {
+			case top >= 0 && bottom >= 0:
+				wantFG, wantBG = fg[top], bg[bottom]
+			case top >= 0:
+				wantFG = fg[top]
+			case bottom >= 0:
+				block, wantFG = "▄", fg[bottom]
+			default:
+				block = " "
+			}
+			if block == " " {
+				wantFG = curFG // the foreground doesn't show
+			}
+			switch {
+			case wantFG != curFG && wantBG != curBG:
+				fmt.Fprintf(bw, "\x1b[%s;%sm", wantFG, wantBG)
+			case wantFG != curFG:
+				fmt.Fprintf(bw, "\x1b[%sm", wantFG)
+			case wantBG != curBG:
+				fmt.Fprintf(bw, "\x1b[%sm", wantBG)
+			}
+			curFG, curBG = wantFG, wantBG
+			bw.WriteString(block)
+		}
+		bw.WriteString("\x1b[0m\n")
+	}
+	return bw.Flush()
+}
+
+// brailleDots are the bits of the dots of the braille patterns, by row and
+// column of the pixels of a character.
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// EncodeBraille writes img to w as plain text: each character is the
+// braille pattern of a block of 2x4 pixels, with a raised dot for the pixels
+// of the colors lighter than the mid-gray, the transparent ones being taken
+// over white like with EncodePBM. On a terminal of light text on a dark
+// background, the raised dots are the light pixels.
+func EncodeBraille(w io.Writer, img *image.Paletted) error {
+	levels := grayLevels(img.Palette)
+	light := make([]bool, len(levels))
+	for i, l := range levels {
+		light[i] = l >= 0x80
+	}
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	for y := b.Min.Y; y < b.Max.Y; y += 4 {
+		for x := b.Min.X; x < b.Max.X; x += 2 {
+			r := rune(0x2800)
+			for dy := 0; dy < 4 && y+dy < b.Max.Y; dy++ {
+				for dx := 0; dx < 2 && x+dx < b.Max.X; dx++ {
+					if v := img.Pix[img.PixOffset(x+dx, y+dy)]; int(v) < len(light) && light[v] {
+						r |= brailleDots[dy][dx]
+					}
+				}
+			}
+			bw.WriteRune(r)
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
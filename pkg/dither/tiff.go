@@ -0,0 +1,215 @@
+package dither
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxPages is the largest number of pages read from a multi-page image, which
+// also stops the loops of a corrupted IFD chain.
+const maxPages = 10000
+
+// Pages returns the number of pages of the image of the given format read
+// from r, 1 for the formats without pages. The pages of a TIFF image are its
+// image file directories, IFDs.
+func Pages(r io.ReaderAt, format string) (int, error) {
+	if format != "tiff" {
+		return 1, nil
+	}
+	offsets, err := tiffIFDs(r, maxPages)
+	if err != nil {
+		return 0, &DecodeError{Format: format, Err: err}
+	}
+	return len(offsets), nil
+}
+
+// tiffHeader returns the byte order of the TIFF structure read from r and the
+// offset of its first IFD.
+func tiffHeader(r io.ReaderAt) (binary.ByteOrder, int64, error) {
+	var header [8]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return nil, 0, fmt.Errorf("reading the header: %w", err)
+	}
+	var order binary.ByteOrder
+	switch string(header[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, 0, errors.New("not a TIFF structure")
+	}
+	return order, int64(order.Uint32(header[4:])), nil
+}
+
+// tiffIFDs returns the offsets of the first n IFDs of the TIFF image read from
+// r, or of all of them if it has fewer.
+func tiffIFDs(r io.ReaderAt, n int) ([]int64, error) {
+	order, first, err := tiffHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	var offsets []int64
+	seen := make(map[int64]bool)
+	for off := first; off != 0 && len(offsets) < n; {
+		if seen[off] {
+			return nil, errors.New("loop in the IFD chain")
+		}
+		seen[off] = true
+		offsets = append(offsets, off)
+		// An IFD is a count of entries of 12 bytes, followed by the offset
+		// of the next IFD.
+		var count [2]byte
+		if _, err := r.ReadAt(count[:], off); err != nil {
+			return nil, fmt.Errorf("reading the IFD at %d: %w", off, err)
+		}
+		var next [4]byte
+		if _, err := r.ReadAt(next[:], off+2+12*int64(order.Uint16(count[:]))); err != nil {
+			return nil, fmt.Errorf("reading the IFD at %d: %w", off, err)
+		}
+		off = int64(order.Uint32(next[:]))
+	}
+	return offsets, nil
+}
+
+// tiffPage reads a TIFF image as if the IFD at ifd were its first one, so
+// that the decoders reading the first page decode that one.
+type tiffPage struct {
+	data []byte
+	ifd  [4]byte // the offset of the first IFD in the header
+}
+
+func (p *tiffPage) ReadAt(b []byte, off int64) (int, error) {
+	if off >= int64(len(p.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, p.data[off:])
+	for i, v := range p.ifd {
+		if j := 4 + int64(i) - off; j >= 0 && j < int64(n) {
+			b[j] = v
+		}
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// pageReader returns the reader of the page, from 0, of the image of the
+// given format read from r.
+func pageReader(r io.Reader, format string, page int) (io.Reader, error) {
+	if format != "tiff" {
+		return nil, &DecodeError{Format: format, Err: fmt.Errorf("no page %d, %s images have a single one", page+1, format)}
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &DecodeError{Format: format, Err: err}
+	}
+	offsets, err := tiffIFDs(bytes.NewReader(data), page+1)
+	if err != nil {
+		return nil, &DecodeError{Format: format, Err: err}
+	}
+	if len(offsets) <= page {
+		return nil, &DecodeError{Format: format, Err: fmt.Errorf("no page %d, the image has %d", page+1, len(offsets))}
+	}
+	p := &tiffPage{data: data}
+	order := binary.ByteOrder(binary.LittleEndian)
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+	order.PutUint32(p.ifd[:], uint32(offsets[page]))
+	return io.NewSectionReader(p, 0, int64(len(data))), nil
+}
+
+// A tiffEntry is an entry of an IFD, of count values of the given type.
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	field [4]byte // the values if they fit, their offset otherwise
+}
+
+// tiffTypeSizes are the sizes of the values of the TIFF types.
+var tiffTypeSizes = map[uint16]int64{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1}
+
+// readIFD returns the entries of the IFD at off of the TIFF structure read
+// from r, by tag.
+func readIFD(r io.ReaderAt, order binary.ByteOrder, off int64) (map[uint16]tiffEntry, error) {
+	var count [2]byte
+	if _, err := r.ReadAt(count[:], off); err != nil {
+		return nil, fmt.Errorf("reading the IFD at %d: %w", off, err)
+	}
+	data := make([]byte, 12*int64(order.Uint16(count[:])))
+	if _, err := r.ReadAt(data, off+2); err != nil {
+		return nil, fmt.Errorf("reading the IFD at %d: %w", off, err)
+	}
+	entries := make(map[uint16]tiffEntry)
+	for ; len(data) >= 12; data = data[12:] {
+		e := tiffEntry{typ: order.Uint16(data[2:]), count: order.Uint32(data[4:])}
+		copy(e.field[:], data[8:12])
+		entries[order.Uint16(data)] = e
+	}
+	return entries, nil
+}
+
+// values returns the bytes of the values of e, failing for an unknown type or
+// values larger than max bytes.
+func (e tiffEntry) values(r io.ReaderAt, order binary.ByteOrder, max int64) ([]byte, bool) {
+	size, ok := tiffTypeSizes[e.typ]
+	if !ok || e.count == 0 || size*int64(e.count) > max {
+		return nil, false
+	}
+	n := size * int64(e.count)
+	if n <= 4 {
+		return e.field[:n], true
+	}
+	data := make([]byte, n)
+	if _, err := r.ReadAt(data, int64(order.Uint32(e.field[:]))); err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// first returns the bytes of the first value of e.
+func (e tiffEntry) first(r io.ReaderAt, order binary.ByteOrder) ([]byte, bool) {
+	size, ok := tiffTypeSizes[e.typ]
+	if !ok || e.count == 0 {
+		return nil, false
+	}
+	if size*int64(e.count) <= 4 {
+		return e.field[:size], true
+	}
+	v := make([]byte, size)
+	if _, err := r.ReadAt(v, int64(order.Uint32(e.field[:]))); err != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// uint returns the first value of the entry e of integers.
+func (e tiffEntry) uint(r io.ReaderAt, order binary.ByteOrder) (uint32, bool) {
+	v, ok := e.first(r, order)
+	if !ok {
+		return 0, false
+	}
+	switch e.typ {
+	case 1:
+		return uint32(v[0]), true
+	case 3:
+		return uint32(order.Uint16(v)), true
+	case 4:
+		return order.Uint32(v), true
+	}
+	return 0, false
+}
+
+// rational returns the first value of the entry e of rationals.
+func (e tiffEntry) rational(r io.ReaderAt, order binary.ByteOrder) (float64, bool) {
+	v, ok := e.first(r, order)
+	if !ok || e.typ != 5 || order.Uint32(v[4:]) == 0 {
+		return 0, false
+	}
+	return float64(order.Uint32(v)) / float64(order.Uint32(v[4:])), true
+}